@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A config holds the player's settings.  Unset fields keep the default.
+type config struct {
+	// Transcript is the file that the story's transcript is appended to.
+	Transcript string `json:"transcript,omitempty"`
+
+	// AutoQuit sets the interpreter's AutoQuit field.
+	AutoQuit *bool `json:"autoquit,omitempty"`
+
+	// SaveDir is the directory that the story's auxiliary files are kept
+	// in, each story in a subdirectory named for its IFID.  A relative path
+	// is taken from gonorth's XDG data directory.  If it's empty, the files
+	// are kept next to the story file.
+	SaveDir string `json:"savedir,omitempty"`
+
+	// Paging stops the output after each screenful until the player presses
+	// Enter.
+	Paging *bool `json:"paging,omitempty"`
+
+	// Strict sets the machine's Strict option.
+	Strict *bool `json:"strict,omitempty"`
+
+	// Throttle is a pause after each line of output, such as "50ms".
+	Throttle string `json:"throttle,omitempty"`
+}
+
+// merge overrides c's settings with the ones set in over.
+func (c *config) merge(over config) {
+	if over.Transcript != "" {
+		c.Transcript = over.Transcript
+	}
+	if over.AutoQuit != nil {
+		c.AutoQuit = over.AutoQuit
+	}
+	if over.SaveDir != "" {
+		c.SaveDir = over.SaveDir
+	}
+	if over.Paging != nil {
+		c.Paging = over.Paging
+	}
+	if over.Strict != nil {
+		c.Strict = over.Strict
+	}
+	if over.Throttle != "" {
+		c.Throttle = over.Throttle
+	}
+}
+
+// throttle returns the pause after each line of output.
+func (c *config) throttle() (time.Duration, error) {
+	if c.Throttle == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Throttle)
+	if err != nil {
+		return 0, fmt.Errorf("throttle: %v", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("throttle %v is negative", d)
+	}
+	return d, nil
+}
+
+// A configFile holds the global settings and the settings for each story,
+// keyed by IFID.
+type configFile struct {
+	config
+	Stories map[string]config `json:"stories,omitempty"`
+}
+
+// configPath returns the location of the settings file.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gonorth", "config.json"), nil
+}
+
+// dataDir returns gonorth's data directory: $XDG_DATA_HOME/gonorth, or
+// ~/.local/share/gonorth if that isn't set.
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "gonorth"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "gonorth"), nil
+}
+
+// saveDir returns the directory that auxiliary files for the story at
+// storyPath with the given IFID are kept in.
+func saveDir(c config, ifid, storyPath string) (string, error) {
+	if c.SaveDir == "" {
+		return filepath.Dir(storyPath), nil
+	}
+	dir := c.SaveDir
+	if !filepath.IsAbs(dir) {
+		data, err := dataDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(data, dir)
+	}
+	name := cleanAuxName(ifid)
+	if name == "" {
+		return "", fmt.Errorf("IFID %q is not a directory name", ifid)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// loadConfig reads the settings file at path.  A missing file has no
+// settings.
+func loadConfig(path string) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return new(configFile), nil
+	} else if err != nil {
+		return nil, err
+	}
+	f := new(configFile)
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// save writes the settings file to path, creating its directory if needed.
+func (f *configFile) save(path string) error {
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
+
+// forStory returns the global settings overridden by the settings for the
+// story with the given IFID.
+func (f *configFile) forStory(ifid string) config {
+	c := f.config
+	if s, ok := f.Stories[ifid]; ok {
+		c.merge(s)
+	}
+	return c
+}
+
+// remember merges c into the settings for the story with the given IFID.
+func (f *configFile) remember(ifid string, c config) {
+	if f.Stories == nil {
+		f.Stories = make(map[string]config)
+	}
+	s := f.Stories[ifid]
+	s.merge(c)
+	f.Stories[ifid] = s
+}
+
+// flagConfig returns the settings given on the command line in fs.
+func flagConfig(fs *flag.FlagSet) config {
+	var c config
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "transcript":
+			c.Transcript = f.Value.String()
+		case "autoquit":
+			b := f.Value.(flag.Getter).Get().(bool)
+			c.AutoQuit = &b
+		case "savedir":
+			c.SaveDir = f.Value.String()
+		case "paging":
+			b := f.Value.(flag.Getter).Get().(bool)
+			c.Paging = &b
+		case "strict":
+			b := f.Value.(flag.Getter).Get().(bool)
+			c.Strict = &b
+		case "throttle":
+			c.Throttle = f.Value.String()
+		}
+	})
+	return c
+}
+
+// storyConfig loads the settings for the story with the given IFID.  Flags
+// set on the command line take precedence.  If remember is true, those flags
+// are saved as the story's settings first.
+func storyConfig(ifid string, remember bool) (config, error) {
+	fc := flagConfig(flag.CommandLine)
+	path, err := configPath()
+	if err != nil {
+		// No place for a settings file; use the defaults.
+		return fc, nil
+	}
+	f, err := loadConfig(path)
+	if err != nil {
+		return config{}, err
+	}
+	if remember {
+		f.remember(ifid, fc)
+		if err := f.save(path); err != nil {
+			return config{}, err
+		}
+	}
+	c := f.forStory(ifid)
+	c.merge(fc)
+	return c, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigMissing(t *testing.T) {
+	f, err := loadConfig(filepath.Join(t.TempDir(), "gonorth", "config.json"))
+	if err != nil {
+		t.Fatal("loadConfig:", err)
+	}
+	if c := f.forStory("ZCODE-1-000000"); !reflect.DeepEqual(c, config{}) {
+		t.Errorf("settings with no file != zero (got %+v)", c)
+	}
+}
+
+func TestConfigForStory(t *testing.T) {
+	yes, no := true, false
+	path := filepath.Join(t.TempDir(), "gonorth", "config.json")
+	f := &configFile{config: config{Transcript: "all.txt", AutoQuit: &yes}}
+	f.remember("ZCODE-88-840726", config{AutoQuit: &no})
+	f.remember("ZCODE-1-000000", config{Transcript: "old.txt"})
+	if err := f.save(path); err != nil {
+		t.Fatal("save:", err)
+	}
+	f, err := loadConfig(path)
+	if err != nil {
+		t.Fatal("loadConfig:", err)
+	}
+
+	tests := []struct {
+		IFID       string
+		Transcript string
+		AutoQuit   bool
+	}{
+		{"ZCODE-88-840726", "all.txt", false},
+		{"ZCODE-1-000000", "old.txt", true},
+		{"1974A053-7DAE-4B64-9B6F-BBF9A2B47F4A", "all.txt", true},
+	}
+	for _, tt := range tests {
+		c := f.forStory(tt.IFID)
+		if c.Transcript != tt.Transcript {
+			t.Errorf("%s: Transcript != %q (got %q)", tt.IFID, tt.Transcript, c.Transcript)
+		}
+		if c.AutoQuit == nil || *c.AutoQuit != tt.AutoQuit {
+			t.Errorf("%s: AutoQuit != %t (got %v)", tt.IFID, tt.AutoQuit, c.AutoQuit)
+		}
+	}
+}
+
+func TestFlagConfig(t *testing.T) {
+	fs := flag.NewFlagSet("gonorth", flag.ContinueOnError)
+	fs.String("transcript", "", "")
+	fs.Bool("autoquit", false, "")
+	fs.String("savedir", "", "")
+	fs.Bool("paging", false, "")
+	fs.Bool("strict", false, "")
+	fs.Duration("throttle", 0, "")
+	if err := fs.Parse([]string{"-autoquit=false", "-paging", "-throttle=50ms"}); err != nil {
+		t.Fatal(err)
+	}
+	c := flagConfig(fs)
+	if c.Transcript != "" {
+		t.Errorf("Transcript != \"\" (got %q)", c.Transcript)
+	}
+	if c.AutoQuit == nil || *c.AutoQuit {
+		t.Errorf("AutoQuit != false (got %v)", c.AutoQuit)
+	}
+	if c.SaveDir != "" || c.Strict != nil {
+		t.Errorf("SaveDir, Strict = %q, %v; want unset", c.SaveDir, c.Strict)
+	}
+	if c.Paging == nil || !*c.Paging {
+		t.Errorf("Paging != true (got %v)", c.Paging)
+	}
+	if d, err := c.throttle(); d != 50*time.Millisecond || err != nil {
+		t.Errorf("throttle() = %v, %v; want 50ms, <nil>", d, err)
+	}
+
+	// Flags win over the file.
+	yes := true
+	s := config{Transcript: "story.txt", AutoQuit: &yes}
+	s.merge(c)
+	if s.Transcript != "story.txt" || *s.AutoQuit {
+		t.Errorf("merged settings = %q, %t; want \"story.txt\", false", s.Transcript, *s.AutoQuit)
+	}
+}
+
+func TestConfigThrottle(t *testing.T) {
+	for _, s := range []string{"fast", "-1s"} {
+		c := config{Throttle: s}
+		if _, err := c.throttle(); err == nil {
+			t.Errorf("throttle %q: no error", s)
+		}
+	}
+}
+
+func TestSaveDir(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	abs := t.TempDir()
+	tests := []struct {
+		SaveDir string
+		IFID    string
+		Want    string
+	}{
+		{"", "ZCODE-88-840726", "stories"},
+		{"saves", "ZCODE-88-840726", filepath.Join(data, "gonorth", "saves", "ZCODE-88-840726")},
+		{abs, "ZCODE-88-840726", filepath.Join(abs, "ZCODE-88-840726")},
+	}
+	for _, tt := range tests {
+		dir, err := saveDir(config{SaveDir: tt.SaveDir}, tt.IFID, filepath.Join("stories", "zork.z3"))
+		if err != nil || dir != tt.Want {
+			t.Errorf("saveDir(%q, %q) = %q, %v; want %q, <nil>", tt.SaveDir, tt.IFID, dir, err, tt.Want)
+		}
+	}
+	if dir, err := saveDir(config{SaveDir: "saves"}, "../escape", "zork.z3"); err == nil {
+		t.Errorf("saveDir with IFID \"../escape\" = %q; want error", dir)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+// dumpOptions selects the optional sections of a story dump.
+type dumpOptions struct {
+	Words   bool
+	Objects bool
+
+	// Code is the address of a routine to disassemble, or zero for none.
+	Code north.Address
+}
+
+// dump writes a description of the story loaded in m to w.
+func dump(w io.Writer, m *north.Machine, opts dumpOptions) error {
+	if md := m.Metadata(); md != nil && md.Title != "" {
+		dumpBanner(w, md)
+		fmt.Fprintln(w)
+	}
+	h := m.Header()
+	fmt.Fprintln(w, "Header:")
+	fmt.Fprintf(w, "  Version:          %d\n", h.Version)
+	fmt.Fprintf(w, "  Release:          %d\n", h.Release)
+	fmt.Fprintf(w, "  Serial:           %q\n", h.Serial)
+	fmt.Fprintf(w, "  Flags 1:          %#02x\n", h.Flags1)
+	fmt.Fprintf(w, "  Flags 2:          %v\n", h.Flags2)
+	fmt.Fprintf(w, "  High memory:      %v\n", h.HighMemoryBase)
+	fmt.Fprintf(w, "  Initial PC:       %v\n", h.InitialPC)
+	fmt.Fprintf(w, "  Dictionary:       %v\n", h.Dictionary)
+	fmt.Fprintf(w, "  Object table:     %v\n", h.ObjectTable)
+	fmt.Fprintf(w, "  Global variables: %v\n", h.GlobalVariables)
+	fmt.Fprintf(w, "  Static memory:    %v\n", h.StaticMemoryBase)
+	fmt.Fprintf(w, "  Abbreviations:    %v\n", h.Abbreviations)
+	fmt.Fprintf(w, "  File length:      %d\n", h.FileLength)
+	if n := m.ImageSize(); n < h.FileLength {
+		fmt.Fprintf(w, "  ** Truncated: only %d bytes present\n", n)
+	}
+	fmt.Fprintf(w, "  Checksum:         %v\n", h.Checksum)
+
+	dict, err := m.Dictionary()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Dictionary:")
+	fmt.Fprintf(w, "  Separators: %q\n", string(dict.Separators))
+	fmt.Fprintf(w, "  Entry size: %d\n", dict.EntrySize)
+	fmt.Fprintf(w, "  Count:      %d\n", len(dict.Words))
+	if opts.Words {
+		for _, e := range dict.Words {
+			fmt.Fprintf(w, "  %v  %s\n", e.Address, e.Word)
+		}
+	}
+
+	fmt.Fprintln(w)
+	if n := m.AbbreviationCount(); n == 0 {
+		fmt.Fprintln(w, "Abbreviations: none")
+	} else {
+		fmt.Fprintln(w, "Abbreviations:")
+		for i := 0; i < n; i++ {
+			s, err := m.Unabbreviate(i)
+			if err != nil {
+				return fmt.Errorf("abbreviation %d: %v", i, err)
+			}
+			fmt.Fprintf(w, "  %2d: %q\n", i, s)
+		}
+	}
+
+	if opts.Objects {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Objects (%d):\n", m.ObjectCount())
+		visited := make(map[north.Word]bool)
+		for i := 1; i <= m.ObjectCount(); i++ {
+			o, err := m.Object(north.Word(i))
+			if err != nil {
+				return err
+			}
+			if o.Parent == 0 {
+				if err := dumpObject(w, m, o, 1, visited); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if opts.Code != 0 {
+		r, err := m.DisassembleRoutine(opts.Code)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Routine %v (%d locals)", r.Address, len(r.Locals))
+		for _, l := range r.Locals {
+			fmt.Fprintf(w, " %v", l)
+		}
+		fmt.Fprintln(w)
+		for _, in := range r.Instructions {
+			fmt.Fprintf(w, "  %v  %s\n", in.Address, in.Text)
+		}
+	}
+	return nil
+}
+
+// dumpObject writes o and its descendants, indented by depth.
+func dumpObject(w io.Writer, m *north.Machine, o north.ObjectInfo, depth int, visited map[north.Word]bool) error {
+	for {
+		if visited[o.Number] {
+			return fmt.Errorf("object %d: loop in object tree", o.Number)
+		}
+		visited[o.Number] = true
+		fmt.Fprintf(w, "%*s%d. %q %v\n", depth*2, "", o.Number, o.Name, o.Attrs)
+		if o.Child != 0 {
+			child, err := m.Object(o.Child)
+			if err != nil {
+				return err
+			}
+			if err := dumpObject(w, m, child, depth+1, visited); err != nil {
+				return err
+			}
+		}
+		if o.Sibling == 0 || depth == 1 {
+			return nil
+		}
+		var err error
+		if o, err = m.Object(o.Sibling); err != nil {
+			return err
+		}
+	}
+}
+
+// dumpBanner writes the story's title and byline.
+func dumpBanner(w io.Writer, md *north.StoryMetadata) {
+	fmt.Fprintln(w, md.Title)
+	byline := md.Headline
+	if md.Author != "" {
+		if byline != "" {
+			byline += " "
+		}
+		byline += "by " + md.Author
+	}
+	if md.FirstPublished != "" {
+		byline += " (" + md.FirstPublished + ")"
+	}
+	if byline = strings.TrimSpace(byline); byline != "" {
+		fmt.Fprintln(w, byline)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/north"
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files")
+
+// dumpTestStory returns a small story exercising every dump section.
+func dumpTestStory(t *testing.T) *north.Machine {
+	b := zasm.New(3)
+	b.Release = 42
+	b.Serial = "260101"
+	b.Routine("main", 0)
+	b.Instr("call_vs", zasm.Routine("count"), zasm.Const(3), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Routine("count", 2, 0, 7)
+	b.Label("loop")
+	b.Instr("dec_chk", zasm.Const(1), zasm.Const(0), zasm.IfTrue("done"))
+	b.Instr("inc", zasm.Const(2))
+	b.Instr("jump", zasm.Label("loop"))
+	b.Label("done")
+	b.Instr("ret", zasm.Local(2))
+	b.DictWord("lamp", "take", "north", "brass")
+	b.Object("West of House", "", []int{3})
+	b.Object("mailbox", "West of House", []int{0, 12})
+	b.Object("leaflet", "mailbox", nil)
+	b.Object("door", "West of House", nil)
+	b.Object("Limbo", "", nil)
+
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := north.NewMachine(bytes.NewReader(img), nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	return m
+}
+
+func TestDump(t *testing.T) {
+	m := dumpTestStory(t)
+	tests := []struct {
+		Golden string
+		Opts   dumpOptions
+	}{
+		{"dump.golden", dumpOptions{}},
+		{"dump-all.golden", dumpOptions{Words: true, Objects: true, Code: routineAddress(t, m)}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := dump(&buf, m, tt.Opts); err != nil {
+			t.Errorf("%s: %v", tt.Golden, err)
+			continue
+		}
+		path := filepath.Join("testdata", tt.Golden)
+		if *update {
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: output differs from golden file:\n%s", tt.Golden, buf.Bytes())
+		}
+	}
+}
+
+// routineAddress returns the address of the routine called by main.
+func routineAddress(t *testing.T, m *north.Machine) north.Address {
+	pc := m.Header().InitialPC
+	// call_vs: opcode, types, packed address
+	return north.Address(m.LoadWord(pc+2)) * 2
+}
+
+func TestDumpBanner(t *testing.T) {
+	tests := []struct {
+		Metadata north.StoryMetadata
+		Banner   string
+	}{
+		{north.StoryMetadata{Title: "Zork I"}, "Zork I\n"},
+		{north.StoryMetadata{Title: "Zork I", Author: "Infocom"}, "Zork I\nby Infocom\n"},
+		{
+			north.StoryMetadata{Title: "Zork I", Headline: "An Interactive Fantasy", Author: "Infocom", FirstPublished: "1980"},
+			"Zork I\nAn Interactive Fantasy by Infocom (1980)\n",
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		dumpBanner(&buf, &tt.Metadata)
+		if s := buf.String(); s != tt.Banner {
+			t.Errorf("dumpBanner(%+v) = %q; want %q", tt.Metadata, s, tt.Banner)
+		}
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := north.NewMachine(bytes.NewReader(img[:len(img)-2]), nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	var buf bytes.Buffer
+	if err := dump(&buf, m, dumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("** Truncated: only %d bytes present\n", len(img)-2)
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("dump doesn't contain %q:\n%s", want, buf.Bytes())
+	}
+}
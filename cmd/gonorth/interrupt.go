@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+var (
+	interruptMu sync.Mutex
+	onInterrupt func()
+	exitHandler func()
+)
+
+// stdin is standard input, read so that an interrupted run can abandon a
+// read that is waiting for the player.
+var stdin *cancelReader
+
+// watchInterrupts routes SIGINT to the current interrupt handler instead of
+// killing the process.  Outside of a run, SIGINT calls exit, which should
+// clean up and end the process.
+func watchInterrupts(exit func()) {
+	exitHandler = exit
+	handleInterrupt(exit)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		for range c {
+			interruptMu.Lock()
+			f := onInterrupt
+			interruptMu.Unlock()
+			f()
+		}
+	}()
+}
+
+// handleInterrupt sets the function called on SIGINT.
+func handleInterrupt(f func()) {
+	interruptMu.Lock()
+	onInterrupt = f
+	interruptMu.Unlock()
+}
+
+// interruptible returns a context that is cancelled by the next SIGINT.  A
+// read from stdin waiting for the player during the run returns the
+// context's error when it's cancelled.  The returned function must be called
+// when the run ends, after which SIGINT exits again.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handleInterrupt(cancel)
+	if stdin != nil {
+		stdin.setContext(ctx)
+	}
+	return ctx, func() {
+		handleInterrupt(exitHandler)
+		if stdin != nil {
+			stdin.setContext(nil)
+		}
+		cancel()
+	}
+}
+
+// A cancelReader reads from another reader in the background, so that a Read
+// waiting for data can give up when its context is done.  Data that arrives
+// after a Read gives up goes to the next Read.
+type cancelReader struct {
+	results chan readResult
+
+	mu  sync.Mutex
+	ctx context.Context // nil if reads can't be cancelled
+
+	buf []byte
+	err error
+}
+
+type readResult struct {
+	p   []byte
+	err error
+}
+
+func newCancelReader(r io.Reader) *cancelReader {
+	cr := &cancelReader{results: make(chan readResult)}
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := r.Read(buf)
+			cr.results <- readResult{buf[:n], err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return cr
+}
+
+// setContext sets the context that cancels reads.  nil means reads wait
+// until there's data.
+func (cr *cancelReader) setContext(ctx context.Context) {
+	cr.mu.Lock()
+	cr.ctx = ctx
+	cr.mu.Unlock()
+}
+
+// Read returns data from the underlying reader, or the context's error if
+// the context is done before any arrives.
+func (cr *cancelReader) Read(p []byte) (int, error) {
+	if len(cr.buf) == 0 && cr.err == nil {
+		cr.mu.Lock()
+		ctx := cr.ctx
+		cr.mu.Unlock()
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+		select {
+		case res := <-cr.results:
+			cr.buf, cr.err = res.p, res.err
+		case <-done:
+			return 0, ctx.Err()
+		}
+	}
+	if len(cr.buf) == 0 {
+		return 0, cr.err
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/gonorth/north"
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+var sigint = flag.Bool("sigint", false, "Run tests that send SIGINT to the test process")
+
+func TestInterruptStory(t *testing.T) {
+	if !*sigint {
+		t.Skip("SIGINT tests disabled; run with -sigint")
+	}
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Label("loop")
+	b.Instr("inc", zasm.Const(0x10))
+	b.Instr("jump", zasm.Label("loop"))
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	tu := &terminalUI{TextUI: north.NewTextUI(new(bytes.Buffer), new(bytes.Buffer), 80)}
+	interp, err := north.NewInterpreter(bytes.NewReader(img), tu)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+
+	exits := make(chan struct{}, 1)
+	watchInterrupts(func() { exits <- struct{}{} })
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.Signal(os.Interrupt)
+	}()
+	ctx, done := interruptible()
+	err = interp.RunContext(ctx)
+	done()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext = %v; want %v", err, context.Canceled)
+	}
+	if interp.Machine().Variable(0x10) == 0 {
+		t.Error("story did not run before interrupt")
+	}
+
+	// Outside of a run, SIGINT goes to the exit handler.
+	p.Signal(os.Interrupt)
+	select {
+	case <-exits:
+	case <-time.After(time.Second):
+		t.Error("second SIGINT did not call exit handler")
+	}
+}
+
+func TestCancelReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	cr := newCancelReader(pr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cr.setContext(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cr.Read(make([]byte, 16))
+		errc <- err
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Read after cancel = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read didn't return after cancel")
+	}
+
+	// The next line typed goes to the next read.
+	cr.setContext(nil)
+	go pw.Write([]byte("y\n"))
+	line, err := bufio.NewReader(cr).ReadString('\n')
+	if err != nil || line != "y\n" {
+		t.Errorf("ReadString after cancel = %q, %v; want \"y\\n\"", line, err)
+	}
+}
+
+func TestInterruptInput(t *testing.T) {
+	if !*sigint {
+		t.Skip("SIGINT tests disabled; run with -sigint")
+	}
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	defer func(s *cancelReader, r *bufio.Reader) { stdin, in = s, r }(stdin, in)
+	stdin = newCancelReader(pr)
+	in = bufio.NewReader(stdin)
+	tu := &terminalUI{TextUI: north.NewTextUI(in, new(bytes.Buffer), 80)}
+	interp, err := north.NewInterpreter(bytes.NewReader(img), tu)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+
+	watchInterrupts(func() {})
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.Signal(os.Interrupt)
+	}()
+	ctx, done := interruptible()
+	err = interp.RunContext(ctx)
+	done()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext interrupted during input = %v; want %v", err, context.Canceled)
+	}
+
+	// The story asks for the line again.
+	go pw.Write([]byte("look\n"))
+	if err := interp.Run(); err != nil {
+		t.Error("Run after interrupt:", err)
+	}
+}
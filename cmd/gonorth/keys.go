@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ReadRune reads a key for read_char without echoing it.  When standard
+// input is a terminal, it's taken out of line mode for the read, so the key
+// arrives as soon as it's pressed.
+//
+// To check by hand, run a story with a "press any key" prompt in a terminal:
+// the key shouldn't appear, and the story should continue without Enter.
+func (t *terminalUI) ReadRune() (rune, int, error) {
+	defer keyMode()()
+	defer t.out.inputRead()
+	return t.TextUI.ReadRune()
+}
+
+// keyMode switches a terminal on standard input to reading single keys
+// without echo, and returns a function that restores it.  If standard input
+// isn't a terminal or stty fails, it does nothing.
+func keyMode() (restore func()) {
+	nop := func() {}
+	if !isTerminal(os.Stdin) {
+		return nop
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nop
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nop
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}
+}
+
+// stty runs stty on standard input and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+var breakpoints []north.Address
+var marked *mark
+var m *north.Machine
+var in *bufio.Reader
+var ui *terminalUI
+
+func main() {
+	stdin = newCancelReader(os.Stdin)
+	in = bufio.NewReader(stdin)
+
+	debug := flag.Bool("debug", false, "Run story in debugger")
+	dumpStory := flag.Bool("dump", false, "Print information about the story instead of running it")
+	dumpWords := flag.Bool("dump-words", false, "With -dump, list every dictionary word")
+	dumpObjects := flag.Bool("dump-objects", false, "With -dump, print the object tree")
+	dumpCode := flag.String("dump-code", "", "With -dump, disassemble the routine at this hex address")
+	flag.String("transcript", "", "Append the story's transcript to this file")
+	flag.Bool("autoquit", false, "Answer \"quit\" when input runs out")
+	flag.String("savedir", "", "Keep the story's files in a directory for it here, relative to the XDG data directory")
+	flag.Bool("paging", false, "Stop after each screenful of output until Enter is pressed")
+	flag.Bool("strict", false, "Stop on problems in the story instead of working around them")
+	flag.Duration("throttle", 0, "Pause this long after each line of output")
+	linear := flag.Bool("linear", false, "Read the status line out as a line of text before each prompt, for screen readers")
+	patchFile := flag.String("patch", "", "Apply the story-file patch in this file before running")
+	flameProfile := flag.String("flameprofile", "", "Write a folded-stack profile of the story's routines to this file")
+	remember := flag.Bool("remember", false, "Save the -transcript, -autoquit, -savedir, -paging, -strict, and -throttle flags as this story's settings")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("usage: gonorth [options] FILE")
+		os.Exit(2)
+	}
+
+	if *dumpStory {
+		opts := dumpOptions{Words: *dumpWords, Objects: *dumpObjects}
+		if *dumpCode != "" {
+			a, err := strconv.ParseUint(strings.TrimPrefix(*dumpCode, "0x"), 16, 32)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "bad -dump-code address:", *dumpCode)
+				os.Exit(2)
+			}
+			opts.Code = north.Address(a)
+		}
+		interp, err := openStory(flag.Arg(0), newTerminalUI())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := dump(os.Stdout, interp.Machine(), opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ui = newTerminalUI()
+	interp, err := openStory(flag.Arg(0), ui, north.Linear(*linear))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	m = interp.Machine()
+	if h := m.Header(); m.ImageSize() < h.FileLength {
+		fmt.Fprintf(os.Stderr, "** Warning: story file is truncated (%d of %d bytes)\n", m.ImageSize(), h.FileLength)
+	}
+	if *patchFile != "" {
+		if err := applyPatch(interp, *patchFile); err != nil {
+			fmt.Fprintln(os.Stderr, "patch:", err)
+			os.Exit(1)
+		}
+	}
+	if md := m.Metadata(); md != nil && md.Title != "" && isTerminal(os.Stdout) {
+		fmt.Print(terminalTitle(md.Title))
+	}
+	cfg, err := storyConfig(m.IFID(), *remember)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+	ui.transcriptPath = cfg.Transcript
+	ui.storyDir, err = saveDir(cfg, m.IFID(), flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+	if cfg.AutoQuit != nil {
+		interp.AutoQuit = *cfg.AutoQuit
+	}
+	if cfg.Strict != nil {
+		m.SetStrict(*cfg.Strict)
+	}
+	if cfg.Paging != nil && *cfg.Paging {
+		ui.out.height = screenHeight()
+	}
+	if ui.out.delay, err = cfg.throttle(); err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+	if *flameProfile != "" {
+		m.EnableStackProfile(profileInterval)
+	}
+	watchInterrupts(func() {
+		interp.Close()
+		writeFlameProfile(*flameProfile)
+		fmt.Println()
+		os.Exit(130)
+	})
+
+	if !*debug {
+		err := runStory(interp)
+		writeFlameProfile(*flameProfile)
+		switch {
+		case err == nil:
+			os.Exit(0)
+		case errors.Is(err, north.ErrReturnFromMain), err == north.ErrNoFrame:
+			fmt.Fprintln(os.Stderr, "** The story ended unexpectedly.")
+			os.Exit(1)
+		default:
+			fmt.Fprintln(os.Stderr, "** Internal Error:", err)
+			if events := north.ErrorEvents(err); len(events) > 0 {
+				fmt.Fprintln(os.Stderr, "** Recent events:")
+				for _, e := range events {
+					fmt.Fprintln(os.Stderr, "\t"+e.String())
+				}
+			}
+			os.Exit(1)
+		}
+	} else {
+		fmt.Println("Version is:", m.Version())
+		for {
+			err = debugPrompt()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// runStory runs interp until the story ends.  On SIGINT, it asks whether to
+// quit and resumes the story if not.  A line the player was typing when
+// interrupted is dropped, and the story asks for it again.
+func runStory(interp *north.Interpreter) error {
+	for {
+		ctx, done := interruptible()
+		err := interp.RunContext(ctx)
+		done()
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		in.Discard(in.Buffered())
+		fmt.Print("\nReally quit? (y/n) ")
+		line, err := in.ReadString('\n')
+		if err != nil || strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "y") {
+			return interp.Close()
+		}
+	}
+}
+
+// terminalTitle returns the escape sequence that sets a terminal's title.
+// The title comes from the story file, so it's sanitized like the story's
+// output and kept to one line.
+func terminalTitle(title string) string {
+	title = strings.ReplaceAll(north.Sanitize(title), "\n", " ")
+	return "\x1b]0;" + title + "\x07"
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// profileInterval is the number of instructions between samples for
+// -flameprofile.
+const profileInterval = 100
+
+// writeFlameProfile writes the stack profile to path, unless path is empty.
+func writeFlameProfile(path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "flameprofile:", err)
+		return
+	}
+	err = m.WriteFoldedStacks(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "flameprofile:", err)
+	}
+}
+
+func debugPrompt() error {
+	ui.Flush()
+	fmt.Print("\x1b[31m> \x1b[0m")
+
+	var command string
+	if _, err := fmt.Fscan(in, &command); err != nil {
+		return err
+	}
+
+	switch command {
+	case "n", "next":
+		in.ReadLine()
+		return m.Step()
+	case "b", "break":
+		var a north.Address
+		if _, err := fmt.Fscanf(in, "%x", &a); err != nil {
+			return err
+		}
+		breakpoints = append(breakpoints, a)
+	case "c", "cont", "continue":
+		in.ReadLine()
+		ctx, done := interruptible()
+		defer done()
+		for {
+			if ctx.Err() != nil {
+				fmt.Println("Interrupted at", m.PC())
+				return nil
+			}
+			err := m.Step()
+			if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				// Interrupted while reading input.  The read runs again
+				// when play continues.
+				in.Discard(in.Buffered())
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, bp := range breakpoints {
+				if bp == m.PC() {
+					return nil
+				}
+			}
+		}
+	case "p", "print":
+		m.PrintVariables()
+	case "bt", "backtrace":
+		frames := m.Frames()
+		for i := len(frames) - 1; i >= 0; i-- {
+			f := frames[i]
+			fmt.Printf("#%d %v locals=%v stack=%v\n", len(frames)-1-i, f.PC, f.Locals, f.Stack)
+		}
+	case "v", "var", "variable":
+		var name string
+		if _, err := fmt.Fscanln(in, &name); err != nil {
+			return err
+		}
+		v, err := north.ParseVariableRef(name)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if v == 0 {
+			// Reading sp would pop it, so peek instead.
+			if f := m.Frames(); len(f) > 0 && len(f[len(f)-1].Stack) > 0 {
+				s := f[len(f)-1].Stack
+				fmt.Printf("%v: %v\n", v, s[len(s)-1])
+			} else {
+				fmt.Printf("%v: empty\n", v)
+			}
+			return nil
+		}
+		fmt.Printf("%v: %v\n", v, m.Variable(uint8(v)))
+	case "set":
+		var name string
+		var val north.Word
+		if _, err := fmt.Fscanln(in, &name, &val); err != nil {
+			return err
+		}
+		v, err := north.ParseVariableRef(name)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		if err := m.SetVariable(uint8(v), val); err != nil {
+			fmt.Println(err)
+		}
+	case "w", "word":
+		var a north.Address
+		if _, err := fmt.Fscanf(in, "%x", &a); err != nil {
+			return err
+		}
+		fmt.Println(m.LoadWord(a))
+	case "s", "string":
+		var a north.Address
+		if _, err := fmt.Fscanf(in, "%x", &a); err != nil {
+			return err
+		}
+		if s, err := m.LoadString(a); err == nil {
+			fmt.Printf("%v: %q\n", a, s)
+		} else {
+			fmt.Println("Decode error:", err)
+		}
+	case "i", "instr":
+		var a north.Address
+		if _, err := fmt.Fscanf(in, "%x", &a); err != nil {
+			return err
+		}
+		if inst, n, err := m.InstructionAt(a); err == nil {
+			fmt.Printf("%v: %v (%d bytes)\n", a, inst, n)
+		} else {
+			fmt.Println("Decode error:", err)
+		}
+	case "d", "disas", "disassemble":
+		var start, end north.Address
+		if _, err := fmt.Fscanf(in, "%x %x", &start, &end); err != nil {
+			return err
+		}
+		lines, err := m.DisassembleRange(start, end)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+	case "mark":
+		marked = newMark(m)
+	case "diff":
+		if marked == nil {
+			fmt.Println("No mark set")
+			return nil
+		}
+		marked.diff(os.Stdout, m)
+	case "a", "attrs":
+		var o north.Word
+		if _, err := fmt.Fscanf(in, "%d", &o); err != nil {
+			return err
+		}
+		fmt.Printf("object %d: attributes %v\n", o, m.ObjectAttrs(o))
+	case "q", "quit", "exit":
+		os.Exit(0)
+	default:
+		fmt.Println("Bad command:", command)
+	}
+
+	return nil
+}
+
+func openStory(path string, ui north.UI, opts ...north.Option) (*north.Interpreter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return north.NewInterpreter(f, ui, opts...)
+}
+
+// applyPatch reads the patch at path and applies it to interp.
+func applyPatch(interp *north.Interpreter, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p, err := north.ParsePatch(f)
+	if err != nil {
+		return err
+	}
+	return interp.ApplyPatch(p)
+}
+
+// terminalUI is the text UI on standard input and output, wrapped at the
+// width in $COLUMNS.
+type terminalUI struct {
+	*north.TextUI
+
+	// transcriptPath is the file that transcripts are appended to.  If it's
+	// empty, the player is asked for one.
+	transcriptPath string
+
+	// storyDir is the directory that auxiliary files are kept in.
+	storyDir string
+
+	// out paces the output.  It may be nil if the TextUI writes elsewhere.
+	out *pager
+}
+
+func newTerminalUI() *terminalUI {
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil {
+		width = 80
+	}
+	out := &pager{w: os.Stdout, in: in}
+	t := &terminalUI{TextUI: north.NewTextUI(in, out, width), out: out}
+	t.TerminalEcho = true
+	return t
+}
+
+// Input reads a line, starting a new page of output.
+func (t *terminalUI) Input(n int) ([]rune, error) {
+	defer t.out.inputRead()
+	return t.TextUI.Input(n)
+}
+
+// InputWithPrefill reads the rest of a prefilled line, starting a new page
+// of output.
+func (t *terminalUI) InputWithPrefill(n int, prefill []rune) ([]rune, error) {
+	defer t.out.inputRead()
+	return t.TextUI.InputWithPrefill(n, prefill)
+}
+
+// AskTranscriptFile opens the file that the transcript is appended to,
+// asking the player for its name unless one was configured.  An empty name
+// declines.
+func (t *terminalUI) AskTranscriptFile() (io.WriteCloser, error) {
+	path := t.transcriptPath
+	if path == "" {
+		if err := t.Output(0, "Enter a file name for the transcript: "); err != nil {
+			return nil, err
+		}
+		name, err := t.Input(255)
+		if err != nil {
+			return nil, err
+		}
+		path = strings.TrimSpace(string(name))
+		if path == "" {
+			return nil, nil
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// auxPath returns the path of an auxiliary file in the story's directory,
+// asking the player for it if prompt is true or the story's name for it
+// doesn't reduce to a bare file name.  Names without an extension get ".aux".
+func (t *terminalUI) auxPath(name string, prompt bool) (string, error) {
+	clean := cleanAuxName(name)
+	if prompt || clean == "" {
+		if err := t.Output(0, fmt.Sprintf("Enter a file name [%s]: ", clean)); err != nil {
+			return "", err
+		}
+		r, err := t.Input(255)
+		if err != nil {
+			return "", err
+		}
+		if s := strings.TrimSpace(string(r)); s != "" {
+			clean = cleanAuxName(s)
+			if clean == "" {
+				return "", fmt.Errorf("%q is not a file name", s)
+			}
+		}
+	}
+	if clean == "" {
+		return "", errors.New("no file name")
+	}
+	if filepath.Ext(clean) == "" {
+		clean += ".aux"
+	}
+	return filepath.Join(t.storyDir, clean), nil
+}
+
+// cleanAuxName reduces name to its last element, or returns "" if name is
+// absolute or climbs out of a directory with "..".  Both slashes count as
+// separators, whatever the platform.
+func cleanAuxName(name string) string {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return ""
+	}
+	for _, elem := range strings.FieldsFunc(name, isSlash) {
+		if elem == ".." {
+			return ""
+		}
+	}
+	name = name[strings.LastIndexFunc(name, isSlash)+1:]
+	if name == "." || filepath.VolumeName(name) != "" {
+		return ""
+	}
+	return name
+}
+
+func isSlash(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// SaveAux writes an auxiliary file.
+func (t *terminalUI) SaveAux(name string, prompt bool, data []byte) error {
+	path, err := t.auxPath(name, prompt)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+// RestoreAux reads an auxiliary file.
+func (t *terminalUI) RestoreAux(name string, prompt bool, buf []byte) (int, error) {
+	path, err := t.auxPath(name, prompt)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := io.ReadFull(f, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Warn prints a warning about the story.
+func (t *terminalUI) Warn(msg string) {
+	fmt.Fprintln(os.Stderr, "** Warning:", msg)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+func TestAuxPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		prompt bool
+		input  string
+		want   string
+		asked  bool
+	}{
+		{name: "scores", want: "scores.aux"},
+		{name: "scores.dat", want: "scores.dat"},
+		{name: "sub/scores", want: "scores.aux"},
+		{name: `sub\scores`, want: "scores.aux"},
+		{name: "../../etc/passwd", input: "mine\n", want: "mine.aux", asked: true},
+		{name: `..\secret`, input: "mine\n", want: "mine.aux", asked: true},
+		{name: "/etc/passwd", input: "mine\n", want: "mine.aux", asked: true},
+		{name: "..", input: "mine\n", want: "mine.aux", asked: true},
+		{name: "", input: "mine\n", want: "mine.aux", asked: true},
+		{name: "scores", prompt: true, input: "\n", want: "scores.aux", asked: true},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		tu := &terminalUI{
+			TextUI:   north.NewTextUI(strings.NewReader(test.input), &out, 80),
+			storyDir: dir,
+		}
+		path, err := tu.auxPath(test.name, test.prompt)
+		if err != nil {
+			t.Errorf("auxPath(%q, %t): %v", test.name, test.prompt, err)
+			continue
+		}
+		if want := filepath.Join(dir, test.want); path != want {
+			t.Errorf("auxPath(%q, %t) = %q; want %q", test.name, test.prompt, path, want)
+		}
+		if asked := out.Len() > 0; asked != test.asked {
+			t.Errorf("auxPath(%q, %t) asked the player = %t; want %t", test.name, test.prompt, asked, test.asked)
+		}
+	}
+}
+
+func TestAuxPathRejectsTypedTraversal(t *testing.T) {
+	dir := t.TempDir()
+	for _, input := range []string{"../escape", "/tmp/escape", ".."} {
+		tu := &terminalUI{
+			TextUI:   north.NewTextUI(strings.NewReader(input+"\n"), new(bytes.Buffer), 80),
+			storyDir: dir,
+		}
+		if path, err := tu.auxPath("scores", true); err == nil {
+			t.Errorf("auxPath with %q typed = %q; want error", input, path)
+		}
+	}
+}
+
+func TestSaveAuxStaysInStoryDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "story")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	tu := &terminalUI{
+		TextUI:   north.NewTextUI(strings.NewReader("kept\n"), new(bytes.Buffer), 80),
+		storyDir: dir,
+	}
+	if err := tu.SaveAux("../escaped", false, []byte("data")); err != nil {
+		t.Fatal("SaveAux:", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.aux")); err == nil {
+		t.Error("SaveAux wrote outside the story directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kept.aux")); err != nil {
+		t.Error("SaveAux didn't write the name the player gave:", err)
+	}
+}
+
+func TestTerminalTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Zork", "\x1b]0;Zork\x07"},
+		{"Evil\x07\x1b]0;pwned\x1b\\", "\x1b]0;Evil??]0;pwned?\\\x07"},
+		{"Two\nLines", "\x1b]0;Two Lines\x07"},
+		{"C1\u009dcode", "\x1b]0;C1?code\x07"},
+	}
+	for _, tt := range tests {
+		if got := terminalTitle(tt.title); got != tt.want {
+			t.Errorf("terminalTitle(%q) = %q; want %q", tt.title, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+// A mark is the story state saved by the debugger's mark command, for diff to
+// compare against.
+type mark struct {
+	memory  []byte
+	globals [240]north.Word
+	// parents holds each object's parent, indexed from object 1.
+	parents []north.Word
+}
+
+// newMark saves the state of m.
+func newMark(m *north.Machine) *mark {
+	mk := &mark{memory: m.DynamicMemorySnapshot()}
+	for i := range mk.globals {
+		mk.globals[i] = m.Variable(uint8(0x10 + i))
+	}
+	for i := 1; i <= m.ObjectCount(); i++ {
+		o, err := m.Object(north.Word(i))
+		if err != nil {
+			break
+		}
+		mk.parents = append(mk.parents, o.Parent)
+	}
+	return mk
+}
+
+// diff writes the changes to m since mk: globals, objects that moved, and
+// runs of other bytes labeled with the table that holds them.
+func (mk *mark) diff(w io.Writer, m *north.Machine) {
+	for i, old := range mk.globals {
+		if v := m.Variable(uint8(0x10 + i)); v != old {
+			fmt.Fprintf(w, "%v: %v -> %v\n", north.VariableRef(0x10+i), old, v)
+		}
+	}
+	for i, old := range mk.parents {
+		o, err := m.Object(north.Word(i + 1))
+		if err != nil {
+			break
+		}
+		if o.Parent != old {
+			fmt.Fprintf(w, "object %d %q: moved from %d to %d\n", o.Number, o.Name, old, o.Parent)
+		}
+	}
+	for _, d := range north.MemoryDiff(mk.memory, m.DynamicMemorySnapshot()) {
+		// Split the run where it crosses from one table into another.
+		start := 0
+		region := m.ClassifyAddress(d.Address)
+		for i := 1; i <= len(d.New); i++ {
+			var next north.Region
+			if i < len(d.New) {
+				next = m.ClassifyAddress(d.Address + north.Address(i))
+				if next == region {
+					continue
+				}
+			}
+			if region.Kind != north.GlobalRegion {
+				fmt.Fprintf(w, "%v (%v): % x -> % x\n", d.Address+north.Address(start), region, d.Old[start:i], d.New[start:i])
+			}
+			start, region = i, next
+		}
+	}
+}
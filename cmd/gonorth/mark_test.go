@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/north"
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestMarkDiff(t *testing.T) {
+	b := zasm.New(3)
+	b.Object("kitchen", "", nil)
+	cellar := b.Object("cellar", "", nil)
+	lamp := b.Object("lamp", "kitchen", nil)
+	b.Data("array", make([]byte, 4))
+	b.Routine("main", 0)
+	b.Instr("store", zasm.Const(0x11), zasm.Const(5))
+	b.Instr("insert_obj", zasm.Const(uint16(lamp)), zasm.Const(uint16(cellar)))
+	b.Instr("storeb", zasm.Addr("array"), zasm.Const(1), zasm.Const(7))
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := north.NewMachine(bytes.NewReader(img), nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	array, _ := b.DataAddress("array")
+
+	mk := newMark(m)
+	if err := m.Run(); !errors.Is(err, north.ErrQuit) {
+		t.Fatal("Run:", err)
+	}
+	var buf bytes.Buffer
+	mk.diff(&buf, m)
+	out := buf.String()
+	for _, want := range []string{
+		"g1: 0x0000 -> 0x0005\n",
+		"object 3 \"lamp\": moved from 1 to 2\n",
+		fmt.Sprintf("%v (other): 00 -> 07\n", north.Address(array+1)),
+		"(object 3): 01 -> 02\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff output doesn't contain %q; got:\n%s", want, out)
+		}
+	}
+	// Globals are only shown by number.
+	if strings.Contains(out, "(global") {
+		t.Errorf("diff output shows global bytes; got:\n%s", out)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A pager paces the story's output to w.  With a height, it stops after each
+// screenful of lines until the player presses Enter.  With a delay, it pauses
+// after every line.
+type pager struct {
+	w  io.Writer
+	in *bufio.Reader
+
+	// height is the number of lines on the screen.  Paging is off if it's
+	// less than two.
+	height int
+
+	// delay is the pause after each line.
+	delay time.Duration
+	sleep func(time.Duration)
+
+	// lines is the number of lines written since the player last typed.
+	lines int
+}
+
+// Write writes b to p.w, stopping for the player and pausing as needed.  The
+// pager stops before the first line that doesn't fit, so a prompt on the
+// last line is shown without waiting.
+func (p *pager) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		if p.height > 1 && p.lines >= p.height-1 {
+			if err := p.more(); err != nil {
+				return n, err
+			}
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			nn, err := p.w.Write(b)
+			return n + nn, err
+		}
+		nn, err := p.w.Write(b[:i+1])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		b = b[i+1:]
+		p.lines++
+		if p.delay > 0 {
+			p.pause()
+		}
+	}
+	return n, nil
+}
+
+// more waits for the player to press Enter.
+func (p *pager) more() error {
+	if _, err := io.WriteString(p.w, "[MORE]"); err != nil {
+		return err
+	}
+	if _, err := p.in.ReadString('\n'); err != nil {
+		return err
+	}
+	p.lines = 0
+	return nil
+}
+
+func (p *pager) pause() {
+	if p.sleep != nil {
+		p.sleep(p.delay)
+	} else {
+		time.Sleep(p.delay)
+	}
+}
+
+// inputRead starts a new page, since the player has just typed.  A nil pager
+// does nothing.
+func (p *pager) inputRead() {
+	if p != nil {
+		p.lines = 0
+	}
+}
+
+// screenHeight returns the height of the terminal in $LINES, or 24.
+func screenHeight() int {
+	n, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || n <= 0 {
+		return 24
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPager(t *testing.T) {
+	var out bytes.Buffer
+	in := bufio.NewReader(strings.NewReader("\n\n"))
+	p := &pager{w: &out, in: in, height: 3}
+	if _, err := p.Write([]byte("a\nb\nc\n>")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if s, want := out.String(), "a\nb\n[MORE]c\n>"; s != want {
+		t.Errorf("output = %q; want %q", s, want)
+	}
+	if in.Buffered() != 1 {
+		t.Errorf("pager read %d Enters; want 1", 2-in.Buffered())
+	}
+
+	// Typing starts a new page.
+	out.Reset()
+	p.inputRead()
+	if _, err := p.Write([]byte("d\ne\n")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if s, want := out.String(), "d\ne\n"; s != want {
+		t.Errorf("after input, output = %q; want %q", s, want)
+	}
+}
+
+func TestPagerThrottle(t *testing.T) {
+	var out bytes.Buffer
+	var slept []time.Duration
+	p := &pager{
+		w:     &out,
+		delay: 50 * time.Millisecond,
+		sleep: func(d time.Duration) { slept = append(slept, d) },
+	}
+	if _, err := p.Write([]byte("a\nb\nc")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if out.String() != "a\nb\nc" {
+		t.Errorf("output = %q; want \"a\\nb\\nc\"", out.String())
+	}
+	if len(slept) != 2 || slept[0] != p.delay || slept[1] != p.delay {
+		t.Errorf("slept %v; want two 50ms pauses", slept)
+	}
+}
@@ -0,0 +1,291 @@
+// Command gonorth-gio plays a story in a Gio window.  It draws a gui.Screen:
+// a status bar, the upper window as a fixed grid, and the lower window as
+// scrollback, with a text box below for commands and key presses.
+//
+// Usage:
+//
+//	gonorth-gio STORY
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"gioui.org/app"
+	"gioui.org/font"
+	"gioui.org/font/gofont"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"github.com/zombiezen/gonorth/examples/gui"
+	"github.com/zombiezen/gonorth/north"
+)
+
+// The screen starts at this size in characters, until the first frame says
+// how big the window is.
+const (
+	startWidth  = 80
+	startHeight = 25
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gonorth-gio STORY")
+		os.Exit(2)
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := gui.NewSession(f, startWidth, startHeight)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		w := new(app.Window)
+		w.Option(app.Title(filepath.Base(os.Args[1])), app.Size(unit.Dp(800), unit.Dp(600)))
+		if err := newFrontEnd(s).run(w); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}()
+	app.Main()
+}
+
+// A frontEnd is the widget layer: it draws the session's screen and passes
+// the player's typing to the session.
+type frontEnd struct {
+	session *gui.Session
+	theme   *material.Theme
+	input   widget.Editor
+	lower   widget.List
+
+	// width and height are the screen size last given to the session.
+	width, height int
+
+	// stopped is why the story stopped, once it has.
+	stopped error
+}
+
+func newFrontEnd(s *gui.Session) *frontEnd {
+	th := material.NewTheme()
+	th.Shaper = text.NewShaper(text.WithCollection(gofont.Collection()))
+	fe := &frontEnd{
+		session: s,
+		theme:   th,
+		input:   widget.Editor{SingleLine: true, Submit: true},
+		width:   startWidth,
+		height:  startHeight,
+	}
+	fe.lower.Axis = layout.Vertical
+	fe.lower.ScrollToEnd = true
+	fe.resumed(s.Start())
+	return fe
+}
+
+func (fe *frontEnd) run(w *app.Window) error {
+	var ops op.Ops
+	for {
+		switch e := w.Event().(type) {
+		case app.DestroyEvent:
+			return e.Err
+		case app.FrameEvent:
+			gtx := app.NewContext(&ops, e)
+			fe.resize(gtx)
+			fe.update(gtx)
+			fe.layout(gtx)
+			e.Frame(gtx.Ops)
+		}
+	}
+}
+
+// resumed records the error that a session call returned.
+func (fe *frontEnd) resumed(err error) {
+	if err != nil {
+		fe.stopped = err
+	}
+}
+
+// resize tells the session how many characters fit in the window.  The
+// characters are Go Mono, which is 0.6em wide, on lines 1.2em apart.
+func (fe *frontEnd) resize(gtx layout.Context) {
+	em := gtx.Sp(fe.theme.TextSize)
+	if em <= 0 {
+		return
+	}
+	width := gtx.Constraints.Max.X * 10 / (em * 6)
+	height := gtx.Constraints.Max.Y * 10 / (em * 12)
+	if width > 0 && height > 0 && (width != fe.width || height != fe.height) {
+		fe.width, fe.height = width, height
+		fe.session.Resize(width, height)
+	}
+}
+
+// update passes the player's typing to the session.  A line is sent when the
+// player presses Enter; while the story waits for a key, the first character
+// typed is sent at once.
+func (fe *frontEnd) update(gtx layout.Context) {
+	for {
+		ev, ok := fe.input.Update(gtx)
+		if !ok {
+			break
+		}
+		req := fe.session.Request
+		if req == nil {
+			fe.input.SetText("")
+			continue
+		}
+		switch ev := ev.(type) {
+		case widget.SubmitEvent:
+			fe.input.SetText("")
+			if req.Kind == north.CharInput {
+				fe.resumed(fe.session.Key('\n'))
+			} else {
+				fe.resumed(fe.session.Submit(ev.Text))
+			}
+		case widget.ChangeEvent:
+			if req.Kind != north.CharInput {
+				continue
+			}
+			if r, _ := utf8.DecodeRuneInString(fe.input.Text()); r != utf8.RuneError {
+				fe.input.SetText("")
+				fe.resumed(fe.session.Key(r))
+			}
+		}
+	}
+	if req := fe.session.Request; req != nil && req.Kind == north.LineInput && len(req.Prefill) > 0 && fe.input.Len() == 0 {
+		fe.input.SetText(string(req.Prefill))
+		fe.input.SetCaret(fe.input.Len(), fe.input.Len())
+	}
+	gtx.Execute(key.FocusCmd{Tag: &fe.input})
+}
+
+func (fe *frontEnd) layout(gtx layout.Context) layout.Dimensions {
+	paint.Fill(gtx.Ops, fe.theme.Bg)
+	scr := fe.session.Screen
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if scr.StatusLeft == "" && scr.StatusRight == "" {
+				return layout.Dimensions{}
+			}
+			return fe.statusBar(gtx, scr.StatusLeft, scr.StatusRight)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return fe.upperWindow(gtx, scr)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			return material.List(fe.theme, &fe.lower).Layout(gtx, len(scr.Lower), func(gtx layout.Context, i int) layout.Dimensions {
+				return fe.lowerLine(gtx, scr.Lower[i])
+			})
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(unit.Dp(4)).Layout(gtx, material.Editor(fe.theme, &fe.input, fe.hint()).Layout)
+		}),
+	)
+}
+
+// hint is the text shown in the empty input box.
+func (fe *frontEnd) hint() string {
+	switch {
+	case fe.session.Request == nil && fe.stopped != nil:
+		return fmt.Sprintf("The story has stopped: %v", fe.stopped)
+	case fe.session.Request == nil:
+		return "The story has stopped."
+	case fe.session.Request.Kind == north.CharInput:
+		return "Press a key"
+	default:
+		return "Type a command"
+	}
+}
+
+func (fe *frontEnd) statusBar(gtx layout.Context, left, right string) layout.Dimensions {
+	return layout.Flex{}.Layout(gtx,
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			return fe.label(gtx, left, gui.Reverse)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return fe.label(gtx, right, gui.Reverse)
+		}),
+	)
+}
+
+// upperWindow draws the upper window's grid, one label for each run of cells
+// in the same style.
+func (fe *frontEnd) upperWindow(gtx layout.Context, scr *gui.Screen) layout.Dimensions {
+	rows := make([]layout.FlexChild, len(scr.Upper))
+	for i, row := range scr.Upper {
+		row := row
+		rows[i] = layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			var cells []layout.FlexChild
+			for start := 0; start < len(row); {
+				end := start + 1
+				for end < len(row) && row[end].Style == row[start].Style {
+					end++
+				}
+				s := make([]rune, 0, end-start)
+				for _, c := range row[start:end] {
+					s = append(s, c.R)
+				}
+				style := row[start].Style | gui.Fixed
+				cells = append(cells, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return fe.label(gtx, string(s), style)
+				}))
+				start = end
+			}
+			return layout.Flex{}.Layout(gtx, cells...)
+		})
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, rows...)
+}
+
+func (fe *frontEnd) lowerLine(gtx layout.Context, line []gui.Run) layout.Dimensions {
+	if len(line) == 0 {
+		return fe.label(gtx, " ", 0)
+	}
+	runs := make([]layout.FlexChild, len(line))
+	for i, run := range line {
+		run := run
+		runs[i] = layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return fe.label(gtx, run.Text, run.Style)
+		})
+	}
+	return layout.Flex{}.Layout(gtx, runs...)
+}
+
+// label draws text in a story's text style.
+func (fe *frontEnd) label(gtx layout.Context, s string, style north.Word) layout.Dimensions {
+	l := material.Body1(fe.theme, s)
+	l.Font = font.Font{Typeface: "Go"}
+	if style&gui.Fixed != 0 {
+		l.Font.Typeface = "Go Mono"
+	}
+	if style&gui.Bold != 0 {
+		l.Font.Weight = font.Bold
+	}
+	if style&gui.Italic != 0 {
+		l.Font.Style = font.Italic
+	}
+	if style&gui.Reverse == 0 {
+		return l.Layout(gtx)
+	}
+	l.Color = fe.theme.Bg
+	return layout.Background{}.Layout(gtx,
+		func(gtx layout.Context) layout.Dimensions {
+			size := gtx.Constraints.Min
+			paint.FillShape(gtx.Ops, fe.theme.Fg, clip.Rect{Max: size}.Op())
+			return layout.Dimensions{Size: size}
+		},
+		l.Layout,
+	)
+}
@@ -0,0 +1,18 @@
+module github.com/zombiezen/gonorth/examples/gui
+
+go 1.24.0
+
+require github.com/zombiezen/gonorth v0.0.0
+
+require (
+	gioui.org v0.10.2
+	gioui.org/shader v1.0.9 // indirect
+	github.com/go-text/typesetting v0.3.4 // indirect
+	golang.org/x/exp/shiny v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
+	golang.org/x/image v0.26.0 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+)
+
+replace github.com/zombiezen/gonorth => ../..
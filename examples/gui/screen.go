@@ -0,0 +1,266 @@
+// Package gui is the toolkit-independent half of a windowed front-end.  A
+// Screen keeps the state a GUI draws: a status bar, a fixed grid for the
+// upper window, and scrollback for the lower window, with text styles.  A
+// Session drives a story with Machine.StepUntilInput, so the widget layer
+// only has to draw the Screen and pass keys and lines to the Session.
+//
+// The gonorth-gio command is such a widget layer, written with Gio.  The
+// example is its own module, so the GUI toolkit never becomes a dependency
+// of the interpreter.
+package gui
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+// Text styles, as set by set_text_style
+const (
+	Reverse north.Word = 1 << iota
+	Bold
+	Italic
+	Fixed
+)
+
+// A Cell is one character of the upper window.
+type Cell struct {
+	R     rune
+	Style north.Word
+}
+
+// A Run is lower window text in one style.
+type Run struct {
+	Text  string
+	Style north.Word
+}
+
+// A Screen is a north.UI that records what a GUI should show.  It never
+// blocks for input: stories are run through a Session, which supplies input
+// before the machine asks for it.
+type Screen struct {
+	Width, Height int
+
+	// StatusLeft and StatusRight are the status bar in versions 1-3.
+	StatusLeft, StatusRight string
+
+	// Upper is the upper window, one row per line.
+	Upper [][]Cell
+
+	// Lower is the lower window's scrollback.  The last line is the one
+	// being printed.
+	Lower [][]Run
+
+	// Row and Col are the upper window cursor, counting from 0.
+	Row, Col int
+
+	// CursorHidden is set while the story hides the cursor.
+	CursorHidden bool
+
+	style    north.Word
+	buffered bool
+}
+
+// NewScreen returns an empty screen of the given size in characters.
+func NewScreen(width, height int) *Screen {
+	return &Screen{Width: width, Height: height, Lower: make([][]Run, 1), buffered: true}
+}
+
+// Output adds text to a window.
+func (s *Screen) Output(window int, text string) error {
+	if window == 0 {
+		s.outputLower(text)
+	} else {
+		s.outputUpper(text)
+	}
+	return nil
+}
+
+func (s *Screen) outputLower(text string) {
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			s.Lower = append(s.Lower, nil)
+		}
+		if line == "" {
+			continue
+		}
+		last := &s.Lower[len(s.Lower)-1]
+		if n := len(*last); n > 0 && (*last)[n-1].Style == s.style {
+			(*last)[n-1].Text += line
+		} else {
+			*last = append(*last, Run{line, s.style})
+		}
+	}
+}
+
+func (s *Screen) outputUpper(text string) {
+	for _, r := range text {
+		if r == '\n' {
+			s.Row++
+			s.Col = 0
+			continue
+		}
+		if s.Row < len(s.Upper) && s.Col < s.Width {
+			s.Upper[s.Row][s.Col] = Cell{r, s.style}
+		}
+		s.Col++
+	}
+}
+
+// LowerText returns the lower window's scrollback without styles.
+func (s *Screen) LowerText() string {
+	var sb strings.Builder
+	for i, line := range s.Lower {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		for _, run := range line {
+			sb.WriteString(run.Text)
+		}
+	}
+	return sb.String()
+}
+
+// UpperText returns row i of the upper window without styles.  Trailing
+// blanks are dropped.
+func (s *Screen) UpperText(i int) string {
+	var sb strings.Builder
+	for _, c := range s.Upper[i] {
+		sb.WriteRune(c.R)
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// StatusLine sets the status bar.
+func (s *Screen) StatusLine(left, right string) error {
+	s.StatusLeft, s.StatusRight = left, right
+	return nil
+}
+
+// SplitWindow resizes the upper window, keeping the rows that still fit.
+func (s *Screen) SplitWindow(lines int) error {
+	if lines > s.Height {
+		lines = s.Height
+	}
+	for len(s.Upper) < lines {
+		s.Upper = append(s.Upper, s.blankRow())
+	}
+	s.Upper = s.Upper[:lines]
+	if s.Row >= lines {
+		s.Row, s.Col = 0, 0
+	}
+	return nil
+}
+
+func (s *Screen) blankRow() []Cell {
+	row := make([]Cell, s.Width)
+	for i := range row {
+		row[i] = Cell{R: ' '}
+	}
+	return row
+}
+
+// SetCursor moves the upper window cursor.  Stories only move the cursor in
+// the upper window.
+func (s *Screen) SetCursor(line, column int) error {
+	s.Row, s.Col = line-1, column-1
+	return nil
+}
+
+// SetCursorVisible shows or hides the cursor.
+func (s *Screen) SetCursorVisible(visible bool) error {
+	s.CursorHidden = !visible
+	return nil
+}
+
+// EraseWindow clears a window, or both if window is -1.
+func (s *Screen) EraseWindow(window int) error {
+	if window != 0 {
+		for i := range s.Upper {
+			s.Upper[i] = s.blankRow()
+		}
+		s.Row, s.Col = 0, 0
+	}
+	if window != 1 {
+		s.Lower = make([][]Run, 1)
+	}
+	return nil
+}
+
+// EraseLine clears the upper window from the cursor to the end of the line.
+func (s *Screen) EraseLine() error {
+	if s.Row >= len(s.Upper) {
+		return nil
+	}
+	for i := s.Col; i < s.Width; i++ {
+		s.Upper[s.Row][i] = Cell{R: ' '}
+	}
+	return nil
+}
+
+// SetTextStyle changes the style of text that follows.
+func (s *Screen) SetTextStyle(style north.Word) error {
+	if style == 0 {
+		s.style = 0
+	} else {
+		s.style |= style
+	}
+	return nil
+}
+
+// SetBufferMode records whether lower window text may be word-wrapped.  The
+// widget layer wraps the scrollback as it draws it.
+func (s *Screen) SetBufferMode(buffered bool) {
+	s.buffered = buffered
+}
+
+// Buffered reports whether lower window text may be word-wrapped.
+func (s *Screen) Buffered() bool {
+	return s.buffered
+}
+
+// ResizeScreen changes the screen size after the window is resized.  Upper
+// window rows are cut or padded to the new width.
+func (s *Screen) ResizeScreen(width, height int) {
+	s.Width, s.Height = width, height
+	for i, row := range s.Upper {
+		if len(row) >= width {
+			s.Upper[i] = row[:width]
+			continue
+		}
+		for len(row) < width {
+			row = append(row, Cell{R: ' '})
+		}
+		s.Upper[i] = row
+	}
+	if len(s.Upper) > height {
+		s.SplitWindow(height)
+	}
+}
+
+// ScreenSize returns the screen size in characters.
+func (s *Screen) ScreenSize() (width, height int) {
+	return s.Width, s.Height
+}
+
+// ReadRune returns io.EOF.  A Session submits input before it's read.
+func (s *Screen) ReadRune() (rune, int, error) {
+	return 0, 0, io.EOF
+}
+
+// Input returns io.EOF.  A Session submits input before it's read.
+func (s *Screen) Input(n int) ([]rune, error) {
+	return nil, io.EOF
+}
+
+// Save fails; the example has nowhere to keep saved games.
+func (s *Screen) Save(m *north.Machine) error {
+	return errors.New("Saving is not supported")
+}
+
+// Restore does nothing.
+func (s *Screen) Restore(m *north.Machine) error {
+	return nil
+}
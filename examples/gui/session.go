@@ -0,0 +1,63 @@
+package gui
+
+import (
+	"io"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+// A Session runs a story on a Screen.  The widget layer calls Start once,
+// then Submit or Key whenever the player finishes a line or presses a key,
+// redrawing the Screen after each call.
+type Session struct {
+	Machine *north.Machine
+	Screen  *Screen
+
+	// Request is the input the story is waiting for, or nil if it has
+	// stopped.
+	Request *north.InputRequest
+}
+
+// NewSession loads a story onto a new screen of the given size.
+func NewSession(story io.Reader, width, height int) (*Session, error) {
+	s := NewScreen(width, height)
+	m, err := north.NewMachine(story, s)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Machine: m, Screen: s}, nil
+}
+
+// Start runs the story until it first asks for input.
+func (s *Session) Start() error {
+	return s.resume()
+}
+
+// Submit sends a line of input and runs the story until it asks for more.
+func (s *Session) Submit(line string) error {
+	s.Machine.SubmitInput(line)
+	return s.resume()
+}
+
+// Key sends a single key press and runs the story until it asks for more.
+func (s *Session) Key(r rune) error {
+	if r == '\n' || r == '\r' {
+		// An empty line is read as a carriage return.
+		s.Machine.SubmitInput("")
+	} else {
+		s.Machine.SubmitInput(string(r))
+	}
+	return s.resume()
+}
+
+// Resize tells the story the window now holds width by height characters.
+// The widget layer calls it when the window is resized.
+func (s *Session) Resize(width, height int) {
+	s.Machine.UpdateScreenSize(width, height)
+}
+
+func (s *Session) resume() error {
+	req, err := s.Machine.StepUntilInput()
+	s.Request = req
+	return err
+}
@@ -0,0 +1,84 @@
+package gui
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/zombiezen/gonorth/north"
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestSession(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("split_window", zasm.Const(2))
+	b.Instr("set_window", zasm.Const(1))
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(3))
+	b.Instr("set_text_style", zasm.Const(2))
+	b.Instr("print", zasm.Text("Room"))
+	b.Instr("set_text_style", zasm.Const(0))
+	b.Instr("set_window", zasm.Const(0))
+	b.Instr("print", zasm.Text("Hello"))
+	b.Instr("new_line")
+	b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.Global(0)))
+	b.Instr("print_char", zasm.Global(0))
+	b.Instr("set_window", zasm.Const(1))
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(4))
+	b.Instr("erase_line", zasm.Const(1))
+	b.Instr("set_window", zasm.Const(0))
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("erase_window", zasm.Large(0xffff))
+	b.Instr("quit")
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	s, err := NewSession(bytes.NewReader(img), 20, 10)
+	if err != nil {
+		t.Fatal("NewSession:", err)
+	}
+	if w := s.Machine.Capabilities().ScreenWidth; w != 20 {
+		t.Errorf("screen width = %d; want 20", w)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	if s.Request == nil || s.Request.Kind != north.CharInput {
+		t.Fatalf("request after Start = %+v; want character input", s.Request)
+	}
+	if row := s.Screen.UpperText(0); row != "  Room" {
+		t.Errorf("upper row 0 = %q; want \"  Room\"", row)
+	}
+	if c := s.Screen.Upper[0][2]; c != (Cell{'R', Bold}) {
+		t.Errorf("upper cell (0, 2) = %+v; want bold R", c)
+	}
+	if text := s.Screen.LowerText(); text != "Hello\n" {
+		t.Errorf("lower window = %q; want \"Hello\\n\"", text)
+	}
+	if run := s.Screen.Lower[0][0]; run.Style != 0 {
+		t.Errorf("lower window style = %d; want roman", run.Style)
+	}
+
+	if err := s.Key('x'); err != nil {
+		t.Fatal("Key:", err)
+	}
+	if s.Request == nil || s.Request.Kind != north.LineInput {
+		t.Fatalf("request after Key = %+v; want line input", s.Request)
+	}
+	if text := s.Screen.LowerText(); text != "Hello\nx" {
+		t.Errorf("lower window = %q; want \"Hello\\nx\"", text)
+	}
+	if row := s.Screen.UpperText(0); row != "  R" {
+		t.Errorf("upper row 0 after erase_line = %q; want \"  R\"", row)
+	}
+
+	if err := s.Submit("look"); !errors.Is(err, north.ErrQuit) {
+		t.Errorf("Submit = %v; want quit", err)
+	}
+	if len(s.Screen.Upper) != 0 || s.Screen.LowerText() != "" {
+		t.Errorf("screen after erase_window -1 = %d upper rows, lower %q; want empty", len(s.Screen.Upper), s.Screen.LowerText())
+	}
+}
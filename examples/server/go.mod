@@ -0,0 +1,9 @@
+module github.com/zombiezen/gonorth/examples/server
+
+go 1.24.0
+
+require github.com/zombiezen/gonorth v0.0.0
+
+require golang.org/x/net v0.48.0
+
+replace github.com/zombiezen/gonorth => ../..
@@ -0,0 +1,484 @@
+// Package server is an example of hosting many players' stories in one
+// process.  Each session owns a Machine that is driven a turn at a time with
+// Machine.StepUntilInputContext, and is held to the Limits given to New.
+// Sessions left idle are saved with Machine.SaveState and unloaded, and are
+// restored on their next message.  Each loaded session keeps a
+// Machine.Snapshot from before each of its recent turns, so players can take
+// turns back.
+//
+// Players connect with a websocket.  A connection to /play starts a session,
+// and a connection to /play?id=ID rejoins one.  Session IDs are random, so
+// knowing one's own ID doesn't give away anyone else's.  The server sends a reply as
+// JSON at the end of every turn, and the client sends messages:
+//
+//	{"type": "input", "text": "look"}  submits a line or key and runs a turn
+//	{"type": "undo"}                   goes back to before the last turn
+//	{"type": "resume"}                 carries on a turn that timed out
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zombiezen/gonorth/north"
+	"golang.org/x/net/websocket"
+)
+
+// Limits bounds the resources each session may use.  Zero means no limit.
+type Limits struct {
+	// Instructions is the most instructions a session may execute, over
+	// its whole life.
+	Instructions int64
+	// Turns is the most inputs a session may submit.
+	Turns int
+	// TurnTimeout is how long one turn may run.  A turn that runs out of
+	// time is stopped, and carries on when the player resumes it.
+	TurnTimeout time.Duration
+	// IdleTimeout is how long a session may go without a message before it
+	// is saved and unloaded.
+	IdleTimeout time.Duration
+	// SavedTimeout is how long an unloaded session's state is kept.  Once
+	// it expires, the session can't be rejoined.
+	SavedTimeout time.Duration
+	// Sessions is the most sessions, loaded or saved, the server holds at
+	// once.  New players are turned away while it's full.
+	Sessions int
+	// UndoLevels is the number of states the story's own save_undo keeps.
+	// Zero means the north default.
+	UndoLevels int
+	// UndoMemory is the most bytes of snapshots a session keeps for players
+	// to undo turns with.  The oldest are dropped first.  Zero turns undo
+	// off.
+	UndoMemory int
+}
+
+// A Server hosts sessions of one story.  It is an http.Handler.
+type Server struct {
+	story  []byte
+	limits Limits
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	saved    map[string]*savedSession
+}
+
+// New returns a server for the story file in story.
+func New(story []byte, limits Limits) *Server {
+	return &Server{
+		story:    story,
+		limits:   limits,
+		sessions: make(map[string]*session),
+		saved:    make(map[string]*savedSession),
+	}
+}
+
+// A session is a loaded story.  mu is held while a turn runs.
+type session struct {
+	id string
+
+	mu       sync.Mutex
+	m        *north.Machine
+	ui       *outputUI
+	turns    int
+	spent    int64  // instructions executed before the machine was loaded
+	input    string // the reply's Input at the end of the last turn
+	undo     []*north.Snapshot
+	last     time.Time
+	unloaded bool
+	idle     *time.Timer
+}
+
+// A savedSession is an unloaded session.  Its undo snapshots are dropped.
+type savedSession struct {
+	state []byte
+	turns int
+	spent int64
+	input string
+}
+
+// A message is sent by the client.
+type message struct {
+	// Type is "input", "undo", or "resume".
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+// A reply is sent by the server at the end of each turn.
+type reply struct {
+	ID     string `json:"id"`
+	Output string `json:"output"`
+	// Input is "line" or "char" while the story is waiting for input.
+	Input string `json:"input,omitempty"`
+	// TimedOut is set when the turn ran out of time.  Output holds what
+	// the story printed before it was stopped.
+	TimedOut bool   `json:"timedOut,omitempty"`
+	Ended    bool   `json:"ended,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ServeHTTP handles websocket connections to /play.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/play" {
+		http.NotFound(w, r)
+		return
+	}
+	websocket.Handler(s.play).ServeHTTP(w, r)
+}
+
+// play talks to one player until the connection closes or the story ends.
+func (s *Server) play(ws *websocket.Conn) {
+	ctx := ws.Request().Context()
+	var out reply
+	id := ws.Request().URL.Query().Get("id")
+	if id == "" {
+		sess, err := s.start()
+		if err != nil {
+			websocket.JSON.Send(ws, reply{Error: err.Error()})
+			return
+		}
+		id = sess.id
+		out = s.run(ctx, sess)
+		sess.mu.Unlock()
+	} else {
+		sess, err := s.lock(id)
+		if err == nil && sess == nil {
+			err = errors.New("no such session")
+		}
+		if err != nil {
+			websocket.JSON.Send(ws, reply{ID: id, Error: err.Error()})
+			return
+		}
+		out = reply{ID: id, Input: sess.input}
+		sess.mu.Unlock()
+	}
+
+	for {
+		if err := websocket.JSON.Send(ws, out); err != nil || out.Ended {
+			return
+		}
+		var msg message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		sess, err := s.lock(id)
+		if err == nil && sess == nil {
+			err = errors.New("no such session")
+		}
+		if err != nil {
+			websocket.JSON.Send(ws, reply{ID: id, Error: err.Error()})
+			return
+		}
+		out = s.handle(ctx, sess, msg)
+		sess.mu.Unlock()
+	}
+}
+
+// handle acts on a message for sess, which must be locked.
+func (s *Server) handle(ctx context.Context, sess *session, msg message) reply {
+	switch msg.Type {
+	case "input":
+		if s.limits.Turns > 0 && sess.turns >= s.limits.Turns {
+			return reply{ID: sess.id, Input: sess.input, Error: "turn limit reached"}
+		}
+		sess.turns++
+		s.saveUndo(sess)
+		sess.m.SubmitInput(strings.TrimRight(msg.Text, "\r\n"))
+	case "undo":
+		if len(sess.undo) == 0 {
+			return reply{ID: sess.id, Input: sess.input, Error: "nothing to undo"}
+		}
+		snap := sess.undo[len(sess.undo)-1]
+		sess.undo = sess.undo[:len(sess.undo)-1]
+		if err := sess.m.RestoreSnapshot(snap); err != nil {
+			return reply{ID: sess.id, Input: sess.input, Error: err.Error()}
+		}
+	case "resume":
+	default:
+		return reply{ID: sess.id, Input: sess.input, Error: "unknown message type " + strconv.Quote(msg.Type)}
+	}
+	return s.run(ctx, sess)
+}
+
+// saveUndo snapshots sess before a turn, dropping the oldest snapshots
+// to stay within the undo memory limit.
+func (s *Server) saveUndo(sess *session) {
+	max := s.limits.UndoMemory
+	if max <= 0 {
+		return
+	}
+	sess.undo = append(sess.undo, sess.m.Snapshot())
+	total := 0
+	for i := len(sess.undo) - 1; i >= 0; i-- {
+		total += sess.undo[i].Size()
+		if total > max {
+			sess.undo = append(sess.undo[:0], sess.undo[i+1:]...)
+			break
+		}
+	}
+}
+
+// errFull is returned when the server holds Limits.Sessions sessions.
+var errFull = errors.New("too many sessions")
+
+// start loads a new session and returns it locked.
+func (s *Server) start() (*session, error) {
+	// Check before loading too, so that turning players away is cheap.
+	s.mu.Lock()
+	full := s.full()
+	s.mu.Unlock()
+	if full {
+		return nil, errFull
+	}
+	sess, err := s.load(nil)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.full() {
+		return nil, errFull
+	}
+	for sess.id == "" || s.sessions[sess.id] != nil || s.saved[sess.id] != nil {
+		if sess.id, err = newID(); err != nil {
+			return nil, err
+		}
+	}
+	s.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// full reports whether the server can't take another session.  s.mu must be
+// held.
+func (s *Server) full() bool {
+	n := s.limits.Sessions
+	return n > 0 && len(s.sessions)+len(s.saved) >= n
+}
+
+// newID returns a random session ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lock returns session id with its mutex held, loading it first if it was
+// unloaded.  It returns nil if there is no such session.
+func (s *Server) lock(id string) (*session, error) {
+	for {
+		s.mu.Lock()
+		sess := s.sessions[id]
+		if sess == nil {
+			saved := s.saved[id]
+			if saved == nil {
+				s.mu.Unlock()
+				return nil, nil
+			}
+			var err error
+			sess, err = s.load(saved)
+			if err != nil {
+				s.mu.Unlock()
+				return nil, err
+			}
+			sess.id = id
+			delete(s.saved, id)
+			s.sessions[id] = sess
+		}
+		s.mu.Unlock()
+
+		sess.mu.Lock()
+		if !sess.unloaded {
+			return sess, nil
+		}
+		// The session was unloaded while we waited; its state is saved now.
+		sess.mu.Unlock()
+	}
+}
+
+// load starts a session's machine, restoring saved if it isn't nil.
+func (s *Server) load(saved *savedSession) (*session, error) {
+	sess := &session{ui: new(outputUI)}
+	var spent int64
+	if saved != nil {
+		sess.turns, spent, sess.input = saved.turns, saved.spent, saved.input
+	}
+	opts, _ := s.options(spent)
+	m, err := north.NewMachine(bytes.NewReader(s.story), sess.ui, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		if err := m.RestoreState(bytes.NewReader(saved.state)); err != nil {
+			return nil, err
+		}
+	}
+	sess.m, sess.spent = m, spent
+	return sess, nil
+}
+
+// options returns the machine options for a session that has already
+// executed spent instructions, or false if it may not execute any more.
+func (s *Server) options(spent int64) ([]north.Option, bool) {
+	opts := []north.Option{north.UndoLevels(s.limits.UndoLevels)}
+	if n := s.limits.Instructions; n > 0 {
+		if spent >= n {
+			return nil, false
+		}
+		// InstructionLimit counts from the load, so only allow what's left.
+		opts = append(opts, north.InstructionLimit(n-spent))
+	}
+	return opts, true
+}
+
+// run runs a turn of sess, which must be locked, and returns the reply.
+func (s *Server) run(ctx context.Context, sess *session) reply {
+	if s.limits.TurnTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.limits.TurnTimeout)
+		defer cancel()
+	}
+	req, err := sess.m.StepUntilInputContext(ctx)
+	for errors.Is(err, north.ErrRestart) {
+		sess.spent += sess.m.Steps()
+		opts, ok := s.options(sess.spent)
+		if !ok {
+			err = &north.TerminationError{Reason: north.LimitReached}
+			break
+		}
+		if err = sess.m.Load(bytes.NewReader(s.story), opts...); err == nil {
+			req, err = sess.m.StepUntilInputContext(ctx)
+		}
+	}
+	out := reply{ID: sess.id, Output: sess.ui.take()}
+	var term *north.TerminationError
+	switch {
+	case err == nil && req.Kind == north.CharInput:
+		out.Input = "char"
+	case err == nil:
+		out.Input = "line"
+	case errors.As(err, &term) && term.Reason == north.Canceled:
+		// The turn stopped between instructions, so it can carry on.
+		out.TimedOut = true
+	case errors.As(err, &term):
+		out.Ended = true
+	default:
+		out.Ended = true
+		out.Error = err.Error()
+	}
+	sess.input = out.Input
+	if out.Ended {
+		s.end(sess)
+	} else {
+		s.wait(sess)
+	}
+	return out
+}
+
+// wait starts sess's idle timer.
+func (s *Server) wait(sess *session) {
+	sess.last = time.Now()
+	if s.limits.IdleTimeout <= 0 {
+		return
+	}
+	if sess.idle == nil {
+		sess.idle = time.AfterFunc(s.limits.IdleTimeout, func() { s.unload(sess) })
+	} else {
+		sess.idle.Reset(s.limits.IdleTimeout)
+	}
+}
+
+// unload saves sess and drops its machine, unless it has been used since its
+// idle timer was set.
+func (s *Server) unload(sess *session) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.unloaded || time.Since(sess.last) < s.limits.IdleTimeout {
+		return
+	}
+	spent := sess.spent + sess.m.Steps()
+	if _, ok := s.options(spent); !ok {
+		// The session can't run again, so there's nothing to save.
+		s.end(sess)
+		return
+	}
+	var state bytes.Buffer
+	if err := sess.m.SaveState(&state); err != nil {
+		// Keep the session loaded rather than lose it.
+		return
+	}
+	saved := &savedSession{state: state.Bytes(), turns: sess.turns, spent: spent, input: sess.input}
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.saved[sess.id] = saved
+	s.mu.Unlock()
+	sess.unloaded = true
+	if d := s.limits.SavedTimeout; d > 0 {
+		id := sess.id
+		time.AfterFunc(d, func() { s.expire(id, saved) })
+	}
+}
+
+// expire drops the saved state of session id, unless the session has been
+// loaded again since it was saved.
+func (s *Server) expire(id string, saved *savedSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved[id] == saved {
+		delete(s.saved, id)
+	}
+}
+
+// end removes sess, which has stopped for good.
+func (s *Server) end(sess *session) {
+	if sess.idle != nil {
+		sess.idle.Stop()
+	}
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+	sess.unloaded = true
+}
+
+// Unloaded reports whether session id is saved and unloaded.
+func (s *Server) Unloaded(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saved[id] != nil
+}
+
+// outputUI is the UI of a session.  It collects lower window text until the
+// end of the turn, and never reads input: sessions submit input before the
+// story asks for it.
+type outputUI struct {
+	sb strings.Builder
+}
+
+func (ui *outputUI) Output(window int, text string) error {
+	if window == 0 {
+		ui.sb.WriteString(text)
+	}
+	return nil
+}
+
+// take returns the text output since the last call.
+func (ui *outputUI) take() string {
+	s := ui.sb.String()
+	ui.sb.Reset()
+	return s
+}
+
+func (ui *outputUI) ReadRune() (rune, int, error)   { return 0, 0, io.EOF }
+func (ui *outputUI) Input(n int) ([]rune, error)    { return nil, io.EOF }
+func (ui *outputUI) Save(m *north.Machine) error    { return errors.New("Saving is not supported") }
+func (ui *outputUI) Restore(m *north.Machine) error { return nil }
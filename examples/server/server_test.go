@@ -0,0 +1,340 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/gonorth/zasm"
+	"golang.org/x/net/websocket"
+)
+
+// counterStory greets the player, then prints the number of each line it
+// reads until it reads one starting with "q".
+func counterStory(t *testing.T) []byte {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("Hi"))
+	b.Instr("new_line")
+	b.Label("loop")
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.Global(1)))
+	b.Instr("loadb", zasm.Addr("text"), zasm.Const(2), zasm.Store(zasm.SP))
+	b.Instr("je", zasm.SP, zasm.Const('q'), zasm.IfTrue("quit"))
+	b.Instr("inc", zasm.Const(0x10))
+	b.Instr("print_num", zasm.Global(0))
+	b.Instr("new_line")
+	b.Instr("jump", zasm.Label("loop"))
+	b.Label("quit")
+	b.Instr("quit")
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+	return build(t, b)
+}
+
+// loopStory prints a line and never stops.
+func loopStory(t *testing.T) []byte {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("Thinking"))
+	b.Instr("new_line")
+	b.Label("loop")
+	b.Instr("jump", zasm.Label("loop"))
+	return build(t, b)
+}
+
+func build(t *testing.T, b *zasm.Builder) []byte {
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	return img
+}
+
+// A client is one player's connection.
+type client struct {
+	ws *websocket.Conn
+}
+
+// dial connects to the server at url, rejoining session id if it isn't
+// empty, and returns the first reply.
+func dial(url, id string) (*client, reply, error) {
+	url = "ws" + strings.TrimPrefix(url, "http") + "/play"
+	if id != "" {
+		url += "?id=" + id
+	}
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		return nil, reply{}, err
+	}
+	c := &client{ws}
+	r, err := c.receive()
+	if err != nil {
+		ws.Close()
+		return nil, reply{}, err
+	}
+	return c, r, nil
+}
+
+// send sends a message and returns the reply.
+func (c *client) send(msg message) (reply, error) {
+	if err := websocket.JSON.Send(c.ws, msg); err != nil {
+		return reply{}, err
+	}
+	return c.receive()
+}
+
+func (c *client) receive() (reply, error) {
+	var r reply
+	err := websocket.JSON.Receive(c.ws, &r)
+	return r, err
+}
+
+func input(text string) message {
+	return message{Type: "input", Text: text}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{Instructions: 10000, UndoLevels: 2, UndoMemory: 4096}))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for p := 0; p < 2; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			c, r, err := dial(srv.URL, "")
+			if err != nil || r.Output != "Hi\n" || r.Input != "line" {
+				t.Errorf("player %d: start = %+v, %v; want greeting and line input", p, r, err)
+				return
+			}
+			defer c.ws.Close()
+			id := r.ID
+			for turn := 1; turn <= 5; turn++ {
+				line := fmt.Sprintf("look %d", p)
+				r, err := c.send(input(line))
+				if want := fmt.Sprintf("%s\n%d\n", line, turn); err != nil || r.Output != want {
+					t.Errorf("player %d: turn %d = %+v, %v; want output %q", p, turn, r, err, want)
+					return
+				}
+			}
+			if r, err := c.send(input("quit")); err != nil || !r.Ended {
+				t.Errorf("player %d: quit = %+v, %v; want ended", p, r, err)
+			}
+			if _, r, err := dial(srv.URL, id); err != nil || r.Error == "" {
+				t.Errorf("player %d: rejoin after quit = %+v, %v; want error", p, r, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+func TestIdleSessionUnloaded(t *testing.T) {
+	s := New(counterStory(t), Limits{IdleTimeout: 10 * time.Millisecond})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	c, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	if _, err := c.send(input("look")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Unloaded(r.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("session wasn't unloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The restored session carries on counting.
+	if r, err := c.send(input("look")); err != nil || r.Output != "look\n2\n" {
+		t.Errorf("turn after unload = %+v, %v; want output \"look\\n2\\n\"", r, err)
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{}))
+	defer srv.Close()
+	c, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ws.Close()
+	c, r, err = dial(srv.URL, r.ID)
+	if err != nil || r.Input != "line" || r.Error != "" {
+		t.Fatalf("rejoin = %+v, %v; want line input", r, err)
+	}
+	defer c.ws.Close()
+	if r, err := c.send(input("look")); err != nil || r.Output != "look\n1\n" {
+		t.Errorf("turn after rejoin = %+v, %v; want output \"look\\n1\\n\"", r, err)
+	}
+}
+
+func TestSessionIDs(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{}))
+	defer srv.Close()
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		c, r, err := dial(srv.URL, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ws.Close()
+		if len(r.ID) != 32 || seen[r.ID] {
+			t.Errorf("session %d ID = %q; want 32 new hex digits", i, r.ID)
+		}
+		seen[r.ID] = true
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{Sessions: 1}))
+	defer srv.Close()
+	c, _, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	c2, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.ws.Close()
+	if r.Error != errFull.Error() {
+		t.Errorf("second session = %+v; want error %q", r, errFull)
+	}
+}
+
+func TestSavedSessionExpires(t *testing.T) {
+	s := New(counterStory(t), Limits{IdleTimeout: 10 * time.Millisecond, SavedTimeout: 10 * time.Millisecond})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	c, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Unloaded(r.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("session wasn't unloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for s.Unloaded(r.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("saved session didn't expire")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c, r, err = dial(srv.URL, r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	if r.Error != "no such session" {
+		t.Errorf("rejoin after expiry = %+v; want error \"no such session\"", r)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{UndoMemory: 1 << 20}))
+	defer srv.Close()
+	c, _, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := c.send(input("look")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if r, err := c.send(message{Type: "undo"}); err != nil || r.Input != "line" || r.Error != "" {
+			t.Fatalf("undo %d = %+v, %v; want line input", i, r, err)
+		}
+	}
+	if r, err := c.send(message{Type: "undo"}); err != nil || r.Error == "" {
+		t.Errorf("undo past the first turn = %+v, %v; want error", r, err)
+	}
+	if r, err := c.send(input("look")); err != nil || r.Output != "look\n1\n" {
+		t.Errorf("turn after undo = %+v, %v; want output \"look\\n1\\n\"", r, err)
+	}
+}
+
+func TestUndoMemory(t *testing.T) {
+	s := New(counterStory(t), Limits{UndoMemory: 1})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	c, _, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	if _, err := c.send(input("look")); err != nil {
+		t.Fatal(err)
+	}
+	// Every snapshot is bigger than a byte, so none is kept.
+	if r, err := c.send(message{Type: "undo"}); err != nil || r.Error == "" {
+		t.Errorf("undo with no room for snapshots = %+v, %v; want error", r, err)
+	}
+}
+
+func TestTurnLimit(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{Turns: 2}))
+	defer srv.Close()
+	c, _, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	for turn := 1; turn <= 3; turn++ {
+		r, err := c.send(input("look"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limited := r.Error != ""; limited != (turn == 3) {
+			t.Errorf("turn %d = %+v; want limited = %t", turn, r, turn == 3)
+		}
+	}
+}
+
+func TestInstructionLimit(t *testing.T) {
+	srv := httptest.NewServer(New(counterStory(t), Limits{Instructions: 20}))
+	defer srv.Close()
+	c, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	for turn := 1; !r.Ended; turn++ {
+		if turn > 10 {
+			t.Fatal("session didn't end")
+		}
+		if r, err = c.send(input("look")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTurnTimeout(t *testing.T) {
+	srv := httptest.NewServer(New(loopStory(t), Limits{TurnTimeout: 10 * time.Millisecond}))
+	defer srv.Close()
+	c, r, err := dial(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.ws.Close()
+	if !r.TimedOut || r.Output != "Thinking\n" || r.Input != "" || r.Ended {
+		t.Errorf("start = %+v; want timed out with output \"Thinking\\n\"", r)
+	}
+	// The output was sent, so resuming has none.
+	if r, err := c.send(message{Type: "resume"}); err != nil || !r.TimedOut || r.Output != "" {
+		t.Errorf("resume = %+v, %v; want timed out with no output", r, err)
+	}
+}
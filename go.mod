@@ -0,0 +1,3 @@
+module github.com/zombiezen/gonorth
+
+go 1.18
@@ -0,0 +1,98 @@
+package north
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestAssembleRoundTrip(t *testing.T) {
+	tests := []struct {
+		Version  byte
+		Mnemonic string
+		Args     []zasm.Arg
+		Expected string
+	}{
+		{3, "add", []zasm.Arg{zasm.Local(1), zasm.Const(3), zasm.Store(zasm.SP)}, "add\tlocal1 0x0003 -> sp"},
+		{3, "sub", []zasm.Arg{zasm.Large(1000), zasm.Global(0), zasm.Store(zasm.Local(2))}, "sub\t0x03e8 g0 -> local2"},
+		{3, "je", []zasm.Arg{zasm.SP, zasm.Const(4), zasm.Const(5), zasm.Branch("next")}, "je\tsp 0x0004 0x0005 ?(+2)"},
+		{3, "jz", []zasm.Arg{zasm.Local(1), zasm.IfFalse(zasm.ReturnFalse)}, "jz\tlocal1 ?~(+0)"},
+		{3, "jl", []zasm.Arg{zasm.Local(1), zasm.Const(0), zasm.IfTrue(zasm.ReturnTrue)}, "jl\tlocal1 0x0000 ?(+1)"},
+		{3, "inc", []zasm.Arg{zasm.Const(0x10)}, "inc\t0x0010"},
+		{3, "jump", []zasm.Arg{zasm.Label("next")}, "jump\t0x0002"},
+		{3, "get_child", []zasm.Arg{zasm.Const(1), zasm.Store(zasm.SP), zasm.Branch("next")}, "get_child\t0x0001 -> sp ?(+2)"},
+		{3, "not", []zasm.Arg{zasm.Local(3), zasm.Store(zasm.SP)}, "not\tlocal3 -> sp"},
+		{3, "pop", nil, "pop\t"},
+		{3, "save", []zasm.Arg{zasm.Branch("next")}, "save\t ?(+2)"},
+		{3, "print", []zasm.Arg{zasm.Text("Hello")}, "print\t \"Hello\""},
+		{3, "sread", []zasm.Arg{zasm.Const(0x40), zasm.Const(0x80)}, "sread\t0x0040 0x0080"},
+		{3, "storew", []zasm.Arg{zasm.Large(0x300), zasm.Const(0), zasm.Large(0x1234)}, "storew\t0x0300 0x0000 0x1234"},
+		{3, "put_prop", []zasm.Arg{zasm.Const(1), zasm.Const(5), zasm.SP}, "put_prop\t0x0001 0x0005 sp"},
+		{5, "not", []zasm.Arg{zasm.Local(3), zasm.Store(zasm.SP)}, "not\tlocal3 -> sp"},
+		{5, "call_1n", []zasm.Arg{zasm.Const(0)}, "call_1n\t0x0000"},
+		{5, "catch", []zasm.Arg{zasm.Store(zasm.SP)}, "catch\t -> sp"},
+		{5, "aread", []zasm.Arg{zasm.Const(0x40), zasm.Const(0x80), zasm.Store(zasm.SP)}, "aread\t0x0040 0x0080 -> sp"},
+		{5, "call_vn2", []zasm.Arg{zasm.Const(0), zasm.Const(1), zasm.Const(2), zasm.Const(3), zasm.Const(4), zasm.Const(5)}, "call_vn2\t0x0000 0x0001 0x0002 0x0003 0x0004 0x0005"},
+		{5, "save", []zasm.Arg{zasm.Store(zasm.SP)}, "save\t -> sp"},
+		{5, "log_shift", []zasm.Arg{zasm.Local(1), zasm.Large(0xfffe), zasm.Store(zasm.SP)}, "log_shift\tlocal1 0xfffe -> sp"},
+		{5, "scan_table", []zasm.Arg{zasm.Const(1), zasm.Large(0x200), zasm.Const(3), zasm.Store(zasm.SP), zasm.IfFalse("next")}, "scan_table\t0x0001 0x0200 0x0003 -> sp ?~(+2)"},
+		{8, "check_arg_count", []zasm.Arg{zasm.Const(2), zasm.Branch(zasm.ReturnTrue)}, "check_arg_count\t0x0002 ?(+1)"},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		b.Instr(tt.Mnemonic, tt.Args...)
+		b.Label("next")
+		b.Instr("quit")
+		img, err := b.Build()
+		if err != nil {
+			t.Errorf("v%d %s: build: %v", tt.Version, tt.Mnemonic, err)
+			continue
+		}
+		pc := Address(img[6])<<8 | Address(img[7])
+		in, err := decodeInstruction(bytes.NewReader(img[pc:]), StandardAlphabetSet, nil, tt.Version)
+		if err != nil {
+			t.Errorf("v%d %s: decode: %v", tt.Version, tt.Mnemonic, err)
+			continue
+		}
+		if s := fmt.Sprint(in); s != tt.Expected {
+			t.Errorf("v%d %s: disassembly != %q (got %q)", tt.Version, tt.Mnemonic, tt.Expected, s)
+		}
+	}
+}
+
+func TestAssembleMnemonics(t *testing.T) {
+	for _, version := range []byte{3, 4, 5, 8} {
+		for _, name := range zasm.Mnemonics(version) {
+			b := zasm.New(version)
+			b.Routine("main", 0)
+			count, _, _ := zasm.Lookup(name, version)
+			switch count {
+			case zasm.OP1:
+				b.Instr(name, zasm.Const(1))
+			case zasm.OP2:
+				b.Instr(name, zasm.Const(1), zasm.Const(2))
+			default:
+				b.Instr(name)
+			}
+			img, err := b.Build()
+			if err != nil {
+				t.Errorf("v%d %s: build: %v", version, name, err)
+				continue
+			}
+			// The decoder reads any store and branch bytes from the padding
+			// that follows, which is fine for checking names.
+			pc := Address(img[6])<<8 | Address(img[7])
+			in, err := decodeInstruction(bytes.NewReader(append(img[pc:], 0, 0, 0, 0, 0x80, 0)), StandardAlphabetSet, nil, version)
+			if err != nil {
+				t.Errorf("v%d %s: decode: %v", version, name, err)
+				continue
+			}
+			if in.Name() != name {
+				t.Errorf("v%d %s: decoded as %s", version, name, in.Name())
+			}
+		}
+	}
+}
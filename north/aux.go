@@ -0,0 +1,72 @@
+package north
+
+import "fmt"
+
+// auxArgs returns the region and file name given to the auxiliary forms of
+// save and restore: table, bytes, name and prompt.  name is the address of a
+// length byte followed by the characters of the name, or 0 for no name.
+func (m *Machine) auxArgs(ops []Word) (table Address, size int, name string, prompt bool, err error) {
+	for len(ops) < 4 {
+		ops = append(ops, 0)
+	}
+	table, size = Address(ops[0]), int(ops[1])
+	if int(table)+size > len(m.memory) {
+		return 0, 0, "", false, &MemoryError{Address: table, Size: size}
+	}
+	if a := Address(ops[2]); a != 0 {
+		n := int(m.loadByte(a))
+		if int(a)+1+n > len(m.memory) {
+			return 0, 0, "", false, &MemoryError{Address: a, Size: n + 1}
+		}
+		name = string(m.memory[a+1 : int(a)+1+n])
+	}
+	return table, size, name, ops[3] != 0, nil
+}
+
+// saveAux writes a region of memory to an auxiliary file, as save does when
+// it has operands.  It returns 1 on success and 0 on failure, including when
+// the UI isn't an AuxFiler.
+func (m *Machine) saveAux(ops []Word) (Word, error) {
+	table, size, name, prompt, err := m.auxArgs(ops)
+	if err != nil {
+		return 0, err
+	}
+	af, ok := m.ui.(AuxFiler)
+	if !ok {
+		return 0, nil
+	}
+	data := append([]byte(nil), m.memory[table:int(table)+size]...)
+	if err := af.SaveAux(name, prompt, data); err != nil {
+		m.warn("Saving auxiliary file %q: %v", name, err)
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// restoreAux reads an auxiliary file into a region of dynamic memory, as
+// restore does when it has operands.  It returns the number of bytes read,
+// which is 0 on failure.
+func (m *Machine) restoreAux(ops []Word) (Word, error) {
+	table, size, name, prompt, err := m.auxArgs(ops)
+	if err != nil {
+		return 0, err
+	}
+	if int(table)+size > int(m.staticMemoryBase()) {
+		return 0, fmt.Errorf("Auxiliary file table at %v is not in dynamic memory", table)
+	}
+	af, ok := m.ui.(AuxFiler)
+	if !ok {
+		return 0, nil
+	}
+	buf := make([]byte, size)
+	n, err := af.RestoreAux(name, prompt, buf)
+	if err != nil {
+		m.warn("Restoring auxiliary file %q: %v", name, err)
+		return 0, nil
+	}
+	if n > size {
+		n = size
+	}
+	m.storeBytes(table, buf[:n])
+	return Word(n), nil
+}
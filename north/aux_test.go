@@ -0,0 +1,87 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// auxUI is a bufferUI that keeps auxiliary files in memory.
+type auxUI struct {
+	bufferUI
+	files  map[string][]byte
+	prompt bool
+}
+
+func (ui *auxUI) SaveAux(name string, prompt bool, data []byte) error {
+	ui.files[name] = append([]byte(nil), data...)
+	ui.prompt = prompt
+	return nil
+}
+
+func (ui *auxUI) RestoreAux(name string, prompt bool, buf []byte) (int, error) {
+	data, ok := ui.files[name]
+	if !ok {
+		return 0, errors.New("no such file")
+	}
+	ui.prompt = prompt
+	return copy(buf, data), nil
+}
+
+func TestAuxSaveRestore(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("save", zasm.Addr("region"), zasm.Const(4), zasm.Addr("name"), zasm.Const(1), zasm.Store(zasm.Global(0)))
+	b.Instr("restore", zasm.Addr("dest"), zasm.Const(6), zasm.Addr("name"), zasm.Const(0), zasm.Store(zasm.Global(1)))
+	b.Instr("restore", zasm.Addr("dest"), zasm.Const(6), zasm.Addr("other"), zasm.Const(0), zasm.Store(zasm.Global(2)))
+	b.Instr("quit")
+	b.Data("region", []byte{1, 2, 3, 4})
+	b.Data("dest", make([]byte, 6))
+	b.Data("name", []byte{5, 'S', 'C', 'O', 'R', 'E'})
+	b.Data("other", []byte{1, 'X'})
+	ui := &auxUI{files: make(map[string][]byte)}
+	m := buildMachine(t, b, ui)
+	m.SetVariable(0x12, 0xffff)
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if data := ui.files["SCORE"]; !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+		t.Errorf("auxiliary file SCORE = %v; want [1 2 3 4]", data)
+	}
+	if ui.prompt {
+		t.Error("restore asked for a prompt")
+	}
+	if g := m.Variable(0x10); g != 1 {
+		t.Errorf("save result = %d; want 1", g)
+	}
+	if g := m.Variable(0x11); g != 4 {
+		t.Errorf("restore result = %d; want 4", g)
+	}
+	if g := m.Variable(0x12); g != 0 {
+		t.Errorf("restore of missing file result = %d; want 0", g)
+	}
+	a, _ := b.DataAddress("dest")
+	if got, want := m.memory[a:a+6], []byte{1, 2, 3, 4, 0, 0}; !bytes.Equal(got, want) {
+		t.Errorf("restored region = %v; want %v", got, want)
+	}
+}
+
+func TestAuxWithoutFiler(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("save", zasm.Addr("region"), zasm.Const(4), zasm.Const(0), zasm.Const(0), zasm.Store(zasm.Global(0)))
+	b.Instr("quit")
+	b.Data("region", []byte{1, 2, 3, 4})
+	m := buildMachine(t, b, new(bufferUI))
+	m.SetVariable(0x10, 0xffff)
+	if err := m.Step(); err != nil {
+		t.Fatal("Step:", err)
+	}
+	if g := m.Variable(0x10); g != 0 {
+		t.Errorf("save result = %d; want 0", g)
+	}
+}
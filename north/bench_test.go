@@ -0,0 +1,166 @@
+package north
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// scriptUI is a bufferUI that answers every Input with the same line.
+type scriptUI struct {
+	bufferUI
+	Line   string
+	Inputs int
+}
+
+func (ui *scriptUI) Input(n int) ([]rune, error) {
+	ui.Inputs++
+	r := []rune(ui.Line)
+	if len(r) > n {
+		r = r[:n]
+	}
+	return r, nil
+}
+
+// buildMachine assembles a story with b and loads it with ui and opts.
+func buildMachine(tb testing.TB, b *zasm.Builder, ui UI, opts ...Option) *Machine {
+	img, err := b.Build()
+	if err != nil {
+		tb.Fatal("build story:", err)
+	}
+	m, err := NewMachine(bytes.NewReader(img), ui, opts...)
+	if err != nil {
+		tb.Fatal("load story:", err)
+	}
+	return m
+}
+
+// benchmarkSteps runs n steps of m per iteration.
+func benchmarkSteps(b *testing.B, m *Machine, n int) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < n; j++ {
+			if err := m.Step(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkStepArithmetic(b *testing.B) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Op(zasm.VAR, 0x00, zasm.Routine("loop"), zasm.Store(zasm.SP)) // call_vs
+	zb.Op(zasm.OP0, 0x0a)                                            // quit
+	zb.Routine("loop", 2)
+	zb.Label("top")
+	zb.Op(zasm.OP2, 0x14, zasm.Local(1), zasm.Const(3), zasm.Store(zasm.Local(1))) // add
+	zb.Op(zasm.OP2, 0x15, zasm.Local(1), zasm.Local(2), zasm.Store(zasm.Local(2))) // sub
+	zb.Op(zasm.OP2, 0x16, zasm.Local(2), zasm.Const(7), zasm.Store(zasm.SP))       // mul
+	zb.Op(zasm.OP2, 0x18, zasm.SP, zasm.Large(1000), zasm.Store(zasm.Local(2)))    // mod
+	zb.Op(zasm.OP1, 0x0c, zasm.Label("top"))                                       // jump
+	m := buildMachine(b, zb, new(bufferUI))
+	benchmarkSteps(b, m, 5)
+}
+
+func BenchmarkStepCalls(b *testing.B) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Label("top")
+	zb.Op(zasm.VAR, 0x00, zasm.Routine("double"), zasm.Const(21), zasm.Store(zasm.SP)) // call_vs
+	zb.Op(zasm.VAR, 0x19, zasm.Routine("nothing"), zasm.SP)                            // call_vn
+	zb.Op(zasm.OP1, 0x0c, zasm.Label("top"))                                           // jump
+	zb.Routine("double", 1)
+	zb.Op(zasm.OP2, 0x14, zasm.Local(1), zasm.Local(1), zasm.Store(zasm.SP)) // add
+	zb.Op(zasm.OP0, 0x08)                                                    // ret_popped
+	zb.Routine("nothing", 1)
+	zb.Op(zasm.OP0, 0x00) // rtrue
+	m := buildMachine(b, zb, new(bufferUI))
+	benchmarkSteps(b, m, 6)
+}
+
+// benchmarkWords is the vocabulary for BenchmarkTokenise and BenchmarkFullTurn.
+var benchmarkWords = []string{
+	"a", "again", "all", "an", "and", "answer", "attack", "brass", "break",
+	"bring", "burn", "but", "climb", "close", "down", "drink", "drop", "east",
+	"eat", "enter", "examine", "except", "fill", "get", "give", "go", "in",
+	"inventory", "jump", "kill", "lamp", "lantern", "leave", "light", "listen",
+	"look", "move", "north", "northeast", "northwest", "open", "pick", "pray",
+	"pull", "push", "put", "read", "south", "take", "the", "up", "wait", "west",
+}
+
+func BenchmarkTokenise(b *testing.B) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Op(zasm.OP0, 0x0a) // quit
+	zb.DictWord(benchmarkWords...)
+	zb.Data("parse", append([]byte{16}, make([]byte, 65)...))
+	m := buildMachine(b, zb, new(bufferUI))
+	dict, err := m.dictionary(m.dictionaryAddress())
+	if err != nil {
+		b.Fatal(err)
+	}
+	a, _ := zb.DataAddress("parse")
+	parse := Address(a)
+	input := []rune("take the brass lantern, then go northeast and examine xyzzy")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.storeByte(parse, 16)
+		m.tokenise(input, dict, parse, true)
+	}
+}
+
+// newTurnMachine returns a machine running a story that reads a command,
+// looks up its first word, and prints a response each turn.
+func newTurnMachine(tb testing.TB) (*Machine, *scriptUI) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Label("turn")
+	zb.Op(zasm.OP0, 0x02, zasm.Text(">"))                                                 // print
+	zb.Op(zasm.VAR, 0x02, zasm.Addr("text"), zasm.Const(1), zasm.Const(0))                // storeb
+	zb.Op(zasm.VAR, 0x04, zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))     // aread
+	zb.Op(zasm.OP2, 0x0f, zasm.Addr("parse"), zasm.Const(1), zasm.Store(zasm.Global(0)))  // loadw
+	zb.Op(zasm.OP2, 0x01, zasm.Global(0), zasm.DictAddr("take"), zasm.IfFalse("unknown")) // je
+	zb.Op(zasm.OP1, 0x0d, zasm.Packed("taken"))                                           // print_paddr
+	zb.Op(zasm.OP1, 0x0c, zasm.Label("turn"))                                             // jump
+	zb.Label("unknown")
+	zb.Op(zasm.OP1, 0x0d, zasm.Packed("huh")) // print_paddr
+	zb.Op(zasm.OP1, 0x0c, zasm.Label("turn")) // jump
+	zb.String("taken", "Taken.\n")
+	zb.String("huh", "I don't know the word \"xyzzy\".\n")
+	zb.DictWord(benchmarkWords...)
+	zb.Data("text", append([]byte{80}, make([]byte, 81)...))
+	zb.Data("parse", append([]byte{16}, make([]byte, 65)...))
+	ui := &scriptUI{Line: "take brass lantern"}
+	return buildMachine(tb, zb, ui), ui
+}
+
+func BenchmarkFullTurn(b *testing.B) {
+	m, ui := newTurnMachine(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for n := ui.Inputs; ui.Inputs == n; {
+			if err := m.Step(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ui.Reset()
+	}
+}
+
+func TestFullTurn(t *testing.T) {
+	m, ui := newTurnMachine(t)
+	for ui.Inputs < 2 {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := ui.String(); out != ">take brass lantern\nTaken.\n>take brass lantern\n" {
+		t.Errorf("output = %q; want \">take brass lantern\\nTaken.\\n>take brass lantern\\n\"", out)
+	}
+}
@@ -0,0 +1,321 @@
+package north
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type instForm uint8
+
+const (
+	longForm instForm = iota
+	shortForm
+	variableForm
+	extendedForm
+)
+
+// A decodedInst is an instruction of any form, decoded for execution.  The
+// machine reuses a single decodedInst for every step, so it must not be
+// retained after the step finishes; use instruction to get a copy.
+type decodedInst struct {
+	// pc is the address of the instruction, if it was decoded by Step.
+	pc Address
+
+	form    instForm
+	version uint8
+	opcode  uint8
+	types   uint16
+	nops    int
+
+	operands [8]Word
+	values   [8]Word
+
+	store         bool
+	storeVariable uint8
+	hasBranch     bool
+	branch        branchInfo
+	text          string
+}
+
+// An instReader reads instruction bytes, either directly from memory or from
+// an io.Reader.
+type instReader struct {
+	mem []byte
+	pos Address
+	r   io.Reader
+}
+
+func (ir *instReader) readByte() (byte, error) {
+	if ir.r != nil {
+		var b [1]byte
+		_, err := io.ReadFull(ir.r, b[:])
+		return b[0], err
+	}
+	if int(ir.pos) >= len(ir.mem) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := ir.mem[ir.pos]
+	ir.pos++
+	return b, nil
+}
+
+func (ir *instReader) readWord() (Word, error) {
+	hi, err := ir.readByte()
+	if err != nil {
+		return 0, err
+	}
+	lo, err := ir.readByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return Word(hi)<<8 | Word(lo), nil
+}
+
+// readText reads an inline string.
+func (ir *instReader) readText(alphaset AlphabetSet, u Unabbreviater) (string, error) {
+	if ir.r != nil {
+		return decodeString(ir.r, alphaset, true, u)
+	}
+	start := ir.pos
+	for {
+		if int(ir.pos)+2 > len(ir.mem) {
+			return "", io.ErrUnexpectedEOF
+		}
+		end := ir.mem[ir.pos]&0x80 != 0
+		ir.pos += 2
+		if end {
+			break
+		}
+	}
+	return decodeString(bytes.NewReader(ir.mem[start:ir.pos]), alphaset, true, u)
+}
+
+// decode reads an instruction from ir into d.
+func (d *decodedInst) decode(ir *instReader, version uint8, alphaset AlphabetSet, u Unabbreviater) error {
+	*d = decodedInst{version: version}
+	op, err := ir.readByte()
+	if err != nil {
+		return err
+	}
+
+	// Opcode and operand types
+	switch {
+	case op == 0xbe:
+		if op, err = ir.readByte(); err != nil {
+			return err
+		}
+		types, err := ir.readByte()
+		if err != nil {
+			return err
+		}
+		d.form, d.opcode, d.types = extendedForm, op, uint16(types)<<8|0xff
+	case op == 0xec || op == 0xfa:
+		// call_vs2 and call_vn2
+		types, err := ir.readWord()
+		if err != nil {
+			return err
+		}
+		d.form, d.opcode, d.types = variableForm, op, uint16(types)
+	case op&0xc0 == 0xc0:
+		types, err := ir.readByte()
+		if err != nil {
+			return err
+		}
+		d.form, d.opcode, d.types = variableForm, op, uint16(types)<<8|0xff
+	case op&0xc0 == 0x80:
+		d.form, d.opcode = shortForm, op
+		d.types = uint16(op>>4&0x3)<<14 | 0x3fff
+	default:
+		d.form, d.opcode = longForm, op
+		t0, t1 := smallConstantOperand, smallConstantOperand
+		if op&0x40 != 0 {
+			t0 = variableOperand
+		}
+		if op&0x20 != 0 {
+			t1 = variableOperand
+		}
+		d.types = uint16(t0)<<14 | uint16(t1)<<12 | 0x0fff
+	}
+
+	// Operands
+	for ; d.nops < len(d.operands); d.nops++ {
+		t := d.operandType(d.nops)
+		if t == omittedOperand {
+			break
+		}
+		if t == largeConstantOperand {
+			d.operands[d.nops], err = ir.readWord()
+		} else {
+			var b byte
+			b, err = ir.readByte()
+			d.operands[d.nops] = Word(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Store variable
+	if d.store = d.storesResult(); d.store {
+		if d.storeVariable, err = ir.readByte(); err != nil {
+			return err
+		}
+	}
+
+	// Branch info
+	if d.hasBranch = d.branches(); d.hasBranch {
+		b, err := ir.readByte()
+		if err != nil {
+			return err
+		}
+		if b&0x40 == 0 {
+			lo, err := ir.readByte()
+			if err != nil {
+				return err
+			}
+			d.branch = branchInfo(b)<<8 | branchInfo(lo)
+		} else {
+			d.branch = branchInfo(b) << 8
+		}
+	}
+
+	// Text
+	if d.form == shortForm && (d.opcode == 0xb2 || d.opcode == 0xb3) {
+		if d.text, err = ir.readText(alphaset, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decodedInst) operandType(i int) operandType {
+	return operandType(d.types >> (14 - uint(i)*2) & 0x3)
+}
+
+// storesResult reports whether the instruction has a store variable.  The
+// rules are the same as the instruction types'.
+func (d *decodedInst) storesResult() bool {
+	var ok bool
+	switch d.form {
+	case longForm:
+		_, ok = longInstruction{version: d.version, opcode: d.opcode}.StoreVariable()
+	case shortForm:
+		_, ok = shortInstruction{version: d.version, opcode: d.opcode}.StoreVariable()
+	case variableForm:
+		_, ok = variableInstruction{version: d.version, opcode: d.opcode}.StoreVariable()
+	case extendedForm:
+		_, ok = extendedInstruction{version: d.version, opcode: d.opcode}.StoreVariable()
+	}
+	return ok
+}
+
+// branches reports whether the instruction has branch info.
+func (d *decodedInst) branches() bool {
+	var ok bool
+	switch d.form {
+	case longForm:
+		_, ok = longInstruction{version: d.version, opcode: d.opcode}.BranchInfo()
+	case shortForm:
+		_, ok = shortInstruction{version: d.version, opcode: d.opcode}.BranchInfo()
+	case variableForm:
+		_, ok = variableInstruction{version: d.version, opcode: d.opcode}.BranchInfo()
+	case extendedForm:
+		_, ok = extendedInstruction{version: d.version, opcode: d.opcode}.BranchInfo()
+	}
+	return ok
+}
+
+func (d *decodedInst) Opcode() uint16 {
+	if d.form == extendedForm {
+		return 0xbe00 | uint16(d.opcode)
+	}
+	return uint16(d.opcode)
+}
+
+func (d *decodedInst) OpcodeNumber() uint8 {
+	switch d.form {
+	case shortForm:
+		return d.opcode & 0x0f
+	case extendedForm:
+		return d.opcode
+	}
+	return d.opcode & 0x1f
+}
+
+func (d *decodedInst) is2OP() bool {
+	return d.form == longForm || d.form == variableForm && d.opcode&0x20 == 0
+}
+
+func (d *decodedInst) NOperand() int {
+	return d.nops
+}
+
+func (d *decodedInst) Operand(i int) (Word, operandType) {
+	if i < 0 || i >= d.nops {
+		return 0, omittedOperand
+	}
+	return d.operands[i], d.operandType(i)
+}
+
+func (d *decodedInst) StoreVariable() (uint8, bool) {
+	return d.storeVariable, d.store
+}
+
+func (d *decodedInst) BranchInfo() (branchInfo, bool) {
+	return d.branch, d.hasBranch
+}
+
+func (d *decodedInst) Name() string {
+	return d.instruction().Name()
+}
+
+func (d *decodedInst) String() string {
+	// The form-specific types know how to show inline text.
+	return fmt.Sprint(d.instruction())
+}
+
+// instruction returns a copy of d as one of the form-specific instruction
+// types.
+func (d *decodedInst) instruction() instruction {
+	switch d.form {
+	case longForm:
+		return &longInstruction{
+			version:       d.version,
+			opcode:        d.opcode,
+			operands:      [2]uint8{uint8(d.operands[0]), uint8(d.operands[1])},
+			storeVariable: d.storeVariable,
+			branch:        d.branch,
+		}
+	case shortForm:
+		return &shortInstruction{
+			version:       d.version,
+			opcode:        d.opcode,
+			operand:       d.operands[0],
+			storeVariable: d.storeVariable,
+			branch:        d.branch,
+			text:          d.text,
+		}
+	case variableForm:
+		return &variableInstruction{
+			version:       d.version,
+			opcode:        d.opcode,
+			types:         d.types,
+			operands:      d.operands,
+			storeVariable: d.storeVariable,
+			branch:        d.branch,
+		}
+	}
+	ei := &extendedInstruction{
+		version:       d.version,
+		opcode:        d.opcode,
+		types:         uint8(d.types >> 8),
+		storeVariable: d.storeVariable,
+		branch:        d.branch,
+	}
+	copy(ei.operands[:], d.operands[:])
+	return ei
+}
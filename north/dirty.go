@@ -0,0 +1,83 @@
+package north
+
+// Memory is divided into pages for tracking which parts of it have been
+// written to.  Each page records the generation it was last written in, so
+// callers can find what changed since any earlier generation.
+const (
+	dirtyPageShift = 9
+	dirtyPageSize  = 1 << dirtyPageShift
+)
+
+// resetDirtyPages clears all write tracking.  It must be called whenever the
+// memory slice is replaced.
+func (m *Machine) resetDirtyPages() {
+	m.pageGen = make([]uint32, (len(m.memory)+dirtyPageSize-1)/dirtyPageSize)
+	m.gen = 1
+}
+
+// markGeneration starts a new generation and returns the one that just ended.
+// Passing the result to dirtyPagesSince gives the pages written afterward.
+func (m *Machine) markGeneration() uint32 {
+	g := m.gen
+	m.gen++
+	return g
+}
+
+// dirtyPagesSince returns the indices of the pages written to after
+// generation gen ended, in ascending order.
+func (m *Machine) dirtyPagesSince(gen uint32) []int {
+	var pages []int
+	for i, g := range m.pageGen {
+		if g > gen {
+			pages = append(pages, i)
+		}
+	}
+	return pages
+}
+
+// storeBytes copies b into memory starting at a.
+func (m *Machine) storeBytes(a Address, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	copy(m.memory[a:], b)
+	end := int(a) + len(b) - 1
+	if end >= len(m.memory) {
+		end = len(m.memory) - 1
+	}
+	for p := int(a) >> dirtyPageShift; p <= end>>dirtyPageShift; p++ {
+		m.pageGen[p] = m.gen
+	}
+	if a <= flags2Game && int(flags2Game) <= end {
+		m.flags2Changed()
+	}
+}
+
+// A pageDelta holds copies of memory pages, keyed by page index.
+type pageDelta map[int][]byte
+
+// deltaSince copies the pages of dynamic memory written to after generation
+// gen ended.
+func (m *Machine) deltaSince(gen uint32) pageDelta {
+	d := make(pageDelta)
+	n := int(m.staticMemoryBase())
+	for _, p := range m.dirtyPagesSince(gen) {
+		start := p << dirtyPageShift
+		if start >= n {
+			break
+		}
+		end := start + dirtyPageSize
+		if end > n {
+			end = n
+		}
+		d[p] = append([]byte(nil), m.memory[start:end]...)
+	}
+	return d
+}
+
+// apply writes the pages in d over mem.
+func (d pageDelta) apply(mem []byte) {
+	for p, b := range d {
+		copy(mem[p<<dirtyPageShift:], b)
+	}
+}
@@ -0,0 +1,162 @@
+package north
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testVAR returns a VAR-form instruction with large constant operands.  2OP
+// opcodes can be given by passing n|0xc0 as the opcode.
+func testVAR(opcode uint8, ops ...Word) *decodedInst {
+	in := &variableInstruction{version: 3, opcode: opcode, types: 0xffff}
+	for i, o := range ops {
+		in.types &^= 3 << (14 - uint(i)*2)
+		in.operands[i] = o
+	}
+	return decoded(in)
+}
+
+// newDirtyTestMachine returns a version 3 machine with three objects and a
+// global variable table.
+func newDirtyTestMachine() *Machine {
+	m, _ := newTestMachine(3, 0x1000)
+	m.storeWord(0x0a, 0x40)
+	m.storeWord(0x0c, 0x200)
+	for i := Address(0); i < 3; i++ {
+		obj := 0x7e + i*9
+		props := 0x640 + i*0x10
+		m.storeWord(obj+7, Word(props))
+		m.storeByte(props+1, 0x25)
+	}
+	return m
+}
+
+func TestDirtyPages(t *testing.T) {
+	m := newDirtyTestMachine()
+	baseline := append([]byte(nil), m.memory...)
+	gen := m.markGeneration()
+
+	session := []struct {
+		Name string
+		Step func() error
+	}{
+		{"storew", func() error { return m.stepVariableInstruction(testVAR(0xe1, 0x300, 0, 0xbeef)) }},
+		{"storeb", func() error { return m.stepVariableInstruction(testVAR(0xe2, 0x3ff, 1, 0x42)) }},
+		{"storew across pages", func() error { return m.stepVariableInstruction(testVAR(0xe1, 0x5ff, 0, 0xabcd)) }},
+		{"put_prop", func() error { return m.stepVariableInstruction(testVAR(0xe3, 1, 5, 0x1234)) }},
+		{"set_attr", func() error { return m.step2OPInstruction(testVAR(0xcb, 2, 10)) }},
+		{"insert_obj", func() error { return m.step2OPInstruction(testVAR(0xce, 2, 1)) }},
+		{"insert_obj", func() error { return m.step2OPInstruction(testVAR(0xce, 3, 1)) }},
+		{"remove_obj", func() error {
+			return m.step1OPInstruction(decoded(&shortInstruction{version: 3, opcode: 0x89, operand: 2}))
+		}},
+		{"store", func() error { return m.step2OPInstruction(testVAR(0xcd, 0x10, 7)) }},
+		{"inc", func() error {
+			return m.step1OPInstruction(decoded(&shortInstruction{version: 3, opcode: 0x85, operand: 0x11}))
+		}},
+		{"copy_table", func() error { return m.stepVariableInstruction(testVAR(0xfd, 0x300, 0x700, 4)) }},
+		{"output_stream", func() error { return m.stepVariableInstruction(testVAR(0xf3, 3, 0x500)) }},
+		{"print_num", func() error { return m.stepVariableInstruction(testVAR(0xe6, 42)) }},
+		{"output_stream", func() error { return m.stepVariableInstruction(testVAR(0xf3, 0xfffd)) }},
+		{"get_cursor", func() error { return m.stepVariableInstruction(testVAR(0xf0, 0x520)) }},
+	}
+	for _, s := range session {
+		if err := s.Step(); err != nil {
+			t.Fatalf("%s: %v", s.Name, err)
+		}
+	}
+
+	if bytes.Equal(baseline, m.memory) {
+		t.Fatal("session did not change memory")
+	}
+	if pages := m.dirtyPagesSince(gen); len(pages) == len(m.pageGen) {
+		t.Errorf("dirtyPagesSince = %v; want a subset of pages", pages)
+	}
+	m.deltaSince(gen).apply(baseline)
+	for i := range baseline {
+		if baseline[i] != m.memory[i] {
+			t.Errorf("reconstruction[%v] = %#02x; want %#02x", Address(i), baseline[i], m.memory[i])
+		}
+	}
+	n := m.staticMemoryBase()
+	if d, want := m.dynamicDiff(), diffMemory(m.image[:n], m.memory[:n]); !bytes.Equal(d, want) {
+		t.Errorf("dynamicDiff() = %v; want %v", d, want)
+	}
+}
+
+func TestUndoPages(t *testing.T) {
+	m, _ := newTestMachine(3, 0x1000, UndoLevels(3), UndoDropOldest(true))
+	writes := []Address{0x300, 0x5ff, 0x40, 0x300, 0x7fe, 0x20}
+	var saved [][]byte
+	for i, a := range writes {
+		m.storeWord(a, Word(i+1))
+		saved = append(saved, m.DynamicMemorySnapshot())
+		if m.saveUndo(0) != 1 {
+			t.Fatalf("save %d did not save", i)
+		}
+	}
+	m.storeWord(0x200, 0xffff)
+	// Only the last three states are kept.
+	for i := len(writes) - 1; i >= len(writes)-3; i-- {
+		if !m.restoreUndo() {
+			t.Fatalf("restore %d failed", i)
+		}
+		if mem := m.DynamicMemorySnapshot(); !bytes.Equal(mem, saved[i]) {
+			t.Errorf("after restore %d: memory differs at %v", i, MemoryDiff(saved[i], mem))
+		}
+		m.storeWord(0x600, Word(i))
+	}
+	if m.restoreUndo() {
+		t.Error("restored more than three states")
+	}
+}
+
+func TestDirtyPagesGeneration(t *testing.T) {
+	m := newDirtyTestMachine()
+	m.storeByte(0x300, 1)
+	gen := m.markGeneration()
+	if pages := m.dirtyPagesSince(gen); len(pages) != 0 {
+		t.Errorf("after mark, dirtyPagesSince = %v; want []", pages)
+	}
+	m.storeWord(0x5ff, 0xffff)
+	if pages := m.dirtyPagesSince(gen); len(pages) != 2 || pages[0] != 2 || pages[1] != 3 {
+		t.Errorf("dirtyPagesSince = %v; want [2 3]", pages)
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	m, _ := newTestMachine(3, 0x1000)
+	// storew 0x300 0 0x1234; jump -8
+	code := []byte{0xe1, 0x13, 0x03, 0x00, 0x00, 0x12, 0x34, 0x8c, 0xff, 0xf8}
+	copy(m.memory[0x100:], code)
+	m.currStackFrame().PC = 0x100
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Step(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveUndo(b *testing.B) {
+	m, _ := newTestMachine(3, 0x1e000, UndoDropOldest(true))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.storeWord(0x300, Word(i))
+		if m.saveUndo(0) != 1 {
+			b.Fatal("save_undo did not save")
+		}
+	}
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	m, _ := newTestMachine(3, 0x1e000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.storeWord(0x300, Word(i))
+		m.Snapshot()
+	}
+}
@@ -1,6 +1,14 @@
 /*
-	Package north is a Z-machine interpreter written entirely in Go.
+Package north is a Z-machine interpreter written entirely in Go.
 
-	It supports Version 3 and later.
+It supports Version 3 and later.
+
+# Compatibility
+
+The module follows semantic versioning.  Within a major version, the
+following are stable: NewMachine, NewInterpreter and their Options, the
+Machine and Interpreter methods, the UI interface, and the error types that
+Step returns.  The optional UI interfaces may gain new interfaces, but the
+existing ones won't change.
 */
 package north
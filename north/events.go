@@ -0,0 +1,140 @@
+package north
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultEventLogSize is the number of events a machine remembers unless the
+// EventLogSize option says otherwise.
+const DefaultEventLogSize = 256
+
+// An EventKind says what happened in an Event.
+type EventKind uint8
+
+// Event kinds
+const (
+	// StepEvent is an instruction about to execute at PC.
+	StepEvent EventKind = iota
+	// CallEvent is a call to the routine at PC with Value arguments.
+	CallEvent
+	// ReturnEvent is a return of Value from the current routine.
+	ReturnEvent
+	// InputEvent is input read by the story.  Text is the line or character.
+	InputEvent
+	// StreamEvent is a change of output stream; Value is the operand of
+	// output_stream or input_stream.
+	StreamEvent
+	// WindowEvent is a switch to window Value.
+	WindowEvent
+)
+
+// An Event is an entry in a machine's event log.
+type Event struct {
+	Kind  EventKind
+	PC    Address
+	Value Word
+	Text  string
+
+	// The instruction of a StepEvent, kept undecoded so that recording is
+	// cheap.
+	form    instForm
+	version uint8
+	opcode  uint8
+}
+
+// Name returns the opcode name of a StepEvent, or the empty string for other
+// kinds of events.
+func (e Event) Name() string {
+	if e.Kind != StepEvent {
+		return ""
+	}
+	d := decodedInst{form: e.form, version: e.version, opcode: e.opcode}
+	return d.instruction().Name()
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case StepEvent:
+		return fmt.Sprintf("%v %s", e.PC, e.Name())
+	case CallEvent:
+		return fmt.Sprintf("call %v with %d args", e.PC, e.Value)
+	case ReturnEvent:
+		return fmt.Sprintf("return %d", e.Value)
+	case InputEvent:
+		return fmt.Sprintf("input %q", e.Text)
+	case StreamEvent:
+		return fmt.Sprintf("stream %d", int16(e.Value))
+	case WindowEvent:
+		return fmt.Sprintf("window %d", e.Value)
+	}
+	return fmt.Sprintf("Event(%d)", e.Kind)
+}
+
+// An eventLog is a ring buffer of the most recent events.
+type eventLog struct {
+	events []Event
+	next   int
+	full   bool
+}
+
+func (l *eventLog) record(e Event) {
+	if len(l.events) == 0 {
+		return
+	}
+	l.events[l.next] = e
+	l.next++
+	if l.next == len(l.events) {
+		l.next, l.full = 0, true
+	}
+}
+
+// recent returns the events in the order they happened.
+func (l *eventLog) recent() []Event {
+	if !l.full {
+		return append([]Event(nil), l.events[:l.next]...)
+	}
+	s := make([]Event, 0, len(l.events))
+	s = append(s, l.events[l.next:]...)
+	return append(s, l.events[:l.next]...)
+}
+
+// SetEventLogSize changes the EventLogSize setting between steps and forgets
+// the events logged so far.  Zero or less turns the log off.
+func (m *Machine) SetEventLogSize(n int) {
+	EventLogSize(n)(&m.cfg)
+	m.resetEventLog()
+}
+
+// eventLogSize returns the number of events to remember, or -1 for none.
+func (m *Machine) eventLogSize() int {
+	if m.cfg.eventLogSize == 0 {
+		return DefaultEventLogSize
+	}
+	return m.cfg.eventLogSize
+}
+
+// resetEventLog forgets the events logged so far.
+func (m *Machine) resetEventLog() {
+	m.events = eventLog{events: make([]Event, clampNone(m.eventLogSize()))}
+}
+
+// RecentEvents returns the events in the machine's log, oldest first.
+func (m *Machine) RecentEvents() []Event {
+	return m.events.recent()
+}
+
+// ErrorEvents returns the events that led up to an error returned by Step for
+// a bad instruction, or nil if err isn't such an error.
+func ErrorEvents(err error) []Event {
+	var ierr instructionError
+	if !errors.As(err, &ierr) {
+		return nil
+	}
+	return ierr.Events
+}
+
+// recordStep logs the instruction that is about to execute.
+func (m *Machine) recordStep(pc Address, in *decodedInst) {
+	m.events.record(Event{Kind: StepEvent, PC: pc, form: in.form, version: in.version, opcode: in.opcode})
+}
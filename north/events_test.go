@@ -0,0 +1,83 @@
+package north
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestEventLogWraps(t *testing.T) {
+	l := eventLog{events: make([]Event, 3)}
+	var pcs []Address
+	for pc := Address(1); pc <= 5; pc++ {
+		l.record(Event{Kind: StepEvent, PC: pc})
+		pcs = pcs[:0]
+		for _, e := range l.recent() {
+			pcs = append(pcs, e.PC)
+		}
+		want := []Address{1, 2, 3, 4, 5}[:pc]
+		if len(want) > 3 {
+			want = want[len(want)-3:]
+		}
+		if !reflect.DeepEqual(pcs, want) {
+			t.Errorf("after %d events, PCs = %v; want %v", pc, pcs, want)
+		}
+	}
+
+	var off eventLog
+	off.record(Event{Kind: StepEvent})
+	if e := off.recent(); len(e) != 0 {
+		t.Errorf("log with no room recorded %v", e)
+	}
+}
+
+func TestErrorEvents(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("set_window", zasm.Const(0))
+	b.Instr("call_vs", zasm.Routine("sub"), zasm.Const(7), zasm.Store(zasm.SP))
+	b.Routine("sub", 1)
+	b.Instr("pop")
+	m := buildMachine(t, b, new(bufferUI))
+	main := m.PC()
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = m.Step()
+	}
+	if err == nil {
+		t.Fatal("pop on an empty stack succeeded")
+	}
+
+	events := ErrorEvents(err)
+	var got []string
+	for _, e := range events {
+		got = append(got, e.String())
+	}
+	var sub Address
+	if len(events) > 3 {
+		sub = events[3].PC
+	}
+	want := []string{
+		main.String() + " set_window",
+		"window 0",
+		(main + 3).String() + " call_vs",
+		"call " + sub.String() + " with 1 args",
+		(sub + 3).String() + " pop",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ErrorEvents(err) = %q; want %q", got, want)
+	}
+	if recent := m.RecentEvents(); !reflect.DeepEqual(recent, events) {
+		t.Errorf("RecentEvents() = %v; want %v", recent, events)
+	}
+
+	m = buildMachine(t, b, new(bufferUI), EventLogSize(0))
+	err = nil
+	for i := 0; i < 3 && err == nil; i++ {
+		err = m.Step()
+	}
+	if err == nil || len(ErrorEvents(err)) != 0 {
+		t.Errorf("with the log off, ErrorEvents(%v) = %v; want empty", err, ErrorEvents(err))
+	}
+}
@@ -1,121 +1,244 @@
 package north
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"runtime"
+	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 type instructionError struct {
 	PC          Address
 	Instruction instruction
 	Err         error
+
+	// Events is the machine's event log when the error happened.
+	Events []Event
 }
 
 func (e instructionError) Error() string {
 	return fmt.Sprintf("%v @ %v: %v", e.Instruction, e.PC, e.Err)
 }
 
-// Step executes the next opcode in the machine.
+func (e instructionError) Unwrap() error {
+	return e.Err
+}
+
+// Step executes the next opcode in the machine.  A malformed story makes
+// Step return an error; it never panics.
 func (m *Machine) Step() (err error) {
+	if len(m.stack) == 0 {
+		return ErrNoFrame
+	}
+	if m.cfg.instructionLimit > 0 && m.steps >= m.cfg.instructionLimit {
+		return &TerminationError{Reason: LimitReached}
+	}
+	m.steps++
+	if m.profile != nil && m.steps%int64(m.profile.every) == 0 {
+		m.profile.sample(m.stack)
+	}
+	if err := m.checkPadding(m.PC(), "Instruction"); err != nil {
+		return instructionError{PC: m.PC(), Err: err}
+	}
+	in := &m.inst
 	defer func(pc Address) {
-		if err != nil {
+		if r := recover(); r != nil {
+			// The instructions check their operands, variables, and
+			// tables before using them.  This is a last resort for any
+			// access they miss: report it like any other bad instruction.
+			rerr, ok := r.(runtime.Error)
+			if !ok {
+				panic(r)
+			}
+			ierr := instructionError{PC: pc, Err: rerr}
+			if in.pc == pc {
+				// The panic came after the instruction was decoded.
+				ierr.Instruction = in.instruction()
+			}
+			err = ierr
+		}
+		if err != nil && len(m.stack) > 0 {
 			// XXX: What if we messed with the state already (esp. stack)?
 			m.currStackFrame().PC = pc
 			if ierr, ok := err.(instructionError); ok {
-				err = instructionError{pc, ierr.Instruction, ierr.Err}
+				err = instructionError{pc, ierr.Instruction, ierr.Err, m.RecentEvents()}
 			}
 		}
 	}(m.PC())
 
-	r, err := m.MemoryReader(m.PC())
-	if err != nil {
-		return err
-	}
 	// TODO: Get story alphabet set
-	i, err := decodeInstruction(r, StandardAlphabetSet, m, m.Version())
-	if err != nil {
+	ir := instReader{mem: m.memory, pos: m.PC()}
+	if err := in.decode(&ir, m.Version(), StandardAlphabetSet, m); err != nil {
 		return instructionError{Err: err}
 	}
-	//fmt.Printf("\x1b[34m%v\x1b[33m\t%v\x1b[0m\n", m.PC(), i)
-	newPC, _ := r.Seek(0, 1)
-	m.currStackFrame().PC = Address(newPC)
-
-	switch in := i.(type) {
-	case *longInstruction:
-		return m.step2OPInstruction(in)
-	case *shortInstruction:
-		if in.NOperand() == 0 {
-			return m.step0OPInstruction(in)
-		} else {
-			return m.step1OPInstruction(in)
+	//fmt.Printf("\x1b[34m%v\x1b[33m\t%v\x1b[0m\n", m.PC(), in)
+	in.pc = m.PC()
+	m.recordStep(in.pc, in)
+	if v, ok := in.StoreVariable(); ok {
+		if err := m.checkVariable(v); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
 		}
-	case *variableInstruction:
-		if in.is2OP() {
-			return m.step2OPInstruction(in)
-		} else {
-			return m.stepVariableInstruction(in)
+	}
+	m.currStackFrame().PC = ir.pos
+
+	switch {
+	case in.is2OP():
+		err = m.step2OPInstruction(in)
+	case in.form == shortForm && in.NOperand() == 0:
+		err = m.step0OPInstruction(in)
+	case in.form == shortForm:
+		err = m.step1OPInstruction(in)
+	case in.form == variableForm:
+		err = m.stepVariableInstruction(in)
+	default:
+		err = m.stepExtendedInstruction(in)
+	}
+	if f := m.currStackFrame(); err == nil && f != nil && len(f.Stack) > MaxEvalStack {
+		return instructionError{Instruction: in.instruction(), Err: m.stackOverflow(true)}
+	}
+	return err
+}
+
+// callPacked calls the routine at packed address p, as every call opcode does.
+// store is nil for the opcodes that throw the result away.  Calling address 0
+// calls nothing: it stores 0 if the opcode stores a result, and otherwise
+// does nothing at all.  An address that can't hold a routine is an error in
+// strict mode.  Otherwise the machine warns and treats it like address 0.
+func (m *Machine) callPacked(in *decodedInst, p Word, args []Word, store *uint8) error {
+	if p == 0 {
+		return m.routineCall(0, nil, store)
+	}
+	a, err := m.packedAddress(p)
+	if err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
+	}
+	if err := m.checkRoutine(a); err != nil {
+		err = fmt.Errorf("Call to %v (packed %#04x) %v", a, uint16(p), err)
+		if m.cfg.strict {
+			return instructionError{Instruction: in.instruction(), Err: err}
 		}
-	case *extendedInstruction:
-		return m.stepExtendedInstruction(in)
+		m.warn("%v @ %v: %v", in.instruction().Name(), in.pc, err)
+		return m.routineCall(0, nil, store)
+	}
+	if err := m.routineCall(a, args, store); err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
 	}
-	return instructionError{Instruction: i, Err: errors.New("Instruction type not implemented yet")}
+	return nil
 }
 
-func (m *Machine) routineCall(address Address, args []Word, ret uint8) error {
-	if address == 0 {
-		m.setVariable(ret, 0)
-		return nil
+// checkUnicode reports whether the UI can print and read r.  Surrogates and
+// control characters are never valid.  Characters in the story's Unicode
+// translation table can always be printed, since the story was written to
+// use them.
+func (m *Machine) checkUnicode(r rune) (print, read bool) {
+	if !utf8.ValidRune(r) || unicode.IsControl(r) {
+		return false, false
 	}
-	nlocals := int(m.loadByte(address))
-	if nlocals > 15 {
-		return errors.New("Routines have a maximum of 15 local variables")
+	if u, ok := m.ui.(UnicodeCapable); ok {
+		print, read = u.CanOutput(r), u.CanInput(r)
+	} else {
+		print, read = unicode.IsPrint(r), r >= ' ' && r <= '~'
 	}
-	newFrame := stackFrame{
-		PC:            address + 1,
-		Locals:        make([]Word, nlocals),
-		Store:         true,
-		StoreVariable: ret,
-		NArg:          uint8(len(args)),
+	return print || m.inUnicodeTable(r), read
+}
+
+// arrayAddress returns the address of entry i of the table at base for
+// loadw, storew, loadb and storeb, where size is the entry size in bytes.
+// The address is computed with 16-bit arithmetic, so it wraps around at 64K.
+func (m *Machine) arrayAddress(base, i Word, size int) (Address, error) {
+	a := Address(base + Word(size)*i)
+	if int(a)+size > len(m.memory) {
+		return 0, &MemoryError{Address: a, Size: size}
 	}
-	if m.Version() <= 4 {
-		for i := range newFrame.Locals {
-			newFrame.Locals[i] = m.loadWord(address + 1 + Address(i)*2)
-		}
-		newFrame.PC += Address(nlocals) * 2
+	return a, nil
+}
+
+// checkStore checks a write of size bytes at a by storew or storeb.  Writes
+// outside dynamic memory are errors in strict mode; otherwise they are warned
+// about and skipped, and ok is false with a nil error.
+func (m *Machine) checkStore(in *decodedInst, a Address, size int) (ok bool, err error) {
+	if a+Address(size) <= m.staticMemoryBase() {
+		return true, nil
+	}
+	err = fmt.Errorf("Write of %d bytes at %v is outside dynamic memory", size, a)
+	if m.cfg.strict {
+		return false, instructionError{Instruction: in.instruction(), Err: err}
+	}
+	m.warn("%v @ %v: %v", in.instruction().Name(), in.pc, err)
+	return false, nil
+}
+
+// checkRoutine returns an error if there can't be a routine at a.
+func (m *Machine) checkRoutine(a Address) error {
+	switch {
+	case a < m.staticMemoryBase():
+		return errors.New("is below static memory")
+	case int(a) >= m.storyLength:
+		return errors.New("is past the end of the story")
+	case m.loadByte(a) > 15:
+		return fmt.Errorf("has %d locals", m.loadByte(a))
 	}
-	copy(newFrame.Locals, args)
-	m.stack = append(m.stack, newFrame)
 	return nil
 }
 
-func (m *Machine) routineNCall(address Address, args []Word) error {
+// routineCall starts the routine at address with args.  If store is not nil,
+// the routine's return value will be stored in the variable it points to.
+// Address 0 is the routine that returns 0 at once, so the call only stores 0.
+func (m *Machine) routineCall(address Address, args []Word, store *uint8) error {
 	if address == 0 {
+		if store != nil {
+			m.setVariable(*store, 0)
+		}
 		return nil
 	}
+	if address >= Address(len(m.memory)) {
+		return fmt.Errorf("Routine address %v out of range", address)
+	}
+	m.events.record(Event{Kind: CallEvent, PC: address, Value: Word(len(args))})
 	nlocals := int(m.loadByte(address))
 	if nlocals > 15 {
 		return errors.New("Routines have a maximum of 15 local variables")
 	}
+	if max := m.maxStackDepth(); max > 0 && len(m.stack) >= max {
+		return m.stackOverflow(false)
+	}
 	newFrame := stackFrame{
-		PC:     address + 1,
-		Locals: make([]Word, nlocals),
-		NArg:   uint8(len(args)),
+		Routine: address,
+		PC:      address + 1,
+		Locals:  make([]Word, nlocals),
+		NArg:    uint8(len(args)),
+	}
+	if store != nil {
+		newFrame.Store = true
+		newFrame.StoreVariable = *store
 	}
 	if m.Version() <= 4 {
+		end := newFrame.PC + Address(nlocals)*2
+		if end > Address(len(m.memory)) {
+			return fmt.Errorf("Routine %v local variables out of range", address)
+		}
+		defaults := m.memory[newFrame.PC:end]
 		for i := range newFrame.Locals {
-			newFrame.Locals[i] = m.loadWord(address + 1 + Address(i)*2)
+			newFrame.Locals[i] = Word(binary.BigEndian.Uint16(defaults[i*2:]))
 		}
-		newFrame.PC += Address(nlocals) * 2
+		newFrame.PC = end
 	}
 	copy(newFrame.Locals, args)
 	m.stack = append(m.stack, newFrame)
+	if len(m.stack) > m.maxDepthSeen {
+		m.maxDepthSeen = len(m.stack)
+	}
 	return nil
 }
 
 func (m *Machine) routineReturn(val Word) error {
-	if len(m.stack) == 1 {
-		return errors.New("return from main")
+	m.events.record(Event{Kind: ReturnEvent, Value: val})
+	if len(m.stack) <= 1 {
+		return m.terminate(EndOfMain)
 	}
 
 	frame := m.currStackFrame()
@@ -126,27 +249,41 @@ func (m *Machine) routineReturn(val Word) error {
 	return nil
 }
 
-func (m *Machine) conditional(branch branchInfo, test bool) error {
-	if test == branch.Condition() {
-		switch branch.Offset() {
-		case 0:
-			return m.routineReturn(0)
-		case 1:
-			return m.routineReturn(1)
-		default:
-			m.currStackFrame().PC += Address(branch.Offset()) - 2
+func (m *Machine) conditional(in *decodedInst, test bool) error {
+	if test != in.branch.Condition() {
+		return nil
+	}
+	switch in.branch.Offset() {
+	case 0, 1:
+		if m.cfg.strict && len(m.stack) <= 1 {
+			return instructionError{PC: in.pc, Instruction: in.instruction(), Err: errors.New("Branch returns from the main routine")}
 		}
+		return m.routineReturn(Word(in.branch.Offset()))
+	}
+	return m.jump(in, Address(in.branch.Offset())-2)
+}
+
+// jump moves the PC by offset, which must land in memory and outside the
+// header.
+func (m *Machine) jump(in *decodedInst, offset Address) error {
+	f := m.currStackFrame()
+	target := f.PC + offset
+	if target < headerSize || int(target) >= len(m.memory) {
+		return instructionError{PC: in.pc, Instruction: in.instruction(), Err: fmt.Errorf("Jump to %v is outside memory", target)}
 	}
+	f.PC = target
 	return nil
 }
 
-func (m *Machine) step2OPInstruction(in instruction) error {
-	ops := m.fetchOperands(in)
-	branch, _ := in.BranchInfo()
+func (m *Machine) step2OPInstruction(in *decodedInst) error {
+	ops, err := m.fetchOperands(in)
+	if err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
+	}
 	storeVariable, _ := in.StoreVariable()
 	switch in.OpcodeNumber() {
 	case 0x01:
-		// je
+		// je, which compares bit patterns, so signedness doesn't matter
 		var eq bool
 		for i := 1; i < len(ops); i++ {
 			if ops[0] == ops[i] {
@@ -154,30 +291,38 @@ func (m *Machine) step2OPInstruction(in instruction) error {
 				break
 			}
 		}
-		return m.conditional(branch, eq)
+		return m.conditional(in, eq)
 	case 0x02:
-		// jl
-		return m.conditional(branch, int16(ops[0]) < int16(ops[1]))
+		// jl, a signed comparison
+		return m.conditional(in, int16(ops[0]) < int16(ops[1]))
 	case 0x03:
-		// jg
-		return m.conditional(branch, int16(ops[0]) > int16(ops[1]))
+		// jg, a signed comparison
+		return m.conditional(in, int16(ops[0]) > int16(ops[1]))
 	case 0x04:
 		// dec_chk
-		newVal := int16(m.getVariable(uint8(ops[0]))) - 1
-		m.setVariable(uint8(ops[0]), Word(newVal))
-		return m.conditional(branch, newVal < int16(ops[1]))
+		val, err := m.getIndirect(uint8(ops[0]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		newVal := int16(val) - 1
+		m.setIndirect(uint8(ops[0]), Word(newVal))
+		return m.conditional(in, newVal < int16(ops[1]))
 	case 0x05:
 		// inc_chk
-		newVal := int16(m.getVariable(uint8(ops[0]))) + 1
-		m.setVariable(uint8(ops[0]), Word(newVal))
-		return m.conditional(branch, newVal > int16(ops[1]))
+		val, err := m.getIndirect(uint8(ops[0]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		newVal := int16(val) + 1
+		m.setIndirect(uint8(ops[0]), Word(newVal))
+		return m.conditional(in, newVal > int16(ops[1]))
 	case 0x06:
 		// jin
 		obj1 := m.loadObject(ops[0])
-		return m.conditional(branch, obj1.Parent == ops[1])
+		return m.conditional(in, obj1.Parent == ops[1])
 	case 0x07:
 		// test
-		return m.conditional(branch, ops[0]&ops[1] == ops[1])
+		return m.conditional(in, ops[0]&ops[1] == ops[1])
 	case 0x08:
 		// or
 		m.setVariable(storeVariable, ops[0]|ops[1])
@@ -187,38 +332,65 @@ func (m *Machine) step2OPInstruction(in instruction) error {
 	case 0x0a:
 		// test_attr
 		obj := m.loadObject(ops[0])
-		return m.conditional(branch, obj.Attr(uint8(ops[1])))
+		if err := obj.checkAttr(ops[1]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		return m.conditional(in, obj.Attr(uint8(ops[1])))
 	case 0x0b:
 		// set_attr
 		obj := m.loadObject(ops[0])
+		if err := obj.checkAttr(ops[1]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		obj.SetAttr(uint8(ops[1]), true)
 		m.storeObject(ops[0], obj)
 	case 0x0c:
 		// clear_attr
 		obj := m.loadObject(ops[0])
+		if err := obj.checkAttr(ops[1]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		obj.SetAttr(uint8(ops[1]), false)
 		m.storeObject(ops[0], obj)
 	case 0x0d:
 		// store
-		m.setVariable(uint8(ops[0]), ops[1])
+		if err := m.setIndirect(uint8(ops[0]), ops[1]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 	case 0x0e:
 		// insert_obj
 		m.insertObject(ops[0], ops[1])
 	case 0x0f:
 		// loadw
-		a := Address(ops[0]) + 2*Address(ops[1])
+		a, err := m.arrayAddress(ops[0], ops[1], 2)
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		m.setVariable(storeVariable, m.loadWord(a))
 	case 0x10:
 		// loadb
-		a := Address(ops[0]) + Address(ops[1])
+		a, err := m.arrayAddress(ops[0], ops[1], 1)
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		m.setVariable(storeVariable, Word(m.loadByte(a)))
 	case 0x11:
 		// get_prop
+		if err := m.checkObject(ops[0]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		obj := m.loadObject(ops[0])
-		p := obj.Property(m, uint8(ops[1]))
+		p, err := obj.Property(m, uint8(ops[1]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		switch len(p) {
 		case 0:
-			m.setVariable(storeVariable, m.defaultPropertyValue(uint8(ops[1])))
+			val, err := m.defaultPropertyValue(uint8(ops[1]))
+			if err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+			m.setVariable(storeVariable, val)
 		case 1:
 			m.setVariable(storeVariable, Word(p[0]))
 		case 2:
@@ -228,14 +400,23 @@ func (m *Machine) step2OPInstruction(in instruction) error {
 		}
 	case 0x12:
 		// get_prop_addr
-		obj := m.loadObject(ops[0])
-		m.setVariable(storeVariable, Word(obj.PropertyAddress(m, uint8(ops[1]))))
+		if err := m.checkObject(ops[0]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		a, err := m.loadObject(ops[0]).PropertyAddress(m, uint8(ops[1]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setVariable(storeVariable, Word(a))
 	case 0x13:
 		// get_next_prop
+		if err := m.checkObject(ops[0]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 		obj := m.loadObject(ops[0])
 		np, err := obj.NextProperty(m, uint8(ops[1]))
 		if err != nil {
-			return err
+			return instructionError{Instruction: in.instruction(), Err: err}
 		}
 		m.setVariable(storeVariable, Word(np))
 	case 0x14:
@@ -255,43 +436,42 @@ func (m *Machine) step2OPInstruction(in instruction) error {
 		m.setVariable(storeVariable, Word(int16(ops[0])%int16(ops[1])))
 	case 0x19:
 		// call_2s
-		if ops[0] == 0 {
-			return m.routineCall(0, nil, storeVariable)
-		} else {
-			return m.routineCall(m.packedAddress(ops[0]), ops[1:], storeVariable)
-		}
+		return m.callPacked(in, ops[0], ops[1:], &storeVariable)
 	case 0x1a:
 		// call_2n
-		if ops[0] == 0 {
-			return m.routineNCall(0, nil)
-		} else {
-			return m.routineNCall(m.packedAddress(ops[0]), ops[1:])
-		}
+		return m.callPacked(in, ops[0], ops[1:], nil)
 	case 0x1b:
 		// set_colour
-		// TODO
+		if c, ok := m.ui.(Colorer); ok {
+			if err := c.SetColor(ops[0], ops[1]); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	default:
-		return instructionError{Instruction: in, Err: errors.New("2OP opcode not implemented yet")}
+		return instructionError{Instruction: in.instruction(), Err: errors.New("2OP opcode not implemented yet")}
 	}
 	return nil
 }
 
-func (m *Machine) step1OPInstruction(in *shortInstruction) error {
-	ops := m.fetchOperands(in)
+func (m *Machine) step1OPInstruction(in *decodedInst) error {
+	ops, err := m.fetchOperands(in)
+	if err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
+	}
 	switch in.OpcodeNumber() {
 	case 0x0:
 		// jz
-		return m.conditional(in.branch, ops[0] == 0)
+		return m.conditional(in, ops[0] == 0)
 	case 0x1:
 		// get_sibling
 		obj := m.loadObject(ops[0])
 		m.setVariable(in.storeVariable, obj.Sibling)
-		return m.conditional(in.branch, obj.Sibling != 0)
+		return m.conditional(in, obj.Sibling != 0)
 	case 0x2:
 		// get_child
 		obj := m.loadObject(ops[0])
 		m.setVariable(in.storeVariable, obj.Child)
-		return m.conditional(in.branch, obj.Child != 0)
+		return m.conditional(in, obj.Child != 0)
 	case 0x3:
 		// get_parent
 		obj := m.loadObject(ops[0])
@@ -317,10 +497,18 @@ func (m *Machine) step1OPInstruction(in *shortInstruction) error {
 		m.setVariable(in.storeVariable, Word(size))
 	case 0x5:
 		// inc
-		m.setVariable(uint8(ops[0]), m.getVariable(uint8(ops[0]))+1)
+		val, err := m.getIndirect(uint8(ops[0]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setIndirect(uint8(ops[0]), val+1)
 	case 0x6:
 		// dec
-		m.setVariable(uint8(ops[0]), m.getVariable(uint8(ops[0]))-1)
+		val, err := m.getIndirect(uint8(ops[0]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setIndirect(uint8(ops[0]), val-1)
 	case 0x7:
 		// print_addr
 		s, err := m.loadString(Address(ops[0]), true)
@@ -330,11 +518,7 @@ func (m *Machine) step1OPInstruction(in *shortInstruction) error {
 		return m.out(s)
 	case 0x8:
 		// call_1s
-		if ops[0] == 0 {
-			return m.routineCall(0, nil, in.storeVariable)
-		} else {
-			return m.routineCall(m.packedAddress(ops[0]), nil, in.storeVariable)
-		}
+		return m.callPacked(in, ops[0], nil, &in.storeVariable)
 	case 0x9:
 		// remove_obj
 		m.removeObject(ops[0])
@@ -352,37 +536,40 @@ func (m *Machine) step1OPInstruction(in *shortInstruction) error {
 		return m.routineReturn(ops[0])
 	case 0xc:
 		// jump
-		// TODO: do we ever use 0 or 1 offsets here?
-		m.currStackFrame().PC += Address(int16(ops[0])) - 2
+		return m.jump(in, Address(int16(ops[0]))-2)
 	case 0xd:
 		// print_paddr
-		s, err := m.loadString(m.packedAddress(ops[0]), true)
+		a, err := m.packedStringAddress(ops[0])
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		s, err := m.loadString(a, true)
 		if err != nil {
 			return err
 		}
 		return m.out(s)
 	case 0xe:
 		// load
-		m.setVariable(in.storeVariable, m.getVariable(uint8(ops[0])))
+		val, err := m.getIndirect(uint8(ops[0]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setVariable(in.storeVariable, val)
 	case 0xf:
-		if m.Version() < 5 {
+		if in.version < 5 {
 			// not
 			m.setVariable(in.storeVariable, ^ops[0])
 		} else {
 			// call_1n
-			if ops[0] == 0 {
-				return m.routineNCall(0, nil)
-			} else {
-				return m.routineNCall(m.packedAddress(ops[0]), nil)
-			}
+			return m.callPacked(in, ops[0], nil, nil)
 		}
 	default:
-		return instructionError{Instruction: in, Err: errors.New("1OP opcode not implemented yet")}
+		return instructionError{Instruction: in.instruction(), Err: errors.New("1OP opcode not implemented yet")}
 	}
 	return nil
 }
 
-func (m *Machine) step0OPInstruction(in *shortInstruction) error {
+func (m *Machine) step0OPInstruction(in *decodedInst) error {
 	switch in.OpcodeNumber() {
 	case 0x0:
 		// rtrue
@@ -395,7 +582,10 @@ func (m *Machine) step0OPInstruction(in *shortInstruction) error {
 		return m.out(in.text)
 	case 0x3:
 		// print_ret
-		if err := m.out(in.text + "\n"); err != nil {
+		if err := m.out(in.text); err != nil {
+			return err
+		}
+		if err := m.newLine(); err != nil {
 			return err
 		}
 		return m.routineReturn(1)
@@ -403,11 +593,11 @@ func (m *Machine) step0OPInstruction(in *shortInstruction) error {
 		// nop
 	case 0x5:
 		// save
-		switch m.Version() {
+		switch in.version {
 		case 1, 2, 3:
 			// TODO: log error?
 			err := m.ui.Save(m)
-			return m.conditional(in.branch, err == nil)
+			return m.conditional(in, err == nil)
 		case 4:
 			// TODO: log error?
 			err := m.ui.Save(m)
@@ -417,11 +607,11 @@ func (m *Machine) step0OPInstruction(in *shortInstruction) error {
 				m.setVariable(in.storeVariable, 0)
 			}
 		default:
-			return instructionError{Instruction: in, Err: errors.New("Illegal instruction")}
+			return instructionError{Instruction: in.instruction(), Err: errors.New("Illegal instruction")}
 		}
 	case 0x6:
 		// restore
-		switch m.Version() {
+		switch in.version {
 		case 1, 2, 3:
 			return m.ui.Restore(m)
 		case 4:
@@ -431,92 +621,126 @@ func (m *Machine) step0OPInstruction(in *shortInstruction) error {
 				return err
 			}
 		default:
-			return instructionError{Instruction: in, Err: errors.New("Illegal instruction")}
+			return instructionError{Instruction: in.instruction(), Err: errors.New("Illegal instruction")}
 		}
 	case 0x7:
 		// restart
-		return ErrRestart
+		return m.terminate(Restart)
 	case 0x8:
 		// ret_popped
-		m.routineReturn(m.currStackFrame().Pop())
+		w, err := m.currStackFrame().popChecked()
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		return m.routineReturn(w)
 	case 0x9:
-		if m.Version() < 5 {
+		if in.version < 5 {
 			// pop
-			m.currStackFrame().Pop()
+			if _, err := m.currStackFrame().popChecked(); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
 		} else {
 			// catch
-			// TODO
-			return instructionError{Instruction: in, Err: errors.New("catch not implemented")}
+			// The frame is identified by the number of frames on the stack.
+			m.setVariable(in.storeVariable, Word(len(m.stack)))
 		}
 	case 0xa:
 		// quit
-		return ErrQuit
+		return m.terminate(Quit)
 	case 0xb:
 		// new_line
-		return m.out("\n")
+		return m.newLine()
 	case 0xc:
 		// show_status
 		if m.Version() <= 3 {
-			m.refreshStatusLine()
+			if err := m.refreshStatusLine(); err != nil {
+				return err
+			}
 		}
 	case 0xd:
 		// verify
-		// TODO: actually perform verification
-		return m.conditional(in.branch, true)
+		return m.conditional(in, m.verify())
 	case 0xf:
 		// piracy
 		// ARR NO PIRATES HERE
-		return m.conditional(in.branch, true)
+		return m.conditional(in, true)
 	default:
-		return instructionError{Instruction: in, Err: errors.New("0OP opcode not implemented yet")}
+		return instructionError{Instruction: in.instruction(), Err: errors.New("0OP opcode not implemented yet")}
 	}
 	return nil
 }
 
-func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
-	ops := m.fetchOperands(in)
+func (m *Machine) stepVariableInstruction(in *decodedInst) error {
+	ops, err := m.fetchOperands(in)
+	if err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
+	}
 	switch in.OpcodeNumber() {
 	case 0x0:
 		// call (v3), call_vs (v4+)
-		if ops[0] == 0 {
-			return m.routineCall(0, nil, in.storeVariable)
-		} else {
-			return m.routineCall(m.packedAddress(ops[0]), ops[1:], in.storeVariable)
-		}
+		return m.callPacked(in, ops[0], ops[1:], &in.storeVariable)
 	case 0x1:
 		// storew
-		a := Address(ops[0]) + 2*Address(ops[1])
+		a, err := m.arrayAddress(ops[0], ops[1], 2)
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		if ok, err := m.checkStore(in, a, 2); !ok {
+			return err
+		}
 		m.storeWord(a, ops[2])
 	case 0x2:
 		// storeb
-		a := Address(ops[0]) + Address(ops[1])
+		a, err := m.arrayAddress(ops[0], ops[1], 1)
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		if ok, err := m.checkStore(in, a, 1); !ok {
+			return err
+		}
 		m.storeByte(a, byte(ops[2]))
 	case 0x3:
 		// put_prop
-		obj := m.loadObject(ops[0])
-		p := obj.Property(m, uint8(ops[1]))
-		switch len(p) {
+		if err := m.checkObject(ops[0]); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		a, size, err := m.loadObject(ops[0]).propLoc(m, uint8(ops[1]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		switch size {
 		case 1:
-			p[0] = byte(ops[2] & 0xff)
+			m.storeByte(a, byte(ops[2]&0xff))
 		case 2:
-			p[0] = byte(ops[2] >> 8)
-			p[1] = byte(ops[2] & 0xff)
+			m.storeWord(a, ops[2])
 		default:
-			return fmt.Errorf("Mismatched property size: vs. %d", len(p))
+			return fmt.Errorf("Mismatched property size: vs. %d", size)
 		}
 	case 0x4:
 		// read
 		if m.Version() <= 3 {
-			m.refreshStatusLine()
+			if err := m.refreshStatusLine(); err != nil {
+				return err
+			}
+		}
+		m.lastParse = nil
+		if err := m.linearStatus(); err != nil {
+			return err
 		}
 		var input []rune
+		var term Word
 		textAddr := Address(ops[0])
+		if err := m.checkTextBuffer(textAddr); err != nil {
+			return err
+		}
+		m.textBuffer, m.parseBuffer = textAddr, Address(ops[1])
 		if m.Version() <= 4 {
 			var err error
-			input, err = m.ui.Input(int(m.loadByte(textAddr)) - 1)
+			input, _, _, err = m.readLine(lineRequest{n: int(m.loadByte(textAddr)) - 1, timeout: readTimeout(ops)})
 			if err != nil {
 				return err
 			}
+			m.events.record(Event{Kind: InputEvent, Text: string(input)})
 
 			for i := range input {
 				// TODO: Ensure input is ZSCII-clean
@@ -525,20 +749,32 @@ func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
 			}
 			m.storeByte(textAddr+1+Address(len(input)), 0)
 		} else {
-			var err error
-			input, err = m.ui.Input(int(m.loadByte(Address(ops[0]))))
+			max := int(m.loadByte(textAddr))
+			prefill := m.inputPrefill(textAddr)
+			line, replaced, t, err := m.readLine(lineRequest{
+				n:           max,
+				prefill:     prefill,
+				terminators: m.terminatingChars(),
+				timeout:     readTimeout(ops),
+			})
 			if err != nil {
 				return err
 			}
-
-			base := textAddr + 2
-			if n := m.loadByte(textAddr + 1); n > 0 {
-				base += Address(n)
+			term = t
+			if replaced {
+				input = line
+			} else {
+				input = append(prefill, line...)
+			}
+			if len(input) > max {
+				input = input[:max]
 			}
-			m.storeByte(textAddr+1, m.loadByte(textAddr+1)+byte(len(input)))
+			m.events.record(Event{Kind: InputEvent, Text: string(input)})
+
+			m.storeByte(textAddr+1, byte(len(input)))
 			for i := range input {
 				// TODO: Ensure input is ZSCII-clean
-				m.storeByte(base+Address(i), byte(input[i]))
+				m.storeByte(textAddr+2+Address(i), byte(input[i]))
 				input[i] = unicode.ToLower(input[i])
 			}
 		}
@@ -548,16 +784,15 @@ func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
 			if err != nil {
 				return err
 			}
-			m.tokenise(input, dict, Address(ops[1]), true)
+			m.setLastParse(input, m.tokenise(input, dict, Address(ops[1]), true))
 		}
 
 		if m.Version() >= 5 {
-			// TODO: use actual terminating character
-			m.setVariable(in.storeVariable, '\n')
+			m.setVariable(in.storeVariable, term)
 		}
 	case 0x5:
 		// print_char
-		r, err := zsciiLookup(uint16(ops[0]), true)
+		r, err := m.zsciiRune(uint16(ops[0]), true)
 		if err != nil {
 			return err
 		}
@@ -581,75 +816,160 @@ func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
 		if m.Version() == 6 {
 			return errors.New("multiple stacks not supported yet")
 		}
-		m.setVariable(uint8(ops[0]), m.currStackFrame().Pop())
+		w, err := m.currStackFrame().popChecked()
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		if err := m.checkVariable(uint8(ops[0])); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setVariable(uint8(ops[0]), w)
 	case 0xa:
 		// split_window
-		// TODO
+		if m.cfg.linear {
+			m.linear.split(int(ops[0]))
+		} else if ws, ok := m.ui.(WindowSplitter); ok {
+			if err := ws.SplitWindow(int(ops[0])); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0xb:
 		// set_window
-		m.window = int(ops[0])
+		m.setWindow(int(ops[0]))
+		m.events.record(Event{Kind: WindowEvent, Value: ops[0]})
 	case 0xc:
 		// call_vs2
-		if ops[0] == 0 {
-			return m.routineCall(0, nil, in.storeVariable)
-		} else {
-			return m.routineCall(m.packedAddress(ops[0]), ops[1:], in.storeVariable)
-		}
+		return m.callPacked(in, ops[0], ops[1:], &in.storeVariable)
 	case 0xd:
 		// erase_window
-		// TODO
+		w := int(int16(ops[0]))
+		if w < 0 {
+			m.columns = [8]int{}
+		} else if w < len(m.columns) {
+			m.columns[w] = 0
+		}
+		if m.cfg.linear {
+			// The UI only has the lower window.
+			if w != 0 {
+				m.linear.erase()
+			}
+			switch w {
+			case 1:
+				return nil
+			case -1:
+				m.linear.split(0)
+				m.setWindow(0)
+			}
+			w = 0
+		}
+		switch w {
+		case -1:
+			// Unsplit the screen, then clear it.
+			if ws, ok := m.ui.(WindowSplitter); ok {
+				if err := ws.SplitWindow(0); err != nil {
+					return instructionError{Instruction: in.instruction(), Err: err}
+				}
+			}
+			m.setWindow(0)
+		case -2:
+			// Clear the screen without unsplitting it.
+			w = -1
+		}
+		if we, ok := m.ui.(WindowEraser); ok {
+			if err := we.EraseWindow(w); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0xe:
 		// erase_line
-		// TODO
+		if m.cfg.linear {
+			if m.window == 1 && ops[0] == 1 {
+				m.linear.eraseLine()
+			}
+		} else if we, ok := m.ui.(WindowEraser); ok && ops[0] == 1 {
+			if err := we.EraseLine(); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0xf:
 		// set_cursor
-		// TODO
+		if int16(ops[0]) > 0 {
+			c := m.column()
+			*c = 0
+			if len(ops) > 1 && ops[1] > 0 {
+				*c = int(ops[1]) - 1
+			}
+		}
+		if m.cfg.linear {
+			if line := int16(ops[0]); line > 0 {
+				col := 1
+				if len(ops) > 1 {
+					col = int(ops[1])
+				}
+				m.linear.setCursor(int(line), col)
+			}
+			break
+		}
+		sm, ok := m.ui.(ScreenManager)
+		if !ok {
+			break
+		}
+		var err error
+		switch line := int16(ops[0]); {
+		case m.Version() == 6 && line == -1:
+			err = sm.SetCursorVisible(false)
+		case m.Version() == 6 && line == -2:
+			err = sm.SetCursorVisible(true)
+		case len(ops) > 1:
+			err = sm.SetCursor(int(line), int(ops[1]))
+		default:
+			err = sm.SetCursor(int(line), 1)
+		}
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
 	case 0x10:
 		// get_cursor
 		// TODO
 		addr := Address(ops[0])
+		if ok, err := m.checkStore(in, addr, 4); !ok {
+			return err
+		}
 		m.storeWord(addr, 0)   // row
 		m.storeWord(addr+2, 0) // col
 	case 0x11:
 		// set_text_style
-		// TODO
+		if st, ok := m.ui.(Styler); ok {
+			if err := st.SetTextStyle(ops[0]); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0x12:
 		// buffer_mode
-		// TODO
+		if bm, ok := m.ui.(BufferModer); ok {
+			bm.SetBufferMode(ops[0] != 0)
+		}
 	case 0x13:
 		// output_stream
-		switch int16(ops[0]) {
-		case 0:
-			// do nothing
-		case screenOutput:
-			m.streams |= 1 << screenOutput
-		case -screenOutput:
-			m.streams &^= 1 << screenOutput
-		case transcriptOutput:
-			m.streams |= 1 << transcriptOutput
-		case -transcriptOutput:
-			m.streams &^= 1 << transcriptOutput
-		case redirectOutput:
-			m.streams |= 1 << redirectOutput
-			if len(m.rtables) == cap(m.rtables) {
-				return instructionError{Instruction: in, Err: errors.New("Too many output redirection levels")}
-			}
-			addr := Address(ops[1])
-			m.rtables = append(m.rtables, rtable{addr, addr + 2})
-			m.storeWord(addr, 0)
-		case -redirectOutput:
-			if len(m.rtables) > 1 {
-				m.rtables = m.rtables[:len(m.rtables)-1]
-			} else {
-				m.rtables = m.rtables[:0]
-				m.streams &^= 1 << redirectOutput
-			}
-		default:
-			return instructionError{Instruction: in, Err: fmt.Errorf("Invalid output stream: %d", int16(ops[0]))}
+		m.events.record(Event{Kind: StreamEvent, Value: ops[0]})
+		var table Address
+		if len(ops) > 1 {
+			table = Address(ops[1])
+		}
+		if err := m.SelectOutputStream(int(int16(ops[0])), table); err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
 		}
 	case 0x14:
 		// input_stream
-		// TODO
+		m.events.record(Event{Kind: StreamEvent, Value: ops[0]})
+		switch ops[0] {
+		case 0:
+			m.closeCommands()
+		case 1:
+			if err := m.openCommands(); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0x15:
 		// sound_effect
 		if player, ok := m.ui.(SoundPlayer); ok {
@@ -675,21 +995,34 @@ func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
 		}
 	case 0x16:
 		// read_char
-		input, _, err := m.ui.ReadRune()
+		if err := m.linearStatus(); err != nil {
+			return err
+		}
+		input, err := m.readChar()
 		if err != nil {
 			return err
 		}
-		m.setVariable(in.storeVariable, Word(input))
+		m.events.record(Event{Kind: InputEvent, Text: string(input)})
+		m.setVariable(in.storeVariable, keyCode(input))
+	case 0x17:
+		// scan_table
+		form := Word(0x82)
+		if in.NOperand() > 3 {
+			form = ops[3]
+		}
+		a, found, err := m.scanTable(ops[0], Address(ops[1]), int(ops[2]), form)
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		m.setVariable(in.storeVariable, Word(a))
+		// The branch is taken on a match, so ?~ branches when there's none.
+		return m.conditional(in, found)
 	case 0x18:
 		// not (v5+)
 		m.setVariable(in.storeVariable, ^ops[0])
 	case 0x19, 0x1a:
 		// call_vn, call_vn2
-		if ops[0] == 0 {
-			return m.routineNCall(0, nil)
-		} else {
-			return m.routineNCall(m.packedAddress(ops[0]), ops[1:])
-		}
+		return m.callPacked(in, ops[0], ops[1:], nil)
 	case 0x1b:
 		// tokenise
 		var dict *dictionary
@@ -711,41 +1044,89 @@ func (m *Machine) stepVariableInstruction(in *variableInstruction) error {
 		m.tokenise(input, dict, Address(ops[1]), len(ops) < 3 || ops[3] == 0)
 	case 0x1d:
 		// copy_table
-		src := Address(ops[0])
-		dst := Address(ops[1])
-		size := Address(int16(ops[2]))
-		if dst == 0 {
-			for addr := src; addr < src+size; addr++ {
-				m.storeByte(addr, 0)
+		src, dst, size := Address(ops[0]), Address(ops[1]), int(int16(ops[2]))
+		var err error
+		switch {
+		case dst == 0:
+			if size < 0 {
+				size = -size
 			}
-			return nil
+			err = m.ByteTable(src, size).zero()
+		case size >= 0:
+			err = m.ByteTable(src, size).CopyTo(m.ByteTable(dst, size))
+		default:
+			// Negative size means forcibly copy forward.
+			err = m.ByteTable(src, -size).copyForward(m.ByteTable(dst, -size))
 		}
-		if size >= 0 {
-			// Go guarantees proper overlapping copies.
-			copy(m.memory[dst:dst+size], m.memory[src:src+size])
-		} else {
-			// Negative size means forcibly copy backward.
-			for i := -size - 1; i >= 0; i-- {
-				m.storeByte(dst+i, m.loadByte(src+i))
-			}
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
 		}
 	case 0x1e:
 		// print_table
-		// TODO
+		width, height, skip := int(ops[1]), 1, 0
+		// Each row starts in the same column as the first.  Only the lower
+		// window is lined up with spaces: in the upper window they would
+		// overwrite what's to the left.
+		var indent string
+		if m.window == 0 && m.streams&(1<<redirectOutput) == 0 {
+			indent = strings.Repeat(" ", m.columns[0])
+		}
+		if in.NOperand() > 2 {
+			height = int(ops[2])
+		}
+		if in.NOperand() > 3 {
+			skip = int(ops[3])
+		}
+		for row := 0; row < height; row++ {
+			if row > 0 {
+				if err := m.newLine(); err != nil {
+					return err
+				}
+				if indent != "" {
+					if err := m.out(indent); err != nil {
+						return err
+					}
+				}
+			}
+			line, err := m.ByteTable(Address(ops[0])+Address(row*(width+skip)), width).Slice()
+			if err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+			r := make([]rune, len(line))
+			for i, c := range line {
+				if r[i], err = m.zsciiRune(uint16(c), true); err != nil {
+					return instructionError{Instruction: in.instruction(), Err: err}
+				}
+			}
+			if err := m.out(string(r)); err != nil {
+				return err
+			}
+		}
 	case 0x1f:
 		// check_arg_count
-		return m.conditional(in.branch, m.currStackFrame().NArg == uint8(ops[0]))
+		return m.conditional(in, m.currStackFrame().NArg == uint8(ops[0]))
 	default:
-		return instructionError{Instruction: in, Err: errors.New("VAR opcode not implemented yet")}
+		return instructionError{Instruction: in.instruction(), Err: errors.New("VAR opcode not implemented yet")}
 	}
 	return nil
 }
 
-func (m *Machine) stepExtendedInstruction(in *extendedInstruction) error {
-	ops := m.fetchOperands(in)
+func (m *Machine) stepExtendedInstruction(in *decodedInst) error {
+	ops, err := m.fetchOperands(in)
+	if err != nil {
+		return instructionError{Instruction: in.instruction(), Err: err}
+	}
 	switch in.OpcodeNumber() {
 	case 0x00:
 		// save
+		if len(ops) > 0 {
+			n, err := m.saveAux(ops)
+			if err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+			m.setVariable(in.storeVariable, n)
+			return nil
+		}
 		// TODO: log error?
 		err := m.ui.Save(m)
 		if err == nil {
@@ -755,6 +1136,14 @@ func (m *Machine) stepExtendedInstruction(in *extendedInstruction) error {
 		}
 	case 0x01:
 		// restore
+		if len(ops) > 0 {
+			n, err := m.restoreAux(ops)
+			if err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+			m.setVariable(in.storeVariable, n)
+			return nil
+		}
 		err := m.ui.Restore(m)
 		if err != nil {
 			m.setVariable(in.storeVariable, 0)
@@ -762,42 +1151,253 @@ func (m *Machine) stepExtendedInstruction(in *extendedInstruction) error {
 		}
 	case 0x02:
 		// log_shift
-		result := ops[0]
-		if places := int16(ops[1]); places > 0 {
-			result <<= uint(places)
-		} else if places < 0 {
-			result >>= uint(-places)
-		}
-		m.setVariable(in.storeVariable, result)
+		m.setVariable(in.storeVariable, logShift(ops[0], int16(ops[1])))
 	case 0x03:
 		// art_shift
-		result := int16(ops[0])
-		if places := int16(ops[1]); places > 0 {
-			result <<= uint(places)
-		} else if places < 0 {
-			result >>= uint(-places)
-		}
-		m.setVariable(in.storeVariable, Word(result))
+		m.setVariable(in.storeVariable, artShift(ops[0], int16(ops[1])))
 	case 0x04:
 		// set_font
-		// TODO
-		m.setVariable(in.storeVariable, 0)
+		m.setVariable(in.storeVariable, m.setFont(ops[0]))
+	case 0x05:
+		// draw_picture
+		if g, ok := m.ui.(Graphics); ok {
+			y, x := pictureCoords(ops)
+			if err := g.DrawPicture(m.window, int(ops[0]), y, x); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
+	case 0x06:
+		// picture_data
+		g, ok := m.ui.(Graphics)
+		if !ok {
+			return m.conditional(in, false)
+		}
+		width, height, ok := g.PictureData(int(ops[0]))
+		if ok {
+			store, err := m.checkStore(in, Address(ops[1]), 4)
+			if err != nil {
+				return err
+			}
+			if store {
+				m.storeWord(Address(ops[1]), Word(height))
+				m.storeWord(Address(ops[1])+2, Word(width))
+			}
+		}
+		return m.conditional(in, ok)
+	case 0x07:
+		// erase_picture
+		if g, ok := m.ui.(Graphics); ok {
+			y, x := pictureCoords(ops)
+			if err := g.ErasePicture(m.window, int(ops[0]), y, x); err != nil {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+		}
 	case 0x09:
 		// save_undo
-		// TODO
-		m.setVariable(in.storeVariable, Word(0xffff))
+		m.setVariable(in.storeVariable, m.saveUndo(in.storeVariable))
 	case 0x0a:
 		// restore_undo
-		// TODO
+		if !m.restoreUndo() {
+			m.setVariable(in.storeVariable, 0)
+		}
 	case 0x0b:
 		// print_unicode
-		return m.out(string(rune(ops[0])))
+		r := rune(ops[0])
+		if print, _ := m.checkUnicode(r); !print {
+			r = '?'
+		}
+		return m.out(string(r))
 	case 0x0c:
 		// check_unicode
-		// XXX: should we ask the UI whether it can receive Unicode?
-		m.setVariable(in.storeVariable, 0x0003)
+		var result Word
+		print, read := m.checkUnicode(rune(ops[0]))
+		if print {
+			result |= 1
+		}
+		if read {
+			result |= 2
+		}
+		m.setVariable(in.storeVariable, result)
+	case 0x16:
+		// read_mouse
+		if p, ok := m.ui.(Pointer); ok {
+			// The standard puts y before x.
+			a := Address(ops[0])
+			if ok, err := m.checkStore(in, a, 8); !ok {
+				return err
+			}
+			x, y, buttons := p.MouseState()
+			m.storeWord(a, Word(y))
+			m.storeWord(a+2, Word(x))
+			m.storeWord(a+4, buttons)
+			var menu Word
+			if mu, ok := m.ui.(Menuer); ok {
+				id, item := mu.MenuSelection()
+				menu = Word(id)<<8 | Word(item)
+			}
+			m.storeWord(a+6, menu)
+		}
+	case 0x17:
+		// mouse_window
+		// Clicks are reported wherever they land.
+	case 0x1b:
+		// make_menu
+		mu, ok := m.ui.(Menuer)
+		if !ok {
+			return m.conditional(in, false)
+		}
+		if ops[1] == 0 {
+			return m.conditional(in, mu.RemoveMenu(int(ops[0])) == nil)
+		}
+		items, err := m.menuItems(Address(ops[1]))
+		if err != nil {
+			return instructionError{Instruction: in.instruction(), Err: err}
+		}
+		return m.conditional(in, mu.MakeMenu(int(ops[0]), items) == nil)
+	case 0x1c:
+		// picture_table
+		// Pictures are loaded on demand, so the table is only checked.
+		if err := m.checkPictureTable(Address(ops[0])); err != nil {
+			if m.cfg.strict {
+				return instructionError{Instruction: in.instruction(), Err: err}
+			}
+			m.warn("%v @ %v: %v", in.instruction().Name(), in.pc, err)
+		}
 	default:
-		return instructionError{Instruction: in, Err: errors.New("EXT opcode not implemented yet")}
+		return instructionError{Instruction: in.instruction(), Err: errors.New("EXT opcode not implemented yet")}
 	}
 	return nil
 }
+
+// menuItems reads a make_menu table: a word count followed by the addresses
+// of the items, each a length byte followed by that many ZSCII characters.
+func (m *Machine) menuItems(a Address) ([]string, error) {
+	n := int(m.loadWord(a))
+	items := make([]string, n)
+	for i := range items {
+		ta := Address(m.loadWord(a + 2 + Address(i)*2))
+		length := Address(m.loadByte(ta))
+		r := make([]rune, length)
+		for j := range r {
+			var err error
+			r[j], err = m.zsciiRune(uint16(m.loadByte(ta+1+Address(j))), true)
+			if err != nil {
+				return nil, fmt.Errorf("Menu item %d: %v", i+1, err)
+			}
+		}
+		items[i] = string(r)
+	}
+	return items, nil
+}
+
+// checkPictureTable checks a picture_table table: a list of picture numbers
+// ending with 0.  Without a Graphics UI, any number is allowed.
+func (m *Machine) checkPictureTable(table Address) error {
+	g, _ := m.ui.(Graphics)
+	for a := table; ; a += 2 {
+		if int(a)+2 > len(m.memory) {
+			return &MemoryError{Address: a, Size: 2}
+		}
+		n := m.loadWord(a)
+		if n == 0 {
+			return nil
+		}
+		if g == nil {
+			continue
+		}
+		if _, _, ok := g.PictureData(int(n)); !ok {
+			return fmt.Errorf("Picture table at %v lists picture %d, which doesn't exist", table, n)
+		}
+	}
+}
+
+// pictureCoords returns the y and x operands of draw_picture or
+// erase_picture.  Omitted coordinates are 0, meaning the cursor position.
+func pictureCoords(ops []Word) (y, x int) {
+	if len(ops) > 1 {
+		y = int(ops[1])
+	}
+	if len(ops) > 2 {
+		x = int(ops[2])
+	}
+	return
+}
+
+// logShift shifts w left by places, or right if places is negative, filling
+// with zeroes.  Shifting by 16 or more places in either direction yields 0.
+func logShift(w Word, places int16) Word {
+	switch {
+	case places >= 16 || places <= -16:
+		return 0
+	case places > 0:
+		return w << uint(places)
+	case places < 0:
+		return w >> uint(-places)
+	}
+	return w
+}
+
+// artShift shifts w left by places, or right if places is negative, preserving
+// the sign.  Shifting left by 16 or more places yields 0, and shifting right
+// by 16 or more places yields 0 or -1 depending on the sign of w.
+func artShift(w Word, places int16) Word {
+	x := int16(w)
+	switch {
+	case places >= 16:
+		return 0
+	case places > 0:
+		return Word(x << uint(places))
+	case places <= -15:
+		return Word(x >> 15)
+	case places < 0:
+		return Word(x >> uint(-places))
+	}
+	return w
+}
+
+// setFont changes the current window to font n and returns the previous font,
+// or 0 if n isn't available.  Font 0 asks for the current font without
+// changing it.
+func (m *Machine) setFont(n Word) Word {
+	font := m.windowFont()
+	prev := *font
+	switch fs, ok := m.ui.(FontSetter); {
+	case n == 0:
+		return prev
+	case ok && fs.SetFont(n):
+	case !ok && n == 1:
+	default:
+		return 0
+	}
+	*font = n
+	return prev
+}
+
+// windowFont returns the font of the current window.  Windows past the ones
+// a story can have share window 0's font.
+func (m *Machine) windowFont() *Word {
+	if m.window < 0 || m.window >= len(m.fonts) {
+		return &m.fonts[0]
+	}
+	return &m.fonts[m.window]
+}
+
+// setWindow selects window w, switching the UI to that window's font.
+func (m *Machine) setWindow(w int) {
+	prev := *m.windowFont()
+	m.window = w
+	if f := *m.windowFont(); f != prev {
+		if fs, ok := m.ui.(FontSetter); ok {
+			fs.SetFont(f)
+		}
+	}
+}
+
+// readTimeout returns the time limit given by read's time and routine
+// operands (version 4+).  There is no limit unless both are given.
+func readTimeout(ops []Word) time.Duration {
+	if len(ops) < 4 || ops[2] == 0 || ops[3] == 0 {
+		return 0
+	}
+	return time.Duration(ops[2]) * time.Second / 10
+}
@@ -0,0 +1,1173 @@
+package north
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// decoded converts in to the form that the step functions take.
+func decoded(in instruction) *decodedInst {
+	d := &decodedInst{types: 0xffff}
+	switch in := in.(type) {
+	case *longInstruction:
+		d.form, d.version, d.opcode = longForm, in.version, in.opcode
+	case *shortInstruction:
+		d.form, d.version, d.opcode, d.text = shortForm, in.version, in.opcode, in.text
+	case *variableInstruction:
+		d.form, d.version, d.opcode = variableForm, in.version, in.opcode
+	case *extendedInstruction:
+		d.form, d.version, d.opcode = extendedForm, in.version, in.opcode
+	}
+	d.nops = in.NOperand()
+	for i := 0; i < d.nops; i++ {
+		var t operandType
+		d.operands[i], t = in.Operand(i)
+		shift := 14 - uint(i)*2
+		d.types = d.types&^(3<<shift) | uint16(t)<<shift
+	}
+	d.storeVariable, d.store = in.StoreVariable()
+	d.branch, d.hasBranch = in.BranchInfo()
+	return d
+}
+
+func TestRoutineCall(t *testing.T) {
+	const routine Address = 0x100
+	storeVar := uint8(0x03)
+	tests := []struct {
+		Version byte
+		Args    []Word
+		Store   *uint8
+		Frame   stackFrame
+	}{
+		{
+			3, []Word{9}, &storeVar,
+			stackFrame{Routine: routine, PC: routine + 5, Locals: []Word{9, 0x5678}, Store: true, StoreVariable: 0x03, NArg: 1},
+		},
+		{
+			3, nil, nil,
+			stackFrame{Routine: routine, PC: routine + 5, Locals: []Word{0x1234, 0x5678}, NArg: 0},
+		},
+		{
+			3, []Word{1, 2, 3}, nil,
+			stackFrame{Routine: routine, PC: routine + 5, Locals: []Word{1, 2}, NArg: 3},
+		},
+		{
+			5, []Word{9}, &storeVar,
+			stackFrame{Routine: routine, PC: routine + 1, Locals: []Word{9, 0}, Store: true, StoreVariable: 0x03, NArg: 1},
+		},
+		{
+			5, nil, nil,
+			stackFrame{Routine: routine, PC: routine + 1, Locals: []Word{0, 0}, NArg: 0},
+		},
+	}
+	for i, tt := range tests {
+		m, _ := newTestMachine(tt.Version, 0x200)
+		m.storeByte(routine, 2)
+		m.storeWord(routine+1, 0x1234)
+		m.storeWord(routine+3, 0x5678)
+		if err := m.routineCall(routine, tt.Args, tt.Store); err != nil {
+			t.Errorf("[%d] routineCall error: %v", i, err)
+			continue
+		}
+		if len(m.stack) != 2 {
+			t.Errorf("[%d] len(m.stack) = %d; want 2", i, len(m.stack))
+			continue
+		}
+		if f := m.stack[1]; !reflect.DeepEqual(f, tt.Frame) {
+			t.Errorf("[%d] frame = %+v; want %+v", i, f, tt.Frame)
+		}
+	}
+}
+
+func TestRoutineCallZero(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	storeVar := uint8(0)
+	if err := m.routineCall(0, []Word{1}, &storeVar); err != nil {
+		t.Fatalf("routineCall error: %v", err)
+	}
+	if err := m.routineCall(0, []Word{1}, nil); err != nil {
+		t.Fatalf("routineCall error: %v", err)
+	}
+	if len(m.stack) != 1 {
+		t.Errorf("len(m.stack) = %d; want 1", len(m.stack))
+	}
+	if !reflect.DeepEqual(m.stack[0].Stack, []Word{0}) {
+		t.Errorf("stack = %v; want [0]", m.stack[0].Stack)
+	}
+}
+
+func TestRoutineCallBounds(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	if err := m.routineCall(0x200, nil, nil); err == nil {
+		t.Error("routineCall past end of memory did not fail")
+	}
+	m.storeByte(0x1fd, 2)
+	if err := m.routineCall(0x1fd, nil, nil); err == nil {
+		t.Error("routineCall with locals past end of memory did not fail")
+	}
+	if len(m.stack) != 1 {
+		t.Errorf("len(m.stack) = %d; want 1", len(m.stack))
+	}
+}
+
+func TestPackedAddressBadVersion(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// Corrupt the version byte after loading.
+	m.memory[0] = 9
+	if _, err := m.packedAddress(0x40); err == nil {
+		t.Error("packedAddress in version 9 succeeded")
+	}
+	call := &variableInstruction{version: 3, opcode: 0xe0, types: 0x3fff, operands: [8]Word{0x40}}
+	if err := m.stepVariableInstruction(decoded(call)); err == nil {
+		t.Error("call_vs in version 9 succeeded")
+	} else if _, ok := err.(instructionError); !ok {
+		t.Errorf("call_vs error = %#v; want instructionError", err)
+	}
+	printPaddr := &shortInstruction{version: 3, opcode: 0x8d, operand: 0x40}
+	if err := m.step1OPInstruction(decoded(printPaddr)); err == nil {
+		t.Error("print_paddr in version 9 succeeded")
+	}
+}
+
+func TestNot(t *testing.T) {
+	tests := []struct {
+		Input, Output Word
+	}{
+		{0x0000, 0xffff},
+		{0xffff, 0x0000},
+		{0xaaaa, 0x5555},
+		{0x00ff, 0xff00},
+	}
+	for _, tt := range tests {
+		m4, _ := newTestMachine(4, 0x200)
+		in1 := &shortInstruction{version: 4, opcode: 0x8f, operand: tt.Input, storeVariable: 0}
+		if err := m4.step1OPInstruction(decoded(in1)); err != nil {
+			t.Errorf("1OP not %v error: %v", tt.Input, err)
+		} else if w := m4.currStackFrame().Pop(); w != tt.Output {
+			t.Errorf("1OP not %v = %v; want %v", tt.Input, w, tt.Output)
+		}
+
+		m5, _ := newTestMachine(5, 0x200)
+		inv := &variableInstruction{version: 5, opcode: 0xf8, types: 0x3fff, operands: [8]Word{tt.Input}, storeVariable: 0}
+		if err := m5.stepVariableInstruction(decoded(inv)); err != nil {
+			t.Errorf("VAR not %v error: %v", tt.Input, err)
+		} else if w := m5.currStackFrame().Pop(); w != tt.Output {
+			t.Errorf("VAR not %v = %v; want %v", tt.Input, w, tt.Output)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	tests := []struct {
+		Input  Word
+		Places int16
+		Log    Word
+		Art    Word
+	}{
+		{0x0001, 0, 0x0001, 0x0001},
+		{0x0001, 4, 0x0010, 0x0010},
+		{0x0001, 15, 0x8000, 0x8000},
+		{0x0001, 16, 0x0000, 0x0000},
+		{0x0001, 20, 0x0000, 0x0000},
+		{0x8000, -1, 0x4000, 0xc000},
+		{0x8000, -15, 0x0001, 0xffff},
+		{0x8000, -16, 0x0000, 0xffff},
+		{0x8000, -20, 0x0000, 0xffff},
+		{0x7fff, -16, 0x0000, 0x0000},
+		{0x7fff, -20, 0x0000, 0x0000},
+		{0xffff, 16, 0x0000, 0x0000},
+	}
+	for _, tt := range tests {
+		if w := logShift(tt.Input, tt.Places); w != tt.Log {
+			t.Errorf("log_shift %v %d = %v; want %v", tt.Input, tt.Places, w, tt.Log)
+		}
+		if w := artShift(tt.Input, tt.Places); w != tt.Art {
+			t.Errorf("art_shift %v %d = %v; want %v", tt.Input, tt.Places, w, tt.Art)
+		}
+	}
+}
+
+func TestPrintChar(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Table   []rune
+		Code    Word
+		Output  string
+		Error   bool
+	}{
+		{3, nil, 'A', "A", false},
+		{3, nil, 155, "ä", false},
+		{5, nil, 223, "¿", false},
+		{3, nil, 224, "", true},
+		{5, []rune{'€'}, 155, "€", false},
+		{5, []rune{'€'}, 156, "", true},
+	}
+	for _, tt := range tests {
+		m, ui := newTestMachine(tt.Version, 0x200)
+		if tt.Table != nil {
+			// Header extension table at 0x40, Unicode table at 0x50.
+			m.memory[0x37] = 0x40
+			m.memory[0x41] = 3
+			m.memory[0x47] = 0x50
+			m.memory[0x50] = byte(len(tt.Table))
+			for i, r := range tt.Table {
+				m.memory[0x51+i*2], m.memory[0x52+i*2] = byte(r>>8), byte(r)
+			}
+		}
+		in := &variableInstruction{version: tt.Version, opcode: 0xe5, types: 0x3fff, operands: [8]Word{tt.Code}}
+		err := m.stepVariableInstruction(decoded(in))
+		if tt.Error {
+			if err == nil {
+				t.Errorf("v%d print_char %d succeeded with %q; want error", tt.Version, tt.Code, ui.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("v%d print_char %d error: %v", tt.Version, tt.Code, err)
+		} else if s := ui.String(); s != tt.Output {
+			t.Errorf("v%d print_char %d printed %q; want %q", tt.Version, tt.Code, s, tt.Output)
+		}
+	}
+}
+
+func TestStepAllocs(t *testing.T) {
+	m, _ := newTestMachine(3, 0x1000)
+	// add 1 2 -> sp; pop; jump -6
+	code := []byte{0x14, 0x01, 0x02, 0x00, 0xb9, 0x8c, 0xff, 0xfa}
+	copy(m.memory[0x100:], code)
+	m.currStackFrame().PC = 0x100
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := 0; i < 3; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Step allocated %v times per loop; want 0", allocs)
+	}
+	if pc := m.PC(); pc != 0x100 {
+		t.Errorf("PC = %v; want 00100", pc)
+	}
+}
+
+func TestJumpTargets(t *testing.T) {
+	tests := []struct {
+		Name string
+		Code []byte
+		PC   Address // 0 for an error
+	}{
+		{"jump", []byte{0x8c, 0x00, 0x4f}, 0x150},
+		{"jump to 0", []byte{0x8c, 0xfe, 0xff}, 0},
+		{"jump into header", []byte{0x8c, 0xff, 0x1f}, 0},
+		{"jump past end", []byte{0x8c, 0x00, 0xff}, 0},
+		{"branch", []byte{0x01, 0x01, 0x01, 0x80, 0x4d}, 0x150},
+		{"branch into header", []byte{0x01, 0x01, 0x01, 0xbf, 0x1d}, 0},
+		{"branch past end", []byte{0x01, 0x01, 0x01, 0x81, 0xfd}, 0},
+		{"branch not taken", []byte{0x01, 0x01, 0x02, 0x81, 0xfd}, 0x105},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(3, 0x200)
+		copy(m.memory[0x100:], tt.Code)
+		m.currStackFrame().PC = 0x100
+		err := m.Step()
+		if tt.PC == 0 {
+			if err == nil || !strings.Contains(err.Error(), "outside memory") {
+				t.Errorf("%s: Step() = %v; want outside memory", tt.Name, err)
+			}
+			if pc := m.PC(); pc != 0x100 {
+				t.Errorf("%s: PC after error = %v; want 00100", tt.Name, pc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.Name, err)
+		}
+		if pc := m.PC(); pc != tt.PC {
+			t.Errorf("%s: PC = %v; want %v", tt.Name, pc, tt.PC)
+		}
+	}
+}
+
+func TestBranchReturnFromMain(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		m, _ := newTestMachine(3, 0x200, Strict(strict))
+		// je 1 1 ?rtrue
+		copy(m.memory[0x100:], []byte{0x01, 0x01, 0x01, 0xc1})
+		m.currStackFrame().PC = 0x100
+		err := m.Step()
+		if isEnd := errors.Is(err, ErrReturnFromMain); err == nil || isEnd == strict {
+			t.Errorf("strict=%t: Step() = %v", strict, err)
+		}
+	}
+}
+
+func TestCallTargets(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Packed Word
+		PC     Address // 0 for a bad routine
+		Reason string
+	}{
+		{"start of static memory", 0x80, 0x101, ""},
+		{"end of memory", 0xff, 0x1ff, ""},
+		{"dynamic memory", 0x40, 0, "is below static memory"},
+		{"past end", 0x100, 0, "is past the end of the story"},
+		{"too many locals", 0x90, 0, "has 16 locals"},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			m, _ := newTestMachine(3, 0x200, Strict(strict))
+			ui := new(warnUI)
+			m.ui = ui
+			// call_vs packed -> sp
+			copy(m.memory[0x40:], []byte{0xe0, 0x3f, byte(tt.Packed >> 8), byte(tt.Packed), 0x00})
+			m.storeByte(0x100, 0)
+			m.storeByte(0x120, 16)
+			m.storeByte(0x1fe, 0)
+			m.currStackFrame().PC = 0x40
+			err := m.Step()
+			if tt.PC != 0 {
+				if err != nil || m.PC() != tt.PC {
+					t.Errorf("%s strict=%t: Step() = %v, PC = %v; want <nil>, %v", tt.Name, strict, err, m.PC(), tt.PC)
+				}
+				continue
+			}
+			want := fmt.Sprintf("Call to %v (packed %#04x) %s", 2*Address(tt.Packed), uint16(tt.Packed), tt.Reason)
+			if strict {
+				if err == nil || !strings.Contains(err.Error(), "@ 00040: "+want) {
+					t.Errorf("%s strict: Step() = %v; want %q", tt.Name, err, want)
+				}
+				continue
+			}
+			if err != nil || m.PC() != 0x45 || m.StackDepth() != 1 {
+				t.Errorf("%s: Step() = %v, PC = %v, depth %d; want <nil>, 00045, 1", tt.Name, err, m.PC(), m.StackDepth())
+			}
+			if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, []Word{0}) {
+				t.Errorf("%s: stack = %v; want [0]", tt.Name, s)
+			}
+			if len(ui.warnings) != 1 || !strings.Contains(ui.warnings[0], want) {
+				t.Errorf("%s: warnings = %q; want %q", tt.Name, ui.warnings, want)
+			}
+		}
+	}
+}
+
+// graphicsUI is a bufferUI with two pictures.
+type graphicsUI struct {
+	bufferUI
+	drawn  []int
+	erased []int
+}
+
+func (ui *graphicsUI) PictureData(n int) (width, height int, ok bool) {
+	switch n {
+	case 0:
+		return 2, 7, true
+	case 1:
+		return 320, 200, true
+	case 2:
+		return 16, 8, true
+	}
+	return 0, 0, false
+}
+
+func (ui *graphicsUI) DrawPicture(window, n, y, x int) error {
+	ui.drawn = append(ui.drawn, window, n, y, x)
+	return nil
+}
+
+func (ui *graphicsUI) ErasePicture(window, n, y, x int) error {
+	ui.erased = append(ui.erased, window, n, y, x)
+	return nil
+}
+
+func TestPictureData(t *testing.T) {
+	const array Address = 0x80
+	tests := []struct {
+		UI      UI
+		Picture Word
+		Branch  bool
+		Array   [2]Word
+	}{
+		{new(bufferUI), 0, false, [2]Word{0xaaaa, 0xaaaa}},
+		{new(bufferUI), 1, false, [2]Word{0xaaaa, 0xaaaa}},
+		{new(graphicsUI), 0, true, [2]Word{7, 2}},
+		{new(graphicsUI), 1, true, [2]Word{200, 320}},
+		{new(graphicsUI), 3, false, [2]Word{0xaaaa, 0xaaaa}},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x200)
+		m.currStackFrame().PC = 0x180
+		m.ui = tt.UI
+		m.storeWord(array, 0xaaaa)
+		m.storeWord(array+2, 0xaaaa)
+		pc := m.PC()
+		in := decoded(&extendedInstruction{
+			version:  5,
+			opcode:   0x06,
+			types:    0x5f,
+			operands: [4]Word{tt.Picture, Word(array)},
+			branch:   0xca00, // branch on true, offset 10
+		})
+		if err := m.stepExtendedInstruction(in); err != nil {
+			t.Errorf("%T: picture_data %d: %v", tt.UI, tt.Picture, err)
+			continue
+		}
+		if branched := m.PC() != pc; branched != tt.Branch {
+			t.Errorf("%T: picture_data %d branched = %t; want %t", tt.UI, tt.Picture, branched, tt.Branch)
+		}
+		if a := [2]Word{m.loadWord(array), m.loadWord(array + 2)}; a != tt.Array {
+			t.Errorf("%T: picture_data %d array = %v; want %v", tt.UI, tt.Picture, a, tt.Array)
+		}
+	}
+}
+
+func TestDrawPicture(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	ui := new(graphicsUI)
+	m.ui = ui
+	steps := []*extendedInstruction{
+		{version: 5, opcode: 0x05, types: 0x57, operands: [4]Word{1, 10, 20}},
+		{version: 5, opcode: 0x07, types: 0x7f, operands: [4]Word{2}},
+		{version: 5, opcode: 0x1c, types: 0x7f, operands: [4]Word{0x100}},
+	}
+	for _, in := range steps {
+		if err := m.stepExtendedInstruction(decoded(in)); err != nil {
+			t.Errorf("%v: %v", in.Name(), err)
+		}
+	}
+	if want := []int{0, 1, 10, 20}; !reflect.DeepEqual(ui.drawn, want) {
+		t.Errorf("drawn = %v; want %v", ui.drawn, want)
+	}
+	if want := []int{0, 2, 0, 0}; !reflect.DeepEqual(ui.erased, want) {
+		t.Errorf("erased = %v; want %v", ui.erased, want)
+	}
+
+	// Coordinates are relative to the current window.
+	ui.drawn = nil
+	m.setWindow(1)
+	if err := m.stepExtendedInstruction(decoded(steps[0])); err != nil {
+		t.Errorf("draw_picture in window 1: %v", err)
+	}
+	if want := []int{1, 1, 10, 20}; !reflect.DeepEqual(ui.drawn, want) {
+		t.Errorf("drawn in window 1 = %v; want %v", ui.drawn, want)
+	}
+
+	// Without graphics, the opcodes do nothing.
+	m, _ = newTestMachine(5, 0x200)
+	for _, in := range steps {
+		if err := m.stepExtendedInstruction(decoded(in)); err != nil {
+			t.Errorf("%v without graphics: %v", in.Name(), err)
+		}
+	}
+}
+
+func TestPictureTable(t *testing.T) {
+	const table Address = 0x100
+	tests := []struct {
+		Name     string
+		UI       UI
+		Pictures []Word
+		OK       bool
+	}{
+		{"empty", new(graphicsUI), nil, true},
+		{"known pictures", new(graphicsUI), []Word{1, 2}, true},
+		{"unknown picture", new(graphicsUI), []Word{1, 5}, false},
+		{"no graphics", new(bufferUI), []Word{1, 5}, true},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			m, _ := newTestMachine(5, 0x200, Strict(strict))
+			m.ui = tt.UI
+			for i, n := range tt.Pictures {
+				m.storeWord(table+Address(i)*2, n)
+			}
+			in := decoded(&extendedInstruction{version: 5, opcode: 0x1c, types: 0x7f, operands: [4]Word{Word(table)}})
+			if err := m.stepExtendedInstruction(in); (err == nil) != (tt.OK || !strict) {
+				t.Errorf("%s strict=%t: picture_table = %v", tt.Name, strict, err)
+			}
+		}
+	}
+
+	// A table without its terminating 0 runs past the end of memory.
+	m, _ := newTestMachine(5, 0x200, Strict(true))
+	m.storeWord(0x1fe, 1)
+	in := decoded(&extendedInstruction{version: 5, opcode: 0x1c, types: 0x7f, operands: [4]Word{0x1fe}})
+	var merr *MemoryError
+	if err := m.stepExtendedInstruction(in); !errors.As(err, &merr) {
+		t.Errorf("picture_table past end = %v; want MemoryError", err)
+	}
+}
+
+func TestTableStoresStatic(t *testing.T) {
+	// Both tables start at 0xfe, so their second word is in static memory.
+	tests := []struct {
+		Name string
+		Step func(m *Machine) error
+	}{
+		{"get_cursor", func(m *Machine) error { return m.stepVariableInstruction(testVAR(0xf0, 0xfe)) }},
+		{"picture_data", func(m *Machine) error {
+			return m.stepExtendedInstruction(decoded(&extendedInstruction{version: 5, opcode: 0x06, types: 0x5f, operands: [4]Word{1, 0xfe}, branch: 0xca00}))
+		}},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			m, _ := newTestMachine(5, 0x200, Strict(strict))
+			m.currStackFrame().PC = 0x180
+			m.ui = new(graphicsUI)
+			m.storeWord(0xfe, 0xaaaa)
+			err := tt.Step(m)
+			if strict {
+				if err == nil || !strings.Contains(err.Error(), "outside dynamic memory") {
+					t.Errorf("%s strict: %v; want write outside dynamic memory", tt.Name, err)
+				}
+			} else if err != nil {
+				t.Errorf("%s lenient: %v; want <nil>", tt.Name, err)
+			}
+			if w := m.loadWord(0xfe); w != 0xaaaa {
+				t.Errorf("%s strict=%t: word at 0xfe = %#04x; want 0xaaaa", tt.Name, strict, w)
+			}
+			if w := m.loadWord(0x100); w != 0 {
+				t.Errorf("%s strict=%t: word at 0x100 = %#04x; want 0", tt.Name, strict, w)
+			}
+		}
+	}
+}
+
+// pointerUI is a bufferUI with a mouse that has just been clicked.
+type pointerUI struct {
+	bufferUI
+	click rune
+}
+
+func (ui *pointerUI) MouseState() (x, y int, buttons Word) {
+	return 30, 4, 1
+}
+
+func (ui *pointerUI) ReadRune() (rune, int, error) {
+	return ui.click, 3, nil
+}
+
+func TestReadMouse(t *testing.T) {
+	const array Address = 0x80
+	tests := []struct {
+		UI    UI
+		Array [4]Word
+	}{
+		{new(bufferUI), [4]Word{0xaaaa, 0xaaaa, 0xaaaa, 0xaaaa}},
+		{new(pointerUI), [4]Word{4, 30, 1, 0}},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x200)
+		m.ui = tt.UI
+		for i := Address(0); i < 4; i++ {
+			m.storeWord(array+i*2, 0xaaaa)
+		}
+		steps := []*extendedInstruction{
+			{version: 5, opcode: 0x17, types: 0x7f, operands: [4]Word{1}},
+			{version: 5, opcode: 0x16, types: 0x3f, operands: [4]Word{Word(array)}},
+		}
+		for _, in := range steps {
+			if err := m.stepExtendedInstruction(decoded(in)); err != nil {
+				t.Errorf("%T: %v: %v", tt.UI, in.Name(), err)
+			}
+		}
+		var a [4]Word
+		for i := range a {
+			a[i] = m.loadWord(array + Address(i)*2)
+		}
+		if a != tt.Array {
+			t.Errorf("%T: read_mouse array = %v; want %v", tt.UI, a, tt.Array)
+		}
+	}
+}
+
+func TestReadMouseStatic(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		m, _ := newTestMachine(5, 0x200, Strict(strict))
+		m.ui = new(pointerUI)
+		// The array's last word is past dynamic memory.
+		in := &extendedInstruction{version: 5, opcode: 0x16, types: 0x3f, operands: [4]Word{0xfa}}
+		err := m.stepExtendedInstruction(decoded(in))
+		if strict && (err == nil || !strings.Contains(err.Error(), "outside dynamic memory")) {
+			t.Errorf("strict: read_mouse = %v; want write outside dynamic memory", err)
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient: read_mouse = %v; want <nil>", err)
+		}
+		if w := m.loadWord(0xfa); w != 0 {
+			t.Errorf("strict=%t: y = %d; want 0", strict, w)
+		}
+		if w := m.loadWord(0x100); w != 0 {
+			t.Errorf("strict=%t: word at 0x100 = %d; want 0", strict, w)
+		}
+	}
+}
+
+// cursorUI is a bufferUI that records cursor and window calls.
+type cursorUI struct {
+	bufferUI
+	calls []string
+}
+
+func (ui *cursorUI) SetCursor(line, column int) error {
+	ui.calls = append(ui.calls, fmt.Sprintf("move %d,%d", line, column))
+	return nil
+}
+
+func (ui *cursorUI) SetCursorVisible(visible bool) error {
+	ui.calls = append(ui.calls, fmt.Sprintf("visible %t", visible))
+	return nil
+}
+
+func TestSetCursor(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Line    Word
+		Column  Word
+		Call    string
+	}{
+		{5, 3, 10, "move 3,10"},
+		{6, 3, 10, "move 3,10"},
+		{6, 0xffff, 0, "visible false"},
+		{6, 0xfffe, 0, "visible true"},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(tt.Version, 0x200)
+		ui := new(cursorUI)
+		m.ui = ui
+		in := &variableInstruction{version: tt.Version, opcode: 0xef, types: 0x0fff, operands: [8]Word{tt.Line, tt.Column}}
+		if err := m.stepVariableInstruction(decoded(in)); err != nil {
+			t.Errorf("v%d set_cursor %d %d: %v", tt.Version, int16(tt.Line), tt.Column, err)
+			continue
+		}
+		if want := []string{tt.Call}; !reflect.DeepEqual(ui.calls, want) {
+			t.Errorf("v%d set_cursor %d %d calls = %q; want %q", tt.Version, int16(tt.Line), tt.Column, ui.calls, want)
+		}
+	}
+}
+
+func (ui *cursorUI) SplitWindow(lines int) error {
+	ui.calls = append(ui.calls, fmt.Sprintf("split %d", lines))
+	return nil
+}
+
+func (ui *cursorUI) EraseWindow(window int) error {
+	ui.calls = append(ui.calls, fmt.Sprintf("erase %d", window))
+	return nil
+}
+
+func (ui *cursorUI) EraseLine() error {
+	ui.calls = append(ui.calls, "erase line")
+	return nil
+}
+
+func TestEraseWindow(t *testing.T) {
+	tests := []struct {
+		Opcode uint8
+		Arg    Word
+		Calls  []string
+		Window int
+	}{
+		{0xed, 0, []string{"erase 0"}, 1},
+		{0xed, 1, []string{"erase 1"}, 1},
+		{0xed, 0xffff, []string{"split 0", "erase -1"}, 0},
+		{0xed, 0xfffe, []string{"erase -1"}, 1},
+		{0xee, 1, []string{"erase line"}, 1},
+		{0xee, 2, nil, 1},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x200)
+		ui := new(cursorUI)
+		m.ui = ui
+		m.window = 1
+		in := &variableInstruction{version: 5, opcode: tt.Opcode, types: 0x3fff, operands: [8]Word{tt.Arg}}
+		if err := m.stepVariableInstruction(decoded(in)); err != nil {
+			t.Errorf("opcode %#02x %d: %v", tt.Opcode, int16(tt.Arg), err)
+			continue
+		}
+		if !reflect.DeepEqual(ui.calls, tt.Calls) {
+			t.Errorf("opcode %#02x %d calls = %q; want %q", tt.Opcode, int16(tt.Arg), ui.calls, tt.Calls)
+		}
+		if m.window != tt.Window {
+			t.Errorf("opcode %#02x %d: window = %d; want %d", tt.Opcode, int16(tt.Arg), m.window, tt.Window)
+		}
+	}
+}
+
+// menuUI is a pointerUI that records menus.
+type menuUI struct {
+	pointerUI
+	menus map[int][]string
+}
+
+func (ui *menuUI) MakeMenu(id int, items []string) error {
+	if id < 3 {
+		return fmt.Errorf("menu %d is reserved", id)
+	}
+	if ui.menus == nil {
+		ui.menus = make(map[int][]string)
+	}
+	ui.menus[id] = items
+	return nil
+}
+
+func (ui *menuUI) RemoveMenu(id int) error {
+	if _, ok := ui.menus[id]; !ok {
+		return fmt.Errorf("no menu %d", id)
+	}
+	delete(ui.menus, id)
+	return nil
+}
+
+func (ui *menuUI) MenuSelection() (id, item int) {
+	return 3, 2
+}
+
+func TestMakeMenu(t *testing.T) {
+	const (
+		table Address = 0x100
+		items Address = 0x120
+	)
+	m, _ := newTestMachine(6, 0x200)
+	m.currStackFrame().PC = 0x180
+	m.storeWord(table, 3)
+	a := items
+	for i, s := range []string{"Journey", "Look Around", ""} {
+		m.storeWord(table+2+Address(i)*2, Word(a))
+		m.storeByte(a, byte(len(s)))
+		m.storeBytes(a+1, []byte(s))
+		a += 1 + Address(len(s))
+	}
+	makeMenu := func(id, tab Word) (bool, error) {
+		pc := m.PC()
+		err := m.stepExtendedInstruction(decoded(&extendedInstruction{
+			version:  6,
+			opcode:   0x1b,
+			types:    0x1f,
+			operands: [4]Word{id, tab},
+			branch:   0xca00, // branch on true, offset 10
+		}))
+		return m.PC() != pc, err
+	}
+
+	if ok, err := makeMenu(3, Word(table)); err != nil || ok {
+		t.Errorf("make_menu without a Menuer = %t, %v; want false, <nil>", ok, err)
+	}
+
+	ui := new(menuUI)
+	m.ui = ui
+	if ok, err := makeMenu(3, Word(table)); err != nil || !ok {
+		t.Errorf("make_menu 3 = %t, %v; want true, <nil>", ok, err)
+	}
+	if want := []string{"Journey", "Look Around", ""}; !reflect.DeepEqual(ui.menus[3], want) {
+		t.Errorf("menu 3 = %q; want %q", ui.menus[3], want)
+	}
+	if ok, err := makeMenu(1, Word(table)); err != nil || ok {
+		t.Errorf("make_menu 1 = %t, %v; want false, <nil>", ok, err)
+	}
+
+	// A menu selection is read as key 252, then read_mouse gives the item.
+	ui.click = MenuClick
+	r, err := m.readChar()
+	if err != nil || keyCode(r) != 252 {
+		t.Errorf("read_char = %d, %v; want 252, <nil>", keyCode(r), err)
+	}
+	const mouse Address = 0x80
+	if err := m.stepExtendedInstruction(decoded(&extendedInstruction{version: 6, opcode: 0x16, types: 0x3f, operands: [4]Word{Word(mouse)}})); err != nil {
+		t.Fatal("read_mouse:", err)
+	}
+	if w := m.loadWord(mouse + 6); w != 0x0302 {
+		t.Errorf("read_mouse menu word = %#04x; want 0x0302", w)
+	}
+
+	if ok, err := makeMenu(3, 0); err != nil || !ok {
+		t.Errorf("make_menu 3 0 = %t, %v; want true, <nil>", ok, err)
+	}
+	if _, ok := ui.menus[3]; ok {
+		t.Error("menu 3 not removed")
+	}
+}
+
+func TestLoadWordOpcode(t *testing.T) {
+	tests := []struct {
+		Array, Index Word
+		Want         Word
+		Err          bool
+	}{
+		{0x100, 3, 0xbeef, false},
+		{0x10c, 0xfffd, 0xbeef, false},
+		{0x100, 0x200, 0, true},
+		{0x1fe, 1, 0, true},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(3, 0x200)
+		m.storeWord(0x106, 0xbeef)
+		// loadw array index -> sp
+		copy(m.memory[0x40:], []byte{0xcf, 0x0f, byte(tt.Array >> 8), byte(tt.Array), byte(tt.Index >> 8), byte(tt.Index), 0x00})
+		m.currStackFrame().PC = 0x40
+		err := m.Step()
+		if tt.Err {
+			var merr *MemoryError
+			if !errors.As(err, &merr) {
+				t.Errorf("loadw %#04x %#04x: Step() = %v; want MemoryError", uint16(tt.Array), uint16(tt.Index), err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadw %#04x %#04x: Step() = %v", uint16(tt.Array), uint16(tt.Index), err)
+			continue
+		}
+		if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, []Word{tt.Want}) {
+			t.Errorf("loadw %#04x %#04x: stack = %v; want [%v]", uint16(tt.Array), uint16(tt.Index), s, tt.Want)
+		}
+	}
+}
+
+func TestStoreWordOutOfRange(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// storew 0x0100 0x0200 1
+	copy(m.memory[0x40:], []byte{0xe1, 0x07, 0x01, 0x00, 0x02, 0x00, 0x01})
+	m.currStackFrame().PC = 0x40
+	var merr *MemoryError
+	if err := m.Step(); !errors.As(err, &merr) {
+		t.Errorf("Step() = %v; want MemoryError", err)
+	}
+}
+
+func TestStepPanicNamesInstruction(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// get_parent 200 -> sp, whose entry is past the end of memory
+	copy(m.memory[0x40:], []byte{0x93, 200, 0x00})
+	m.currStackFrame().PC = 0x40
+	err := m.Step()
+	var ierr instructionError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("Step() = %v; want instructionError", err)
+	}
+	if ierr.PC != 0x40 || ierr.Instruction == nil || ierr.Instruction.Name() != "get_parent" {
+		t.Errorf("Step() = %v; want get_parent @ 00040", err)
+	}
+}
+
+// TestStepChecks runs instructions that would index past the stack, the
+// locals, or memory, and checks that Step reports them without falling back
+// on recovering from a runtime panic.
+func TestStepChecks(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Instr func(b *zasm.Builder)
+	}{
+		{"pop empty stack", func(b *zasm.Builder) {
+			b.Instr("add", zasm.SP, zasm.Const(1), zasm.Store(zasm.Global(0)))
+		}},
+		{"pull empty stack", func(b *zasm.Builder) {
+			b.Instr("pull", zasm.Const(0x10))
+		}},
+		{"read missing local", func(b *zasm.Builder) {
+			b.Instr("add", zasm.Local(3), zasm.Const(1), zasm.Store(zasm.Global(0)))
+		}},
+		{"store missing local", func(b *zasm.Builder) {
+			b.Instr("add", zasm.Const(1), zasm.Const(1), zasm.Store(zasm.Local(3)))
+		}},
+		{"inc missing local", func(b *zasm.Builder) {
+			b.Instr("inc", zasm.Const(3))
+		}},
+		{"get_prop object 0", func(b *zasm.Builder) {
+			b.Instr("get_prop", zasm.Const(0), zasm.Const(1), zasm.Store(zasm.SP))
+		}},
+		{"get_prop past memory", func(b *zasm.Builder) {
+			b.Instr("get_prop", zasm.Large(0xffff), zasm.Const(1), zasm.Store(zasm.SP))
+		}},
+		{"put_prop object 0", func(b *zasm.Builder) {
+			b.Instr("put_prop", zasm.Const(0), zasm.Const(1), zasm.Const(1))
+		}},
+		{"get_prop_addr object 0", func(b *zasm.Builder) {
+			b.Instr("get_prop_addr", zasm.Const(0), zasm.Const(1), zasm.Store(zasm.SP))
+		}},
+	}
+	for _, tt := range tests {
+		b := zasm.New(5)
+		b.Routine("main", 1)
+		tt.Instr(b)
+		b.Instr("quit")
+		m := buildMachine(t, b, new(bufferUI))
+		err := m.Step()
+		var rerr runtime.Error
+		if err == nil || errors.As(err, &rerr) {
+			t.Errorf("%s: Step() = %v; want a checked error", tt.Name, err)
+		}
+	}
+}
+
+func TestUnabbreviateRange(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	m.storeWord(0x18, 0x1f0)
+	if _, err := m.Unabbreviate(95); err == nil {
+		t.Error("Unabbreviate(95) with the table near the end of memory succeeded")
+	}
+	if _, err := m.Unabbreviate(-1); err == nil {
+		t.Error("Unabbreviate(-1) succeeded")
+	}
+}
+
+func TestStoreByte(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// storeb 0x0080 3 0x42
+	copy(m.memory[0x40:], []byte{0xe2, 0x07, 0x00, 0x80, 0x00, 0x03, 0x42})
+	m.currStackFrame().PC = 0x40
+	if err := m.Step(); err != nil {
+		t.Fatal("Step():", err)
+	}
+	// The index is a byte offset, not a word offset.
+	if b := m.loadByte(0x83); b != 0x42 {
+		t.Errorf("byte at 0x83 = %#02x; want 0x42", b)
+	}
+	if b := m.loadByte(0x86); b != 0 {
+		t.Errorf("byte at 0x86 = %#02x; want 0", b)
+	}
+
+	// storeb 0x01ff 1 1
+	copy(m.memory[0x40:], []byte{0xe2, 0x07, 0x01, 0xff, 0x00, 0x01, 0x01})
+	m.currStackFrame().PC = 0x40
+	var merr *MemoryError
+	if err := m.Step(); !errors.As(err, &merr) {
+		t.Errorf("Step() past end = %v; want MemoryError", err)
+	}
+}
+
+func TestStoreStatic(t *testing.T) {
+	tests := []struct {
+		Name string
+		Code []byte
+	}{
+		// storew 0x00ff 0 1
+		{"storew", []byte{0xe1, 0x07, 0x00, 0xff, 0x00, 0x00, 0x01}},
+		// storeb 0x0100 0 1
+		{"storeb", []byte{0xe2, 0x07, 0x01, 0x00, 0x00, 0x00, 0x01}},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			m, _ := newTestMachine(3, 0x200, Strict(strict))
+			ui := new(warnUI)
+			m.ui = ui
+			copy(m.memory[0x40:], tt.Code)
+			m.currStackFrame().PC = 0x40
+			err := m.Step()
+			if strict {
+				if err == nil || !strings.Contains(err.Error(), "outside dynamic memory") {
+					t.Errorf("%s strict: Step() = %v; want write outside dynamic memory", tt.Name, err)
+				}
+				continue
+			}
+			if err != nil || len(ui.warnings) != 1 {
+				t.Errorf("%s lenient: Step() = %v, warnings = %q; want <nil> and 1 warning", tt.Name, err, ui.warnings)
+			}
+			if b := m.loadByte(0x100); b != 0 {
+				t.Errorf("%s lenient: byte at 0x100 = %#02x; want 0", tt.Name, b)
+			}
+		}
+	}
+}
+
+func TestCompareBranches(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Opcode byte
+		Ops    []Word
+		Want   bool
+	}{
+		{"jl -1 1", 0x02, []Word{0xffff, 1}, true},
+		{"jl 1 -1", 0x02, []Word{1, 0xffff}, false},
+		{"jl -2 -1", 0x02, []Word{0xfffe, 0xffff}, true},
+		{"jl 5 5", 0x02, []Word{5, 5}, false},
+		{"jl 0x7fff -0x8000", 0x02, []Word{0x7fff, 0x8000}, false},
+		{"jg -1 1", 0x03, []Word{0xffff, 1}, false},
+		{"jg 1 -1", 0x03, []Word{1, 0xffff}, true},
+		{"jg 5 5", 0x03, []Word{5, 5}, false},
+		{"jg 0x7fff -0x8000", 0x03, []Word{0x7fff, 0x8000}, true},
+		{"je -1 0xffff", 0x01, []Word{0xffff, 0xffff}, true},
+		{"je -1 1", 0x01, []Word{0xffff, 1}, false},
+		{"je 0x8000 0", 0x01, []Word{0x8000, 0}, false},
+		{"je 3 of 4", 0x01, []Word{7, 1, 2, 7}, true},
+		{"je 2 of 4", 0x01, []Word{7, 1, 7, 2}, true},
+		{"je none of 4", 0x01, []Word{7, 1, 2, 3}, false},
+		{"je none of 3", 0x01, []Word{0xffff, 1, 0x7fff}, false},
+	}
+	for _, tt := range tests {
+		for _, onTrue := range []bool{true, false} {
+			m, _ := newTestMachine(3, 0x200)
+			// Variable form with large constants, branching 16 bytes ahead.
+			code := []byte{0xc0 | tt.Opcode, 0xff}
+			for i, op := range tt.Ops {
+				code[1] &^= 3 << uint(6-2*i)
+				code = append(code, byte(op>>8), byte(op))
+			}
+			br := byte(0x40 | 0x10)
+			if onTrue {
+				br |= 0x80
+			}
+			code = append(code, br)
+			copy(m.memory[0x80:], code)
+			m.currStackFrame().PC = 0x80
+			if err := m.Step(); err != nil {
+				t.Errorf("%s (on %t): Step() = %v", tt.Name, onTrue, err)
+				continue
+			}
+			next := Address(0x80 + len(code))
+			want := next
+			if tt.Want == onTrue {
+				want += 0x10 - 2
+			}
+			if m.PC() != want {
+				t.Errorf("%s (on %t): PC = %v; want %v", tt.Name, onTrue, m.PC(), want)
+			}
+		}
+	}
+}
+
+func TestIndirectStack(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Code   []byte
+		Stack  []Word
+		Branch bool
+	}{
+		{"inc_chk sp 10", []byte{0x05, 0x00, 0x0a, 0xd0}, []Word{5, 11}, true},
+		{"inc_chk sp 20", []byte{0x05, 0x00, 0x14, 0xd0}, []Word{5, 11}, false},
+		{"dec_chk sp 10", []byte{0x04, 0x00, 0x0a, 0xd0}, []Word{5, 9}, true},
+		{"dec_chk sp 0", []byte{0x04, 0x00, 0x00, 0xd0}, []Word{5, 9}, false},
+		{"inc sp", []byte{0x95, 0x00}, []Word{5, 11}, false},
+		{"dec sp", []byte{0x96, 0x00}, []Word{5, 9}, false},
+		{"load sp -> sp", []byte{0x9e, 0x00, 0x00}, []Word{5, 10, 10}, false},
+		{"store sp 7", []byte{0x0d, 0x00, 0x07}, []Word{5, 7}, false},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(3, 0x200)
+		copy(m.memory[0x80:], tt.Code)
+		f := m.currStackFrame()
+		f.PC = 0x80
+		f.Stack = []Word{5, 10}
+		if err := m.Step(); err != nil {
+			t.Errorf("%s: Step() = %v", tt.Name, err)
+			continue
+		}
+		if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, tt.Stack) {
+			t.Errorf("%s: stack = %v; want %v", tt.Name, s, tt.Stack)
+		}
+		want := Address(0x80 + len(tt.Code))
+		if tt.Branch {
+			want += 0x10 - 2
+		}
+		if m.PC() != want {
+			t.Errorf("%s: PC = %v; want %v", tt.Name, m.PC(), want)
+		}
+	}
+}
+
+func TestIndirectStackUnderflow(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// inc_chk sp 10 ?(+16)
+	copy(m.memory[0x80:], []byte{0x05, 0x00, 0x0a, 0xd0})
+	m.currStackFrame().PC = 0x80
+	if err := m.Step(); !errors.Is(err, errStackUnderflow) {
+		t.Errorf("Step() = %v; want %v", err, errStackUnderflow)
+	}
+}
+
+func TestCallZero(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Name    string
+		Args    int
+		Store   bool
+	}{
+		{3, "call_vs", 2, true},
+		{4, "call_1s", 0, true},
+		{4, "call_2s", 1, true},
+		{4, "call_vs", 3, true},
+		{4, "call_vs2", 5, true},
+		{5, "call_1n", 0, false},
+		{5, "call_2n", 1, false},
+		{5, "call_vn", 3, false},
+		{5, "call_vn2", 5, false},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		args := []zasm.Arg{zasm.Const(0)}
+		for i := 0; i < tt.Args; i++ {
+			args = append(args, zasm.Const(uint16(i+1)))
+		}
+		if tt.Store {
+			args = append(args, zasm.Store(zasm.Var(0)))
+		}
+		b.Instr(tt.Name, args...)
+		b.Instr("push", zasm.Const(7))
+		b.Instr("quit")
+		m := buildMachine(t, b, new(bufferUI))
+		for i := 0; i < 2; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("%s step %d: %v", tt.Name, i, err)
+			}
+		}
+		want := []Word{7}
+		if tt.Store {
+			want = []Word{0, 7}
+		}
+		if m.StackDepth() != 1 {
+			t.Errorf("%s: depth = %d; want 1", tt.Name, m.StackDepth())
+		}
+		if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, want) {
+			t.Errorf("%s: stack = %v; want %v", tt.Name, s, want)
+		}
+	}
+}
+
+// fontUI is a bufferUI with fonts 1 and 4 that records the fonts it's asked
+// for.
+type fontUI struct {
+	bufferUI
+	calls []Word
+}
+
+func (ui *fontUI) SetFont(n Word) bool {
+	ui.calls = append(ui.calls, n)
+	return n == 1 || n == 4
+}
+
+func TestSetFont(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("set_font", zasm.Const(0), zasm.Store(zasm.SP)) // query: 1
+	b.Instr("set_font", zasm.Const(4), zasm.Store(zasm.SP)) // switch: 1
+	b.Instr("set_font", zasm.Const(0), zasm.Store(zasm.SP)) // query: 4
+	b.Instr("set_font", zasm.Const(3), zasm.Store(zasm.SP)) // fail: 0
+	b.Instr("set_font", zasm.Const(0), zasm.Store(zasm.SP)) // query: 4
+	b.Instr("set_window", zasm.Const(1))
+	b.Instr("set_font", zasm.Const(0), zasm.Store(zasm.SP)) // query: 1
+	b.Instr("set_window", zasm.Const(0))
+	b.Instr("set_font", zasm.Const(0), zasm.Store(zasm.SP)) // query: 4
+	b.Instr("quit")
+	ui := new(fontUI)
+	m := buildMachine(t, b, ui)
+	for i := 0; i < 9; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if got, want := m.Frames()[0].Stack, []Word{1, 1, 4, 0, 4, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("set_font results = %v; want %v", got, want)
+	}
+	// The UI is switched back to each window's font as it's selected.
+	if want := []Word{4, 3, 1, 4}; !reflect.DeepEqual(ui.calls, want) {
+		t.Errorf("SetFont calls = %v; want %v", ui.calls, want)
+	}
+}
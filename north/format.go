@@ -0,0 +1,155 @@
+package north
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrUnknownFormat is returned when loading a file that isn't a recognized
+// story file format.
+var ErrUnknownFormat = errors.New("unrecognized story file format")
+
+// A VersionError is returned when loading a story whose version the machine
+// can't run.
+type VersionError struct {
+	Version byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("Unknown story version %d", e.Version)
+}
+
+// checkVersion returns a *VersionError if version can't be run.  Versions 1-8
+// are recognized, but versions 6 and 7 are only partially supported.
+func checkVersion(version byte) error {
+	switch version {
+	case 1, 2, 3, 4, 5, 6, 7, 8:
+		return nil
+	}
+	return &VersionError{version}
+}
+
+// headerSize is the length of the Z-machine story file header.
+const headerSize = 64
+
+// unwrapStory returns the Z-code image contained in data.  data may be a bare
+// image, a Blorb file with an executable chunk, or a gzip-compressed version of
+// either.  The Blorb's other chunks are returned in blorb, which is nil for
+// bare images.
+func unwrapStory(data []byte) (story []byte, blorb *blorbInfo, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer r.Close()
+		inner, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bytes.HasPrefix(inner, []byte{0x1f, 0x8b}) {
+			return nil, nil, ErrUnknownFormat
+		}
+		return unwrapStory(inner)
+	case len(data) >= 12 && string(data[0:4]) == "FORM" && string(data[8:12]) == "IFRS":
+		blorb, err := parseBlorb(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return blorb.story, blorb, nil
+	case isBareStory(data):
+		return data, nil, nil
+	}
+	return nil, nil, ErrUnknownFormat
+}
+
+// blorbInfo holds the chunks of a Blorb file that the machine uses.
+type blorbInfo struct {
+	story []byte
+
+	// ifmd is the iFiction metadata, or nil if the file has none.
+	ifmd []byte
+
+	// frontispiece and release are -1 if the file doesn't give them.
+	frontispiece int
+	release      int
+}
+
+// parseBlorb finds the Z-code and metadata chunks in a Blorb file.
+func parseBlorb(data []byte) (*blorbInfo, error) {
+	end := 8 + int(binary.BigEndian.Uint32(data[4:8]))
+	if end > len(data) {
+		return nil, errors.New("blorb: truncated file")
+	}
+	info := &blorbInfo{frontispiece: -1, release: -1}
+	for i := 12; i+8 <= end; {
+		id := string(data[i : i+4])
+		n := int(binary.BigEndian.Uint32(data[i+4 : i+8]))
+		i += 8
+		if n < 0 || i+n > end {
+			return nil, errors.New("blorb: truncated chunk " + id)
+		}
+		chunk := data[i : i+n]
+		switch id {
+		case "ZCOD":
+			if info.story != nil {
+				break
+			}
+			if n < headerSize {
+				return nil, errors.New("blorb: Z-code chunk too short")
+			}
+			info.story = chunk
+		case "IFmd":
+			info.ifmd = chunk
+		case "Fspc":
+			if n >= 4 {
+				info.frontispiece = int(binary.BigEndian.Uint32(chunk))
+			}
+		case "RelN":
+			if n >= 2 {
+				info.release = int(binary.BigEndian.Uint16(chunk))
+			}
+		}
+		i += n + n%2
+	}
+	if info.story == nil {
+		return nil, errors.New("blorb: no Z-code chunk")
+	}
+	return info, nil
+}
+
+// isBareStory reports whether data looks like a Z-code image.  The version
+// byte isn't checked, so that unknown versions can be reported as such.
+func isBareStory(data []byte) bool {
+	if len(data) < headerSize {
+		return false
+	}
+	static := int(binary.BigEndian.Uint16(data[0x0e:]))
+	return data[0] != 0 && static >= headerSize && static <= len(data)
+}
+
+// IFID returns the story's Treaty of Babel identifier.  Stories that embed a
+// "UUID://...//" string use it; others get one built from the release,
+// serial, and checksum.  Call IFID before running the story, since it reads
+// the current memory.
+func (m *Machine) IFID() string {
+	if i := bytes.Index(m.memory, []byte("UUID://")); i >= 0 {
+		u := m.memory[i+7:]
+		if len(u) >= 38 && string(u[36:38]) == "//" {
+			return string(bytes.ToUpper(u[:36]))
+		}
+	}
+	h := m.Header()
+	id := fmt.Sprintf("ZCODE-%d-%s", h.Release, h.Serial)
+	if s := h.Serial; s != "000000" && s[0] >= '0' && s[0] <= '9' && s[0] != '8' {
+		// Serials that look like dates need the checksum to tell builds
+		// from the same day apart.
+		id += fmt.Sprintf("-%04X", uint16(h.Checksum))
+	}
+	return id
+}
@@ -0,0 +1,149 @@
+package north
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+)
+
+func testStoryImage() []byte {
+	img := make([]byte, 0x100)
+	img[0] = 5
+	img[0x0f] = 0x80
+	img[0xff] = 0x42
+	return img
+}
+
+// testBlorb wraps chunks (alternating IDs and data) in a Blorb FORM.
+func testBlorb(chunks ...interface{}) []byte {
+	var body bytes.Buffer
+	body.WriteString("IFRS")
+	for i := 0; i < len(chunks); i += 2 {
+		data := chunks[i+1].([]byte)
+		body.WriteString(chunks[i].(string))
+		binary.Write(&body, binary.BigEndian, uint32(len(data)))
+		body.Write(data)
+		if len(data)%2 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("FORM")
+	binary.Write(&b, binary.BigEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+func gzipped(data []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+func TestUnwrapStory(t *testing.T) {
+	img := testStoryImage()
+	blorb := testBlorb("RIdx", []byte{0, 0, 0, 0}, "Fspc", []byte{1, 2, 3}, "ZCOD", img)
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{"bare", img},
+		{"blorb", blorb},
+		{"gzip", gzipped(img)},
+		{"gzip blorb", gzipped(blorb)},
+	}
+	for _, tt := range tests {
+		result, _, err := unwrapStory(tt.Data)
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.Name, err)
+		} else if !bytes.Equal(result, img) {
+			t.Errorf("%s: image differs", tt.Name)
+		}
+	}
+}
+
+func TestUnwrapStoryBogus(t *testing.T) {
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{"empty", nil},
+		{"text", []byte("This is not a story file, just some plain text that goes on and on for a while.")},
+		{"short", []byte{3, 0, 0}},
+		{"blorb without code", testBlorb("RIdx", []byte{0, 0, 0, 0})},
+		{"truncated blorb", testBlorb("ZCOD", testStoryImage())[:100]},
+		{"short blorb code", testBlorb("ZCOD", []byte{5, 0, 0, 0})},
+		{"gzip text", gzipped([]byte("hello"))},
+	}
+	for _, tt := range tests {
+		if _, _, err := unwrapStory(tt.Data); err == nil {
+			t.Errorf("%s: no error", tt.Name)
+		}
+	}
+	if _, err := NewMachine(bytes.NewReader([]byte("bogus")), new(bufferUI)); err != ErrUnknownFormat {
+		t.Errorf("NewMachine(bogus) error = %v; want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestLoadVersion(t *testing.T) {
+	tests := []struct {
+		Version byte
+		OK      bool
+	}{
+		{1, true},
+		{3, true},
+		{5, true},
+		{6, true},
+		{7, true},
+		{8, true},
+		{9, false},
+		{99, false},
+	}
+	for _, tt := range tests {
+		img := testStoryImage()
+		img[0] = tt.Version
+		for _, data := range [][]byte{img, testBlorb("ZCOD", img)} {
+			_, err := NewMachine(bytes.NewReader(data), new(bufferUI))
+			if tt.OK {
+				if err != nil {
+					t.Errorf("version %d: %v", tt.Version, err)
+				}
+				continue
+			}
+			if e, ok := err.(*VersionError); !ok || e.Version != tt.Version {
+				t.Errorf("version %d: error = %v; want VersionError", tt.Version, err)
+			}
+		}
+	}
+}
+
+func TestIFID(t *testing.T) {
+	tests := []struct {
+		Serial string
+		Extra  string
+		IFID   string
+	}{
+		{"260101", "", "ZCODE-7-260101-ABCD"},
+		{"870915", "", "ZCODE-7-870915"},
+		{"000000", "", "ZCODE-7-000000"},
+		{"260101", "UUID://1974a053-7dae-4b64-9b6f-bbf9a2b47f4a//", "1974A053-7DAE-4B64-9B6F-BBF9A2B47F4A"},
+		{"260101", "UUID://too-short//", "ZCODE-7-260101-ABCD"},
+	}
+	for _, tt := range tests {
+		img := testStoryImage()
+		img[0x03] = 7
+		copy(img[0x12:], tt.Serial)
+		img[0x1c], img[0x1d] = 0xab, 0xcd
+		copy(img[0x80:], tt.Extra)
+		m := new(Machine)
+		if err := m.Load(bytes.NewReader(img)); err != nil {
+			t.Fatal(err)
+		}
+		if id := m.IFID(); id != tt.IFID {
+			t.Errorf("IFID() with serial %q and %q != %q (got %q)", tt.Serial, tt.Extra, tt.IFID, id)
+		}
+	}
+}
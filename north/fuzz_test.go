@@ -0,0 +1,39 @@
+package north
+
+import (
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// fuzzSteps is the most instructions FuzzStep runs for one input.
+const fuzzSteps = 1000
+
+func FuzzStep(f *testing.F) {
+	for _, v := range []byte{3, 5, 8} {
+		b := zasm.New(v)
+		b.Routine("main", 0)
+		b.Instr("print", zasm.Text("Hello"))
+		b.Instr("call_vs", zasm.Routine("sub"), zasm.Const(1), zasm.Store(zasm.SP))
+		b.Instr("quit")
+		b.Routine("sub", 1)
+		b.Instr("add", zasm.Local(1), zasm.Const(2), zasm.Store(zasm.SP))
+		b.Instr("ret_popped")
+		img, err := b.Build()
+		if err != nil {
+			f.Fatal("build story:", err)
+		}
+		f.Add(img)
+	}
+	f.Fuzz(func(t *testing.T, story []byte) {
+		m, err := NewMachineFromBytes(story, new(bufferUI))
+		if err != nil {
+			return
+		}
+		for i := 0; i < fuzzSteps; i++ {
+			if m.Step() != nil {
+				return
+			}
+		}
+	})
+}
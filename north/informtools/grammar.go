@@ -0,0 +1,346 @@
+// Package informtools reads the data structures that the Inform 6 compiler
+// lays out in a story file.  The Z-machine doesn't know about them, so this is
+// best-effort static analysis: it only works on stories that look like they
+// were compiled by Inform 6 with grammar version 2, the library's default.
+// Grammar tables in other versions are reported with an
+// UnsupportedGrammarError.
+package informtools
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zombiezen/gonorth/north"
+)
+
+// ErrNotInform is returned for stories that weren't compiled by Inform 6.
+var ErrNotInform = errors.New("Story was not compiled by Inform 6")
+
+// An UnsupportedGrammarError is returned for stories whose grammar tables
+// aren't in grammar version 2.  Version is 1 or 3 if the tables are laid out
+// like that version, or 0 if they don't look like any version.
+type UnsupportedGrammarError struct {
+	Version int
+}
+
+func (e *UnsupportedGrammarError) Error() string {
+	if e.Version == 0 {
+		return "Grammar table is not in a known grammar version"
+	}
+	return fmt.Sprintf("Grammar version %d is not supported", e.Version)
+}
+
+// errNotVersion2 is returned by readLines for lines that can't be grammar
+// version 2.
+var errNotVersion2 = errors.New("Not grammar version 2")
+
+// Grammar is the verb grammar of an Inform story.
+type Grammar struct {
+	Verbs []Verb
+}
+
+// A Verb is a group of synonymous verb words and the grammar lines they
+// share.
+type Verb struct {
+	Number int
+	Words  []string
+	Meta   bool
+	Lines  []Line
+}
+
+// A Line is a grammar line: a sequence of tokens that the parser matches
+// after the verb, and the action it produces.
+type Line struct {
+	Action int
+
+	// Reverse is true if the action's two nouns are swapped.
+	Reverse bool
+
+	Tokens []Token
+}
+
+func (l Line) String() string {
+	var sb strings.Builder
+	sb.WriteString("*")
+	for _, t := range l.Tokens {
+		if t.Alternative {
+			sb.WriteString("/")
+		} else {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(t.String())
+	}
+	fmt.Fprintf(&sb, " -> %d", l.Action)
+	if l.Reverse {
+		sb.WriteString(" reverse")
+	}
+	return sb.String()
+}
+
+// A TokenType says what a grammar token matches.
+type TokenType uint8
+
+// Token types
+const (
+	ElementaryToken   TokenType = 1
+	PrepositionToken  TokenType = 2
+	NounRoutineToken  TokenType = 3
+	AttributeToken    TokenType = 4
+	ScopeRoutineToken TokenType = 5
+	ParseRoutineToken TokenType = 6
+)
+
+// Parts of a grammar version 2 line
+const (
+	actionMask       = 0x03ff
+	actionReverse    = 0x0400
+	tokenTypeMask    = 0x0f
+	tokenFirst       = 0x10 // first of a run of alternatives
+	tokenAlternative = 0x20
+	endOfLine        = 15
+	maxLineTokens    = 32
+)
+
+// A Token is one part of a grammar line.
+type Token struct {
+	Type TokenType
+
+	// Data is the elementary token number, the attribute number, or the
+	// packed address of the routine.  For prepositions, it's the address of
+	// the dictionary entry, and Word is the word.
+	Data north.Word
+	Word string
+
+	// Alternative is true if the token is an alternative to the one before
+	// it, as in 'in'/'into'.
+	Alternative bool
+}
+
+// elementaryTokens are the names of the elementary tokens.
+var elementaryTokens = [...]string{
+	"noun", "held", "multi", "multiheld", "multiexcept", "multiinside",
+	"creature", "special", "number", "topic",
+}
+
+func (t Token) String() string {
+	switch t.Type {
+	case ElementaryToken:
+		if int(t.Data) < len(elementaryTokens) {
+			return elementaryTokens[t.Data]
+		}
+	case PrepositionToken:
+		return "'" + t.Word + "'"
+	case NounRoutineToken:
+		return fmt.Sprintf("noun=%#04x", uint16(t.Data))
+	case AttributeToken:
+		return fmt.Sprintf("attr %d", t.Data)
+	case ScopeRoutineToken:
+		return fmt.Sprintf("scope=%#04x", uint16(t.Data))
+	case ParseRoutineToken:
+		return fmt.Sprintf("%#04x", uint16(t.Data))
+	}
+	return fmt.Sprintf("token(%d, %#04x)", t.Type, uint16(t.Data))
+}
+
+// Dictionary flags in the first data byte of an entry
+const (
+	verbFlag = 0x01
+	metaFlag = 0x02
+)
+
+// InformGrammar reads the verb grammar of m's story.  It returns ErrNotInform
+// if the header doesn't carry an Inform 6 compiler version.  Inform puts the
+// grammar table at the start of static memory: one word per verb, pointing
+// to the verb's grammar lines.  The dictionary says which words are verbs
+// and numbers them from 255 downwards.
+func InformGrammar(m *north.Machine) (*Grammar, error) {
+	if !isInform(m) {
+		return nil, ErrNotInform
+	}
+	dict, err := m.Dictionary()
+	if err != nil {
+		return nil, err
+	}
+	textSize := north.Address(4)
+	if m.Version() >= 4 {
+		textSize = 6
+	}
+	words := make(map[north.Address]string, len(dict.Words))
+	var verbs []Verb
+	for _, e := range dict.Words {
+		words[e.Address] = e.Word
+		data := m.ByteTable(e.Address+textSize, 2)
+		flags, err := data.Get(0)
+		if err != nil {
+			return nil, err
+		}
+		if flags&verbFlag == 0 {
+			continue
+		}
+		num, err := data.Get(1)
+		if err != nil {
+			return nil, err
+		}
+		n := 255 - int(num)
+		for len(verbs) <= n {
+			verbs = append(verbs, Verb{Number: len(verbs)})
+		}
+		verbs[n].Words = append(verbs[n].Words, e.Word)
+		if flags&metaFlag != 0 {
+			verbs[n].Meta = true
+		}
+	}
+
+	table := m.WordTable(m.Header().StaticMemoryBase, len(verbs))
+	for i := range verbs {
+		a, err := table.Get(i)
+		if err != nil {
+			return nil, err
+		}
+		verbs[i].Lines, err = readLines(m, north.Address(a), words)
+		if err == errNotVersion2 {
+			return nil, &UnsupportedGrammarError{Version: guessGrammarVersion(m, table, len(verbs))}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Verb %d: %v", i, err)
+		}
+	}
+	return &Grammar{Verbs: verbs}, nil
+}
+
+// isInform reports whether the header holds an Inform 6 compiler version,
+// like "6.31", and the serial number is a date.
+func isInform(m *north.Machine) bool {
+	var v [4]byte
+	t := m.ByteTable(0x3c, len(v))
+	for i := range v {
+		b, err := t.Get(i)
+		if err != nil {
+			return false
+		}
+		v[i] = byte(b)
+	}
+	if v[0] != '6' || v[1] != '.' || !isDigit(v[2]) || !isDigit(v[3]) {
+		return false
+	}
+	serial := m.Header().Serial
+	for i := 0; i < len(serial); i++ {
+		if !isDigit(serial[i]) {
+			return false
+		}
+	}
+	return len(serial) == 6
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// readLines reads the grammar lines of a verb in grammar version 2: a count
+// of lines, then each line as an action word followed by three-byte tokens
+// up to an end byte.  It returns errNotVersion2 if an action word or token
+// type has bits that version 2 never sets.
+func readLines(m *north.Machine, a north.Address, words map[north.Address]string) ([]Line, error) {
+	r := &byteReader{t: m.ByteTable(a, m.ImageSize()-int(a))}
+	lines := make([]Line, r.byte())
+	for i := range lines {
+		action := r.word()
+		if action&^(actionMask|actionReverse) != 0 {
+			return nil, errNotVersion2
+		}
+		lines[i] = Line{Action: int(action & actionMask), Reverse: action&actionReverse != 0}
+		for {
+			typ := r.byte()
+			if r.err != nil {
+				return nil, r.err
+			}
+			if typ == endOfLine {
+				break
+			}
+			if len(lines[i].Tokens) == maxLineTokens {
+				return nil, fmt.Errorf("Grammar line %d has no end", i)
+			}
+			t := Token{
+				Type:        TokenType(typ & tokenTypeMask),
+				Data:        r.word(),
+				Alternative: typ&tokenAlternative != 0,
+			}
+			if typ&^(tokenTypeMask|tokenFirst|tokenAlternative) != 0 || t.Type < ElementaryToken || t.Type > ParseRoutineToken {
+				return nil, errNotVersion2
+			}
+			if t.Type == PrepositionToken {
+				t.Word = words[north.Address(t.Data)]
+			}
+			lines[i].Tokens = append(lines[i].Tokens, t)
+		}
+	}
+	return lines, r.err
+}
+
+// guessGrammarVersion returns the grammar version that the first n verbs in
+// table are laid out in, if it's 1 or 3, or 0 if it's neither.
+//
+// Version 3 lines are an action word with the number of tokens in its top
+// five bits, followed by two-byte tokens; at least one line in a real table
+// has a token.  Version 1 lines are eight bytes: the number of parameters,
+// up to six, then six tokens and an action byte.
+func guessGrammarVersion(m *north.Machine, table north.Table, n int) int {
+	v3, v1 := true, true
+	tokens := false
+	for i := 0; i < n && (v3 || v1); i++ {
+		a, err := table.Get(i)
+		if err != nil {
+			return 0
+		}
+		r := &byteReader{t: m.ByteTable(north.Address(a), m.ImageSize()-int(a))}
+		nlines := int(r.byte())
+		start := r.i
+		for j := 0; j < nlines && v3; j++ {
+			count := int(r.word() >> 11)
+			tokens = tokens || count > 0
+			r.i += 2 * count
+		}
+		v3 = v3 && r.err == nil && r.i <= r.t.Len()
+		r.i, r.err = start, nil
+		for j := 0; j < nlines && v1; j++ {
+			v1 = r.byte() <= 6
+			r.i += 7
+		}
+		v1 = v1 && r.err == nil && r.i <= r.t.Len()
+	}
+	switch {
+	case v3 && tokens:
+		return 3
+	case v1:
+		return 1
+	}
+	return 0
+}
+
+// A byteReader reads bytes and words from a table, remembering the first
+// error.
+type byteReader struct {
+	t   north.Table
+	i   int
+	err error
+}
+
+func (r *byteReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.t.Get(r.i)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.i++
+	return byte(b)
+}
+
+func (r *byteReader) word() north.Word {
+	hi := r.byte()
+	lo := r.byte()
+	return north.Word(hi)<<8 | north.Word(lo)
+}
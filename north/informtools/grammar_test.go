@@ -0,0 +1,229 @@
+package informtools
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zombiezen/gonorth/north"
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files")
+
+// grammarData returns the grammar lines of the test story's verbs, with
+// prepositions pointing at the dictionary entries in dict.
+func grammarData(dict map[string]north.Address) (take, score []byte) {
+	prep := func(w string) []byte {
+		return []byte{byte(dict[w] >> 8), byte(dict[w])}
+	}
+	take = []byte{4}
+	// * multi -> 1
+	take = append(take, 0x00, 0x01, 0x01, 0x00, 0x02, 0x0f)
+	// * 'off' held -> 2 reverse
+	take = append(take, 0x04, 0x02, 0x02)
+	take = append(take, prep("off")...)
+	take = append(take, 0x01, 0x00, 0x01, 0x0f)
+	// * 'in'/'into' noun -> 3
+	take = append(take, 0x00, 0x03, 0x12)
+	take = append(take, prep("in")...)
+	take = append(take, 0x22)
+	take = append(take, prep("into")...)
+	take = append(take, 0x01, 0x00, 0x00, 0x0f)
+	// * noun=Routine attr 5 scope=Routine Routine -> 4
+	take = append(take, 0x00, 0x04, 0x03, 0x12, 0x34, 0x04, 0x00, 0x05, 0x05, 0x34, 0x56, 0x06, 0x23, 0x45, 0x0f)
+	// * -> 5
+	score = []byte{1, 0x00, 0x05, 0x0f}
+	return take, score
+}
+
+// grammarV1Data returns grammar lines like grammarData's first and last in
+// grammar version 1.
+func grammarV1Data(dict map[string]north.Address) (take, score []byte) {
+	take = []byte{2}
+	// * multi -> 1
+	take = append(take, 1, 0x02, 0, 0, 0, 0, 0, 1)
+	// * noun -> 2
+	take = append(take, 1, 0x00, 0, 0, 0, 0, 0, 2)
+	// * -> 5
+	score = []byte{1, 0, 0, 0, 0, 0, 0, 0, 5}
+	return take, score
+}
+
+// grammarV3Data returns grammar lines like grammarData's in grammar version 3.
+func grammarV3Data(dict map[string]north.Address) (take, score []byte) {
+	take = []byte{2}
+	// * multi -> 1
+	take = append(take, 0x08, 0x01, 0x01, 0x02)
+	// * 'off' held -> 2 reverse
+	take = append(take, 0x14, 0x02, 0x42, 0x00, 0x01, 0x01)
+	// * -> 5
+	score = []byte{1, 0x00, 0x05}
+	return take, score
+}
+
+// informTestStory returns a story laid out like Inform 6 output, with verbs
+// take/get and the meta verb score whose grammar lines come from data.
+func informTestStory(t *testing.T, data func(dict map[string]north.Address) (take, score []byte)) *north.Machine {
+	b := zasm.New(5)
+	b.Serial = "260101"
+	b.Routine("main", 0)
+	b.Instr("quit")
+	b.DictWord("take", "get", "score", "off", "in", "into")
+	take, score := data(nil)
+	b.Data("take", take)
+	b.Data("score", score)
+	b.Data("grammar", make([]byte, 4))
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := north.NewMachineFromBytes(img, nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	info, err := m.Dictionary()
+	if err != nil {
+		t.Fatal("Dictionary:", err)
+	}
+
+	// Fill in what Inform would have: the compiler version, dictionary
+	// flags, the grammar table, and static memory starting at the table.
+	copy(img[0x3c:], "6.31")
+	dict := make(map[string]north.Address)
+	for _, e := range info.Words {
+		dict[e.Word] = e.Address
+		data := img[e.Address+6:]
+		switch e.Word {
+		case "take", "get":
+			data[0], data[1] = 0x01, 255
+		case "score":
+			data[0], data[1] = 0x03, 254
+		default:
+			data[0] = 0x08
+		}
+	}
+	take, score = data(dict)
+	ta, _ := b.DataAddress("take")
+	sa, _ := b.DataAddress("score")
+	ga, _ := b.DataAddress("grammar")
+	copy(img[ta:], take)
+	copy(img[sa:], score)
+	copy(img[ga:], []byte{byte(ta >> 8), byte(ta), byte(sa >> 8), byte(sa)})
+	img[0x0e], img[0x0f] = byte(ga>>8), byte(ga)
+	m, err = north.NewMachineFromBytes(img, nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	return m
+}
+
+// TestInformGrammar checks the grammar of a story put together to look like
+// Inform output.  It covers token types that a small compiled story doesn't,
+// and supplements TestInformGrammarCompiled.
+func TestInformGrammar(t *testing.T) {
+	g, err := InformGrammar(informTestStory(t, grammarData))
+	if err != nil {
+		t.Fatal("InformGrammar:", err)
+	}
+	checkGolden(t, "grammar.golden", formatGrammar(g))
+}
+
+// TestInformGrammarCompiled checks the grammar of testdata/grammar.z5, which
+// Inform 6 compiles from testdata/grammar.inf.  It's skipped if the story
+// hasn't been compiled.  Run it with -update to write its golden file.
+func TestInformGrammarCompiled(t *testing.T) {
+	img, err := ioutil.ReadFile(filepath.Join("testdata", "grammar.z5"))
+	if os.IsNotExist(err) {
+		t.Skip("testdata/grammar.z5 has not been compiled from grammar.inf")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	m, err := north.NewMachineFromBytes(img, nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	g, err := InformGrammar(m)
+	if err != nil {
+		t.Fatal("InformGrammar:", err)
+	}
+	checkGolden(t, "grammar_compiled.golden", formatGrammar(g))
+}
+
+// formatGrammar lists g's verbs and their grammar lines.
+func formatGrammar(g *Grammar) []byte {
+	var buf bytes.Buffer
+	for _, v := range g.Verbs {
+		if v.Meta {
+			buf.WriteString("meta ")
+		}
+		fmt.Fprintf(&buf, "verb %d:", v.Number)
+		for _, w := range v.Words {
+			fmt.Fprintf(&buf, " '%s'", w)
+		}
+		buf.WriteString("\n")
+		for _, l := range v.Lines {
+			fmt.Fprintf(&buf, "\t%v\n", l)
+		}
+	}
+	return buf.Bytes()
+}
+
+// checkGolden compares got with the golden file testdata/name, or rewrites
+// the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("grammar differs from %s:\n%s", name, got)
+	}
+}
+
+func TestNotInform(t *testing.T) {
+	b := zasm.New(5)
+	b.Serial = "260101"
+	b.Routine("main", 0)
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := north.NewMachineFromBytes(img, nil)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	if _, err := InformGrammar(m); err != ErrNotInform {
+		t.Errorf("InformGrammar(non-Inform story) = _, %v; want ErrNotInform", err)
+	}
+}
+
+func TestUnsupportedGrammar(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    func(map[string]north.Address) ([]byte, []byte)
+		version int
+	}{
+		{"Version1", grammarV1Data, 1},
+		{"Version3", grammarV3Data, 3},
+	}
+	for _, test := range tests {
+		_, err := InformGrammar(informTestStory(t, test.data))
+		if e, ok := err.(*UnsupportedGrammarError); !ok || e.Version != test.version {
+			t.Errorf("%s: InformGrammar(...) = _, %v; want UnsupportedGrammarError{Version: %d}", test.name, err, test.version)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package north
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// Prefiller is a UI that can let the player edit text the story left in the
+// input buffer.  InputWithPrefill returns the whole line, including whatever
+// remains of prefill.
+type Prefiller interface {
+	InputWithPrefill(n int, prefill []rune) ([]rune, error)
+}
+
+// LineTerminator is a UI that can end line input on a function key or after a
+// time limit.  InputTerminated is like Input, but also returns the key that
+// ended the line: 13 for Enter, one of the ZSCII function keys in
+// terminators, or 0 if timeout passed first.  A timeout of zero means no
+// limit.  The story's timer routine isn't called; a timeout always ends the
+// line.
+type LineTerminator interface {
+	InputTerminated(n int, terminators []Word, timeout time.Duration) (input []rune, term Word, err error)
+}
+
+// Mouse clicks that a Pointer or Menuer UI's ReadRune can return for
+// read_char.  They are in the Private Use Area so they can't be mistaken for
+// typed characters.
+const (
+	MouseClick       rune = 0xe0fe
+	MouseDoubleClick rune = 0xe0fd
+	MenuClick        rune = 0xe0fc
+)
+
+// keyCode translates a rune read for read_char into the ZSCII code the story
+// expects.
+func keyCode(r rune) Word {
+	switch r {
+	case MouseClick:
+		return 254
+	case MouseDoubleClick:
+		return 253
+	case MenuClick:
+		return 252
+	}
+	return Word(r)
+}
+
+// An InputKind is the kind of input a story is waiting for.
+type InputKind int
+
+// Input kinds
+const (
+	LineInput InputKind = iota
+	CharInput
+)
+
+// An InputRequest describes the input a story is waiting for.
+type InputRequest struct {
+	Kind InputKind
+
+	// MaxLen is the maximum number of characters in a line.
+	MaxLen int
+
+	// Prefill is text that the story left in the input buffer for the
+	// player to edit (version 5+).  A line submitted in response replaces
+	// it.
+	Prefill []rune
+}
+
+// StepUntilInput executes instructions until the story is about to read
+// input that hasn't been submitted with SubmitInput.  It does not call the
+// UI's input methods, so front-ends can gather input on their own schedule.
+func (m *Machine) StepUntilInput() (*InputRequest, error) {
+	return m.StepUntilInputContext(context.Background())
+}
+
+// StepUntilInputContext is like StepUntilInput, but stops between
+// instructions once ctx is done, returning a Canceled TerminationError as
+// RunContext does.  The machine can be resumed, or saved with SaveState, from
+// where it stopped.
+func (m *Machine) StepUntilInputContext(ctx context.Context) (*InputRequest, error) {
+	done := ctx.Done()
+	for {
+		if len(m.inputQueue) == 0 && !m.commandsPending() {
+			if req := m.inputRequest(); req != nil {
+				return req, nil
+			}
+		}
+		select {
+		case <-done:
+			return nil, &TerminationError{Reason: Canceled, Err: ctx.Err()}
+		default:
+		}
+		if err := m.Step(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// SubmitInput queues a line of input.  For character input, the first
+// character of the line is used, or a carriage return if the line is empty.
+func (m *Machine) SubmitInput(line string) {
+	m.queueInput(line)
+}
+
+// inputRequest returns a request if the next instruction reads input, or nil
+// otherwise.  Operands are peeked without popping the stack.
+func (m *Machine) inputRequest() *InputRequest {
+	if len(m.stack) == 0 {
+		return nil
+	}
+	var in decodedInst
+	ir := instReader{mem: m.memory, pos: m.PC()}
+	if err := in.decode(&ir, m.Version(), StandardAlphabetSet, m); err != nil || in.form != variableForm {
+		return nil
+	}
+	switch in.opcode {
+	case 0xe4:
+		// read
+		text := Address(m.peekOperand(&in, 0))
+		if m.checkTextBuffer(text) != nil {
+			// Step will report the bad buffer.
+			return nil
+		}
+		if m.Version() <= 4 {
+			return &InputRequest{Kind: LineInput, MaxLen: int(m.loadByte(text)) - 1}
+		}
+		return &InputRequest{
+			Kind:    LineInput,
+			MaxLen:  int(m.loadByte(text)),
+			Prefill: m.inputPrefill(text),
+		}
+	case 0xf6:
+		// read_char
+		return &InputRequest{Kind: CharInput}
+	}
+	return nil
+}
+
+// peekOperand returns the value of in's i'th operand, reading the top of the
+// stack instead of popping it.
+func (m *Machine) peekOperand(in *decodedInst, i int) Word {
+	val, optype := in.Operand(i)
+	if optype != variableOperand {
+		return val
+	}
+	if val == 0 {
+		f := m.currStackFrame()
+		if len(f.Stack) == 0 {
+			return 0
+		}
+		return f.Stack[len(f.Stack)-1]
+	}
+	return m.Variable(uint8(val))
+}
+
+// checkTextBuffer returns an error unless the read text buffer at text,
+// including its header and room for as many characters as its first byte
+// allows, lies inside memory.
+func (m *Machine) checkTextBuffer(text Address) error {
+	if int(text) >= len(m.memory) {
+		return &MemoryError{Address: text, Size: 1}
+	}
+	size := int(m.loadByte(text)) + 1
+	if m.Version() >= 5 {
+		size++
+	}
+	if int(text)+size > len(m.memory) {
+		return &MemoryError{Address: text, Size: size}
+	}
+	return nil
+}
+
+// inputPrefill returns the text left in a version 5+ input buffer, which must
+// have passed checkTextBuffer.
+func (m *Machine) inputPrefill(text Address) []rune {
+	n := m.loadByte(text + 1)
+	if max := m.loadByte(text); n > max {
+		n = max
+	}
+	if n == 0 {
+		return nil
+	}
+	prefill := make([]rune, n)
+	for i := range prefill {
+		prefill[i] = rune(m.loadByte(text + 2 + Address(i)))
+	}
+	return prefill
+}
+
+// A lineRequest is the line input that a read instruction asks for.
+type lineRequest struct {
+	// n is the maximum length of the line.
+	n int
+	// prefill is text the story left in the input buffer.
+	prefill []rune
+	// terminators are the function keys that end the line besides Enter.
+	terminators []Word
+	// timeout is the time limit, or zero for none.
+	timeout time.Duration
+}
+
+// readLine reads a line for the read opcode.  Queued input is used before
+// asking the UI.  If the request has a prefill, the returned line replaces it
+// when replaced is true, and follows it otherwise.  term is the key that ended
+// the line, as for LineTerminator.  If the UI returns io.EOF, readLine returns
+// the partial line, or an InputClosed TerminationError if there is none.
+func (m *Machine) readLine(req lineRequest) (input []rune, replaced bool, term Word, err error) {
+	n, prefill := req.n, req.prefill
+	if len(m.inputQueue) > 0 {
+		input = []rune(m.inputQueue[0])
+		m.inputQueue = m.inputQueue[1:]
+		if len(input) > n {
+			input = input[:n]
+		}
+		return input, true, 13, m.display(string(input)+"\n", true)
+	}
+	if m.commands != nil {
+		line, err := m.commands.ReadString('\n')
+		if err == nil || (err == io.EOF && line != "") {
+			input = []rune(strings.TrimRight(line, "\r\n"))
+			if len(input) > n {
+				input = input[:n]
+			}
+			return input, true, 13, m.display(string(input)+"\n", true)
+		}
+		m.closeCommands()
+		if err != io.EOF {
+			return nil, false, 0, err
+		}
+	}
+	typed := 0
+	term = 13
+	if p, ok := m.ui.(Prefiller); ok && len(prefill) > 0 {
+		input, err = p.InputWithPrefill(n, prefill)
+		replaced = true
+		if hasRunePrefix(input, prefill) {
+			// The prefill was already printed by the story.
+			typed = len(prefill)
+		}
+	} else if lt, ok := m.ui.(LineTerminator); ok {
+		input, term, err = lt.InputTerminated(n-len(prefill), req.terminators, req.timeout)
+	} else {
+		input, err = m.ui.Input(n - len(prefill))
+	}
+	if err == io.EOF {
+		if len(input) == 0 {
+			return nil, replaced, 0, &TerminationError{Reason: InputClosed}
+		}
+		err = nil
+	}
+	if err != nil {
+		return input, replaced, 0, err
+	}
+	return input, replaced, term, m.echoInput(string(input[typed:]))
+}
+
+// terminatingChars returns the function keys listed in the story's
+// terminating characters table (version 5+).
+func (m *Machine) terminatingChars() []Word {
+	if m.Version() < 5 {
+		return nil
+	}
+	var terms []Word
+	for a := Address(m.loadWord(0x2e)); a != 0 && int(a) < len(m.memory); a++ {
+		c := Word(m.loadByte(a))
+		switch {
+		case c == 0:
+			return terms
+		case c == 255:
+			// Every function key
+			for k := Word(129); k <= 154; k++ {
+				terms = append(terms, k)
+			}
+			terms = append(terms, 252, 253, 254)
+		case c >= 129 && c <= 154, c >= 252 && c <= 254:
+			terms = append(terms, c)
+		}
+	}
+	return terms
+}
+
+// echoInput copies a line read from the UI to the screen and transcript.
+// Input is never echoed to a memory stream.
+func (m *Machine) echoInput(line string) error {
+	e, ok := m.ui.(InputEchoer)
+	return m.display(line+"\n", !ok || !e.EchoesInput())
+}
+
+func hasRunePrefix(r, prefix []rune) bool {
+	if len(r) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if r[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readChar reads a single character for the read_char opcode.  A queued line
+// gives its first character.  The command file gives one character at a time,
+// with line breaks read as carriage returns.
+func (m *Machine) readChar() (rune, error) {
+	if len(m.inputQueue) > 0 {
+		input := []rune(m.inputQueue[0])
+		m.inputQueue = m.inputQueue[1:]
+		if len(input) == 0 {
+			return '\r', nil
+		}
+		return input[0], nil
+	}
+	if m.commands != nil {
+		r, _, err := m.commands.ReadRune()
+		switch {
+		case err == nil && r == '\n':
+			return '\r', nil
+		case err == nil:
+			return r, nil
+		}
+		m.closeCommands()
+		if err != io.EOF {
+			return 0, err
+		}
+	}
+	r, _, err := m.ui.ReadRune()
+	if err == io.EOF {
+		return 0, &TerminationError{Reason: InputClosed}
+	}
+	return r, err
+}
+
+// openCommands selects input stream 1, if the UI can open a command file.
+func (m *Machine) openCommands() error {
+	cf, ok := m.ui.(CommandFiler)
+	if !ok || m.commands != nil {
+		return nil
+	}
+	r, err := cf.OpenCommandFile()
+	if err != nil {
+		return err
+	}
+	m.commands = bufio.NewReader(r)
+	m.commandCloser, _ = r.(io.Closer)
+	return nil
+}
+
+// commandsPending reports whether the command file has input left.  It
+// returns to the keyboard once the file is exhausted.
+func (m *Machine) commandsPending() bool {
+	if m.commands == nil {
+		return false
+	}
+	if _, err := m.commands.Peek(1); err != nil {
+		m.closeCommands()
+		return false
+	}
+	return true
+}
+
+// closeCommands selects input stream 0, the keyboard.
+func (m *Machine) closeCommands() {
+	if m.commandCloser != nil {
+		m.commandCloser.Close()
+	}
+	m.commands, m.commandCloser = nil, nil
+}
+
+// queueInput arranges for lines to be read before any more input from the UI.
+func (m *Machine) queueInput(lines ...string) {
+	m.inputQueue = append(m.inputQueue, lines...)
+}
@@ -0,0 +1,417 @@
+package north
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// prefillUI is a scriptUI that also accepts prefilled input.
+type prefillUI struct {
+	scriptUI
+	prefill []rune
+}
+
+func (ui *prefillUI) InputWithPrefill(n int, prefill []rune) ([]rune, error) {
+	ui.prefill = append([]rune(nil), prefill...)
+	return ui.Input(n)
+}
+
+// newPrefillStory returns a story that leaves "exa" in the input buffer
+// before reading a line.
+func newPrefillStory() *zasm.Builder {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("storeb", zasm.Addr("text"), zasm.Const(1), zasm.Const(3))
+	b.Instr("storeb", zasm.Addr("text"), zasm.Const(2), zasm.Const('e'))
+	b.Instr("storeb", zasm.Addr("text"), zasm.Const(3), zasm.Const('x'))
+	b.Instr("storeb", zasm.Addr("text"), zasm.Const(4), zasm.Const('a'))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.DictWord("examine", "lamp")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+	return b
+}
+
+// inputBuffer returns the contents of the story's text buffer.
+func inputBuffer(m *Machine, b *zasm.Builder) string {
+	a, _ := b.DataAddress("text")
+	text := Address(a)
+	return string(m.memory[text+2 : text+2+Address(m.loadByte(text+1))])
+}
+
+func TestReadPrefill(t *testing.T) {
+	tests := []struct {
+		Name    string
+		UI      UI
+		Prefill string
+		Buffer  string
+	}{
+		{"Prefiller", &prefillUI{scriptUI: scriptUI{Line: "examine lamp"}}, "exa", "examine lamp"},
+		{"plain UI", &scriptUI{Line: "mine lamp"}, "", "examine lamp"},
+	}
+	for _, tt := range tests {
+		b := newPrefillStory()
+		m := buildMachine(t, b, tt.UI)
+		for i := 0; i < 5; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("%s: step %d: %v", tt.Name, i, err)
+			}
+		}
+		if buf := inputBuffer(m, b); buf != tt.Buffer {
+			t.Errorf("%s: buffer != %q (got %q)", tt.Name, tt.Buffer, buf)
+		}
+		if ui, ok := tt.UI.(*prefillUI); ok && string(ui.prefill) != tt.Prefill {
+			t.Errorf("%s: prefill != %q (got %q)", tt.Name, tt.Prefill, string(ui.prefill))
+		}
+		a, _ := b.DataAddress("parse")
+		if n := m.loadByte(Address(a) + 1); n != 2 {
+			t.Errorf("%s: words parsed != 2 (got %d)", tt.Name, n)
+		}
+	}
+}
+
+func TestStepUntilInput(t *testing.T) {
+	b := newPrefillStory()
+	ui := new(scriptUI)
+	m := buildMachine(t, b, ui)
+	req, err := m.StepUntilInput()
+	if err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+	want := &InputRequest{Kind: LineInput, MaxLen: 32, Prefill: []rune("exa")}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("request != %+v (got %+v)", want, req)
+	}
+
+	m.SubmitInput("look")
+	if _, err := m.StepUntilInput(); !errors.Is(err, ErrQuit) {
+		t.Errorf("StepUntilInput after submit != ErrQuit (got %v)", err)
+	}
+	if ui.Inputs != 0 {
+		t.Errorf("UI asked for input %d times", ui.Inputs)
+	}
+	if buf := inputBuffer(m, b); buf != "look" {
+		t.Errorf("buffer != \"look\" (got %q)", buf)
+	}
+	if out := ui.String(); out != "look\n" {
+		t.Errorf("output != \"look\\n\" (got %q)", out)
+	}
+}
+
+func TestStepUntilInputContext(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Label("loop")
+	b.Instr("jump", zasm.Label("loop"))
+	m := buildMachine(t, b, new(scriptUI))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.StepUntilInputContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("StepUntilInputContext with cancelled context = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestStepUntilInputBadBuffer(t *testing.T) {
+	for _, text := range []uint16{0xfff0, 0xffff} {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("aread", zasm.Const(text), zasm.Const(0), zasm.Store(zasm.SP))
+		b.Instr("quit")
+		m := buildMachine(t, b, new(scriptUI))
+		var merr *MemoryError
+		if _, err := m.StepUntilInput(); !errors.As(err, &merr) {
+			t.Errorf("StepUntilInput with text buffer at %#x = %v; want MemoryError", text, err)
+		}
+	}
+}
+
+func TestSaveStateAtInput(t *testing.T) {
+	b := newPrefillStory()
+	m := buildMachine(t, b, new(scriptUI))
+	req, err := m.StepUntilInput()
+	if err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+	var buf bytes.Buffer
+	if err := m.SaveState(&buf); err != nil {
+		t.Fatal("SaveState:", err)
+	}
+
+	ui := new(scriptUI)
+	m2 := buildMachine(t, b, ui)
+	if err := m2.RestoreState(&buf); err != nil {
+		t.Fatal("RestoreState:", err)
+	}
+	req2, err := m2.StepUntilInput()
+	if err != nil {
+		t.Fatal("StepUntilInput after restore:", err)
+	}
+	if !reflect.DeepEqual(req2, req) {
+		t.Errorf("request after restore = %+v; want %+v", req2, req)
+	}
+	m2.SubmitInput("look")
+	if _, err := m2.StepUntilInput(); !errors.Is(err, ErrQuit) {
+		t.Errorf("StepUntilInput after submit != ErrQuit (got %v)", err)
+	}
+	if got := inputBuffer(m2, b); got != "look" {
+		t.Errorf("buffer after restore and submit = %q; want \"look\"", got)
+	}
+}
+
+func TestStepUntilInputChar(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("push", zasm.Const(1))
+	b.Instr("read_char", zasm.SP, zasm.Store(zasm.Global(0)))
+	b.Instr("quit")
+	m := buildMachine(t, b, new(scriptUI))
+	req, err := m.StepUntilInput()
+	if err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+	if req.Kind != CharInput {
+		t.Errorf("request kind != CharInput (got %v)", req.Kind)
+	}
+	m.SubmitInput("yes")
+	if _, err := m.StepUntilInput(); !errors.Is(err, ErrQuit) {
+		t.Errorf("StepUntilInput after submit != ErrQuit (got %v)", err)
+	}
+	if v := m.Variable(0x10); v != 'y' {
+		t.Errorf("read_char result != 'y' (got %v)", v)
+	}
+}
+
+func TestReadCharClick(t *testing.T) {
+	tests := []struct {
+		Click rune
+		Code  Word
+	}{
+		{MouseClick, 254},
+		{MouseDoubleClick, 253},
+		{'x', 'x'},
+	}
+	for _, tt := range tests {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.Global(0)))
+		b.Instr("quit")
+		m := buildMachine(t, b, &pointerUI{click: tt.Click})
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if v := m.Variable(0x10); v != tt.Code {
+			t.Errorf("read_char of %U != %d (got %d)", tt.Click, tt.Code, v)
+		}
+	}
+}
+
+// commandFileUI is a bufferUI that opens commands as its command file.
+type commandFileUI struct {
+	bufferUI
+	commands string
+	closed   bool
+}
+
+func (ui *commandFileUI) OpenCommandFile() (io.Reader, error) {
+	return ui, nil
+}
+
+func (ui *commandFileUI) Read(p []byte) (int, error) {
+	n := copy(p, ui.commands)
+	ui.commands = ui.commands[n:]
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (ui *commandFileUI) Close() error {
+	ui.closed = true
+	return nil
+}
+
+func TestCommandFileReadChar(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("input_stream", zasm.Const(1))
+	for i := 0; i < 5; i++ {
+		b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.SP))
+	}
+	b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	ui := &commandFileUI{commands: "yé\nn\n"}
+	m := buildMachine(t, b, ui)
+	for i := 0; i < 6; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	want := []Word{'y', 233, '\r', 'n', '\r'}
+	if got := m.Frames()[0].Stack; !reflect.DeepEqual(got, want) {
+		t.Errorf("read_char results = %v; want %v", got, want)
+	}
+
+	// The file has ended, so the next read_char goes to the keyboard.
+	if err := m.Step(); !errors.Is(err, ErrInputClosed) {
+		t.Errorf("read_char after command file = %v; want ErrInputClosed", err)
+	}
+	if !ui.closed {
+		t.Error("command file not closed")
+	}
+}
+
+func TestCommandFileRead(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("input_stream", zasm.Const(1))
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("input_stream", zasm.Const(0))
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	ui := &commandFileUI{commands: "look\r\nnorth\n"}
+	m := buildMachine(t, b, ui)
+	req, err := m.StepUntilInput()
+	if err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+	if req.Kind != LineInput {
+		t.Errorf("request kind != LineInput (got %v)", req.Kind)
+	}
+	if buf := inputBuffer(m, b); buf != "look" {
+		t.Errorf("buffer != \"look\" (got %q)", buf)
+	}
+	if !ui.closed {
+		t.Error("input_stream 0 didn't close the command file")
+	}
+	if out := ui.String(); out != "look\n" {
+		t.Errorf("output != \"look\\n\" (got %q)", out)
+	}
+}
+
+// terminatorUI is a scriptUI that ends its line with a given key.
+type terminatorUI struct {
+	scriptUI
+	term        Word
+	terminators []Word
+	timeout     time.Duration
+}
+
+func (ui *terminatorUI) InputTerminated(n int, terminators []Word, timeout time.Duration) ([]rune, Word, error) {
+	ui.terminators, ui.timeout = terminators, timeout
+	r, err := ui.Input(n)
+	return r, ui.term, err
+}
+
+func TestReadTerminator(t *testing.T) {
+	tests := []struct {
+		Name        string
+		UI          UI
+		Timer       bool
+		Term        Word
+		Buffer      string
+		Terminators []Word
+		Timeout     time.Duration
+	}{
+		{"enter", &scriptUI{Line: "look"}, false, 13, "look", nil, 0},
+		{"enter with terminator UI", &terminatorUI{scriptUI: scriptUI{Line: "look"}, term: 13}, false, 13, "look", []Word{129, 133}, 0},
+		{"function key", &terminatorUI{scriptUI: scriptUI{Line: "go"}, term: 133}, false, 133, "go", []Word{129, 133}, 0},
+		{"timeout", &terminatorUI{scriptUI: scriptUI{Line: "lo"}, term: 0}, true, 0, "lo", []Word{129, 133}, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		if tt.Timer {
+			b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Const(20), zasm.Routine("timer"), zasm.Store(zasm.Global(0)))
+		} else {
+			b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.Global(0)))
+		}
+		b.Instr("quit")
+		b.Routine("timer", 0)
+		b.Instr("rtrue")
+		b.Data("text", append([]byte{20}, make([]byte, 21)...))
+		b.Data("terms", []byte{129, 133, 0})
+		img, err := b.Build()
+		if err != nil {
+			t.Fatal("build story:", err)
+		}
+		terms, _ := b.DataAddress("terms")
+		img[0x2e], img[0x2f] = byte(terms>>8), byte(terms)
+		m, err := NewMachine(bytes.NewReader(img), tt.UI)
+		if err != nil {
+			t.Fatal("load story:", err)
+		}
+		if err := m.Step(); err != nil {
+			t.Errorf("%s: aread: %v", tt.Name, err)
+			continue
+		}
+		if term := m.Variable(0x10); term != tt.Term {
+			t.Errorf("%s: terminator = %d; want %d", tt.Name, term, tt.Term)
+		}
+		if buf := inputBuffer(m, b); buf != tt.Buffer {
+			t.Errorf("%s: buffer = %q; want %q", tt.Name, buf, tt.Buffer)
+		}
+		if ui, ok := tt.UI.(*terminatorUI); ok {
+			if !reflect.DeepEqual(ui.terminators, tt.Terminators) || ui.timeout != tt.Timeout {
+				t.Errorf("%s: UI got terminators %v, timeout %v; want %v, %v", tt.Name, ui.terminators, ui.timeout, tt.Terminators, tt.Timeout)
+			}
+		}
+	}
+}
+
+func TestTerminatingCharsAll(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	m.storeWord(0x2e, 0x100)
+	m.storeBytes(0x100, []byte{'a', 255, 0})
+	terms := m.terminatingChars()
+	if len(terms) != 29 || terms[0] != 129 || terms[25] != 154 || terms[28] != 254 {
+		t.Errorf("terminatingChars() = %v; want 129-154 and 252-254", terms)
+	}
+}
+
+// keyUI is a transcriptUI that reads the same key every time.
+type keyUI struct {
+	transcriptUI
+	key rune
+}
+
+func (ui *keyUI) ReadRune() (rune, int, error) {
+	return ui.key, 1, nil
+}
+
+func TestReadCharNoEcho(t *testing.T) {
+	for _, queued := range []bool{false, true} {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("output_stream", zasm.Const(2))
+		b.Instr("print", zasm.Text("a"))
+		b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.Global(0)))
+		b.Instr("print", zasm.Text("b"))
+		b.Instr("quit")
+		ui := &keyUI{key: 'x'}
+		m := buildMachine(t, b, ui)
+		if queued {
+			m.SubmitInput("x")
+		}
+		if err := m.Run(); !errors.Is(err, ErrQuit) {
+			t.Fatalf("queued=%t: Run() = %v; want %v", queued, err, ErrQuit)
+		}
+		if w := m.Variable(0x10); w != 'x' {
+			t.Errorf("queued=%t: read_char = %v; want 'x'", queued, w)
+		}
+		if s := ui.String(); s != "ab" {
+			t.Errorf("queued=%t: screen = %q; want \"ab\"", queued, s)
+		}
+		if s := ui.transcript.String(); s != "ab" {
+			t.Errorf("queued=%t: transcript = %q; want \"ab\"", queued, s)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package north
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DictionaryInfo describes a story's dictionary.
+type DictionaryInfo struct {
+	Address    Address
+	Separators []rune
+	EntrySize  int
+
+	// Words are the entries in table order.
+	Words []DictionaryEntry
+}
+
+// A DictionaryEntry is a word in the dictionary.
+type DictionaryEntry struct {
+	Address Address
+	Word    string
+}
+
+// Dictionary returns the story's dictionary.
+func (m *Machine) Dictionary() (*DictionaryInfo, error) {
+	d, err := m.dictionary(m.dictionaryAddress())
+	if err != nil {
+		return nil, err
+	}
+	info := &DictionaryInfo{
+		Address:    m.dictionaryAddress(),
+		Separators: d.Separators,
+		EntrySize:  int(d.EntrySize),
+		Words:      make([]DictionaryEntry, d.Count),
+	}
+	for i := range info.Words {
+		a := d.entryAddress(i)
+		s, err := m.loadString(a, false)
+		if err != nil {
+			return nil, fmt.Errorf("Dictionary entry %d: %v", i, err)
+		}
+		info.Words[i] = DictionaryEntry{a, s}
+	}
+	return info, nil
+}
+
+// ObjectInfo describes an object.
+type ObjectInfo struct {
+	Number                 Word
+	Name                   string
+	Parent, Sibling, Child Word
+	Attrs                  []uint8
+}
+
+// ObjectCount returns the number of objects in the object table.  The count
+// isn't stored in the story, so this assumes that the first object's
+// property table immediately follows the last object, as compilers lay them
+// out.
+func (m *Machine) ObjectCount() int {
+	ndefaults, entrySize := 31, 9
+	if m.Version() >= 4 {
+		ndefaults, entrySize = 63, 14
+	}
+	entries := m.objectTableAddress() + Address(ndefaults*2)
+	if int(entries)+entrySize > len(m.memory) {
+		return 0
+	}
+	first := m.loadObject(1).PropertyBase
+	if first <= entries {
+		return 0
+	}
+	return int(first-entries) / entrySize
+}
+
+// Object returns information about object i (1-based).
+func (m *Machine) Object(i Word) (ObjectInfo, error) {
+	if i == 0 || int(i) > m.ObjectCount() {
+		return ObjectInfo{}, fmt.Errorf("Object %d out of range", i)
+	}
+	o := m.loadObject(i)
+	name, err := o.FetchName(m)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Number:  i,
+		Name:    name,
+		Parent:  o.Parent,
+		Sibling: o.Sibling,
+		Child:   o.Child,
+		Attrs:   o.Attrs(),
+	}, nil
+}
+
+// Children returns the children of object i in sibling order, as get_child
+// and get_sibling walk them.  A sibling chain that loops is cut off where it
+// repeats.
+func (m *Machine) Children(i Word) []Word {
+	n := Word(m.ObjectCount())
+	if i == 0 || i > n {
+		return nil
+	}
+	var children []Word
+	seen := make(map[Word]bool)
+	for c := m.loadObject(i).Child; c != 0 && c <= n && !seen[c]; c = m.loadObject(c).Sibling {
+		seen[c] = true
+		children = append(children, c)
+	}
+	return children
+}
+
+// Descendants returns every object below object i, depth first: each child is
+// followed by its own descendants.  No object is listed twice, even if the
+// tree has a cycle.
+func (m *Machine) Descendants(i Word) []Word {
+	var all []Word
+	seen := map[Word]bool{i: true}
+	var walk func(Word)
+	walk = func(o Word) {
+		for _, c := range m.Children(o) {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			all = append(all, c)
+			walk(c)
+		}
+	}
+	walk(i)
+	return all
+}
+
+// AbbreviationCount returns the number of entries in the abbreviation table,
+// or zero if the story has none.
+func (m *Machine) AbbreviationCount() int {
+	switch {
+	case m.abbreviationTableAddress() == 0 || m.Version() == 1:
+		return 0
+	case m.Version() == 2:
+		return 32
+	}
+	return 96
+}
+
+// A RegionKind is a kind of table in memory.
+type RegionKind int
+
+// Kinds of region.
+const (
+	OtherRegion RegionKind = iota
+	HeaderRegion
+	GlobalRegion
+	ObjectRegion
+	PropertyRegion
+	TextBufferRegion
+	ParseBufferRegion
+)
+
+// A Region is the table that an address belongs to.
+type Region struct {
+	Kind RegionKind
+
+	// Number is the variable number of a global, or the number of the
+	// object whose entry or property table holds the address.  It is 0 for
+	// the property defaults at the start of the object table.
+	Number Word
+}
+
+func (r Region) String() string {
+	switch r.Kind {
+	case HeaderRegion:
+		return "header"
+	case GlobalRegion:
+		return "global " + VariableRef(r.Number).String()
+	case ObjectRegion:
+		if r.Number == 0 {
+			return "property defaults"
+		}
+		return fmt.Sprintf("object %d", r.Number)
+	case PropertyRegion:
+		return fmt.Sprintf("object %d properties", r.Number)
+	case TextBufferRegion:
+		return "text buffer"
+	case ParseBufferRegion:
+		return "parse buffer"
+	}
+	return "other"
+}
+
+// ClassifyAddress returns the table that holds a: the header, the global
+// variables, the object table, an object's property table, or the text and
+// parse buffers of the last read.  Other addresses, like arrays that only the
+// story's code knows about, are OtherRegion.
+func (m *Machine) ClassifyAddress(a Address) Region {
+	if a < headerSize {
+		return Region{Kind: HeaderRegion}
+	}
+	if g := m.globalVariableTableAddress(); a >= g && a < g+240*2 {
+		return Region{GlobalRegion, 0x10 + Word(a-g)/2}
+	}
+	ndefaults, entrySize := 31, 9
+	if m.Version() >= 4 {
+		ndefaults, entrySize = 63, 14
+	}
+	n := m.ObjectCount()
+	objects := m.objectTableAddress()
+	entries := objects + Address(ndefaults*2)
+	switch {
+	case a >= objects && a < entries:
+		return Region{Kind: ObjectRegion}
+	case a >= entries && a < entries+Address(n*entrySize):
+		return Region{ObjectRegion, 1 + Word(int(a-entries)/entrySize)}
+	}
+	for i := 1; i <= n; i++ {
+		base := m.loadObject(Word(i)).PropertyBase
+		if a < base {
+			continue
+		}
+		if _, end, err := parsePropertyTable(m.memory, base, m.Version()); err == nil && a < end {
+			return Region{PropertyRegion, Word(i)}
+		}
+	}
+	if t := m.textBuffer; t != 0 && a >= t && a < t+2+Address(m.loadByte(t)) {
+		return Region{Kind: TextBufferRegion}
+	}
+	if p := m.parseBuffer; p != 0 && a >= p && a < p+2+4*Address(m.loadByte(p)) {
+		return Region{Kind: ParseBufferRegion}
+	}
+	return Region{}
+}
+
+// InstructionAt decodes the instruction at a without executing it or moving
+// the PC.  It returns the instruction and its length in bytes.
+func (m *Machine) InstructionAt(a Address) (fmt.Stringer, int, error) {
+	if a < 0 || int(a) >= len(m.memory) {
+		return nil, 0, fmt.Errorf("Instruction address %v out of range", a)
+	}
+	in := new(decodedInst)
+	ir := instReader{mem: m.memory, pos: a}
+	if err := in.decode(&ir, m.Version(), StandardAlphabetSet, m); err != nil {
+		return nil, 0, instructionError{PC: a, Err: err}
+	}
+	return in, int(ir.pos - a), nil
+}
+
+// DisassembleRange disassembles the instructions from start up to end, one
+// line per instruction.  Bytes that don't decode, like strings and tables
+// between routines, are shown one per line and decoding resumes after them.
+func (m *Machine) DisassembleRange(start, end Address) ([]string, error) {
+	if start < 0 || start > end || int(end) > len(m.memory) {
+		return nil, fmt.Errorf("Disassembly range %v-%v out of range", start, end)
+	}
+	var lines []string
+	var in decodedInst
+	for pc := start; pc < end; {
+		ir := instReader{mem: m.memory, pos: pc}
+		if err := in.decode(&ir, m.Version(), StandardAlphabetSet, m); err != nil {
+			lines = append(lines, fmt.Sprintf("%v  .byte %#02x", pc, m.memory[pc]))
+			pc++
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%v  %s", pc, in.String()))
+		pc = ir.pos
+	}
+	return lines, nil
+}
+
+// A Routine is a disassembled routine.
+type Routine struct {
+	Address Address
+
+	// Locals holds the initial values of the local variables.  They are
+	// always zero in version 5+.
+	Locals []Word
+
+	Instructions []DisassembledInstruction
+}
+
+// A DisassembledInstruction is an instruction and its address.
+type DisassembledInstruction struct {
+	Address Address
+	Text    string
+}
+
+// maxRoutineInstructions bounds the disassembly of a routine.
+const maxRoutineInstructions = 10000
+
+// DisassembleRoutine disassembles the routine starting at a.  The end of the
+// routine is taken to be the first return, jump, or quit that no branch in
+// the routine goes past.
+func (m *Machine) DisassembleRoutine(a Address) (*Routine, error) {
+	if a < 0 || int(a) >= len(m.memory) {
+		return nil, fmt.Errorf("Routine address %v out of range", a)
+	}
+	r := &Routine{Address: a, Locals: make([]Word, m.loadByte(a))}
+	if len(r.Locals) > 15 {
+		return nil, errors.New("Routines have a maximum of 15 local variables")
+	}
+	pc := a + 1
+	if m.Version() <= 4 {
+		if int(pc)+2*len(r.Locals) > len(m.memory) {
+			return nil, fmt.Errorf("Routine %v local variables out of range", a)
+		}
+		for i := range r.Locals {
+			r.Locals[i] = m.loadWord(pc)
+			pc += 2
+		}
+	}
+
+	var in decodedInst
+	var farthest Address
+	for len(r.Instructions) < maxRoutineInstructions {
+		ir := instReader{mem: m.memory, pos: pc}
+		if err := in.decode(&ir, m.Version(), StandardAlphabetSet, m); err != nil {
+			return r, instructionError{PC: pc, Err: err}
+		}
+		r.Instructions = append(r.Instructions, DisassembledInstruction{pc, in.String()})
+		next := ir.pos
+
+		if in.hasBranch {
+			if off := in.branch.Offset(); off != 0 && off != 1 {
+				farthest = maxAddress(farthest, next+Address(off)-2)
+			}
+		}
+		name := in.Name()
+		if name == "jump" {
+			farthest = maxAddress(farthest, next+Address(int16(in.operands[0]))-2)
+		}
+		switch name {
+		case "rtrue", "rfalse", "print_ret", "ret", "ret_popped", "jump", "quit", "restart":
+			if next > farthest {
+				return r, nil
+			}
+		}
+		pc = next
+	}
+	return r, nil
+}
+
+func maxAddress(a, b Address) Address {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -54,6 +54,7 @@ type instruction interface {
 }
 
 type longInstruction struct {
+	version       uint8
 	opcode        uint8
 	operands      [2]uint8
 	storeVariable uint8
@@ -98,18 +99,6 @@ func (li longInstruction) BranchInfo() (branchInfo, bool) {
 	return li.branch, (n >= 0x01 && n <= 0x07) || n == 0x0a
 }
 
-func (li *longInstruction) setOperand(i int, val Word) {
-	li.operands[i] = uint8(val)
-}
-
-func (li *longInstruction) setStoreVariable(v uint8) {
-	li.storeVariable = v
-}
-
-func (li *longInstruction) setBranch(b branchInfo) {
-	li.branch = b
-}
-
 type shortInstruction struct {
 	version       uint8
 	opcode        uint8
@@ -157,18 +146,6 @@ func (si shortInstruction) BranchInfo() (branchInfo, bool) {
 	return si.branch, n >= 0x00 && n <= 0x02
 }
 
-func (si *shortInstruction) setOperand(i int, val Word) {
-	si.operand = val
-}
-
-func (si *shortInstruction) setStoreVariable(v uint8) {
-	si.storeVariable = v
-}
-
-func (si *shortInstruction) setBranch(b branchInfo) {
-	si.branch = b
-}
-
 type variableInstruction struct {
 	version       uint8
 	opcode        uint8
@@ -209,7 +186,7 @@ func (vi variableInstruction) Operand(i int) (Word, operandType) {
 func (vi variableInstruction) StoreVariable() (uint8, bool) {
 	n := vi.OpcodeNumber()
 	if vi.is2OP() {
-		_, ok := longInstruction{opcode: n}.StoreVariable()
+		_, ok := longInstruction{version: vi.version, opcode: n}.StoreVariable()
 		return vi.storeVariable, ok
 	}
 	return vi.storeVariable, n == 0x00 || (vi.version >= 5 && n == 0x04) || n == 0x07 || (vi.version == 6 && n == 0x09) || n == 0x0c || (n >= 0x16 && n <= 0x18)
@@ -218,25 +195,14 @@ func (vi variableInstruction) StoreVariable() (uint8, bool) {
 func (vi variableInstruction) BranchInfo() (branchInfo, bool) {
 	n := vi.OpcodeNumber()
 	if vi.is2OP() {
-		_, ok := longInstruction{opcode: n}.BranchInfo()
+		_, ok := longInstruction{version: vi.version, opcode: n}.BranchInfo()
 		return vi.branch, ok
 	}
-	return vi.branch, n == 0x1f
-}
-
-func (vi *variableInstruction) setOperand(i int, val Word) {
-	vi.operands[i] = val
-}
-
-func (vi *variableInstruction) setStoreVariable(v uint8) {
-	vi.storeVariable = v
-}
-
-func (vi *variableInstruction) setBranch(b branchInfo) {
-	vi.branch = b
+	return vi.branch, n == 0x17 || n == 0x1f
 }
 
 type extendedInstruction struct {
+	version       uint8
 	opcode        uint8
 	types         uint8
 	operands      [4]Word
@@ -278,103 +244,12 @@ func (ei extendedInstruction) BranchInfo() (branchInfo, bool) {
 	return ei.branch, n == 0x06 || n == 0x18 || n == 0x1b
 }
 
-func (ei *extendedInstruction) setOperand(i int, val Word) {
-	ei.operands[i] = val
-}
-
-func (ei *extendedInstruction) setStoreVariable(v uint8) {
-	ei.storeVariable = v
-}
-
-func (ei *extendedInstruction) setBranch(b branchInfo) {
-	ei.branch = b
-}
-
 func decodeInstruction(r io.Reader, alphaset AlphabetSet, u Unabbreviater, version uint8) (instruction, error) {
-	var buf [4]byte
-	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+	var d decodedInst
+	if err := d.decode(&instReader{r: r}, version, alphaset, u); err != nil {
 		return nil, err
 	}
-
-	// Opcode and operand types
-	var in interface {
-		instruction
-		setOperand(i int, val Word)
-		setStoreVariable(uint8)
-		setBranch(branchInfo)
-	}
-	switch {
-	case buf[0] == 0xbe:
-		if _, err := io.ReadFull(r, buf[:2]); err != nil {
-			return nil, err
-		}
-		in = &extendedInstruction{opcode: buf[0], types: buf[1]}
-	case buf[0] == 0xec || buf[0] == 0xfa:
-		// call_vs2 and call_vn2
-		if _, err := io.ReadFull(r, buf[1:3]); err != nil {
-			return nil, err
-		}
-		in = &variableInstruction{version: version, opcode: buf[0], types: uint16(buf[1])<<8 | uint16(buf[2])}
-	case buf[0]&0xc0 == 0xc0:
-		if _, err := io.ReadFull(r, buf[1:2]); err != nil {
-			return nil, err
-		}
-		in = &variableInstruction{version: version, opcode: buf[0], types: uint16(buf[1])<<8 | 0xff}
-	case buf[0]&0xc0 == 0x80:
-		in = &shortInstruction{version: version, opcode: buf[0]}
-	default:
-		in = &longInstruction{opcode: buf[0]}
-	}
-
-	// Operands
-	for i, n := 0, in.NOperand(); i < n; i++ {
-		_, t := in.Operand(i)
-		switch t {
-		case smallConstantOperand, variableOperand:
-			if _, err := io.ReadFull(r, buf[:1]); err != nil {
-				return nil, err
-			}
-			in.setOperand(i, Word(buf[0]))
-		case largeConstantOperand:
-			if _, err := io.ReadFull(r, buf[:2]); err != nil {
-				return nil, err
-			}
-			in.setOperand(i, Word(buf[0])<<8|Word(buf[1]))
-		}
-	}
-
-	// Store variable
-	if _, ok := in.StoreVariable(); ok {
-		if _, err := io.ReadFull(r, buf[:1]); err != nil {
-			return nil, err
-		}
-		in.setStoreVariable(buf[0])
-	}
-
-	// Branch info
-	if _, ok := in.BranchInfo(); ok {
-		if _, err := io.ReadFull(r, buf[:1]); err != nil {
-			return nil, err
-		}
-		if buf[0]&0x40 == 0 {
-			if _, err := io.ReadFull(r, buf[1:2]); err != nil {
-				return nil, err
-			}
-			in.setBranch(branchInfo(buf[0])<<8 | branchInfo(buf[1]))
-		} else {
-			in.setBranch(branchInfo(buf[0]) << 8)
-		}
-	}
-
-	// Text
-	if si, ok := in.(*shortInstruction); ok && (si.opcode == 0xb2 || si.opcode == 0xb3) {
-		var err error
-		if si.text, err = decodeString(r, alphaset, true, u); err != nil {
-			return nil, err
-		}
-	}
-
-	return in, nil
+	return d.instruction(), nil
 }
 
 func instructionString(in instruction) string {
@@ -389,11 +264,11 @@ func instructionString(in instruction) string {
 		case largeConstantOperand, smallConstantOperand:
 			fmt.Fprintf(&b, "%v", o)
 		case variableOperand:
-			fmt.Fprintf(&b, "($%02x)", uint8(o))
+			fmt.Fprintf(&b, "%v", VariableRef(o))
 		}
 	}
 	if sv, ok := in.StoreVariable(); ok {
-		fmt.Fprintf(&b, " -> ($%02x)", sv)
+		fmt.Fprintf(&b, " -> %v", VariableRef(sv))
 	}
 	if bi, ok := in.BranchInfo(); ok {
 		fmt.Fprintf(&b, " %v", bi)
@@ -565,7 +440,7 @@ func (si shortInstruction) Name() string {
 
 func (vi variableInstruction) Name() string {
 	if vi.is2OP() {
-		return longInstruction{opcode: uint8(vi.OpcodeNumber())}.Name()
+		return longInstruction{version: vi.version, opcode: vi.OpcodeNumber()}.Name()
 	}
 	switch vi.OpcodeNumber() {
 	case 0x00:
@@ -577,7 +452,10 @@ func (vi variableInstruction) Name() string {
 	case 0x03:
 		return "put_prop"
 	case 0x04:
-		return "read"
+		if vi.version >= 5 {
+			return "aread"
+		}
+		return "sread"
 	case 0x05:
 		return "print_char"
 	case 0x06:
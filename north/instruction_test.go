@@ -14,15 +14,15 @@ func TestDecodeInstruction(t *testing.T) {
 	}{
 		{
 			3, []byte{0x0b, 0x02, 0x03},
-			&longInstruction{opcode: 0x0b, operands: [2]uint8{2, 3}},
+			&longInstruction{version: 3, opcode: 0x0b, operands: [2]uint8{2, 3}},
 		},
 		{
 			3, []byte{0x01, 0x02, 0x03, 0x04, 0x05},
-			&longInstruction{opcode: 1, operands: [2]uint8{2, 3}, branch: branchInfo(0x0405)},
+			&longInstruction{version: 3, opcode: 1, operands: [2]uint8{2, 3}, branch: branchInfo(0x0405)},
 		},
 		{
 			3, []byte{0x01, 0x02, 0x03, 0x44},
-			&longInstruction{opcode: 1, operands: [2]uint8{2, 3}, branch: branchInfo(0x4400)},
+			&longInstruction{version: 3, opcode: 1, operands: [2]uint8{2, 3}, branch: branchInfo(0x4400)},
 		},
 		{
 			3, []byte{0x85, 0xde, 0xad},
@@ -70,11 +70,11 @@ func TestDecodeInstruction(t *testing.T) {
 		},
 		{
 			3, []byte{0xbe, 0x05, 0xff},
-			&extendedInstruction{opcode: 0x05, types: 0xff},
+			&extendedInstruction{version: 3, opcode: 0x05, types: 0xff},
 		},
 		{
 			3, []byte{0xbe, 0x05, 0x57, 0x01, 0x02, 0x03},
-			&extendedInstruction{opcode: 0x05, types: 0x57, operands: [4]Word{1, 2, 3}},
+			&extendedInstruction{version: 3, opcode: 0x05, types: 0x57, operands: [4]Word{1, 2, 3}},
 		},
 		{
 			6, []byte{0xe9, 0x7f, 0x01, 0x02},
@@ -89,6 +89,62 @@ func TestDecodeInstruction(t *testing.T) {
 		} else if !reflect.DeepEqual(result, tt.Expected) {
 			t.Errorf("[%d] != %#v (got %#v)", i, tt.Expected, result)
 		}
+
+		var d decodedInst
+		ir := instReader{mem: tt.Input}
+		if err := d.decode(&ir, tt.Version, StandardAlphabetSet, nil); err != nil {
+			t.Errorf("[%d] memory decode error: %v", i, err)
+		} else if result := d.instruction(); !reflect.DeepEqual(result, tt.Expected) {
+			t.Errorf("[%d] memory decode != %#v (got %#v)", i, tt.Expected, result)
+		}
+		if int(ir.pos) != len(tt.Input) {
+			t.Errorf("[%d] memory decode read %d bytes; want %d", i, ir.pos, len(tt.Input))
+		}
+	}
+}
+
+func TestDecodeInstructionVersion(t *testing.T) {
+	tests := []struct {
+		Version uint8
+		Input   []byte
+		Name    string
+		Store   bool
+		Branch  bool
+	}{
+		{3, []byte{0xb5, 0xc0}, "save", false, true},
+		{4, []byte{0xb5, 0x00}, "save", true, false},
+		{3, []byte{0xb6, 0xc0}, "restore", false, true},
+		{4, []byte{0xb6, 0x00}, "restore", true, false},
+		{4, []byte{0xb9}, "pop", false, false},
+		{5, []byte{0xb9, 0x00}, "catch", true, false},
+		{4, []byte{0x9f, 0x01, 0x00}, "not", true, false},
+		{5, []byte{0x9f, 0x01}, "call_1n", false, false},
+		{3, []byte{0xe4, 0x0f, 0x12, 0x34, 0x56, 0x78}, "sread", false, false},
+		{5, []byte{0xe4, 0x0f, 0x12, 0x34, 0x56, 0x78, 0x00}, "aread", true, false},
+		{5, []byte{0xe9, 0x7f, 0x01}, "pull", false, false},
+		{6, []byte{0xe9, 0x7f, 0x01, 0x00}, "pull", true, false},
+		{5, []byte{0xf7, 0x17, 0x12, 0x34, 0x56, 0x78, 0x03, 0x00, 0xc0}, "scan_table", true, true},
+	}
+
+	for i, tt := range tests {
+		b := bytes.NewBuffer(tt.Input)
+		in, err := decodeInstruction(b, StandardAlphabetSet, nil, tt.Version)
+		if err != nil {
+			t.Errorf("[%d] error: %v", i, err)
+			continue
+		}
+		if name := in.Name(); name != tt.Name {
+			t.Errorf("[%d] Name() != %q (got %q)", i, tt.Name, name)
+		}
+		if _, store := in.StoreVariable(); store != tt.Store {
+			t.Errorf("[%d] %s v%d stores = %t; want %t", i, tt.Name, tt.Version, store, tt.Store)
+		}
+		if _, branch := in.BranchInfo(); branch != tt.Branch {
+			t.Errorf("[%d] %s v%d branches = %t; want %t", i, tt.Name, tt.Version, branch, tt.Branch)
+		}
+		if b.Len() != 0 {
+			t.Errorf("[%d] %s v%d left %d bytes unread", i, tt.Name, tt.Version, b.Len())
+		}
 	}
 }
 
@@ -125,3 +181,48 @@ func TestBranchInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestInstructionAt(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	// add 2 3 -> sp
+	copy(m.memory[0x40:], []byte{0x14, 0x02, 0x03, 0x00})
+	in, n, err := m.InstructionAt(0x40)
+	if err != nil {
+		t.Fatal("InstructionAt:", err)
+	}
+	if s, want := in.String(), "add\t0x0002 0x0003 -> sp"; s != want || n != 4 {
+		t.Errorf("InstructionAt(0x40) = %q, %d; want %q, 4", s, n, want)
+	}
+	if m.PC() != 0 {
+		t.Errorf("PC = %v after InstructionAt; want 00000", m.PC())
+	}
+	if _, _, err := m.InstructionAt(0x200); err == nil {
+		t.Error("InstructionAt past end of memory succeeded")
+	}
+}
+
+func TestDisassembleRange(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	copy(m.memory[0x1f7:], []byte{
+		0xb2, 0x91, 0xae, // print "Hi"
+		0xb0,                   // rtrue
+		0x14, 0x02, 0x03, 0x00, // add 2 3 -> sp
+		0xb2, // print, with its text cut off by the end of memory
+	})
+	lines, err := m.DisassembleRange(0x1f7, 0x200)
+	if err != nil {
+		t.Fatal("DisassembleRange:", err)
+	}
+	want := []string{
+		"001f7  print\t \"Hi\"",
+		"001fa  rtrue\t",
+		"001fb  add\t0x0002 0x0003 -> sp",
+		"001ff  .byte 0xb2",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("DisassembleRange(0x1f7, 0x200) = %q; want %q", lines, want)
+	}
+	if _, err := m.DisassembleRange(0x1f7, 0x201); err == nil {
+		t.Error("DisassembleRange past end of memory succeeded")
+	}
+}
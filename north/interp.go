@@ -0,0 +1,120 @@
+package north
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// An Interpreter runs a story until it ends, restarting it when asked and
+// cleaning up the UI afterward.
+type Interpreter struct {
+	// If AutoQuit is true, then the interpreter answers the first read after
+	// the UI's input closes with "quit" and then "y", giving the story a
+	// chance to end cleanly.
+	AutoQuit bool
+
+	story    []byte
+	m        *Machine
+	patches  []Patch
+	quitting bool
+}
+
+// NewInterpreter creates an interpreter for the story in r.  The machine is
+// configured by opts.
+func NewInterpreter(r io.Reader, ui UI, opts ...Option) (*Interpreter, error) {
+	story, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMachine(bytes.NewReader(story), ui, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Interpreter{story: story, m: m}, nil
+}
+
+// Machine returns the interpreter's machine.
+func (i *Interpreter) Machine() *Machine {
+	return i.m
+}
+
+// ApplyPatch applies p to the interpreter's machine with ApplyPatch, and
+// again each time the story restarts.
+func (i *Interpreter) ApplyPatch(p Patch) error {
+	if err := ApplyPatch(i.m, p); err != nil {
+		return err
+	}
+	i.patches = append(i.patches, p)
+	return nil
+}
+
+// Run executes the story until it quits or fails.  A story that quits or
+// runs out of input returns nil, and one that returns from its main routine
+// returns an EndOfMain TerminationError.  The UI is closed before Run
+// returns.
+func (i *Interpreter) Run() error {
+	return i.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops between instructions once ctx is done.
+// A stopped run returns a Canceled TerminationError and leaves the UI open, so
+// the story can be resumed by calling RunContext again or ended by calling
+// Close.
+func (i *Interpreter) RunContext(ctx context.Context) error {
+	err := i.run(ctx)
+	if term, ok := err.(*TerminationError); ok && term.Reason == Canceled {
+		return err
+	}
+	if cerr := i.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close flushes the machine's output, closes the transcript file from a
+// TranscriptPrompter, and closes the UI.
+func (i *Interpreter) Close() error {
+	err := i.m.Flush()
+	if cerr := i.m.closeTranscript(); err == nil {
+		err = cerr
+	}
+	if c, ok := i.m.ui.(Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (i *Interpreter) run(ctx context.Context) error {
+	for {
+		err := i.m.RunContext(ctx)
+		term, ok := err.(*TerminationError)
+		if !ok {
+			return err
+		}
+		switch term.Reason {
+		case Quit:
+			return nil
+		case InputClosed:
+			if !i.AutoQuit || i.quitting {
+				return nil
+			}
+			i.quitting = true
+			i.m.queueInput("quit", "y")
+		case Restart:
+			if err := i.m.restart(i.story); err != nil {
+				return err
+			}
+			for _, p := range i.patches {
+				if err := ApplyPatch(i.m, p); err != nil {
+					return err
+				}
+			}
+		default:
+			return err
+		}
+	}
+}
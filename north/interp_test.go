@@ -0,0 +1,316 @@
+package north
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// flushUI is a bufferUI that holds output until it is flushed.
+type flushUI struct {
+	bufferUI
+	pending bytes.Buffer
+	flushes int
+	closed  bool
+
+	// If flushErr is not nil, the flushes'th call to Flush returns it.
+	flushErr error
+	errAfter int
+}
+
+func (ui *flushUI) Output(window int, text string) error {
+	if window == 0 {
+		ui.pending.WriteString(text)
+	}
+	return nil
+}
+
+func (ui *flushUI) Flush() error {
+	ui.flushes++
+	ui.pending.WriteTo(&ui.Buffer)
+	if ui.flushErr != nil && ui.flushes == ui.errAfter {
+		return ui.flushErr
+	}
+	return nil
+}
+
+func (ui *flushUI) Close() error {
+	ui.closed = true
+	return nil
+}
+
+func newInterpreter(t *testing.T, b *zasm.Builder, ui UI) *Interpreter {
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	i, err := NewInterpreter(bytes.NewReader(img), ui)
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	return i
+}
+
+func TestInterpreterQuitFlushes(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("*** You have died ***"))
+	b.Instr("new_line")
+	b.Instr("quit")
+	ui := new(flushUI)
+	if err := newInterpreter(t, b, ui).Run(); err != nil {
+		t.Fatal("Run:", err)
+	}
+	if out := ui.String(); out != "*** You have died ***\n" {
+		t.Errorf("output != \"*** You have died ***\\n\" (got %q)", out)
+	}
+	if !ui.closed {
+		t.Error("UI not closed")
+	}
+}
+
+func TestInterpreterReturnFromMain(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("Goodbye"))
+	b.Instr("output_stream", zasm.Const(3), zasm.Addr("table"))
+	b.Instr("print", zasm.Text("hidden"))
+	b.Instr("rtrue")
+	b.Data("table", make([]byte, 16))
+	ui := new(flushUI)
+	i := newInterpreter(t, b, ui)
+	if err := i.Run(); !errors.Is(err, ErrReturnFromMain) {
+		t.Errorf("Run() != ErrReturnFromMain (got %v)", err)
+	}
+	if out := ui.String(); out != "Goodbye" {
+		t.Errorf("output != \"Goodbye\" (got %q)", out)
+	}
+	if m := i.Machine(); m.streams&(1<<redirectOutput) != 0 || len(m.rtables) != 0 {
+		t.Error("memory stream still open")
+	}
+	if !ui.closed {
+		t.Error("UI not closed")
+	}
+}
+
+func TestInterpreterRestart(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("restart")
+	errStop := errors.New("stop")
+	ui := &flushUI{flushErr: errStop, errAfter: 3}
+	if err := newInterpreter(t, b, ui).Run(); err != errStop {
+		t.Errorf("Run() != errStop (got %v)", err)
+	}
+	if out := ui.String(); out != "aaa" {
+		t.Errorf("output != \"aaa\" (got %q)", out)
+	}
+	if !ui.closed {
+		t.Error("UI not closed")
+	}
+}
+
+func TestInterpreterRestartPatch(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("restart")
+	errStop := errors.New("stop")
+	ui := &flushUI{flushErr: errStop, errAfter: 3}
+	interp := newInterpreter(t, b, ui)
+	// Change the printed "a" to "c".
+	p := Patch{Changes: []PatchByte{{interp.Machine().PC() + 1, 0x98, 0xa0}}}
+	if err := interp.ApplyPatch(p); err != nil {
+		t.Fatal("ApplyPatch:", err)
+	}
+	if err := interp.Run(); err != errStop {
+		t.Errorf("Run() != errStop (got %v)", err)
+	}
+	if out := ui.String(); out != "ccc" {
+		t.Errorf("output != \"ccc\" (got %q)", out)
+	}
+}
+
+// linesUI is a bufferUI that reads from a list of lines, then returns a
+// partial line (if any) with io.EOF.
+type linesUI struct {
+	bufferUI
+	lines   []string
+	partial string
+}
+
+func (ui *linesUI) Input(n int) ([]rune, error) {
+	if len(ui.lines) > 0 {
+		line := ui.lines[0]
+		ui.lines = ui.lines[1:]
+		return []rune(line), nil
+	}
+	partial := ui.partial
+	ui.partial = ""
+	return []rune(partial), io.EOF
+}
+
+// newReadStory returns a story that reads lines forever.  If the first word
+// of a line is "quit", it asks for confirmation with read_char and quits.
+func newReadStory() *zasm.Builder {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Label("loop")
+	b.Instr("storeb", zasm.Addr("text"), zasm.Const(1), zasm.Const(0))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	b.Instr("loadw", zasm.Addr("parse"), zasm.Const(1), zasm.Store(zasm.Global(0)))
+	b.Instr("je", zasm.Global(0), zasm.DictAddr("quit"), zasm.IfFalse("loop"))
+	b.Instr("print", zasm.Text("Are you sure? "))
+	b.Instr("read_char", zasm.Const(1), zasm.Store(zasm.SP))
+	b.Instr("je", zasm.SP, zasm.Const('y'), zasm.IfFalse("loop"))
+	b.Instr("print", zasm.Text("Bye"))
+	b.Instr("quit")
+	b.DictWord("look", "quit")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+	return b
+}
+
+func TestReadEOF(t *testing.T) {
+	tests := []struct {
+		Partial string
+		Lines   []string
+	}{
+		{"", []string{"look"}},
+		{"inv", []string{"look", "inv"}},
+	}
+	for _, tt := range tests {
+		b := newReadStory()
+		ui := &linesUI{lines: []string{"look"}, partial: tt.Partial}
+		m := buildMachine(t, b, ui)
+		a, _ := b.DataAddress("text")
+		text := Address(a)
+
+		var lines []string
+		for {
+			pc := m.PC()
+			err := m.Step()
+			if errors.Is(err, ErrInputClosed) {
+				if m.PC() != pc {
+					t.Errorf("partial %q: PC after ErrInputClosed != %v (got %v)", tt.Partial, pc, m.PC())
+				}
+				break
+			} else if err != nil {
+				t.Fatalf("partial %q: %v", tt.Partial, err)
+			}
+			if m.loadByte(pc) == 0xe4 {
+				// aread
+				n := Address(m.loadByte(text + 1))
+				lines = append(lines, string(m.memory[text+2:text+2+n]))
+			}
+		}
+		if !reflect.DeepEqual(lines, tt.Lines) {
+			t.Errorf("partial %q: lines read != %q (got %q)", tt.Partial, tt.Lines, lines)
+		}
+		if err := m.Step(); !errors.Is(err, ErrInputClosed) {
+			t.Errorf("partial %q: retry != ErrInputClosed (got %v)", tt.Partial, err)
+		}
+	}
+}
+
+func TestInterpreterAutoQuit(t *testing.T) {
+	for _, autoQuit := range []bool{false, true} {
+		ui := &linesUI{lines: []string{"look"}}
+		i := newInterpreter(t, newReadStory(), ui)
+		i.AutoQuit = autoQuit
+		if err := i.Run(); err != nil {
+			t.Errorf("AutoQuit=%t: Run: %v", autoQuit, err)
+		}
+		want := "look\n"
+		if autoQuit {
+			want = "look\nquit\nAre you sure? Bye"
+		}
+		if out := ui.String(); out != want {
+			t.Errorf("AutoQuit=%t: output != %q (got %q)", autoQuit, want, out)
+		}
+	}
+}
+
+func TestInterpreterRunContext(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("Resumed"))
+	b.Instr("quit")
+	ui := new(flushUI)
+	i := newInterpreter(t, b, ui)
+	pc := i.Machine().PC()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := i.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext with cancelled context = %v; want %v", err, context.Canceled)
+	}
+	if i.Machine().PC() != pc {
+		t.Errorf("PC after cancel != %v (got %v)", pc, i.Machine().PC())
+	}
+	if ui.closed {
+		t.Error("UI closed after cancel")
+	}
+
+	if err := i.Run(); err != nil {
+		t.Fatal("Run:", err)
+	}
+	if out := ui.String(); out != "Resumed" {
+		t.Errorf("output != \"Resumed\" (got %q)", out)
+	}
+	if !ui.closed {
+		t.Error("UI not closed")
+	}
+}
+
+// cancelInputUI cancels a run from inside its first Input call, as a UI
+// abandoning a read on an interrupt does, and then reads Line.
+type cancelInputUI struct {
+	scriptUI
+	cancel func()
+}
+
+func (ui *cancelInputUI) Input(n int) ([]rune, error) {
+	if ui.cancel != nil {
+		ui.cancel()
+		ui.cancel = nil
+		return nil, context.Canceled
+	}
+	return ui.scriptUI.Input(n)
+}
+
+func TestInterpreterRunContextDuringInput(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ui := &cancelInputUI{scriptUI: scriptUI{Line: "look"}, cancel: cancel}
+	i := newInterpreter(t, b, ui)
+	pc := i.Machine().PC()
+
+	err := i.RunContext(ctx)
+	var term *TerminationError
+	if !errors.As(err, &term) || term.Reason != Canceled {
+		t.Fatalf("RunContext cancelled during input = %v; want Canceled TerminationError", err)
+	}
+	if i.Machine().PC() != pc {
+		t.Errorf("PC after cancel = %v; want %v", i.Machine().PC(), pc)
+	}
+
+	// The read runs again on resume.
+	if err := i.Run(); err != nil {
+		t.Fatal("Run:", err)
+	}
+	if got := inputBuffer(i.Machine(), b); got != "look" {
+		t.Errorf("buffer after resume = %q; want \"look\"", got)
+	}
+}
@@ -1,18 +1,39 @@
 package north
 
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
 type dictionary struct {
 	Separators []rune
 	EntrySize  uint8
 	Count      Word
 	Base       Address
-	Words      map[string]Address
 	WordSize   int
+
+	// Sorted is true if the entries are in ascending order of their encoded
+	// form, which the Standard requires of the game's own dictionary.
+	Sorted bool
+
+	m     *Machine
+	words map[string]Address
 }
 
 func (m *Machine) dictionary(addr Address) (*dictionary, error) {
+	if int(addr) >= len(m.memory) {
+		return nil, &MemoryError{Address: addr, Size: 1}
+	}
 	d := &dictionary{
 		Base:       addr,
 		Separators: make([]rune, m.loadByte(addr)),
+		Sorted:     true,
+		m:          m,
+	}
+	// The separators are followed by the entry length and count.
+	if hdr := 1 + len(d.Separators) + 3; int(addr)+hdr > len(m.memory) {
+		return nil, &MemoryError{Address: addr, Size: hdr}
 	}
 	for i := range d.Separators {
 		var err error
@@ -28,30 +49,96 @@ func (m *Machine) dictionary(addr Address) (*dictionary, error) {
 	if i := int16(d.Count); i < 0 {
 		// XXX: This may not be right for the game dictionary.
 		d.Count = Word(-i)
+		d.Sorted = false
 	}
 	d.Base += 3
-	d.Words = make(map[string]Address, d.Count)
 	if m.Version() <= 3 {
 		d.WordSize = 6
 	} else {
 		d.WordSize = 9
 	}
+	// search compares the encoded word against the start of each entry.
+	if n := d.WordSize / 3 * 2; d.Count > 0 && int(d.EntrySize) < n {
+		return nil, fmt.Errorf("Dictionary entries are %d bytes, shorter than a %d-byte word", d.EntrySize, n)
+	}
+	if size := int(d.Count) * int(d.EntrySize); int(d.Base)+size > len(m.memory) {
+		return nil, &MemoryError{Address: d.Base, Size: size}
+	}
+	return d, nil
+}
+
+// entryAddress returns the address of the i-th (0-based) entry.
+func (d *dictionary) entryAddress(i int) Address {
+	return d.Base + Address(i)*Address(d.EntrySize)
+}
 
+// Words returns every entry in the dictionary, keyed by its decoded text.
+func (d *dictionary) Words() (map[string]Address, error) {
+	if d.words != nil {
+		return d.words, nil
+	}
+	words := make(map[string]Address, d.Count)
 	for i := 0; i < int(d.Count); i++ {
-		a := d.Base + Address(i)*Address(d.EntrySize)
-		s, err := m.loadString(a, false)
+		a := d.entryAddress(i)
+		s, err := d.m.loadString(a, false)
 		if err != nil {
 			return nil, err
 		}
-		d.Words[s] = a
+		words[s] = a
 	}
-	return d, nil
+	d.words = words
+	return words, nil
+}
+
+// Lookup returns the address of the entry for word, or 0 if word is not in the
+// dictionary.  Only the first WordSize Z-characters of word are significant.
+func (d *dictionary) Lookup(word string) Address {
+	if d.Sorted {
+		return d.search(word)
+	}
+	return d.scan(word)
+}
+
+// search finds word by binary searching the encoded entries.
+func (d *dictionary) search(word string) Address {
+	enc, err := d.m.encodeWord(word, d.WordSize)
+	if err != nil {
+		return 0
+	}
+	mem := d.m.memory
+	n := int(d.Count)
+	i := sort.Search(n, func(i int) bool {
+		a := d.entryAddress(i)
+		return bytes.Compare(mem[a:a+Address(len(enc))], enc) >= 0
+	})
+	if i < n {
+		if a := d.entryAddress(i); bytes.Equal(mem[a:a+Address(len(enc))], enc) {
+			return a
+		}
+	}
+	return 0
+}
+
+// scan finds word by comparing the encoded entries in order.  This works for
+// unsorted dictionaries.
+func (d *dictionary) scan(word string) Address {
+	enc, err := d.m.encodeWord(word, d.WordSize)
+	if err != nil {
+		return 0
+	}
+	mem := d.m.memory
+	for i := 0; i < int(d.Count); i++ {
+		if a := d.entryAddress(i); bytes.Equal(mem[a:a+Address(len(enc))], enc) {
+			return a
+		}
+	}
+	return 0
 }
 
 // tokenise performs lexical analysis on input using dict, storing the result at
 // addr. If storeZero is false, then the parse info for any unrecognized words
-// is left unchanged.
-func (m *Machine) tokenise(input []rune, dict *dictionary, addr Address, storeZero bool) {
+// is left unchanged.  It returns the words that fit in the parse buffer.
+func (m *Machine) tokenise(input []rune, dict *dictionary, addr Address, storeZero bool) []lexWord {
 	words := lex(input, dict)
 	maxWords := int(m.loadByte(addr))
 	if len(words) > maxWords {
@@ -71,6 +158,45 @@ func (m *Machine) tokenise(input []rune, dict *dictionary, addr Address, storeZe
 			}
 		}
 	}
+	return words
+}
+
+// A ParsedWord is a word of input as the story's last read split it.
+type ParsedWord struct {
+	Text string
+
+	// Start and End are the positions of the word in the input line,
+	// counting characters from 0.  End is exclusive.
+	Start, End int
+
+	// Dictionary is the address of the word's dictionary entry, or 0 if the
+	// word isn't in the dictionary.
+	Dictionary Address
+}
+
+// Recognized reports whether the word is in the dictionary.
+func (w ParsedWord) Recognized() bool {
+	return w.Dictionary != 0
+}
+
+// LastParse returns the words of the line read by the last read opcode, as
+// written to its parse buffer.  It returns nil if the read had no parse
+// buffer, as version 5 stories may ask, or while a read is in progress.
+func (m *Machine) LastParse() []ParsedWord {
+	return m.lastParse
+}
+
+// setLastParse records the words of input that tokenise stored.
+func (m *Machine) setLastParse(input []rune, words []lexWord) {
+	m.lastParse = make([]ParsedWord, len(words))
+	for i, w := range words {
+		m.lastParse[i] = ParsedWord{
+			Text:       string(input[w.Start:w.End]),
+			Start:      w.Start,
+			End:        w.End,
+			Dictionary: w.Word,
+		}
+	}
 }
 
 type lexWord struct {
@@ -85,11 +211,7 @@ func lex(input []rune, dict *dictionary) []lexWord {
 	for i := range result {
 		result[i].Start = indices[i][0]
 		result[i].End = indices[i][1]
-		word := string(input[indices[i][0]:indices[i][1]])
-		if len(word) > dict.WordSize {
-			word = word[:dict.WordSize]
-		}
-		result[i].Word = dict.Words[word]
+		result[i].Word = dict.Lookup(string(input[indices[i][0]:indices[i][1]]))
 	}
 	return result
 }
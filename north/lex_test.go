@@ -1,8 +1,13 @@
 package north
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
+	"sort"
 	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
 )
 
 func TestSplitWords(t *testing.T) {
@@ -37,3 +42,300 @@ func TestSplitWords(t *testing.T) {
 		}
 	}
 }
+
+var testDictionaryWords = []string{
+	"north", "south", "east", "west", "northeast", "n", "s", "e", "w",
+	"take", "drop", "lamp", "lantern", "mailbox", "leaflet", "xyzzy", "go",
+	"1", "2nd", "i", "inventory", "it", "all", "#comm", "@",
+	",", ".", ";",
+}
+
+// newDictionaryTestMachine returns a version 3 machine with a game dictionary
+// at 0x40 containing testDictionaryWords.  The separators are ",.;".
+func newDictionaryTestMachine() *Machine {
+	const (
+		base      = 0x40
+		entrySize = 7
+	)
+	entries := make([][]byte, 0, len(testDictionaryWords))
+	seen := make(map[string]bool)
+	for _, w := range testDictionaryWords {
+		enc, err := encodeString(w, StandardAlphabetSet, 6)
+		if err != nil {
+			panic(err)
+		}
+		if !seen[string(enc)] {
+			seen[string(enc)] = true
+			entries = append(entries, enc)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	m, _ := newTestMachine(3, base+7+len(entries)*entrySize)
+	m.storeWord(0x08, base)
+	m.storeByte(base, 3)
+	m.storeByte(base+1, ',')
+	m.storeByte(base+2, '.')
+	m.storeByte(base+3, ';')
+	m.storeByte(base+4, entrySize)
+	m.storeWord(base+5, Word(len(entries)))
+	for i, enc := range entries {
+		copy(m.memory[base+7+i*entrySize:], enc)
+	}
+	return m
+}
+
+func TestDictionaryLookup(t *testing.T) {
+	m := newDictionaryTestMachine()
+	d, err := m.dictionary(m.dictionaryAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Sorted {
+		t.Fatal("game dictionary not sorted")
+	}
+	words, err := d.Words()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != int(d.Count) {
+		t.Errorf("len(d.Words()) = %d; want %d", len(words), d.Count)
+	}
+
+	corpus := []string{
+		"", " ", "northwest", "northeastern", "lanterns", "mail", "xyzz", "xyzzyx",
+		"3", "2nd", "ALL", "a", "z", "zzzzzz", "\u00e9", ",", ".", "inv",
+	}
+	corpus = append(corpus, testDictionaryWords...)
+	for w, a := range words {
+		corpus = append(corpus, w)
+		if got := d.search(w); got != a {
+			t.Errorf("d.search(%q) = %v; want %v", w, got, a)
+		}
+	}
+	for _, w := range corpus {
+		if s, l := d.search(w), d.scan(w); s != l {
+			t.Errorf("d.search(%q) = %v, d.scan(%q) = %v", w, s, w, l)
+		}
+	}
+	for _, w := range testDictionaryWords {
+		if d.Lookup(w) == 0 {
+			t.Errorf("d.Lookup(%q) = 0", w)
+		}
+	}
+	if a := d.Lookup("frobozz"); a != 0 {
+		t.Errorf("d.Lookup(%q) = %v; want 0", "frobozz", a)
+	}
+}
+
+// packZChars packs Z-characters three to a word, with the end bit on the
+// last word.
+func packZChars(z ...byte) []byte {
+	b := make([]byte, 0, len(z)/3*2)
+	for i := 0; i < len(z); i += 3 {
+		w := uint16(z[i])<<10 | uint16(z[i+1])<<5 | uint16(z[i+2])
+		if i+3 == len(z) {
+			w |= 0x8000
+		}
+		b = append(b, byte(w>>8), byte(w))
+	}
+	return b
+}
+
+// TestDictionaryEncoding looks up words whose encoding depends on the story:
+// shifts in versions 1 and 2, and characters outside ASCII.
+func TestDictionaryEncoding(t *testing.T) {
+	const base = 0x40
+	tests := []struct {
+		Name    string
+		Version byte
+		Word    string
+		Entry   []byte
+	}{
+		{"v2 punctuation", 2, "don't", packZChars(9, 20, 19, 3, 24, 25)},
+		{"v2 capital", 2, "Lamp", packZChars(2, 17, 6, 18, 21, 5)},
+		{"v3 punctuation", 3, "don't", packZChars(9, 20, 19, 5, 24, 25)},
+		{"v5 accent", 5, "café", packZChars(8, 6, 11, 5, 6, 170>>5, 170&0x1f, 5, 5)},
+		{"v2 accent", 2, "café", packZChars(8, 6, 11, 3, 6, 170>>5)},
+	}
+	for _, tt := range tests {
+		other := packZChars(31, 31, 31, 31, 31, 31, 31, 31, 31)[:len(tt.Entry)]
+		entrySize := len(tt.Entry) + 1
+		for _, sorted := range []bool{true, false} {
+			m, _ := newTestMachine(tt.Version, 0x200)
+			m.storeWord(0x08, base)
+			m.storeByte(base, 0)
+			m.storeByte(base+1, byte(entrySize))
+			if sorted {
+				m.storeWord(base+2, 2)
+				copy(m.memory[base+4:], tt.Entry)
+				copy(m.memory[base+4+entrySize:], other)
+			} else {
+				m.storeWord(base+2, Word(0x10000-2))
+				copy(m.memory[base+4:], other)
+				copy(m.memory[base+4+entrySize:], tt.Entry)
+			}
+			d, err := m.dictionary(m.dictionaryAddress())
+			if err != nil {
+				t.Fatalf("%s: %v", tt.Name, err)
+			}
+			want := Address(base + 4)
+			if !sorted {
+				want += Address(entrySize)
+			}
+			if a := d.Lookup(tt.Word); a != want {
+				t.Errorf("%s sorted=%t: d.Lookup(%q) = %v; want %v", tt.Name, sorted, tt.Word, a, want)
+			}
+		}
+	}
+}
+
+func TestLexSeparators(t *testing.T) {
+	m := newDictionaryTestMachine()
+	d, err := m.dictionary(m.dictionaryAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type token struct {
+		Start, End int
+		Word       string
+	}
+	tests := []struct {
+		Input  string
+		Tokens []token
+	}{
+		{".,;", []token{{0, 1, "."}, {1, 2, ","}, {2, 3, ";"}}},
+		{" ; ", []token{{1, 2, ";"}}},
+		{"take lamp.", []token{{0, 4, "take"}, {5, 9, "lamp"}, {9, 10, "."}}},
+		{"take,lamp", []token{{0, 4, "take"}, {4, 5, ","}, {5, 9, "lamp"}}},
+		{";take;;frobozz;", []token{{0, 1, ";"}, {1, 5, "take"}, {5, 6, ";"}, {6, 7, ";"}, {7, 14, ""}, {14, 15, ";"}}},
+	}
+	for _, tt := range tests {
+		words := lex([]rune(tt.Input), d)
+		if len(words) != len(tt.Tokens) {
+			t.Errorf("lex(%q) = %v; want %d tokens", tt.Input, words, len(tt.Tokens))
+			continue
+		}
+		for i, tok := range tt.Tokens {
+			var want Address
+			if tok.Word != "" {
+				if want = d.Lookup(tok.Word); want == 0 {
+					t.Fatalf("%q not in dictionary", tok.Word)
+				}
+			}
+			if w := words[i]; w.Start != tok.Start || w.End != tok.End || w.Word != want {
+				t.Errorf("lex(%q)[%d] = %+v; want {Start:%d End:%d Word:%v}", tt.Input, i, w, tok.Start, tok.End, want)
+			}
+		}
+	}
+}
+
+func TestDictionaryTruncation(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	b.DictWord("flashlight")
+	m := buildMachine(t, b, new(bufferUI))
+	d, err := m.dictionary(m.dictionaryAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := d.Lookup("flashligh")
+	if want == 0 {
+		t.Fatal("d.Lookup(\"flashligh\") = 0")
+	}
+	tests := []struct {
+		Word  string
+		Match bool
+	}{
+		{"flashlight", true},
+		{"flashlights", true},
+		{"flashlig", false},
+		{"flashlighx", true},
+		{"flashligx", false},
+		// Only Z-characters count, not bytes.
+		{"flashlighté", true},
+	}
+	for _, tt := range tests {
+		if a := d.Lookup(tt.Word); (a == want) != tt.Match {
+			t.Errorf("d.Lookup(%q) = %v; want match = %t", tt.Word, a, tt.Match)
+		}
+	}
+	if a := lex([]rune("take flashlights"), d); len(a) != 2 || a[1].Word != want || a[1].End-a[1].Start != 11 {
+		t.Errorf("lex(\"take flashlights\") = %+v; want second word %v with length 11", a, want)
+	}
+}
+
+func TestDictionaryBounds(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header []byte // at 0x1f0: separators, entry length, count
+	}{
+		{"entries past end", []byte{0, 7, 0x00, 0x10}},
+		{"separators past end", []byte{0x20}},
+		{"negative count past end", []byte{0, 7, 0xff, 0xf0}},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(3, 0x200)
+		copy(m.memory[0x1f0:], tt.Header)
+		var merr *MemoryError
+		if _, err := m.dictionary(0x1f0); !errors.As(err, &merr) {
+			t.Errorf("%s: m.dictionary = %v; want MemoryError", tt.Name, err)
+		}
+	}
+	m, _ := newTestMachine(3, 0x200)
+	copy(m.memory[0x1f0:], []byte{0, 2, 0x00, 0x01})
+	if _, err := m.dictionary(0x1f0); err == nil {
+		t.Error("m.dictionary with 2-byte entries succeeded")
+	}
+}
+
+func TestLastParse(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	b.Instr("aread", zasm.Addr("text"), zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.DictWord("take", "lamp")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{8}, make([]byte, 33)...))
+	m := buildMachine(t, b, new(bufferUI))
+	dict, err := m.Dictionary()
+	if err != nil {
+		t.Fatal("Dictionary:", err)
+	}
+	entries := make(map[string]Address)
+	for _, e := range dict.Words {
+		entries[e.Word] = e.Address
+	}
+
+	if p := m.LastParse(); p != nil {
+		t.Errorf("LastParse() before read = %+v; want nil", p)
+	}
+	m.SubmitInput("Take the lamp")
+	if err := m.Step(); err != nil {
+		t.Fatal("first read:", err)
+	}
+	want := []ParsedWord{
+		{Text: "take", Start: 0, End: 4, Dictionary: entries["take"]},
+		{Text: "the", Start: 5, End: 8},
+		{Text: "lamp", Start: 9, End: 13, Dictionary: entries["lamp"]},
+	}
+	p := m.LastParse()
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("LastParse() = %+v; want %+v", p, want)
+	}
+	if len(p) == 3 && (!p[0].Recognized() || p[1].Recognized()) {
+		t.Errorf("Recognized() = %t, %t; want true, false", p[0].Recognized(), p[1].Recognized())
+	}
+
+	m.SubmitInput("hello")
+	if err := m.Step(); err != nil {
+		t.Fatal("second read:", err)
+	}
+	if p := m.LastParse(); p != nil {
+		t.Errorf("LastParse() after read without parse buffer = %+v; want nil", p)
+	}
+}
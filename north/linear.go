@@ -0,0 +1,146 @@
+package north
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A linearScreen is the upper window and status line of a machine in linear
+// mode.  Instead of sending cursor-positioned text to the UI, the machine
+// keeps it in a grid and reads it out as one line before the story waits for
+// input, so that a screen reader meets the text in reading order.
+type linearScreen struct {
+	grid     [][]rune
+	row, col int
+
+	// status is the version 1-3 status line.
+	status string
+
+	// last is the status last sent to the UI, which isn't repeated.
+	last string
+
+	// partial is the lower window text after the last newline.
+	partial string
+}
+
+// Linear turns on linear mode, for screen readers.  Text in the upper window
+// and the status line isn't sent to the UI as it's drawn.  Instead, before
+// the story reads input, the machine prints a line like "Status: West of
+// House | Score 10 | Moves 4" in the lower window, if the status changed
+// since it was last printed.  The UI sees a single window.
+func Linear(linear bool) Option {
+	return func(c *config) {
+		c.linear = linear
+	}
+}
+
+// write adds upper window text at the cursor.  Text past the edges of the
+// window is dropped.
+func (ls *linearScreen) write(s string, width int) {
+	for _, r := range s {
+		if r == '\n' {
+			ls.row++
+			ls.col = 0
+			continue
+		}
+		if ls.row < len(ls.grid) && ls.col < width {
+			row := ls.grid[ls.row]
+			for len(row) <= ls.col {
+				row = append(row, ' ')
+			}
+			row[ls.col] = r
+			ls.grid[ls.row] = row
+		}
+		ls.col++
+	}
+}
+
+// split resizes the upper window to n lines, keeping the lines that still
+// fit.
+func (ls *linearScreen) split(n int) {
+	for len(ls.grid) < n {
+		ls.grid = append(ls.grid, nil)
+	}
+	ls.grid = ls.grid[:n]
+	if ls.row >= n {
+		ls.row, ls.col = 0, 0
+	}
+}
+
+// erase clears the upper window and moves the cursor to its top left.
+func (ls *linearScreen) erase() {
+	for i := range ls.grid {
+		ls.grid[i] = nil
+	}
+	ls.row, ls.col = 0, 0
+}
+
+// eraseLine clears the upper window from the cursor to the end of the line.
+func (ls *linearScreen) eraseLine() {
+	if ls.row < len(ls.grid) && ls.col < len(ls.grid[ls.row]) {
+		ls.grid[ls.row] = ls.grid[ls.row][:ls.col]
+	}
+}
+
+// setCursor moves the cursor, counting from 1.  Positions before the first
+// line or column are taken as the first.
+func (ls *linearScreen) setCursor(line, column int) {
+	ls.row, ls.col = clampNone(line-1), clampNone(column-1)
+}
+
+// text returns the status to read out: the status line, or the lines of the
+// upper window with runs of blanks replaced by separators.
+func (ls *linearScreen) text() string {
+	if ls.status != "" {
+		return ls.status
+	}
+	var fields []string
+	for _, row := range ls.grid {
+		for _, f := range strings.Split(string(row), "  ") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return strings.Join(fields, " | ")
+}
+
+// track remembers the end of lower window text sent to the UI.
+func (ls *linearScreen) track(s string) {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		ls.partial = s[i+1:]
+	} else {
+		ls.partial += s
+	}
+}
+
+// linearStatus prints the status in linear mode, if it changed since it was
+// last printed.  It goes on a line of its own, and any prompt the story
+// already printed is repeated after it, so the player reads the status
+// before the prompt.
+func (m *Machine) linearStatus() error {
+	if !m.cfg.linear {
+		return nil
+	}
+	ls := &m.linear
+	s := ls.text()
+	if s == "" || s == ls.last {
+		return nil
+	}
+	ls.last = s
+	out := "Status: " + s + "\n"
+	if ls.partial != "" {
+		out = "\n" + out + ls.partial
+	}
+	return m.ui.Output(0, out)
+}
+
+// linearStatusLine sets the version 1-3 status line in linear mode.
+func (m *Machine) linearStatusLine(name, right string, isTime bool) {
+	if isTime {
+		m.linear.status = fmt.Sprintf("%s | Time %s", name, strings.TrimSpace(right))
+		return
+	}
+	score, moves := int16(m.getVariable(0x11)), int16(m.getVariable(0x12))
+	m.linear.status = fmt.Sprintf("%s | Score %d | Moves %d", name, score, moves)
+}
@@ -0,0 +1,113 @@
+package north
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestLinearStatusLine(t *testing.T) {
+	b := zasm.New(3)
+	room := b.Object("West of House", "", nil)
+	b.SetGlobal(0, uint16(room))
+	b.SetGlobal(1, 10)
+	b.SetGlobal(2, 4)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text(">"))
+	b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+	b.Instr("print", zasm.Text("Taken.\n>"))
+	b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+	b.Instr("inc", zasm.Const(0x12))
+	b.Instr("print", zasm.Text("Time passes.\n>"))
+	b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+	b.Instr("quit")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{8}, make([]byte, 33)...))
+	ui := new(screenUI)
+	m := buildMachine(t, b, ui, Linear(true))
+	if c := m.Capabilities(); !c.StatusLine || !c.SplitScreen {
+		t.Errorf("Capabilities() = %+v; want status line and split screen", c)
+	}
+	m.SubmitInput("take lamp")
+	m.SubmitInput("wait")
+	m.SubmitInput("look")
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatal("Run:", err)
+	}
+	want := ">\nStatus: West of House | Score 10 | Moves 4\n>take lamp\n" +
+		"Taken.\n>wait\n" +
+		"Time passes.\n>\nStatus: West of House | Score 10 | Moves 5\n>look\n"
+	if s := ui.screen.String(); s != want {
+		t.Errorf("screen =\n%s\nwant:\n%s", s, want)
+	}
+}
+
+// linearUpperWindow emits a version 5 status bar with the room on the left
+// and the score on the right.
+func linearUpperWindow(b *zasm.Builder, room string) {
+	b.Instr("set_window", zasm.Const(1))
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(1))
+	b.Instr("print", zasm.Text(room))
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(60))
+	b.Instr("print", zasm.Text("Score: 10"))
+	b.Instr("set_window", zasm.Const(0))
+}
+
+func TestLinearUpperWindow(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("split_window", zasm.Const(1))
+	b.Instr("erase_window", zasm.Const(1))
+	linearUpperWindow(b, "West of House")
+	b.Instr("print", zasm.Text("Welcome.\n>"))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	linearUpperWindow(b, "West of House")
+	b.Instr("print", zasm.Text("Nothing happens.\n>"))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	linearUpperWindow(b, "Kitchen      ")
+	b.Instr("print", zasm.Text("You go east.\n>"))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{8}, make([]byte, 33)...))
+	ui := new(screenUI)
+	m := buildMachine(t, b, ui, Linear(true))
+	m.SubmitInput("xyzzy")
+	m.SubmitInput("east")
+	m.SubmitInput("look")
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatal("Run:", err)
+	}
+	want := "Welcome.\n>\nStatus: West of House | Score: 10\n>xyzzy\n" +
+		"Nothing happens.\n>east\n" +
+		"You go east.\n>\nStatus: Kitchen | Score: 10\n>look\n"
+	if s := ui.screen.String(); s != want {
+		t.Errorf("screen =\n%s\nwant:\n%s", s, want)
+	}
+}
+
+func TestLinearSetCursorZero(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("split_window", zasm.Const(1))
+	b.Instr("set_window", zasm.Const(1))
+	// Column 0 is before the first column.
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(0))
+	b.Instr("print", zasm.Text("Attic"))
+	b.Instr("set_window", zasm.Const(0))
+	b.Instr("print", zasm.Text(">"))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Data("text", append([]byte{32}, make([]byte, 33)...))
+	b.Data("parse", append([]byte{8}, make([]byte, 33)...))
+	ui := new(screenUI)
+	m := buildMachine(t, b, ui, Linear(true))
+	m.SubmitInput("look")
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatal("Run:", err)
+	}
+	if want := ">\nStatus: Attic\n>look\n"; ui.screen.String() != want {
+		t.Errorf("screen = %q; want %q", ui.screen.String(), want)
+	}
+}
@@ -1,22 +1,38 @@
 package north
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Normal termination by z-machine story.
+// Termination by z-machine story.  Step returns a *TerminationError that
+// matches these with errors.Is.
 var (
-	ErrQuit    = errors.New("Z-machine quit")
-	ErrRestart = errors.New("Z-machine restart")
+	ErrQuit           = errors.New("Z-machine quit")
+	ErrRestart        = errors.New("Z-machine restart")
+	ErrReturnFromMain = errors.New("Z-machine returned from main routine")
 )
 
+// ErrNoFrame is returned by Step when the stack is empty.
+var ErrNoFrame = errors.New("Z-machine stack is empty")
+
+// ErrInputClosed matches the error returned by Step when the story asks for
+// input after the UI has reported io.EOF.  The read can be retried by
+// stepping again.
+var ErrInputClosed = errors.New("Z-machine input closed")
+
 type Address int
 
 func (a Address) String() string {
@@ -31,6 +47,10 @@ func (w Word) String() string {
 
 // A stackFrame holds a routine call's data.
 type stackFrame struct {
+	// Routine is the address of the frame's routine, or 0 for the frame
+	// that starts the story in versions other than 6 and 7.
+	Routine Address
+
 	PC     Address
 	Locals []Word
 	Stack  []Word
@@ -63,7 +83,42 @@ func (f *stackFrame) Pop() (w Word) {
 	return
 }
 
-// A UI allows a Machine to interact with a user.
+// errStackUnderflow is returned by opcodes that pop a routine's empty stack.
+var errStackUnderflow = errors.New("Stack underflow")
+
+// popChecked removes the top value from the stack, or returns
+// errStackUnderflow if it's empty.
+func (f *stackFrame) popChecked() (Word, error) {
+	if len(f.Stack) == 0 {
+		return 0, errStackUnderflow
+	}
+	return f.Pop(), nil
+}
+
+// peekChecked returns the top value of the stack without removing it, or
+// returns errStackUnderflow if the stack is empty.
+func (f *stackFrame) peekChecked() (Word, error) {
+	if len(f.Stack) == 0 {
+		return 0, errStackUnderflow
+	}
+	return f.Stack[len(f.Stack)-1], nil
+}
+
+// replaceChecked changes the top value of the stack, or returns
+// errStackUnderflow if the stack is empty.
+func (f *stackFrame) replaceChecked(w Word) error {
+	if len(f.Stack) == 0 {
+		return errStackUnderflow
+	}
+	f.Stack[len(f.Stack)-1] = w
+	return nil
+}
+
+// A UI allows a Machine to interact with a user.  Output text never contains
+// control characters other than newline.  Input reads a line of at most n
+// characters.  ReadRune reads a single key for read_char, which must
+// not be shown: the standard says read_char input is never echoed, and the
+// machine never echoes it to the screen or the transcript.
 type UI interface {
 	io.RuneReader
 	Input(n int) ([]rune, error)
@@ -72,11 +127,196 @@ type UI interface {
 	Restore(m *Machine) error
 }
 
+// NewLiner is a UI that is told about the line breaks that new_line and the
+// other opcodes print as their own event, instead of as "\n" passed to
+// Output.  Line breaks inside printed text still reach Output.  UIs that
+// aren't NewLiners are given "\n".
+type NewLiner interface {
+	NewLine(window int) error
+}
+
 // StatusLiner is a UI that can display a status line.
 type StatusLiner interface {
 	StatusLine(left, right string) error
 }
 
+// WindowSplitter is a UI that can split the screen into an upper window of
+// the given height and the lower window.
+type WindowSplitter interface {
+	SplitWindow(lines int) error
+}
+
+// ScreenManager is a UI that can position the cursor.  Lines and columns count
+// from 1 at the top left of the current window.
+type ScreenManager interface {
+	SetCursor(line, column int) error
+	SetCursorVisible(visible bool) error
+}
+
+// WindowEraser is a UI that can clear windows.  EraseWindow clears window 0
+// or 1, or the whole screen if window is -1.  EraseLine clears the current
+// window from the cursor to the end of the line.
+type WindowEraser interface {
+	EraseWindow(window int) error
+	EraseLine() error
+}
+
+// VariablePitcher is a UI that can report whether its default font is
+// variable-pitch.
+type VariablePitcher interface {
+	VariablePitch() bool
+}
+
+// InputEchoer is a UI that can report whether it shows input on the screen as
+// the player types it.  Lines read from other UIs are echoed to the screen by
+// the machine.  Either way, lines are copied to the transcript.
+type InputEchoer interface {
+	EchoesInput() bool
+}
+
+// Warner is a UI that can show warnings about problems in the story that the
+// machine worked around.
+type Warner interface {
+	Warn(msg string)
+}
+
+// Transcriber is a UI that can keep a transcript of the story's output.  It
+// receives the text printed to the lower window while the transcript stream
+// is selected.
+type Transcriber interface {
+	Transcript(text string) error
+}
+
+// TranscriptPrompter is a UI that asks the player where to keep a transcript.
+// If the UI isn't a Transcriber, AskTranscriptFile is called the first time
+// the story prints to the transcript stream.  Returning nil, nil declines, and
+// the transcript bit of Flags 2 is cleared so the story knows scripting
+// failed.  The machine closes the file when the story clears the bit.
+type TranscriptPrompter interface {
+	AskTranscriptFile() (io.WriteCloser, error)
+}
+
+// FixedPitcher is a UI that can force text to be printed in a fixed-pitch
+// font.
+type FixedPitcher interface {
+	SetFixedPitch(fixed bool)
+}
+
+// BufferModer is a UI that word-wraps lower window text and can turn that off,
+// as the buffer_mode opcode asks.  Buffering starts out on.
+type BufferModer interface {
+	SetBufferMode(buffered bool)
+}
+
+// Styler is a UI that can show text styles.  style is a set of the
+// set_text_style bits: 1 reverse video, 2 bold, 4 italic, and 8 fixed pitch.
+// Zero goes back to roman.
+type Styler interface {
+	SetTextStyle(style Word) error
+}
+
+// Colorer is a UI that can change the foreground and background colors.
+// Colors are numbered as in set_colour: 0 keeps the current color and 1
+// selects the default.
+type Colorer interface {
+	SetColor(fg, bg Word) error
+}
+
+// FontSetter is a UI that can change fonts.  SetFont reports whether font n is
+// available; if it isn't, the font stays the same.  UIs that aren't
+// FontSetters only have font 1, the normal font.
+type FontSetter interface {
+	SetFont(n Word) bool
+}
+
+// UnicodeCapable is a UI that can report whether it can print and read a
+// Unicode character, for check_unicode.  Characters it can't print are shown
+// as '?' by print_unicode.  UIs that aren't UnicodeCapable are assumed to
+// print every printable character and to read only printable ASCII.
+type UnicodeCapable interface {
+	CanOutput(r rune) bool
+	CanInput(r rune) bool
+}
+
+// ScreenSizer is a UI that knows the size of its screen in characters.  A
+// height of zero means the screen scrolls without limit.  If the width is zero
+// or the UI isn't a ScreenSizer, the story is told the screen is 80 characters
+// wide.
+type ScreenSizer interface {
+	ScreenSize() (width, height int)
+}
+
+// ScreenResizer is a UI that lays text out for the screen size.  It is told
+// the new size when the front-end calls Machine.UpdateScreenSize.
+type ScreenResizer interface {
+	ResizeScreen(width, height int)
+}
+
+// CommandFiler is a UI that can open a file of commands for input stream 1.
+// Lines are read from the file until it ends, then input returns to the
+// keyboard.  If the reader is an io.Closer, it is closed when the machine is
+// done with it.
+type CommandFiler interface {
+	OpenCommandFile() (io.Reader, error)
+}
+
+// AuxFiler is a UI that can keep auxiliary files, which version 5+ stories
+// read and write with the four-operand forms of save and restore.  name is
+// the file name the story suggests, which may be empty; if prompt is true,
+// the player should be asked for the name, with name as the default.
+// RestoreAux reads at most len(buf) bytes into buf and returns the number
+// read.
+type AuxFiler interface {
+	SaveAux(name string, prompt bool, data []byte) error
+	RestoreAux(name string, prompt bool, buf []byte) (int, error)
+}
+
+// Flusher is a UI that buffers output.  Flush is called when the story ends
+// or restarts, so that no output is lost.
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is a UI that needs to clean up once the story ends.  Interpreter
+// calls Close after the last Flush.
+type Closer interface {
+	Close() error
+}
+
+// Graphics is a UI that can show pictures in version 6 stories.  Pictures are
+// numbered as in the story's Blorb file.  PictureData(0) reports the number
+// of pictures as width and the release number of the pictures as height, as
+// picture_data does.
+//
+// DrawPicture and ErasePicture are given the story's current window, as
+// Output is, and the y and x operands as the story gave them.  A coordinate
+// of 0 means the cursor's.  The machine doesn't track where version 6
+// windows are, so converting the coordinates to the screen, and scaling
+// pictures as the Blorb resolution chunk asks, is left to the UI.
+type Graphics interface {
+	PictureData(n int) (width, height int, ok bool)
+	DrawPicture(window, n, y, x int) error
+	ErasePicture(window, n, y, x int) error
+}
+
+// Pointer is a UI with a mouse.  MouseState returns the position of the last
+// click in screen units, counting from 1, and the buttons held down, one bit
+// per button.  Clicks are reported to read_char as MouseClick or
+// MouseDoubleClick.
+type Pointer interface {
+	MouseState() (x, y int, buttons Word)
+}
+
+// Menuer is a UI that can install menus, as in version 6 stories.  Menus 0-2
+// belong to the interpreter, so stories use 3 and up.  When the player picks
+// an item, ReadRune returns MenuClick and MenuSelection reports the menu and
+// item (counting from 1) for read_mouse.
+type Menuer interface {
+	MakeMenu(id int, items []string) error
+	RemoveMenu(id int) error
+	MenuSelection() (id, item int)
+}
+
 // Predefined sound effects
 const (
 	HighPitchBleep = 1
@@ -113,65 +353,295 @@ type Machine struct {
 	ui     UI
 	rand   *rand.Rand
 
+	// randSource is the generator from SetRandSource, or nil.
+	randSource rand.Source
+
+	// image is the story as loaded.  It is never written to.
+	image []byte
+
+	// storyLength is the declared length of the story, capped at the
+	// memory size.  Anything after it is padding.
+	storyLength   int
+	warnedPadding bool
+
+	cfg   config
+	steps int64
+	undo  []undoState
+
+	// undoBase is the dynamic memory of the oldest undo state.
+	undoBase []byte
+
 	window  int
 	streams uint8
 	rtables []rtable
+
+	inputQueue []string
+	events     eventLog
+
+	// lastParse is the words stored by the last read, for LastParse.
+	lastParse []ParsedWord
+	// textBuffer and parseBuffer are the buffers of the last read, for
+	// ClassifyAddress.
+	textBuffer, parseBuffer Address
+
+	// linear is the upper window in linear mode.
+	linear linearScreen
+
+	maxDepthSeen int
+
+	// profile is the stack profile from EnableStackProfile, or nil.
+	profile *stackProfile
+
+	// commands is the command file while input stream 1 is selected.
+	commands      *bufio.Reader
+	commandCloser io.Closer
+
+	// transcript is the file from the TranscriptPrompter while the
+	// transcript stream is selected.
+	transcript io.WriteCloser
+
+	caps Capabilities
+
+	// fonts is the font of each window, and columns is the column of each
+	// window's cursor (see Column).
+	fonts   [8]Word
+	columns [8]int
+
+	onQuit    func()
+	onRestart func()
+
+	blorb    *blorbInfo
+	metadata *StoryMetadata
+
+	strings *stringCache
+
+	pageGen []uint32
+	gen     uint32
+
+	inst decodedInst
+}
+
+// NewMachine creates a new machine, loaded with the story from r and
+// configured by opts.
+func NewMachine(r io.Reader, ui UI, opts ...Option) (*Machine, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newMachine(data, ui, opts)
+}
+
+// NewMachineFromBytes is like NewMachine, but takes the story file as a byte
+// slice, which is copied.  It is convenient for fuzzing: whatever the story
+// contains, Step returns an error rather than panicking.
+func NewMachineFromBytes(b []byte, ui UI, opts ...Option) (*Machine, error) {
+	return newMachine(append([]byte(nil), b...), ui, opts)
 }
 
-// NewMachine creates a new machine, loaded with the story from r.
-func NewMachine(r io.Reader, ui UI) (*Machine, error) {
+func newMachine(data []byte, ui UI, opts []Option) (*Machine, error) {
 	m := new(Machine)
-	if err := m.Load(r); err != nil {
+	m.applyOptions(opts)
+	if err := m.load(data, false); err != nil {
 		return nil, err
 	}
 	m.SetUI(ui)
 	return m, nil
 }
 
+// SetStrict changes the Strict setting between steps.  It overrides the
+// option the machine was loaded with until the next Load.
+func (m *Machine) SetStrict(strict bool) {
+	Strict(strict)(&m.cfg)
+}
+
+// OnQuit sets a function to call when the story executes quit.  Step still
+// returns ErrQuit afterward.
+func (m *Machine) OnQuit(f func()) {
+	m.onQuit = f
+}
+
+// OnRestart sets a function to call when the story executes restart.  Step
+// still returns ErrRestart afterward; the caller restarts the story.
+func (m *Machine) OnRestart(f func()) {
+	m.onRestart = f
+}
+
+// warn sends a warning to the UI.
+func (m *Machine) warn(format string, args ...interface{}) {
+	if w, ok := m.ui.(Warner); ok {
+		w.Warn(fmt.Sprintf(format, args...))
+	}
+}
+
+// checkPadding checks an access to address a, which must be inside the
+// story's declared length.  Accesses to the padding after it are errors in
+// strict mode and otherwise warned about once per load.
+func (m *Machine) checkPadding(a Address, what string) error {
+	if int(a) < m.storyLength {
+		return nil
+	}
+	if m.cfg.strict {
+		return fmt.Errorf("%s at %v is past the end of the story (%v)", what, a, Address(m.storyLength))
+	}
+	if !m.warnedPadding {
+		m.warnedPadding = true
+		m.warn("%s at %v is past the end of the story (%v)", what, a, Address(m.storyLength))
+	}
+	return nil
+}
+
+// ImageSize returns the size of the loaded Z-code image in bytes.  It is less
+// than the header's file length if the story file was truncated.
+func (m *Machine) ImageSize() int {
+	return len(m.memory)
+}
+
 // UI returns m's user interface.
 func (m *Machine) UI() UI {
 	return m.ui
 }
 
-// SetUI sets m's user interface.
+// SetUI sets m's user interface.  In the middle of a story, the header is
+// updated for the new UI's features and screen size, as it would have been
+// if the story had been loaded with it.  A version 6 story is asked to redraw
+// if the screen size changed.
 func (m *Machine) SetUI(ui UI) {
 	m.ui = ui
-	if m.memory != nil {
-		m.copyUIFlags()
+	if m.memory == nil {
+		return
+	}
+	old := m.caps
+	m.copyUIFlags()
+	m.flags2Changed()
+	if m.Version() == 6 && (m.caps.ScreenWidth != old.ScreenWidth || m.caps.ScreenHeight != old.ScreenHeight) {
+		m.storeByte(flags2Game, m.loadByte(flags2Game)|1<<2)
+	}
+
+	// The new UI starts in the normal font.  Switch it to the current
+	// window's font, or forget fonts it can't have.
+	fs, ok := ui.(FontSetter)
+	if !ok {
+		for i := range m.fonts {
+			m.fonts[i] = 1
+		}
+	} else if f := m.windowFont(); *f != 1 && !fs.SetFont(*f) {
+		*f = 1
 	}
 }
 
 // Run executes the story until an error occurs.
 func (m *Machine) Run() error {
+	return m.RunContext(context.Background())
+}
+
+// RunContext executes the story until an error occurs or ctx is done.  The
+// context is checked between instructions, so a cancelled run returns a
+// Canceled TerminationError with the machine ready to resume from its current
+// PC.  A UI waiting for input can stop the run the same way by returning the
+// context's error once ctx is done; the read runs again when the story
+// resumes.
+func (m *Machine) RunContext(ctx context.Context) error {
+	done := ctx.Done()
 	for {
-		err := m.Step()
-		if err != nil {
+		select {
+		case <-done:
+			return &TerminationError{Reason: Canceled, Err: ctx.Err()}
+		default:
+		}
+		if err := m.Step(); err != nil {
+			if cerr := ctx.Err(); cerr != nil && errors.Is(err, cerr) {
+				return &TerminationError{Reason: Canceled, Err: cerr}
+			}
 			return err
 		}
 	}
-	panic("never reached")
 }
 
-// Load starts the machine with a story file in r.
-func (m *Machine) Load(r io.Reader) error {
-	newMemory, err := ioutil.ReadAll(r)
+// Load starts the machine with a story file in r.  The story may be a bare
+// Z-code image, a Blorb file, or a gzip-compressed version of either.  If opts
+// are given, they replace the machine's configuration; otherwise it is kept.
+func (m *Machine) Load(r io.Reader, opts ...Option) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		m.applyOptions(opts)
+	}
+	return m.load(data, false)
+}
+
+// restart starts the story in data again, as the restart opcode asks.  Unlike
+// Load, it keeps the transcript and fixed-pitch bits of Flags 2, which the
+// standard says survive a restart, so an open transcript stays open.
+func (m *Machine) restart(data []byte) error {
+	return m.load(data, true)
+}
+
+// load starts the machine with the story file in data, which it may keep.  If
+// keepFlags2 is true, the transcript and fixed-pitch bits of Flags 2 are
+// carried over from the story that was running.
+func (m *Machine) load(data []byte, keepFlags2 bool) error {
+	var keep byte
+	if keepFlags2 && len(m.memory) > int(flags2Game) {
+		keep = m.memory[flags2Game] & 0x03
+	}
+	newMemory, blorb, err := unwrapStory(data)
 	if err != nil {
 		return err
 	}
-	m.memory = newMemory
+	if !isBareStory(newMemory) {
+		return ErrUnknownFormat
+	}
+	if err := checkVersion(newMemory[0]); err != nil {
+		return err
+	}
+	m.image = newMemory
+	m.memory = append([]byte(nil), newMemory...)
+	m.blorb, m.metadata = blorb, nil
+	m.storyLength = len(m.memory)
+	if n := m.fileLength(); n != 0 && n < m.storyLength {
+		m.storyLength = n
+	}
+	m.warnedPadding = false
+	m.resetEventLog()
+	m.resetDirtyPages()
 	m.stack = make([]stackFrame, 1)
+	m.maxDepthSeen = 1
 	m.rtables = make([]rtable, 0, 16)
-	m.streams = 1<<screenOutput | 1<<transcriptOutput
+	m.streams = 1 << screenOutput
+	m.closeCommands()
+	m.resetStringCache()
 	m.seed()
-
-	// TODO: In version 6+, this is a routine, not a direct PC.
-	m.stack[0].PC = m.initialPC()
+	for i := range m.fonts {
+		m.fonts[i] = 1
+	}
+	m.columns = [8]int{}
+	m.steps = 0
+	m.undo = m.undo[:0]
+	m.lastParse = nil
+	m.textBuffer, m.parseBuffer = 0, 0
+	m.linear = linearScreen{}
+
+	if v := m.Version(); v == 6 || v == 7 {
+		// main is a routine, called with no arguments.  Its frame is the
+		// bottom of the stack, so returning from it ends the story.
+		if err := m.startMain(); err != nil {
+			return err
+		}
+	} else {
+		m.stack[0].PC = m.initialPC()
+	}
 
 	// Standard revision number
 	// XXX: Change to 0x0100 when compliant
 	m.storeWord(0x32, 0x0000)
 
+	if keepFlags2 {
+		m.memory[flags2Game] = m.memory[flags2Game]&^0x03 | keep
+	}
 	m.copyUIFlags()
+	m.flags2Changed()
 
 	return nil
 }
@@ -188,61 +658,514 @@ func (m *Machine) RestoreStack(r io.Reader) error {
 	return d.Decode(&m.stack)
 }
 
+// A savedState is the part of a machine's state that SaveState keeps.
+type savedState struct {
+	// Memory is the dynamic memory diff, as from dynamicDiff.
+	Memory  []byte
+	Stack   []stackFrame
+	Streams uint8
+	RTables []rtable
+}
+
+// SaveState encodes the dynamic memory, the stack, and the output streams to
+// w.
+func (m *Machine) SaveState(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(savedState{
+		Memory:  m.dynamicDiff(),
+		Stack:   m.stack,
+		Streams: m.streams,
+		RTables: m.rtables,
+	})
+}
+
+// RestoreState decodes a state saved by SaveState from r.  As with restore,
+// the transcript and fixed-pitch bits of Flags 2 are kept, so the transcript
+// stream stays as it is.
+func (m *Machine) RestoreState(r io.Reader) error {
+	var st savedState
+	if err := gob.NewDecoder(r).Decode(&st); err != nil {
+		return err
+	}
+	if err := m.checkState(&st); err != nil {
+		return err
+	}
+	if err := m.applyDynamicDiff(st.Memory); err != nil {
+		return err
+	}
+	m.stack = st.Stack
+	m.rtables = append(m.rtables[:0], st.RTables...)
+	m.streams = st.Streams
+	m.flags2Changed()
+	return nil
+}
+
+// checkState returns an error if st's stack or output redirection tables
+// can't be run by m, so that a corrupt or foreign state is rejected before
+// any of it is applied.
+func (m *Machine) checkState(st *savedState) error {
+	if len(st.Stack) == 0 {
+		return errors.New("Saved state has an empty call stack")
+	}
+	for i, f := range st.Stack {
+		if f.PC < 0 || int(f.PC) >= len(m.memory) {
+			return fmt.Errorf("Saved state frame %d: PC %v out of range", i, f.PC)
+		}
+		if f.Routine < 0 || int(f.Routine) >= len(m.memory) {
+			return fmt.Errorf("Saved state frame %d: routine %v out of range", i, f.Routine)
+		}
+		if len(f.Locals) > 15 {
+			return fmt.Errorf("Saved state frame %d has %d local variables", i, len(f.Locals))
+		}
+		if len(f.Stack) > MaxEvalStack {
+			return fmt.Errorf("Saved state frame %d has %d words on its stack", i, len(f.Stack))
+		}
+	}
+	if len(st.RTables) > cap(m.rtables) {
+		return fmt.Errorf("Saved state has %d output redirection levels", len(st.RTables))
+	}
+	if st.Streams&(1<<redirectOutput) != 0 && len(st.RTables) == 0 {
+		return errors.New("Saved state redirects output without a table")
+	}
+	for _, t := range st.RTables {
+		if t.Start < headerSize || t.Curr < t.Start+2 || t.Curr > m.staticMemoryBase() {
+			return fmt.Errorf("Saved state output redirection table at %v is not in dynamic memory", t.Start)
+		}
+	}
+	return nil
+}
+
+// Capabilities describes the features a machine told the story about in its
+// header.  A feature is only listed if the UI supports it and the story's
+// version has a way to say so.
+type Capabilities struct {
+	// StatusLine, SplitScreen, and VariablePitch are only used by
+	// versions 1-3.
+	StatusLine    bool
+	SplitScreen   bool
+	VariablePitch bool
+
+	// Styles means bold, italic, and fixed-pitch text (version 4 and up).
+	Styles bool
+	// Color is for version 5 and up.
+	Color bool
+	// Pictures is for version 6.
+	Pictures bool
+	// Sound is for version 4 and up.
+	Sound bool
+	// Mouse is for version 5 and up.
+	Mouse bool
+
+	// ScreenWidth and ScreenHeight are in characters.  A height of 255 means
+	// the screen scrolls without limit.
+	ScreenWidth  int
+	ScreenHeight int
+}
+
+// Capabilities returns the features the story was told about when it was
+// loaded or when the UI was last set.
+func (m *Machine) Capabilities() Capabilities {
+	return m.caps
+}
+
+// uiCapabilities returns the features of m's UI that a story of m's version
+// can use.
+func (m *Machine) uiCapabilities() Capabilities {
+	v := m.Version()
+	var c Capabilities
+	if ss, ok := m.ui.(ScreenSizer); ok {
+		c.setScreenSize(ss.ScreenSize())
+	} else {
+		c.setScreenSize(0, 0)
+	}
+	if v <= 3 {
+		_, c.StatusLine = m.ui.(StatusLiner)
+		_, c.SplitScreen = m.ui.(WindowSplitter)
+		if m.cfg.linear {
+			c.StatusLine, c.SplitScreen = true, true
+		}
+		if vp, ok := m.ui.(VariablePitcher); ok {
+			c.VariablePitch = vp.VariablePitch()
+		}
+		return c
+	}
+	_, c.Styles = m.ui.(Styler)
+	_, c.Sound = m.ui.(SoundPlayer)
+	if v >= 5 {
+		_, c.Color = m.ui.(Colorer)
+		_, c.Mouse = m.ui.(Pointer)
+	}
+	if v == 6 {
+		_, c.Pictures = m.ui.(Graphics)
+	}
+	return c
+}
+
+// setScreenSize sets the screen size the story is told about, using 80
+// columns for a width of zero or less and 255 lines for a height of zero or
+// less.
+func (c *Capabilities) setScreenSize(width, height int) {
+	c.ScreenWidth, c.ScreenHeight = 80, 255
+	if width > 0 {
+		c.ScreenWidth = clampScreen(width)
+	}
+	if height > 0 {
+		c.ScreenHeight = clampScreen(height)
+	}
+}
+
+// clampScreen limits a screen dimension to what fits in the header.
+func clampScreen(n int) int {
+	if n > 255 {
+		return 255
+	}
+	return n
+}
+
+// copyUIFlags sets the header bits that describe the UI's features and clears
+// the ones for features it lacks.
 func (m *Machine) copyUIFlags() {
+	const flags1 Address = 0x01
+
+	c := m.uiCapabilities()
+	m.caps = c
+	if m.Version() < 4 {
+		f1 := m.loadByte(flags1) & 0x8f
+		if !c.StatusLine {
+			f1 |= 1 << 4
+		}
+		if c.SplitScreen {
+			f1 |= 1 << 5
+		}
+		if c.VariablePitch {
+			f1 |= 1 << 6
+		}
+		m.storeByte(flags1, f1)
+		return
+	}
+
+	// Timed input (bit 7) isn't supported, so it stays clear.
+	f1 := m.loadByte(flags1) & 0x40
+	if c.Color {
+		f1 |= 1 << 0
+	}
+	if c.Pictures {
+		f1 |= 1 << 1
+	}
+	if c.Styles {
+		f1 |= 1<<2 | 1<<3 | 1<<4
+	}
+	if c.Sound && m.Version() == 6 {
+		f1 |= 1 << 5
+	}
+	m.storeByte(flags1, f1)
+	// The story sets the flags2 bits for the features it wants, and the
+	// interpreter may only clear the ones it can't provide.  The other bits
+	// belong to the game and the player.  A bit cleared for an earlier UI is
+	// offered again if the story as loaded asked for it.
+	const wantBits = 1<<3 | 1<<4 | 1<<5 | 1<<7
+	f2 := m.loadByte(flags2Game)
+	wants := (f2 | m.image[flags2Game]) & wantBits
+	f2 &^= wantBits
+	if c.Pictures {
+		f2 |= wants & (1 << 3)
+	}
+	if m.undoLevels() > 0 {
+		f2 |= wants & (1 << 4)
+	}
+	if c.Mouse {
+		f2 |= wants & (1 << 5)
+	}
+	if c.Sound {
+		f2 |= wants & (1 << 7)
+	}
+	m.storeByte(flags2Game, f2)
+	m.storeScreenSize()
+}
+
+// storeScreenSize writes the screen size from m.caps to the header.  The
+// header only has room for it in version 4 and up.
+func (m *Machine) storeScreenSize() {
 	const (
-		flags1       Address = 0x01
-		flags2       Address = 0x10
-		screenWidth  Address = 0x20
-		screenHeight Address = 0x21
+		screenHeight Address = 0x20
+		screenWidth  Address = 0x21
+		widthUnits   Address = 0x22
+		heightUnits  Address = 0x24
+		fontSize     Address = 0x26
 	)
 
 	if m.Version() < 4 {
-		m.memory[flags1] &= 0x8f
-		if _, ok := m.ui.(StatusLiner); !ok {
-			m.memory[flags1] |= 1 << 4
-		}
 		return
 	}
+	m.storeByte(screenHeight, byte(m.caps.ScreenHeight))
+	m.storeByte(screenWidth, byte(m.caps.ScreenWidth))
+	if m.Version() >= 5 {
+		// Screen units are characters.
+		m.storeWord(widthUnits, Word(m.caps.ScreenWidth))
+		m.storeWord(heightUnits, Word(m.caps.ScreenHeight))
+		m.storeByte(fontSize, 1)
+		m.storeByte(fontSize+1, 1)
+	}
+}
 
-	m.memory[flags1] &= 0x40
-	if _, ok := m.ui.(SoundPlayer); ok {
-		m.memory[flags1] |= 1 << 5
+// UpdateScreenSize tells the story that the screen is now width by height
+// characters.  Front-ends call it from their resize handlers.  As with
+// ScreenSizer, a width of zero or less means 80 columns and a height of zero
+// or less means the screen scrolls without limit.  If the UI is a
+// ScreenResizer, it is told the new size too, so that later output is laid out
+// for it.
+//
+// Version 6 stories also need to redraw the screen, so the redraw bit of
+// Flags 2 is set for them.  Other versions have no way to be told; they see
+// the new size the next time they read the header.
+func (m *Machine) UpdateScreenSize(width, height int) {
+	m.caps.setScreenSize(width, height)
+	m.storeScreenSize()
+	if m.Version() == 6 {
+		m.storeByte(flags2Game, m.loadByte(flags2Game)|1<<2)
 	}
-	m.memory[flags2] &= 0x47
-	if _, ok := m.ui.(SoundPlayer); ok {
-		m.memory[flags2] |= 1 << 7
+	if r, ok := m.ui.(ScreenResizer); ok {
+		r.ResizeScreen(width, height)
 	}
-	// TODO
-	m.storeByte(screenWidth, 255)
-	m.storeByte(screenHeight, 255)
 }
 
-// out handles output. This is sent to the UI, unless redirection has been
-// turned on.
+// Print writes s to the output streams as if the story had printed it, so it
+// is redirected to a table or copied to the transcript as the story's own
+// text would be.
+func (m *Machine) Print(s string) error {
+	return m.out(s)
+}
+
+// out handles output printed by the story.
 func (m *Machine) out(s string) error {
-	if m.streams&(1<<redirectOutput) != 0 {
-		// If redirect is selected, no other streams get output.
+	return m.send(s, routeOutput(m.streams, m.window))
+}
+
+// newLine ends the current line of output.  Opcodes that print a line break
+// of their own use it instead of adding "\n" to their text, so the break
+// reaches a NewLiner UI as a NewLine call and other UIs as a separate Output.
+func (m *Machine) newLine() error {
+	r := routeOutput(m.streams, m.window)
+	nl, ok := m.ui.(NewLiner)
+	if !ok || r&routeScreen == 0 || m.cfg.linear {
+		return m.send("\n", r)
+	}
+	if err := m.send("\n", r&routeTable); err != nil {
+		return err
+	}
+	m.trackColumn("\n")
+	if err := nl.NewLine(m.window); err != nil {
+		return err
+	}
+	return m.send("\n", r&routeTranscript)
+}
+
+// display sends input echoed by the machine to the screen and transcript
+// streams, if selected.  The screen is skipped if screen is false.  Input is
+// never echoed to a memory stream.
+func (m *Machine) display(s string, screen bool) error {
+	r := routeOutput(m.streams&^(1<<redirectOutput), m.window)
+	if !screen && r&routeScreen != 0 {
+		// The UI has shown the input itself.
+		m.trackColumn(s)
+		r &^= routeScreen
+	}
+	return m.send(s, r)
+}
+
+// A route is the set of places that a piece of output goes.
+type route uint8
+
+// Routes
+const (
+	routeScreen route = 1 << iota
+	routeTranscript
+	routeTable
+)
+
+// routeOutput returns where text printed in window goes while streams are
+// selected.  This is the only place that decides it:
+//
+//   - Stream 3 captures everything the story prints, in any window, and
+//     nothing else gets it while stream 3 is selected.
+//   - The screen gets text for every window.
+//   - The transcript only gets text for the lower window.
+//
+// The status line and any paging prompts are the UI's business and never go
+// through the streams.
+func routeOutput(streams uint8, window int) route {
+	if streams&(1<<redirectOutput) != 0 {
+		return routeTable
+	}
+	var r route
+	if streams&(1<<screenOutput) != 0 {
+		r |= routeScreen
+	}
+	if streams&(1<<transcriptOutput) != 0 && window == 0 {
+		r |= routeTranscript
+	}
+	return r
+}
+
+// send writes s to the places in r.
+func (m *Machine) send(s string, r route) error {
+	if r&routeTable != 0 {
 		tab := &m.rtables[len(m.rtables)-1]
-		m.storeWord(tab.Start, m.loadWord(tab.Start)+Word(len(s)))
+		n := utf8.RuneCountInString(s)
+		if tab.Start < headerSize || tab.Start+2 > tab.Curr || tab.Curr+Address(n) > m.staticMemoryBase() {
+			return fmt.Errorf("Output redirection table at %v overflows dynamic memory", tab.Start)
+		}
+		m.storeWord(tab.Start, m.loadWord(tab.Start)+Word(n))
 		for _, r := range s {
-			// rune should already be ZSCII-clean, since we wrote it.
-			m.storeByte(tab.Curr, byte(r))
+			c, ok := m.zsciiCode(r)
+			if !ok {
+				c = '?'
+			}
+			m.storeByte(tab.Curr, c)
 			tab.Curr++
 		}
-		return nil
 	}
-	if m.streams&(1<<screenOutput) != 0 {
-		if err := m.ui.Output(m.window, s); err != nil {
+	s = Sanitize(s)
+	if r&routeScreen != 0 {
+		m.trackColumn(s)
+		switch {
+		case m.cfg.linear && m.window == 1:
+			m.linear.write(s, m.caps.ScreenWidth)
+		case m.cfg.linear:
+			m.linear.track(s)
+			fallthrough
+		default:
+			if err := m.ui.Output(m.window, s); err != nil {
+				return err
+			}
+		}
+	}
+	if r&routeTranscript != 0 {
+		if err := m.writeTranscript(s); err != nil {
 			return err
 		}
 	}
-	// TODO: transcript, etc.
 	return nil
 }
 
+// Sanitize replaces control characters other than newline with '?', so that a
+// story can't send escape sequences to a terminal.  Tabs become spaces.  The
+// machine sanitizes everything it outputs; front-ends use Sanitize for text
+// from the story file they show themselves, like its Blorb metadata.
+func Sanitize(s string) string {
+	clean := true
+	for _, r := range s {
+		if r != '\n' && unicode.IsControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// Column returns the number of characters on the current line of window, as
+// far as the machine knows: output advances it, and new lines, set_cursor,
+// and erase_window reset it.  Windows past the ones a story can have share
+// window 0's column.
+func (m *Machine) Column(window int) int {
+	if window < 0 || window >= len(m.columns) {
+		window = 0
+	}
+	return m.columns[window]
+}
+
+// column returns the column of the current window.
+func (m *Machine) column() *int {
+	if m.window < 0 || m.window >= len(m.columns) {
+		return &m.columns[0]
+	}
+	return &m.columns[m.window]
+}
+
+// trackColumn advances the current window's column past s.
+func (m *Machine) trackColumn(s string) {
+	c := m.column()
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		*c, s = 0, s[i+1:]
+	}
+	*c += utf8.RuneCountInString(s)
+}
+
+// Flush closes any open memory output streams and flushes the UI's output
+// buffers.  The machine flushes before Step returns a Quit, Restart, or
+// EndOfMain TerminationError.
+func (m *Machine) Flush() error {
+	m.rtables = m.rtables[:0]
+	m.streams &^= 1 << redirectOutput
+	if f, ok := m.ui.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// terminate flushes the machine before returning a TerminationError, which
+// ends the story.
+func (m *Machine) terminate(reason StopReason) error {
+	if ferr := m.Flush(); ferr != nil {
+		return ferr
+	}
+	switch {
+	case reason == Quit && m.onQuit != nil:
+		m.onQuit()
+	case reason == Restart && m.onRestart != nil:
+		m.onRestart()
+	}
+	return &TerminationError{Reason: reason}
+}
+
+// flags2Game is the address of the low byte of flags2, which holds the bits
+// that the game may change.
+const flags2Game Address = 0x11
+
+// flags2Changed updates the output streams and the UI after the game writes
+// to the low byte of flags2.
+func (m *Machine) flags2Changed() {
+	f := m.memory[flags2Game]
+	if f&0x01 != 0 {
+		m.streams |= 1 << transcriptOutput
+	} else {
+		m.streams &^= 1 << transcriptOutput
+		if err := m.closeTranscript(); err != nil {
+			m.warn("Closing transcript: %v", err)
+		}
+	}
+	if fp, ok := m.ui.(FixedPitcher); ok {
+		fp.SetFixedPitch(f&0x02 != 0)
+	}
+}
+
+// setTranscript selects or deselects the transcript stream, keeping flags2
+// in sync.
+func (m *Machine) setTranscript(on bool) {
+	f := m.loadByte(flags2Game)
+	if on {
+		f |= 0x01
+	} else {
+		f &^= 0x01
+	}
+	m.storeByte(flags2Game, f)
+}
+
 func (m *Machine) refreshStatusLine() error {
 	liner, ok := m.ui.(StatusLiner)
-	if !ok {
+	if !ok && !m.cfg.linear {
 		return nil
 	}
 
@@ -251,6 +1174,7 @@ func (m *Machine) refreshStatusLine() error {
 	if err != nil {
 		return err
 	}
+	name = Sanitize(name)
 
 	var right string
 	if isTime {
@@ -269,12 +1193,80 @@ func (m *Machine) refreshStatusLine() error {
 		right = fmt.Sprintf("%3d/%4d", int16(m.getVariable(0x11)), int16(m.getVariable(0x12)))
 	}
 
+	if m.cfg.linear {
+		m.linearStatusLine(name, right, isTime)
+		return nil
+	}
 	return liner.StatusLine(name, right)
 }
 
-// PC returns the program counter.
+// PC returns the program counter, or zero if the stack is empty.
 func (m *Machine) PC() Address {
-	return m.currStackFrame().PC
+	f := m.currStackFrame()
+	if f == nil {
+		return 0
+	}
+	return f.PC
+}
+
+// Steps returns the number of instructions executed since the story was
+// loaded, which is what the InstructionLimit option limits.
+func (m *Machine) Steps() int64 {
+	return m.steps
+}
+
+// StackDepth returns the number of routine frames on the stack.  The main
+// routine's frame counts as one.
+func (m *Machine) StackDepth() int {
+	return len(m.stack)
+}
+
+// FramePCs returns the program counter of each frame on the stack, starting
+// with the main routine.  Frames other than the current one hold the address
+// their routine will resume at.
+func (m *Machine) FramePCs() []Address {
+	pcs := make([]Address, len(m.stack))
+	for i := range m.stack {
+		pcs[i] = m.stack[i].PC
+	}
+	return pcs
+}
+
+// FrameInfo is a copy of a routine frame on the stack.
+type FrameInfo struct {
+	// Routine is the address of the frame's routine.  It is 0 for the frame
+	// that starts the story in versions other than 6 and 7.
+	Routine Address
+
+	// PC is the frame's program counter.  Frames other than the current one
+	// hold the address their routine will resume at.
+	PC Address
+
+	Locals []Word
+	Stack  []Word
+	NArg   uint8
+
+	// If Store is true, the routine's result is stored in StoreVariable.
+	Store         bool
+	StoreVariable uint8
+}
+
+// Frames returns copies of the frames on the stack, starting with the main
+// routine.
+func (m *Machine) Frames() []FrameInfo {
+	frames := make([]FrameInfo, len(m.stack))
+	for i, f := range m.stack {
+		frames[i] = FrameInfo{
+			Routine:       f.Routine,
+			PC:            f.PC,
+			Locals:        append([]Word(nil), f.Locals...),
+			Stack:         append([]Word(nil), f.Stack...),
+			NArg:          f.NArg,
+			Store:         f.Store,
+			StoreVariable: f.StoreVariable,
+		}
+	}
+	return frames
 }
 
 // MemoryReader returns an io.Reader that starts reading at a.
@@ -288,17 +1280,39 @@ func (m *Machine) MemoryReader(a Address) (io.ReadSeeker, error) {
 
 // currStackFrame returns the current stack frame, or nil if the stack is empty.
 func (m *Machine) currStackFrame() *stackFrame {
+	if len(m.stack) == 0 {
+		return nil
+	}
 	return &m.stack[len(m.stack)-1]
 }
 
+// PrintVariables writes the current frame's variables to standard output.
 func (m *Machine) PrintVariables() {
-	fmt.Printf("PC:  %v\n", m.currStackFrame().PC)
-	for i, val := range m.currStackFrame().Locals {
-		fmt.Printf("$%02x: %v\n", i+1, val)
+	m.WriteVariables(os.Stdout)
+}
+
+// WriteVariables writes the PC, locals, and stack of the current frame to w,
+// one per line.
+func (m *Machine) WriteVariables(w io.Writer) error {
+	f := m.currStackFrame()
+	if f == nil {
+		_, err := fmt.Fprintln(w, "No stack frame")
+		return err
 	}
-	for i, val := range m.currStackFrame().Stack {
-		fmt.Printf("S%2d: %v\n", i, val)
+	if _, err := fmt.Fprintf(w, "PC:  %v\n", f.PC); err != nil {
+		return err
 	}
+	for i, val := range f.Locals {
+		if _, err := fmt.Fprintf(w, "%v: %v\n", VariableRef(i+1), val); err != nil {
+			return err
+		}
+	}
+	for i, val := range f.Stack {
+		if _, err := fmt.Fprintf(w, "S%2d: %v\n", i, val); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m *Machine) LoadWord(a Address) Word {
@@ -309,10 +1323,34 @@ func (m *Machine) LoadString(a Address) (string, error) {
 	return m.loadString(a, true)
 }
 
+// SetVariable changes variable v.  Setting the stack pushes val.  It is an
+// error to set a local that the current routine doesn't have.
+func (m *Machine) SetVariable(v uint8, val Word) error {
+	if v < 0x10 {
+		f := m.currStackFrame()
+		if f == nil {
+			return ErrNoFrame
+		}
+		if int(v) > len(f.Locals) {
+			return fmt.Errorf("Routine has no %v", VariableRef(v))
+		}
+	}
+	m.setVariable(v, val)
+	return nil
+}
+
 func (m *Machine) Variable(v uint8) Word {
 	if v == 0 {
 		return 0
 	}
+	if v < 0x10 {
+		f := m.currStackFrame()
+		if f == nil || int(v) > len(f.Locals) {
+			return 0
+		}
+	} else if int(m.globalAddress(v-0x10))+2 > len(m.memory) {
+		return 0
+	}
 	return m.getVariable(v)
 }
 
@@ -350,33 +1388,105 @@ func (m *Machine) setVariable(v uint8, val Word) {
 	}
 }
 
-// fetchOperands returns the values of the operands.
-func (m *Machine) fetchOperands(in instruction) []Word {
-	ops := make([]Word, in.NOperand())
+// checkVariable returns an error if variable v can't be read or written
+// without running off the current routine's locals or the end of memory.  The
+// stack is always valid here; popping it is checked where it happens.
+func (m *Machine) checkVariable(v uint8) error {
+	switch {
+	case v == 0:
+		return nil
+	case v < 0x10:
+		if f := m.currStackFrame(); f == nil || int(v) > len(f.Locals) {
+			return fmt.Errorf("Routine has no %v", VariableRef(v))
+		}
+		return nil
+	}
+	if a := m.globalAddress(v - 0x10); int(a)+2 > len(m.memory) {
+		return &MemoryError{Address: a, Size: 2}
+	}
+	return nil
+}
+
+// getIndirect returns the value of the variable named by an operand of an
+// opcode like inc or load.  Unlike getVariable, variable 0 reads the top of
+// the stack in place.
+func (m *Machine) getIndirect(v uint8) (Word, error) {
+	if v == 0 {
+		return m.currStackFrame().peekChecked()
+	}
+	if err := m.checkVariable(v); err != nil {
+		return 0, err
+	}
+	return m.getVariable(v), nil
+}
+
+// setIndirect changes the variable named by an operand of an opcode like inc
+// or store.  Unlike setVariable, variable 0 replaces the top of the stack.
+func (m *Machine) setIndirect(v uint8, val Word) error {
+	if v == 0 {
+		return m.currStackFrame().replaceChecked(val)
+	}
+	if err := m.checkVariable(v); err != nil {
+		return err
+	}
+	m.setVariable(v, val)
+	return nil
+}
+
+// fetchOperands returns the values of the operands.  The returned slice is
+// only valid until in is decoded again.  Reading an empty stack or a variable
+// that doesn't exist is an error.
+func (m *Machine) fetchOperands(in *decodedInst) ([]Word, error) {
+	ops := in.values[:in.nops]
 	for i := range ops {
 		val, optype := in.Operand(i)
 		switch optype {
 		case smallConstantOperand, largeConstantOperand:
 			ops[i] = val
 		case variableOperand:
-			ops[i] = m.getVariable(uint8(val))
+			v := uint8(val)
+			if v == 0 {
+				w, err := m.currStackFrame().popChecked()
+				if err != nil {
+					return nil, err
+				}
+				ops[i] = w
+				continue
+			}
+			if err := m.checkVariable(v); err != nil {
+				return nil, err
+			}
+			ops[i] = m.getVariable(v)
 		}
 	}
-	return ops
+	return ops, nil
+}
+
+// packedAddress returns the byte address of a packed routine address.  It
+// returns an error for versions without a known packing.
+func (m *Machine) packedAddress(p Word) (Address, error) {
+	return m.unpack(p, 0x28)
+}
+
+// packedStringAddress returns the byte address of a packed string address.
+func (m *Machine) packedStringAddress(p Word) (Address, error) {
+	return m.unpack(p, 0x2a)
 }
 
-// packedAddress returns the byte address of a packed address.
-func (m *Machine) packedAddress(p Word) Address {
+// unpack returns the byte address of p.  In versions 6 and 7, the header word
+// at offset gives the start of the routines or strings, in units of 8 bytes.
+func (m *Machine) unpack(p Word, offset Address) (Address, error) {
 	switch m.Version() {
 	case 1, 2, 3:
-		return 2 * Address(p)
+		return 2 * Address(p), nil
 	case 4, 5:
-		return 4 * Address(p)
-	// TODO: 6, 7
+		return 4 * Address(p), nil
+	case 6, 7:
+		return 4*Address(p) + 8*Address(m.loadWord(offset)), nil
 	case 8:
-		return 8 * Address(p)
+		return 8 * Address(p), nil
 	}
-	panic("Bad machine version for packed address!!")
+	return 0, fmt.Errorf("Packed addresses not supported in version %d", m.Version())
 }
 
 // Version returns the version of the machine, defined in the story file.
@@ -384,9 +1494,27 @@ func (m *Machine) Version() byte {
 	return m.loadByte(0)
 }
 
-// seed restarts the random generator with the current time as a seed.
+// SetRandSource makes the machine draw random numbers from src, for tests
+// and deterministic replays.  Unlike the machine's settings, it may be called
+// at any time.  The source is never reseeded: random 0 keeps drawing from it,
+// as do restarts.  A nil src goes back to the usual generator.
+func (m *Machine) SetRandSource(src rand.Source) {
+	m.randSource = src
+	m.seed()
+}
+
+// seed restarts the random generator with the current time as a seed, or with
+// the seed from the Seed option.  A source from SetRandSource is used as is.
 func (m *Machine) seed() {
-	m.rand = rand.New(rand.NewSource(time.Now().Unix()))
+	if m.randSource != nil {
+		m.rand = rand.New(m.randSource)
+		return
+	}
+	s := time.Now().Unix()
+	if m.cfg.seeded {
+		s = m.cfg.seed
+	}
+	m.rand = rand.New(rand.NewSource(s))
 }
 
 // random returns the next random number.
@@ -394,12 +1522,27 @@ func (m *Machine) random(s Word) Word {
 	return Word(m.rand.Uint32()%uint32(s) + 1)
 }
 
+// A MemoryError is returned when an instruction accesses memory past the end
+// of the story.
+type MemoryError struct {
+	Address Address
+	Size    int
+}
+
+func (e *MemoryError) Error() string {
+	return fmt.Sprintf("Access to %d bytes at %v is outside memory", e.Size, e.Address)
+}
+
 func (m *Machine) loadByte(a Address) byte {
 	return m.memory[a]
 }
 
 func (m *Machine) storeByte(a Address, b byte) {
 	m.memory[a] = b
+	m.pageGen[a>>dirtyPageShift] = m.gen
+	if a == flags2Game {
+		m.flags2Changed()
+	}
 }
 
 func (m *Machine) loadWord(a Address) Word {
@@ -409,28 +1552,137 @@ func (m *Machine) loadWord(a Address) Word {
 func (m *Machine) storeWord(a Address, w Word) {
 	m.memory[a] = byte(w >> 8)
 	m.memory[a+1] = byte(w & 0x00ff)
+	m.pageGen[a>>dirtyPageShift] = m.gen
+	m.pageGen[(a+1)>>dirtyPageShift] = m.gen
+	if a == flags2Game || a+1 == flags2Game {
+		m.flags2Changed()
+	}
 }
 
 // loadString decodes a ZSCII string at address addr.  See NewZSCIIDecoder for
 // the output parameter.
 func (m *Machine) loadString(addr Address, output bool) (string, error) {
-	r, err := m.MemoryReader(addr)
-	if err != nil {
+	if err := m.checkPadding(addr, "String"); err != nil {
 		return "", err
 	}
-	// TODO: alphabet set
-	return decodeString(r, StandardAlphabetSet, output, m)
+	decode := func() (string, error) {
+		return m.decodeString(addr, output, false)
+	}
+	if !output {
+		return decode()
+	}
+	return m.cachedString(stringCacheKey{Addr: addr}, decode)
 }
 
+// Unabbreviate returns entry of the story's abbreviation table, counting
+// from 0.
 func (m *Machine) Unabbreviate(entry int) (string, error) {
-	entryWord := m.loadWord(m.abbreviationTableAddress() + Address(entry)*2)
-	r, err := m.MemoryReader(Address(entryWord) * 2)
+	a := m.abbreviationTableAddress() + Address(entry)*2
+	if entry < 0 || int(a)+2 > len(m.memory) {
+		return "", &MemoryError{Address: a, Size: 2}
+	}
+	entryWord := m.loadWord(a)
+	addr := Address(entryWord) * 2
+	return m.cachedString(stringCacheKey{Addr: addr, Abbrev: true}, func() (string, error) {
+		// TODO: output?
+		return m.decodeString(addr, true, true)
+	})
+}
+
+// decodeString decodes the string at addr, which is an abbreviation if abbrev
+// is true.  Abbreviations can't use abbreviations themselves, so expansion
+// only goes one level deep.  A string longer than the MaxStringLength limit is
+// an error in strict mode; otherwise the machine warns and uses the text
+// decoded up to the limit.
+func (m *Machine) decodeString(addr Address, output, abbrev bool) (string, error) {
+	r, err := m.MemoryReader(addr)
 	if err != nil {
 		return "", err
 	}
+	var u Unabbreviater
+	if !abbrev {
+		u = m
+	}
+	limit := m.maxStringLength()
 	// TODO: alphabet set
-	// TODO: output?
-	return decodeString(r, StandardAlphabetSet, true, nil)
+	s, err := decodeStringMax(r, StandardAlphabetSet, output, u, limit)
+	if err != errStringTooLong {
+		return s, err
+	}
+	err = &StringTooLongError{Address: addr, Limit: limit, Abbrev: abbrev}
+	if m.cfg.strict {
+		return "", err
+	}
+	m.warn("%v; using the first %d", err, limit)
+	return s, nil
+}
+
+// Header holds the fields of a story file's header.
+type Header struct {
+	Version byte
+	Release Word
+	Serial  string
+	Flags1  byte
+	Flags2  Word
+
+	HighMemoryBase   Address
+	InitialPC        Address
+	Dictionary       Address
+	ObjectTable      Address
+	GlobalVariables  Address
+	StaticMemoryBase Address
+	Abbreviations    Address
+
+	// FileLength is the length of the story file in bytes, or zero if the
+	// header doesn't give one.
+	FileLength int
+	Checksum   Word
+}
+
+// Header returns a snapshot of the story's header.
+func (m *Machine) Header() Header {
+	return Header{
+		Version:          m.Version(),
+		Release:          m.release(),
+		Serial:           m.serial(),
+		Flags1:           m.loadByte(0x1),
+		Flags2:           m.loadWord(0x10),
+		HighMemoryBase:   m.highMemoryBase(),
+		InitialPC:        m.initialPC(),
+		Dictionary:       m.dictionaryAddress(),
+		ObjectTable:      m.objectTableAddress(),
+		GlobalVariables:  m.globalVariableTableAddress(),
+		StaticMemoryBase: m.staticMemoryBase(),
+		Abbreviations:    m.abbreviationTableAddress(),
+		FileLength:       m.fileLength(),
+		Checksum:         m.checksum(),
+	}
+}
+
+func (m *Machine) release() Word {
+	return m.loadWord(0x2)
+}
+
+func (m *Machine) serial() string {
+	return string(m.memory[0x12:0x18])
+}
+
+// startMain sets up the bottom stack frame to run the routine whose packed
+// address is in the header, as version 6 stories start.
+func (m *Machine) startMain() error {
+	a, err := m.packedAddress(m.loadWord(0x6))
+	if err != nil {
+		return err
+	}
+	if a >= Address(len(m.memory)) {
+		return fmt.Errorf("Main routine address %v out of range", a)
+	}
+	nlocals := int(m.loadByte(a))
+	if nlocals > 15 {
+		return errors.New("Routines have a maximum of 15 local variables")
+	}
+	m.stack[0] = stackFrame{Routine: a, PC: a + 1, Locals: make([]Word, nlocals)}
+	return nil
 }
 
 func (m *Machine) initialPC() Address {
@@ -460,3 +1712,31 @@ func (m *Machine) staticMemoryBase() Address {
 func (m *Machine) abbreviationTableAddress() Address {
 	return Address(m.loadWord(0x18))
 }
+
+func (m *Machine) fileLength() int {
+	n := int(m.loadWord(0x1a))
+	switch m.Version() {
+	case 1, 2, 3:
+		return 2 * n
+	case 4, 5:
+		return 4 * n
+	}
+	return 8 * n
+}
+
+// verify reports whether the story as loaded matches the header's checksum.
+func (m *Machine) verify() bool {
+	n := m.fileLength()
+	if n == 0 || n > len(m.image) {
+		n = len(m.image)
+	}
+	var sum Word
+	for _, b := range m.image[headerSize:n] {
+		sum += Word(b)
+	}
+	return sum == Word(m.image[0x1c])<<8|Word(m.image[0x1d])
+}
+
+func (m *Machine) checksum() Word {
+	return m.loadWord(0x1c)
+}
@@ -1,8 +1,13 @@
 package north
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
 )
 
 func TestFrameLocals(t *testing.T) {
@@ -103,4 +108,808 @@ func TestHeader(t *testing.T) {
 	if x := m.abbreviationTableAddress(); x != 0x01f0 {
 		t.Errorf("m.abbreviationTableAddress() != 0x01f0 (got %v)", x)
 	}
+
+	want := Header{
+		Version:          3,
+		Release:          88,
+		Serial:           "840726",
+		Flags1:           0x00,
+		Flags2:           0x0000,
+		HighMemoryBase:   0x4e37,
+		InitialPC:        0x4f05,
+		Dictionary:       0x3b21,
+		ObjectTable:      0x02b0,
+		GlobalVariables:  0x2271,
+		StaticMemoryBase: 0x2e53,
+		Abbreviations:    0x01f0,
+		FileLength:       0xa5c6 * 2,
+		Checksum:         0xa129,
+	}
+	if h := m.Header(); h != want {
+		t.Errorf("m.Header() != %+v (got %+v)", want, h)
+	}
+}
+
+// newTestMachine returns a machine with size bytes of zeroed memory for the
+// given story version.  Its static and high memory start halfway through.
+func newTestMachine(version byte, size int, opts ...Option) (*Machine, *bufferUI) {
+	mem := make([]byte, size)
+	mem[0] = version
+	mem[0x04], mem[0x05] = byte(size/2>>8), byte(size/2)
+	mem[0x0e], mem[0x0f] = byte(size/2>>8), byte(size/2)
+	ui := new(bufferUI)
+	m, err := NewMachine(bytes.NewReader(mem), ui, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return m, ui
+}
+
+func TestEmptyStack(t *testing.T) {
+	m, _ := newTestMachine(3, 0x200)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode([]stackFrame{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RestoreStack(&buf); err != nil {
+		t.Fatal("RestoreStack:", err)
+	}
+	if n := m.StackDepth(); n != 0 {
+		t.Fatalf("m.StackDepth() != 0 (got %d)", n)
+	}
+	if err := m.Step(); err != ErrNoFrame {
+		t.Errorf("m.Step() != ErrNoFrame (got %v)", err)
+	}
+	if pc := m.PC(); pc != 0 {
+		t.Errorf("m.PC() != 0 (got %v)", pc)
+	}
+	if v := m.Variable(1); v != 0 {
+		t.Errorf("m.Variable(1) != 0 (got %v)", v)
+	}
+	if pcs := m.FramePCs(); len(pcs) != 0 {
+		t.Errorf("m.FramePCs() != [] (got %v)", pcs)
+	}
+}
+
+func TestRestoreStateChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(st *savedState)
+	}{
+		{"empty stack", func(st *savedState) { st.Stack = nil }},
+		{"PC past memory", func(st *savedState) { st.Stack[0].PC = 0x200 }},
+		{"negative PC", func(st *savedState) { st.Stack[0].PC = -1 }},
+		{"routine past memory", func(st *savedState) { st.Stack[0].Routine = 0x10000 }},
+		{"16 locals", func(st *savedState) { st.Stack[0].Locals = make([]Word, 16) }},
+		{"full eval stack", func(st *savedState) { st.Stack[0].Stack = make([]Word, MaxEvalStack+1) }},
+		{"redirect without table", func(st *savedState) { st.Streams |= 1 << redirectOutput }},
+		{"table in header", func(st *savedState) { st.RTables = []rtable{{0x10, 0x12}} }},
+		{"table in static memory", func(st *savedState) { st.RTables = []rtable{{0x100, 0x102}} }},
+		{"table end before start", func(st *savedState) { st.RTables = []rtable{{0x80, 0x40}} }},
+		{"too many tables", func(st *savedState) { st.RTables = make([]rtable, 17) }},
+	}
+	for _, test := range tests {
+		m, _ := newTestMachine(5, 0x200)
+		m.memory[0x80] = 42
+		st := savedState{
+			Memory:  m.dynamicDiff(),
+			Stack:   copyStack(m.stack),
+			Streams: m.streams,
+		}
+		test.modify(&st)
+		m.memory[0x80] = 0
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.RestoreState(&buf); err == nil {
+			t.Errorf("%s: RestoreState succeeded", test.name)
+		}
+		if m.memory[0x80] != 0 || len(m.stack) != 1 {
+			t.Errorf("%s: RestoreState changed the machine", test.name)
+		}
+	}
+}
+
+func TestPop(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("push", zasm.Const(1))
+	b.Instr("push", zasm.Const(2))
+	b.Instr("pop")
+	b.Instr("pop")
+	b.Instr("pop")
+	m := buildMachine(t, b, new(bufferUI))
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, []Word{1}) {
+		t.Errorf("stack after pop = %v; want [1]", s)
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("second pop:", err)
+	}
+	pc := m.PC()
+	if err := m.Step(); err == nil {
+		t.Error("pop on empty stack succeeded")
+	}
+	if m.PC() != pc {
+		t.Errorf("PC after failed pop = %v; want %v", m.PC(), pc)
+	}
+}
+
+func TestRetPopped(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("call_vs", zasm.Routine("sub"), zasm.Store(zasm.Global(0)))
+	b.Instr("ret_popped")
+	b.Routine("sub", 0)
+	b.Instr("push", zasm.Const(42))
+	b.Instr("ret_popped")
+	m := buildMachine(t, b, new(bufferUI))
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if g := m.Variable(0x10); g != 42 {
+		t.Errorf("ret_popped result = %d; want 42", g)
+	}
+	if err := m.Step(); err == nil || errors.Is(err, ErrReturnFromMain) {
+		t.Errorf("ret_popped on empty stack = %v; want underflow", err)
+	}
+	if n := m.StackDepth(); n != 1 {
+		t.Errorf("m.StackDepth() after failed ret_popped = %d; want 1", n)
+	}
+}
+
+func TestCatch(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("catch", zasm.Store(zasm.Global(0)))
+	b.Instr("call_1n", zasm.Routine("sub"))
+	b.Instr("quit")
+	b.Routine("sub", 0)
+	b.Instr("catch", zasm.Store(zasm.Global(1)))
+	b.Instr("rtrue")
+	m := buildMachine(t, b, new(bufferUI))
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if g0, g1 := m.Variable(0x10), m.Variable(0x11); g0 != 1 || g1 != 2 {
+		t.Errorf("catch in main = %d, in sub = %d; want 1 and 2", g0, g1)
+	}
+}
+
+func TestReturnFromMain(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("call_vs", zasm.Routine("sub"), zasm.Store(zasm.SP))
+	b.Instr("rtrue")
+	b.Routine("sub", 0)
+	b.Instr("rfalse")
+	m := buildMachine(t, b, new(bufferUI))
+	start := m.PC()
+
+	if err := m.Step(); err != nil {
+		t.Fatal("call_vs:", err)
+	}
+	pcs := m.FramePCs()
+	if len(pcs) != 2 || pcs[0] != start+5 || pcs[1] != m.PC() {
+		t.Errorf("m.FramePCs() != [%v %v] (got %v)", start+5, m.PC(), pcs)
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("rfalse:", err)
+	}
+	if err := m.Step(); !errors.Is(err, ErrReturnFromMain) {
+		t.Errorf("m.Step() != ErrReturnFromMain (got %v)", err)
+	}
+	if n := m.StackDepth(); n != 1 {
+		t.Errorf("m.StackDepth() != 1 (got %d)", n)
+	}
+}
+
+// splitUI is a bufferUI that can split the screen.
+type splitUI struct {
+	bufferUI
+	lines    int
+	variable bool
+}
+
+func (ui *splitUI) SplitWindow(lines int) error {
+	ui.lines = lines
+	return nil
+}
+
+func (ui *splitUI) VariablePitch() bool {
+	return ui.variable
+}
+
+func TestFlags1Version3(t *testing.T) {
+	tests := []struct {
+		UI    UI
+		Flags byte
+	}{
+		{new(bufferUI), 0x10},
+		{new(splitUI), 0x30},
+		{&splitUI{variable: true}, 0x70},
+	}
+	for _, tt := range tests {
+		b := zasm.New(3)
+		b.Routine("main", 0)
+		b.Instr("split_window", zasm.Const(2))
+		b.Instr("quit")
+		m := buildMachine(t, b, tt.UI)
+		if f := m.loadByte(0x01) & 0x70; f != tt.Flags {
+			t.Errorf("%T: flags1 & 0x70 != %#02x (got %#02x)", tt.UI, tt.Flags, f)
+		}
+		if err := m.Step(); err != nil {
+			t.Errorf("%T: split_window: %v", tt.UI, err)
+		}
+		if ui, ok := tt.UI.(*splitUI); ok && ui.lines != 2 {
+			t.Errorf("%T: split lines != 2 (got %d)", tt.UI, ui.lines)
+		}
+	}
+}
+
+// featureUI is a splitUI with every feature that the header can advertise.
+type featureUI struct {
+	splitUI
+}
+
+func (ui *featureUI) StatusLine(left, right string) error               { return nil }
+func (ui *featureUI) SetTextStyle(style Word) error                     { return nil }
+func (ui *featureUI) SetColor(fg, bg Word) error                        { return nil }
+func (ui *featureUI) SetFont(n Word) bool                               { return n == 1 || n == 4 }
+func (ui *featureUI) MouseState() (x, y int, buttons Word)              { return 0, 0, 0 }
+func (ui *featureUI) ScreenSize() (width, height int)                   { return 60, 20 }
+func (ui *featureUI) PrepareSound(n int) error                          { return nil }
+func (ui *featureUI) PlaySound(n int, volume int8, repeats uint8) error { return nil }
+func (ui *featureUI) StopSound(n int) error                             { return nil }
+func (ui *featureUI) FinishSound(n int) error                           { return nil }
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		Version byte
+		UI      UI
+		Caps    Capabilities
+		Flags1  byte
+		Flags2  byte
+		Screen  [2]byte
+	}{
+		{
+			Version: 3,
+			UI:      new(bufferUI),
+			Caps:    Capabilities{ScreenWidth: 80, ScreenHeight: 255},
+			Flags1:  0x10,
+		},
+		{
+			Version: 3,
+			UI:      new(splitUI),
+			Caps:    Capabilities{SplitScreen: true, ScreenWidth: 80, ScreenHeight: 255},
+			Flags1:  0x30,
+		},
+		{
+			Version: 3,
+			UI:      &featureUI{splitUI{variable: true}},
+			Caps:    Capabilities{StatusLine: true, SplitScreen: true, VariablePitch: true, ScreenWidth: 60, ScreenHeight: 20},
+			Flags1:  0x60,
+		},
+		{
+			Version: 4,
+			UI:      new(bufferUI),
+			Caps:    Capabilities{ScreenWidth: 80, ScreenHeight: 255},
+			Flags1:  0x00,
+			Screen:  [2]byte{255, 80},
+		},
+		{
+			Version: 4,
+			UI:      new(featureUI),
+			Caps:    Capabilities{Styles: true, Sound: true, ScreenWidth: 60, ScreenHeight: 20},
+			Flags1:  0x1c,
+			Flags2:  0x80,
+			Screen:  [2]byte{20, 60},
+		},
+		{
+			Version: 5,
+			UI:      new(bufferUI),
+			Caps:    Capabilities{ScreenWidth: 80, ScreenHeight: 255},
+			Flags1:  0x00,
+			Screen:  [2]byte{255, 80},
+		},
+		{
+			Version: 5,
+			UI:      new(pointerUI),
+			Caps:    Capabilities{Mouse: true, ScreenWidth: 80, ScreenHeight: 255},
+			Flags1:  0x00,
+			Flags2:  0x20,
+			Screen:  [2]byte{255, 80},
+		},
+		{
+			Version: 5,
+			UI:      new(featureUI),
+			Caps:    Capabilities{Styles: true, Color: true, Sound: true, Mouse: true, ScreenWidth: 60, ScreenHeight: 20},
+			Flags1:  0x1d,
+			Flags2:  0xa0,
+			Screen:  [2]byte{20, 60},
+		},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(tt.Version, 0x200)
+		// The story asks for the mouse and sound and claims timed input.
+		m.storeByte(0x01, 0xff)
+		m.storeByte(0x11, 0xa0)
+		m.ui = tt.UI
+		m.copyUIFlags()
+		if c := m.Capabilities(); c != tt.Caps {
+			t.Errorf("v%d %T: Capabilities() = %+v; want %+v", tt.Version, tt.UI, c, tt.Caps)
+		}
+		mask := byte(0x70)
+		if tt.Version >= 4 {
+			mask = 0xbf
+		}
+		if f := m.loadByte(0x01) & mask; f != tt.Flags1 {
+			t.Errorf("v%d %T: flags1 & %#02x != %#02x (got %#02x)", tt.Version, tt.UI, mask, tt.Flags1, f)
+		}
+		if tt.Version < 4 {
+			continue
+		}
+		if f := m.loadByte(0x11) & 0xa8; f != tt.Flags2 {
+			t.Errorf("v%d %T: flags2 & 0xa8 != %#02x (got %#02x)", tt.Version, tt.UI, tt.Flags2, f)
+		}
+		if h, w := m.loadByte(0x20), m.loadByte(0x21); h != tt.Screen[0] || w != tt.Screen[1] {
+			t.Errorf("v%d %T: screen = %dx%d; want %dx%d", tt.Version, tt.UI, w, h, tt.Screen[1], tt.Screen[0])
+		}
+		if tt.Version >= 5 {
+			w, h := m.loadWord(0x22), m.loadWord(0x24)
+			if int(w) != tt.Caps.ScreenWidth || int(h) != tt.Caps.ScreenHeight {
+				t.Errorf("v%d %T: screen units = %dx%d; want %dx%d", tt.Version, tt.UI, w, h, tt.Caps.ScreenWidth, tt.Caps.ScreenHeight)
+			}
+		}
+	}
+}
+
+func TestSetUIMidGame(t *testing.T) {
+	tests := []struct {
+		Version        byte
+		Mask           byte
+		Before, After  byte // flags1 & Mask
+		Screen         [2]byte
+		RedrawAfterSet bool
+	}{
+		{3, 0x70, 0x10, 0x60, [2]byte{0, 0}, false},
+		{5, 0xbf, 0x00, 0x1d, [2]byte{20, 60}, false},
+		{6, 0xbf, 0x00, 0x3d, [2]byte{20, 60}, true},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		b.Instr("quit")
+		m := buildMachine(t, b, new(bufferUI))
+		if f := m.loadByte(0x01) & tt.Mask; f != tt.Before {
+			t.Errorf("v%d: flags1 & %#02x with bufferUI = %#02x; want %#02x", tt.Version, tt.Mask, f, tt.Before)
+		}
+
+		m.SetUI(&featureUI{splitUI{variable: true}})
+		if f := m.loadByte(0x01) & tt.Mask; f != tt.After {
+			t.Errorf("v%d: flags1 & %#02x after SetUI = %#02x; want %#02x", tt.Version, tt.Mask, f, tt.After)
+		}
+		if c := m.Capabilities(); c.ScreenWidth != 60 || c.ScreenHeight != 20 {
+			t.Errorf("v%d: screen after SetUI = %dx%d; want 60x20", tt.Version, c.ScreenWidth, c.ScreenHeight)
+		}
+		if tt.Version >= 4 {
+			if h, w := m.loadByte(0x20), m.loadByte(0x21); h != tt.Screen[0] || w != tt.Screen[1] {
+				t.Errorf("v%d: header screen after SetUI = %dx%d; want %dx%d", tt.Version, w, h, tt.Screen[1], tt.Screen[0])
+			}
+		}
+		if redraw := m.loadByte(0x11)&0x04 != 0; redraw != tt.RedrawAfterSet {
+			t.Errorf("v%d: redraw bit after SetUI = %t; want %t", tt.Version, redraw, tt.RedrawAfterSet)
+		}
+
+		m.SetUI(new(bufferUI))
+		if f := m.loadByte(0x01) & tt.Mask; f != tt.Before {
+			t.Errorf("v%d: flags1 & %#02x after SetUI back = %#02x; want %#02x", tt.Version, tt.Mask, f, tt.Before)
+		}
+	}
+}
+
+func TestSetUIFont(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	m := buildMachine(t, b, new(featureUI))
+	if prev := m.setFont(4); prev != 1 {
+		t.Fatalf("set_font 4 = %d; want 1", prev)
+	}
+	ui := new(fontUI)
+	m.SetUI(ui)
+	if want := []Word{4}; !reflect.DeepEqual(ui.calls, want) {
+		t.Errorf("SetFont calls after SetUI = %v; want %v", ui.calls, want)
+	}
+	m.SetUI(new(bufferUI))
+	if f := m.setFont(0); f != 1 {
+		t.Errorf("set_font 0 after SetUI to a UI without fonts = %d; want 1", f)
+	}
+}
+
+// warnUI is a bufferUI that records warnings.
+type warnUI struct {
+	bufferUI
+	warnings []string
+}
+
+func (ui *warnUI) Warn(msg string) {
+	ui.warnings = append(ui.warnings, msg)
+}
+
+func TestPadding(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	// Pad with quit instructions.
+	end := Address(len(img))
+	img = append(img, 0xba, 0xba, 0xba, 0xba)
+
+	for _, strict := range []bool{false, true} {
+		ui := new(warnUI)
+		m, err := NewMachine(bytes.NewReader(img), ui, Strict(strict))
+		if err != nil {
+			t.Fatal("load story:", err)
+		}
+		m.currStackFrame().PC = end
+		err = m.Step()
+		if strict {
+			if err == nil || errors.Is(err, ErrQuit) {
+				t.Errorf("strict: Step() in padding = %v; want error", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrQuit) {
+			t.Errorf("lenient: Step() in padding = %v; want quit", err)
+		}
+		if len(ui.warnings) != 1 {
+			t.Errorf("lenient: warnings = %q; want 1", ui.warnings)
+		}
+		if _, err := m.loadString(end, true); err != nil {
+			t.Errorf("lenient: loadString in padding: %v", err)
+		}
+		if len(ui.warnings) != 1 {
+			t.Errorf("lenient: warned again (%q)", ui.warnings)
+		}
+	}
+}
+
+func TestTruncatedStory(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	b.Instr("quit")
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	// Drop the last byte, but keep the declared length.
+	img = img[:len(img)-1]
+	m, err := NewMachine(bytes.NewReader(img), new(bufferUI))
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	if n, h := m.ImageSize(), m.Header(); n != len(img) || n >= h.FileLength {
+		t.Errorf("ImageSize() = %d, FileLength = %d; want %d < FileLength", n, h.FileLength, len(img))
+	}
+	if err := m.Step(); !errors.Is(err, ErrQuit) {
+		t.Errorf("Step() = %v; want quit", err)
+	}
+}
+
+func TestLoadKeepsImage(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("verify", zasm.IfFalse(zasm.ReturnFalse))
+	b.Instr("quit")
+	b.SetGlobal(0, 0x1234)
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := NewMachine(bytes.NewReader(img), new(bufferUI))
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	orig := append([]byte(nil), m.image...)
+	m.setVariable(0x10, 0x5678)
+	m.storeBytes(0x40, []byte{1, 2, 3})
+	if !bytes.Equal(m.image, orig) {
+		t.Error("image changed after writing to memory")
+	}
+	if !bytes.Equal(m.image, img) {
+		t.Error("image differs from the story file")
+	}
+	if m.Variable(0x10) != 0x5678 {
+		t.Errorf("global 0 != 0x5678 (got %v)", m.Variable(0x10))
+	}
+
+	// verify checks the image, not the working memory.
+	if err := m.Step(); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := m.Step(); !errors.Is(err, ErrQuit) {
+		t.Errorf("after verify, Step() = %v; want quit", err)
+	}
+
+	// A corrupted story fails verification.
+	img[len(img)-2]++
+	m, err = NewMachine(bytes.NewReader(img), new(bufferUI))
+	if err != nil {
+		t.Fatal("load corrupted story:", err)
+	}
+	if err := m.Step(); !errors.Is(err, ErrReturnFromMain) {
+		t.Errorf("verify of corrupted story: Step() = %v; want return from main", err)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("call_vs", zasm.Routine("a"), zasm.Const(7), zasm.Store(zasm.Global(0)))
+	b.Instr("quit")
+	b.Routine("a", 2)
+	b.Instr("push", zasm.Const(99))
+	b.Instr("call_vn", zasm.Routine("b"), zasm.Const(1), zasm.Const(2))
+	b.Instr("rtrue")
+	b.Routine("b", 3)
+	b.Instr("rtrue")
+	m := buildMachine(t, b, new(bufferUI))
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	frames := m.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("len(m.Frames()) != 3 (got %d)", len(frames))
+	}
+	tests := []struct {
+		Locals []Word
+		Stack  []Word
+		NArg   uint8
+		Store  bool
+	}{
+		{nil, nil, 0, false},
+		{[]Word{7, 0}, []Word{99}, 1, true},
+		{[]Word{1, 2, 0}, nil, 2, false},
+	}
+	for i, tt := range tests {
+		f := frames[i]
+		if !reflect.DeepEqual(f.Locals, tt.Locals) {
+			t.Errorf("frame %d locals != %v (got %v)", i, tt.Locals, f.Locals)
+		}
+		if !reflect.DeepEqual(f.Stack, tt.Stack) {
+			t.Errorf("frame %d stack != %v (got %v)", i, tt.Stack, f.Stack)
+		}
+		if f.NArg != tt.NArg {
+			t.Errorf("frame %d NArg != %d (got %d)", i, tt.NArg, f.NArg)
+		}
+		if f.Store != tt.Store {
+			t.Errorf("frame %d Store != %t (got %t)", i, tt.Store, f.Store)
+		}
+	}
+	if frames[1].StoreVariable != 0x10 {
+		t.Errorf("frame 1 StoreVariable != 0x10 (got %#x)", frames[1].StoreVariable)
+	}
+	if frames[2].PC != m.PC() {
+		t.Errorf("frame 2 PC != %v (got %v)", m.PC(), frames[2].PC)
+	}
+
+	// Changing the copies doesn't change the machine.
+	frames[1].Locals[0] = 42
+	frames[1].Stack[0] = 42
+	if f := m.Frames()[1]; f.Locals[0] != 7 || f.Stack[0] != 99 {
+		t.Errorf("machine frame changed through Frames(): locals %v, stack %v", f.Locals, f.Stack)
+	}
+}
+
+func TestGraphicsAndMouseFlags(t *testing.T) {
+	tests := []struct {
+		Version byte
+		UI      UI
+		Flags1  byte
+		Flags2  byte
+	}{
+		{6, new(bufferUI), 0x00, 0x00},
+		{6, new(graphicsUI), 0x02, 0x08},
+		{5, new(graphicsUI), 0x00, 0x00},
+		{5, new(pointerUI), 0x00, 0x20},
+		{6, new(pointerUI), 0x00, 0x20},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x200)
+		m.ui = tt.UI
+		// The story asks for pictures and the mouse.
+		m.storeByte(0x00, tt.Version)
+		m.storeByte(0x11, 0x28)
+		m.copyUIFlags()
+		if f := m.loadByte(0x01) & 0x02; f != tt.Flags1 {
+			t.Errorf("v%d %T: flags1 & 0x02 != %#02x (got %#02x)", tt.Version, tt.UI, tt.Flags1, f)
+		}
+		if f := m.loadByte(0x11) & 0x28; f != tt.Flags2 {
+			t.Errorf("v%d %T: flags2 & 0x28 != %#02x (got %#02x)", tt.Version, tt.UI, tt.Flags2, f)
+		}
+	}
+}
+
+func TestVersion6Main(t *testing.T) {
+	b := zasm.New(6)
+	b.Routine("main", 2)
+	b.Instr("check_arg_count", zasm.Const(1), zasm.IfTrue("bad"))
+	b.Instr("check_arg_count", zasm.Const(0), zasm.IfFalse("bad"))
+	b.Instr("store", zasm.Const(1), zasm.Const(42))
+	b.Instr("call_vs", zasm.Routine("sub"), zasm.Const(7), zasm.Store(zasm.Global(0)))
+	b.Instr("ret", zasm.Local(1))
+	b.Label("bad")
+	b.Instr("quit")
+	b.Routine("sub", 1)
+	b.Instr("ret", zasm.Local(1))
+	m := buildMachine(t, b, new(bufferUI))
+	if f := m.Frames(); len(f) != 1 || f[0].NArg != 0 || len(f[0].Locals) != 2 || f[0].Store {
+		t.Fatalf("starting frames = %+v; want one frame for main with 2 locals and no arguments", f)
+	}
+	err := m.Run()
+	if !errors.Is(err, ErrReturnFromMain) {
+		t.Errorf("Run = %v; want ErrReturnFromMain", err)
+	}
+	if v := m.Variable(0x10); v != 7 {
+		t.Errorf("call_vs result != 7 (got %d)", v)
+	}
+}
+
+func TestUnpackVersion6(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	m.memory[0] = 6
+	m.storeWord(0x28, 0x10)
+	m.storeWord(0x2a, 0x20)
+	if a, err := m.packedAddress(0x40); err != nil || a != 0x180 {
+		t.Errorf("packedAddress(0x40) = %v, %v; want 0x180, <nil>", a, err)
+	}
+	if a, err := m.packedStringAddress(0x40); err != nil || a != 0x200 {
+		t.Errorf("packedStringAddress(0x40) = %v, %v; want 0x200, <nil>", a, err)
+	}
+}
+
+// outputsUI is a scriptUI that records each Output call and status line.
+type outputsUI struct {
+	scriptUI
+	outputs []string
+}
+
+func (ui *outputsUI) Output(window int, text string) error {
+	ui.outputs = append(ui.outputs, text)
+	return ui.scriptUI.Output(window, text)
+}
+
+func (ui *outputsUI) StatusLine(left, right string) error {
+	ui.outputs = append(ui.outputs, "<status "+left+">")
+	return nil
+}
+
+// newLinerUI is an outputsUI that records NewLine calls.
+type newLinerUI struct {
+	outputsUI
+}
+
+func (ui *newLinerUI) NewLine(window int) error {
+	ui.outputs = append(ui.outputs, "<new line>")
+	return ui.scriptUI.Output(window, "\n")
+}
+
+// TestNewLines pins the line breaks that the print opcodes produce, and the
+// Output calls that carry them.
+func TestNewLines(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Story   func(b *zasm.Builder)
+		Screen  string
+		Outputs []string
+		// NewLines is Outputs for a NewLiner UI.
+		NewLines []string
+	}{
+		{
+			"print_ret",
+			func(b *zasm.Builder) {
+				b.Instr("call_vs", zasm.Routine("sub"), zasm.Store(zasm.SP))
+				b.Instr("print", zasm.Text("b"))
+				b.Instr("quit")
+				b.Routine("sub", 0)
+				b.Instr("print_ret", zasm.Text("a"))
+			},
+			"a\nb",
+			[]string{"a", "\n", "b"},
+			[]string{"a", "<new line>", "b"},
+		},
+		{
+			"print and new_line",
+			func(b *zasm.Builder) {
+				b.Instr("print", zasm.Text("a"))
+				b.Instr("new_line")
+				b.Instr("new_line")
+				b.Instr("print", zasm.Text("b"))
+				b.Instr("quit")
+			},
+			"a\n\nb",
+			[]string{"a", "\n", "\n", "b"},
+			[]string{"a", "<new line>", "<new line>", "b"},
+		},
+		{
+			"print with newline then read",
+			func(b *zasm.Builder) {
+				b.Instr("print", zasm.Text("a\n>"))
+				b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+				b.Instr("print", zasm.Text("b"))
+				b.Instr("quit")
+			},
+			"a\n>look\nb",
+			[]string{"a\n>", "<status room>", "look\n", "b"},
+			[]string{"a\n>", "<status room>", "look\n", "b"},
+		},
+		{
+			"show_status",
+			func(b *zasm.Builder) {
+				b.Instr("print", zasm.Text("a"))
+				b.Instr("show_status")
+				b.Instr("print", zasm.Text("b"))
+				b.Instr("quit")
+			},
+			"ab",
+			[]string{"a", "<status room>", "b"},
+			[]string{"a", "<status room>", "b"},
+		},
+	}
+	for _, tt := range tests {
+		b := zasm.New(3)
+		b.Routine("main", 0)
+		tt.Story(b)
+		text := make([]byte, 20)
+		text[0] = 18
+		b.Data("text", text)
+		parse := make([]byte, 2+4*4)
+		parse[0] = 4
+		b.Data("parse", parse)
+		b.Object("room", "", nil)
+		b.SetGlobal(0, 1)
+		ui := &outputsUI{scriptUI: scriptUI{Line: "look"}}
+		m := buildMachine(t, b, ui)
+		if err := m.Run(); !errors.Is(err, ErrQuit) {
+			t.Errorf("%s: Run = %v", tt.Name, err)
+			continue
+		}
+		if s := ui.String(); s != tt.Screen {
+			t.Errorf("%s: screen = %q; want %q", tt.Name, s, tt.Screen)
+		}
+		if !reflect.DeepEqual(ui.outputs, tt.Outputs) {
+			t.Errorf("%s: outputs = %q; want %q", tt.Name, ui.outputs, tt.Outputs)
+		}
+
+		nl := &newLinerUI{outputsUI{scriptUI: scriptUI{Line: "look"}}}
+		m = buildMachine(t, b, nl)
+		if err := m.Run(); !errors.Is(err, ErrQuit) {
+			t.Errorf("%s with NewLiner: Run = %v", tt.Name, err)
+			continue
+		}
+		if s := nl.String(); s != tt.Screen {
+			t.Errorf("%s with NewLiner: screen = %q; want %q", tt.Name, s, tt.Screen)
+		}
+		if !reflect.DeepEqual(nl.outputs, tt.NewLines) {
+			t.Errorf("%s with NewLiner: outputs = %q; want %q", tt.Name, nl.outputs, tt.NewLines)
+		}
+	}
 }
@@ -0,0 +1,184 @@
+package north
+
+import (
+	"errors"
+	"fmt"
+)
+
+// diffMemory returns the run-length-encoded XOR of mem against orig, as used
+// by Quetzal's CMem chunk.  A zero byte is followed by the number of further
+// zero bytes in the run (at most 255).  Trailing zeros are omitted.  mem and
+// orig must be the same length.
+func diffMemory(orig, mem []byte) []byte {
+	var w diffWriter
+	for i := range mem {
+		w.add(mem[i] ^ orig[i])
+	}
+	return w.diff
+}
+
+// A diffWriter builds a diff in the form returned by diffMemory, one XORed
+// byte at a time.
+type diffWriter struct {
+	diff  []byte
+	zeros int
+}
+
+// add appends the XOR x of the next byte.
+func (w *diffWriter) add(x byte) {
+	if x == 0 {
+		w.zeros++
+		return
+	}
+	for ; w.zeros > 0; w.zeros -= 256 {
+		n := w.zeros
+		if n > 256 {
+			n = 256
+		}
+		w.diff = append(w.diff, 0, byte(n-1))
+	}
+	w.zeros = 0
+	w.diff = append(w.diff, x)
+}
+
+// applyDiff returns a copy of orig with diff, as returned by diffMemory,
+// applied.
+func applyDiff(orig, diff []byte) ([]byte, error) {
+	mem := append([]byte(nil), orig...)
+	i := 0
+	for j := 0; j < len(diff); j++ {
+		if diff[j] == 0 {
+			if j+1 >= len(diff) {
+				return nil, errors.New("memory diff ends in the middle of a run")
+			}
+			j++
+			i += int(diff[j]) + 1
+			continue
+		}
+		if i >= len(mem) {
+			return nil, fmt.Errorf("memory diff runs past %d bytes", len(mem))
+		}
+		mem[i] ^= diff[j]
+		i++
+	}
+	if i > len(mem) {
+		return nil, fmt.Errorf("memory diff runs past %d bytes", len(mem))
+	}
+	return mem, nil
+}
+
+// DynamicMemorySnapshot returns a copy of the story's dynamic memory.
+func (m *Machine) DynamicMemorySnapshot() []byte {
+	return append([]byte(nil), m.memory[:m.staticMemoryBase()]...)
+}
+
+// ApplyDynamicMemory replaces the story's dynamic memory with mem, which must
+// be a snapshot of the same story.  The transcript and fixed-pitch bits of
+// Flags 2 are kept.
+func (m *Machine) ApplyDynamicMemory(mem []byte) error {
+	if len(mem) != int(m.staticMemoryBase()) {
+		return fmt.Errorf("Dynamic memory is %d bytes (got %d)", m.staticMemoryBase(), len(mem))
+	}
+	m.restoreMemory(0, mem)
+	return nil
+}
+
+// restoreMemory copies mem over memory starting at a, keeping the transcript
+// and fixed-pitch bits of Flags 2.  The kept bits are merged into mem's Flags
+// 2 before it's stored, so the transcript isn't closed and reopened.
+func (m *Machine) restoreMemory(a Address, mem []byte) {
+	i := int(flags2Game - a)
+	if a > flags2Game || i >= len(mem) {
+		m.storeBytes(a, mem)
+		return
+	}
+	keep := m.memory[flags2Game] & 0x03
+	m.storeBytes(a, mem[:i])
+	m.storeByte(flags2Game, mem[i]&^0x03|keep)
+	m.storeBytes(flags2Game+1, mem[i+1:])
+}
+
+// OriginalDynamicMemory returns a copy of the story's dynamic memory as it
+// was in the story file, before the interpreter filled in the header.
+func (m *Machine) OriginalDynamicMemory() []byte {
+	return append([]byte(nil), m.image[:m.staticMemoryBase()]...)
+}
+
+// A MemDelta is a run of consecutive bytes starting at Address that differ
+// from the story file.  Old and New are the same length.
+type MemDelta struct {
+	Address  Address
+	Old, New []byte
+}
+
+// DynamicMemoryDiff returns the runs of dynamic memory that differ from the
+// story file, in address order.  It is the same difference that a Quetzal
+// save's CMem chunk records, so it includes the header fields set by the
+// interpreter.
+func (m *Machine) DynamicMemoryDiff() []MemDelta {
+	n := m.staticMemoryBase()
+	return MemoryDiff(m.image[:n], m.memory[:n])
+}
+
+// MemoryDiff returns the runs of bytes that differ between two copies of the
+// same memory, like snapshots from DynamicMemorySnapshot, in address order.
+// Bytes past the end of the shorter copy are ignored.
+func MemoryDiff(before, after []byte) []MemDelta {
+	n := len(before)
+	if len(after) < n {
+		n = len(after)
+	}
+	var deltas []MemDelta
+	for i := 0; i < n; {
+		if before[i] == after[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < n && before[i] != after[i] {
+			i++
+		}
+		deltas = append(deltas, MemDelta{
+			Address: Address(start),
+			Old:     append([]byte(nil), before[start:i]...),
+			New:     append([]byte(nil), after[start:i]...),
+		})
+	}
+	return deltas
+}
+
+// dynamicDiff returns the difference between the dynamic memory and the
+// story as it was loaded, in the form returned by diffMemory.
+func (m *Machine) dynamicDiff() []byte {
+	n := int(m.staticMemoryBase())
+	var w diffWriter
+	i := 0
+	for _, p := range m.dirtyPagesSince(0) {
+		start := p << dirtyPageShift
+		if start >= n {
+			break
+		}
+		end := start + dirtyPageSize
+		if end > n {
+			end = n
+		}
+		// Pages that haven't been written to still match the story.
+		w.zeros += start - i
+		for i = start; i < end; i++ {
+			w.add(m.memory[i] ^ m.image[i])
+		}
+	}
+	return w.diff
+}
+
+// applyDynamicDiff replaces the dynamic memory with the story as it was
+// loaded with diff applied.  Like ApplyDynamicMemory, it keeps the transcript
+// and fixed-pitch bits.
+func (m *Machine) applyDynamicDiff(diff []byte) error {
+	mem, err := applyDiff(m.image[:m.staticMemoryBase()], diff)
+	if err != nil {
+		return err
+	}
+	m.restoreMemory(0, mem)
+	return nil
+}
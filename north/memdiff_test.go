@@ -0,0 +1,168 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestDiffMemory(t *testing.T) {
+	orig := make([]byte, 600)
+	for i := range orig {
+		orig[i] = byte(i * 7)
+	}
+	tests := []struct {
+		Name    string
+		Changes map[int]byte
+		Diff    []byte
+	}{
+		{"same", nil, nil},
+		{"first", map[int]byte{0: 0xff}, []byte{0xff}},
+		{"gap", map[int]byte{1: 0x01, 4: 0x02}, []byte{0, 0, 0x01, 0, 1, 0x02}},
+		{"long run", map[int]byte{300: 0x10}, []byte{0, 255, 0, 43, 0x10}},
+		{"exact run", map[int]byte{256: 0x10}, []byte{0, 255, 0x10}},
+		{"last", map[int]byte{599: 0x80}, []byte{0, 255, 0, 255, 0, 86, 0x80}},
+	}
+	for _, tt := range tests {
+		mem := append([]byte(nil), orig...)
+		for i, x := range tt.Changes {
+			mem[i] ^= x
+		}
+		diff := diffMemory(orig, mem)
+		if !bytes.Equal(diff, tt.Diff) {
+			t.Errorf("%s: diffMemory = % x; want % x", tt.Name, diff, tt.Diff)
+		}
+		got, err := applyDiff(orig, diff)
+		if err != nil {
+			t.Errorf("%s: applyDiff: %v", tt.Name, err)
+		} else if !bytes.Equal(got, mem) {
+			t.Errorf("%s: applyDiff didn't restore memory", tt.Name)
+		}
+	}
+}
+
+func TestApplyDiffBad(t *testing.T) {
+	orig := make([]byte, 10)
+	for _, diff := range [][]byte{{0}, {0, 9, 1}, {0, 20}} {
+		if _, err := applyDiff(orig, diff); err == nil {
+			t.Errorf("applyDiff(% x) succeeded", diff)
+		}
+	}
+}
+
+func TestDynamicMemory(t *testing.T) {
+	m, _ := newTestMachine(3, 0x400)
+	snap := m.DynamicMemorySnapshot()
+	if len(snap) != 0x200 {
+		t.Fatalf("len(snapshot) != 0x200 (got %#x)", len(snap))
+	}
+	m.storeWord(0x100, 0x1234)
+	m.storeByte(0x1ff, 0x56)
+	diff := m.dynamicDiff()
+	changed := m.DynamicMemorySnapshot()
+
+	if err := m.ApplyDynamicMemory(snap); err != nil {
+		t.Fatal("ApplyDynamicMemory:", err)
+	}
+	if w := m.loadWord(0x100); w != 0 {
+		t.Errorf("word at 0x100 after restoring snapshot != 0 (got %v)", w)
+	}
+	if err := m.applyDynamicDiff(diff); err != nil {
+		t.Fatal("applyDynamicDiff:", err)
+	}
+	if !bytes.Equal(m.DynamicMemorySnapshot(), changed) {
+		t.Error("memory after applying diff differs")
+	}
+	if err := m.ApplyDynamicMemory(snap[:10]); err == nil {
+		t.Error("ApplyDynamicMemory with short snapshot succeeded")
+	}
+}
+
+func TestDynamicMemoryDiff(t *testing.T) {
+	m, _ := newTestMachine(3, 0x400)
+	orig := m.OriginalDynamicMemory()
+	if len(orig) != 0x200 {
+		t.Fatalf("len(OriginalDynamicMemory()) != 0x200 (got %#x)", len(orig))
+	}
+	// Compare against the header as loaded, since the interpreter fills it in.
+	base := m.DynamicMemoryDiff()
+	m.storeWord(0x100, 0x1234)
+	m.storeByte(0x102, 0x56)
+	m.storeByte(0x180, 0x78)
+	m.storeByte(0x1ff, 0x9a)
+	m.storeByte(0x1ff, 0)
+	want := append(base,
+		MemDelta{0x100, []byte{0, 0, 0}, []byte{0x12, 0x34, 0x56}},
+		MemDelta{0x180, []byte{0}, []byte{0x78}},
+	)
+	if got := m.DynamicMemoryDiff(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DynamicMemoryDiff() = %+v; want %+v", got, want)
+	}
+
+	// The copy doesn't alias the story.
+	orig[0x100] = 0xff
+	if o := m.OriginalDynamicMemory(); o[0x100] != 0 {
+		t.Errorf("OriginalDynamicMemory()[0x100] = %#02x after changing a copy; want 0", o[0x100])
+	}
+}
+
+func BenchmarkDynamicMemoryDiff(b *testing.B) {
+	// 48K of dynamic memory, with a change every 100 bytes.
+	m, _ := newTestMachine(5, 0x18000)
+	for a := Address(0x40); a < m.staticMemoryBase(); a += 100 {
+		m.storeByte(a, 1)
+	}
+	b.SetBytes(int64(m.staticMemoryBase()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.DynamicMemoryDiff()
+	}
+}
+
+func TestClassifyAddress(t *testing.T) {
+	b := zasm.New(3)
+	b.Object("room", "", nil)
+	b.Object("lamp", "room", nil, zasm.Prop(5, 1, 2))
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+	b.Routine("main", 0)
+	b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+	b.Instr("quit")
+	m := buildMachine(t, b, &scriptUI{Line: "look"})
+	text, _ := b.DataAddress("text")
+	parse, _ := b.DataAddress("parse")
+	if r := m.ClassifyAddress(Address(text)); r.Kind != OtherRegion {
+		t.Errorf("ClassifyAddress(text) before a read = %v; want other", r)
+	}
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatal("Run:", err)
+	}
+
+	objects := m.objectTableAddress()
+	tests := []struct {
+		Address Address
+		Want    Region
+	}{
+		{0x00, Region{Kind: HeaderRegion}},
+		{0x3f, Region{Kind: HeaderRegion}},
+		{m.globalAddress(0), Region{GlobalRegion, 0x10}},
+		{m.globalAddress(3) + 1, Region{GlobalRegion, 0x13}},
+		{objects, Region{ObjectRegion, 0}},
+		{objects + 61, Region{ObjectRegion, 0}},
+		{objects + 62, Region{ObjectRegion, 1}},
+		{objects + 62 + 9 + 4, Region{ObjectRegion, 2}},
+		{m.loadObject(1).PropertyBase, Region{PropertyRegion, 1}},
+		{m.loadObject(2).PropertyBase + 1, Region{PropertyRegion, 2}},
+		{Address(text) + 3, Region{Kind: TextBufferRegion}},
+		{Address(parse) + 5, Region{Kind: ParseBufferRegion}},
+		{m.dictionaryAddress(), Region{}},
+	}
+	for _, tt := range tests {
+		if r := m.ClassifyAddress(tt.Address); r != tt.Want {
+			t.Errorf("ClassifyAddress(%v) = %v; want %v", tt.Address, r, tt.Want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package north
+
+import (
+	"encoding/xml"
+)
+
+// StoryMetadata describes a story, as given by its Blorb file.
+type StoryMetadata struct {
+	// Bibliographic information from the iFiction record.  Fields are empty
+	// if the Blorb has no metadata chunk or it can't be parsed.
+	Title          string
+	Author         string
+	Headline       string
+	Description    string
+	FirstPublished string
+	IFIDs          []string
+
+	// Frontispiece is the number of the cover art picture, or -1 if none.
+	Frontispiece int
+
+	// Release is the release number from the Blorb, or -1 if none.
+	Release int
+}
+
+// ifindex is the subset of the Treaty of Babel's iFiction format that
+// StoryMetadata uses.
+type ifindex struct {
+	Story struct {
+		IFIDs          []string `xml:"identification>ifid"`
+		Title          string   `xml:"bibliographic>title"`
+		Author         string   `xml:"bibliographic>author"`
+		Headline       string   `xml:"bibliographic>headline"`
+		Description    string   `xml:"bibliographic>description"`
+		FirstPublished string   `xml:"bibliographic>firstpublished"`
+	} `xml:"story"`
+}
+
+// Metadata returns the story's metadata, or nil if the story wasn't loaded
+// from a Blorb file.  The iFiction record is parsed on the first call.
+func (m *Machine) Metadata() *StoryMetadata {
+	if m.blorb == nil {
+		return nil
+	}
+	if m.metadata == nil {
+		m.metadata = parseMetadata(m.blorb)
+	}
+	return m.metadata
+}
+
+func parseMetadata(b *blorbInfo) *StoryMetadata {
+	md := &StoryMetadata{Frontispiece: b.frontispiece, Release: b.release}
+	if b.ifmd == nil {
+		return md
+	}
+	var idx ifindex
+	if err := xml.Unmarshal(b.ifmd, &idx); err != nil {
+		return md
+	}
+	md.Title = idx.Story.Title
+	md.Author = idx.Story.Author
+	md.Headline = idx.Story.Headline
+	md.Description = idx.Story.Description
+	md.FirstPublished = idx.Story.FirstPublished
+	md.IFIDs = idx.Story.IFIDs
+	return md
+}
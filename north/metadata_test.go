@@ -0,0 +1,49 @@
+package north
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	ifmd, err := ioutil.ReadFile("testdata/sample.iFiction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testStoryImage()
+	tests := []struct {
+		Name     string
+		Data     []byte
+		Metadata *StoryMetadata
+	}{
+		{"bare", img, nil},
+		{"blorb", testBlorb("ZCOD", img), &StoryMetadata{Frontispiece: -1, Release: -1}},
+		{"bad xml", testBlorb("ZCOD", img, "IFmd", []byte("<ifindex")), &StoryMetadata{Frontispiece: -1, Release: -1}},
+		{
+			"ifmd",
+			testBlorb("ZCOD", img, "IFmd", ifmd, "Fspc", []byte{0, 0, 0, 1}, "RelN", []byte{0, 42}),
+			&StoryMetadata{
+				Title:          "Lantern Trouble",
+				Author:         "A. N. Author",
+				Headline:       "An Interactive Test",
+				Description:    "A short story about a brass lantern.",
+				FirstPublished: "2026",
+				IFIDs:          []string{"ZCODE-42-260101-ABCD", "1974A053-7DAE-4B64-9B6F-BBF9A2B47F4A"},
+				Frontispiece:   1,
+				Release:        42,
+			},
+		},
+	}
+	for _, tt := range tests {
+		m, err := NewMachine(bytes.NewReader(tt.Data), new(bufferUI))
+		if err != nil {
+			t.Errorf("%s: %v", tt.Name, err)
+			continue
+		}
+		if md := m.Metadata(); !reflect.DeepEqual(md, tt.Metadata) {
+			t.Errorf("%s: Metadata() != %+v (got %+v)", tt.Name, tt.Metadata, md)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package north
 
 import (
 	"errors"
+	"fmt"
 )
 
 type object struct {
+	// NumAttrs is 32 in versions 1-3 and 48 after.
+	NumAttrs     uint8
 	Attributes   [6]byte
 	Parent       Word
 	Sibling      Word
@@ -12,19 +15,47 @@ type object struct {
 	PropertyBase Address
 }
 
-// Attr returns the value of attribute i.
+// checkAttr returns an error if i isn't a valid attribute number.
+func (o *object) checkAttr(i Word) error {
+	if i >= Word(o.NumAttrs) {
+		return fmt.Errorf("Attribute %d out of range (object has %d)", i, o.NumAttrs)
+	}
+	return nil
+}
+
+// Attr returns the value of attribute i.  Attributes out of range are never
+// set.
 func (o *object) Attr(i uint8) bool {
+	if i >= o.NumAttrs {
+		return false
+	}
 	return o.Attributes[i/8]&(1<<(7-i%8)) != 0
 }
 
 // SetAttr changes the value of attribute i.
-func (o *object) SetAttr(i uint8, val bool) {
+func (o *object) SetAttr(i uint8, val bool) error {
+	if err := o.checkAttr(Word(i)); err != nil {
+		return err
+	}
 	mask := byte(1 << (7 - i%8))
 	if val {
 		o.Attributes[i/8] |= mask
 	} else {
 		o.Attributes[i/8] &^= mask
 	}
+	return nil
+}
+
+// Attrs returns the numbers of the attributes that are set, in increasing
+// order.
+func (o *object) Attrs() []uint8 {
+	var attrs []uint8
+	for i := 0; i < int(o.NumAttrs); i++ {
+		if o.Attr(uint8(i)) {
+			attrs = append(attrs, uint8(i))
+		}
+	}
+	return attrs
 }
 
 // FetchName retrieves the object's name from m's memory.
@@ -33,33 +64,55 @@ func (o *object) FetchName(m *Machine) (string, error) {
 	return m.loadString(o.PropertyBase+1, true)
 }
 
-func (o *object) propLoc(m *Machine, i uint8) (Address, uint8) {
+// propLoc returns the address and size of the object's property i (1-based),
+// or 0 if the object doesn't have it.  It returns a MemoryError if the
+// property table runs outside memory.
+func (o *object) propLoc(m *Machine, i uint8) (Address, uint8, error) {
 	if i == 0 {
-		return 0, 0
+		return 0, 0, nil
+	}
+	var err error
+	load := func(a Address) byte {
+		if int(a) >= len(m.memory) {
+			if err == nil {
+				err = &MemoryError{Address: a, Size: 1}
+			}
+			return 0
+		}
+		return m.memory[a]
+	}
+	found := func(a Address, size uint8) (Address, uint8, error) {
+		if err == nil && int(a)+int(size) > len(m.memory) {
+			err = &MemoryError{Address: a, Size: int(size)}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		return a, size, nil
 	}
 
-	a := o.PropertyBase + 1 + Address(m.loadByte(o.PropertyBase))*2
+	a := o.PropertyBase + 1 + Address(load(o.PropertyBase))*2
 	if m.Version() <= 3 {
-		for m.loadByte(a) != 0 {
-			size, n := m.loadByte(a)>>5+1, m.loadByte(a)&0x1f
+		for b := load(a); b != 0 && err == nil; b = load(a) {
+			size, n := b>>5+1, b&0x1f
 			a++
 			if n == i {
-				return a, size
+				return found(a, size)
 			}
 			a += Address(size)
 		}
-		return 0, 0
+		return 0, 0, err
 	}
 
-	for {
+	for err == nil {
 		var size, n uint8
-		if m.loadByte(a)&0x80 == 0 {
+		if b := load(a); b&0x80 == 0 {
 			// One-byte
-			size, n = m.loadByte(a)>>6+1, m.loadByte(a)&0x3f
+			size, n = b>>6+1, b&0x3f
 			a++
 		} else {
 			// Two-byte
-			size, n = m.loadByte(a+1)&0x3f, m.loadByte(a)&0x3f
+			size, n = load(a+1)&0x3f, b&0x3f
 			if size == 0 {
 				// Standard 12.4.2.1.1: 0 should be interpreted as 64
 				size = 64
@@ -69,57 +122,122 @@ func (o *object) propLoc(m *Machine, i uint8) (Address, uint8) {
 		if n == 0 {
 			break
 		} else if n == i {
-			return a, size
+			return found(a, size)
 		}
 		a += Address(size)
 	}
-	return 0, 0
+	return 0, 0, err
 }
 
 // NextProperty returns the number of the next property in the object. If i is
 // 0, then the first property number is returned.
 func (o *object) NextProperty(m *Machine, i uint8) (uint8, error) {
+	mask := byte(0x3f)
+	if m.Version() <= 3 {
+		mask = 0x1f
+	}
 	if i == 0 {
 		// First property
+		if int(o.PropertyBase) >= len(m.memory) {
+			return 0, &MemoryError{Address: o.PropertyBase, Size: 1}
+		}
 		a := o.PropertyBase + 1 + Address(m.loadByte(o.PropertyBase))*2
-		return m.loadByte(a) & 0x1f, nil
+		if int(a) >= len(m.memory) {
+			return 0, &MemoryError{Address: a, Size: 1}
+		}
+		return m.loadByte(a) & mask, nil
 	}
 
-	a, size := o.propLoc(m, i)
+	a, size, err := o.propLoc(m, i)
+	if err != nil {
+		return 0, err
+	}
 	if a == 0 {
 		return 0, errors.New("trying to find next on non-existent property")
 	}
-	return m.loadByte(a+Address(size)) & 0x1f, nil
+	if next := a + Address(size); int(next) >= len(m.memory) {
+		return 0, &MemoryError{Address: next, Size: 1}
+	}
+	return m.loadByte(a+Address(size)) & mask, nil
 }
 
 // Property retrieves an object's property i (1-based) from m's memory.  The
 // returned slice points to m's memory, or nil if the object doesn't have
-// property i.
-func (o *object) Property(m *Machine, i uint8) []byte {
-	a, size := o.propLoc(m, i)
+// property i.  Writes must go through m.storeBytes instead of the slice.
+func (o *object) Property(m *Machine, i uint8) ([]byte, error) {
+	a, size, err := o.propLoc(m, i)
 	if a == 0 {
-		return nil
+		return nil, err
 	}
-	return m.memory[a : a+Address(size)]
+	return m.memory[a : a+Address(size)], nil
 }
 
 // PropertyAddress returns the address of the object's property i (1-based), or
 // 0 if not found.
-func (o *object) PropertyAddress(m *Machine, i uint8) Address {
-	a, _ := o.propLoc(m, i)
-	return a
+func (o *object) PropertyAddress(m *Machine, i uint8) (Address, error) {
+	a, _, err := o.propLoc(m, i)
+	return a, err
+}
+
+// A PropertyEntry is a property number and its data.
+type PropertyEntry struct {
+	Number uint8
+	Data   []byte
+}
+
+// PropertyList returns the properties of object i (1-based) in table order.
+// The data is copied from memory.
+func (m *Machine) PropertyList(i Word) ([]PropertyEntry, error) {
+	if err := m.checkObject(i); err != nil {
+		return nil, err
+	}
+	o := m.loadObject(i)
+	var list []PropertyEntry
+	n, err := o.NextProperty(m, 0)
+	for ; err == nil && n != 0; n, err = o.NextProperty(m, n) {
+		if len(list) > 0 && n >= list[len(list)-1].Number {
+			return list, fmt.Errorf("Object %d properties out of order", i)
+		}
+		p, perr := o.Property(m, n)
+		if perr != nil {
+			return list, perr
+		}
+		list = append(list, PropertyEntry{n, append([]byte(nil), p...)})
+	}
+	return list, err
 }
 
 // defaultPropertyValue fetches the value that should be returned when querying
 // property i on an object that doesn't have property i.
-func (m *Machine) defaultPropertyValue(i uint8) Word {
-	return m.loadWord(m.objectTableAddress() + Address(i-1)*2)
+func (m *Machine) defaultPropertyValue(i uint8) (Word, error) {
+	a := m.objectTableAddress() + Address(i-1)*2
+	if int(a)+2 > len(m.memory) {
+		return 0, &MemoryError{Address: a, Size: 2}
+	}
+	return m.loadWord(a), nil
+}
+
+// checkObject returns an error unless object i's record in the object table
+// lies inside memory.  There is no object 0.
+func (m *Machine) checkObject(i Word) error {
+	if i == 0 {
+		return errors.New("Object 0 doesn't exist")
+	}
+	base, size := int(m.objectTableAddress())+31*2, 9
+	if m.Version() > 3 {
+		base, size = int(m.objectTableAddress())+63*2, 14
+	}
+	if a := base + (int(i)-1)*size; a+size > len(m.memory) {
+		return &MemoryError{Address: Address(a), Size: size}
+	}
+	return nil
 }
 
 // loadObject returns the record for object i (1-based) in the object table.
 func (m *Machine) loadObject(i Word) *object {
 	o := new(object)
 	if m.Version() <= 3 {
+		o.NumAttrs = 32
 		base := m.objectTableAddress() + (31 * 2) + Address((i-1)*9)
 		copy(o.Attributes[:4], m.memory[base:])
 		o.Parent = Word(m.loadByte(base + 4))
@@ -127,6 +245,7 @@ func (m *Machine) loadObject(i Word) *object {
 		o.Child = Word(m.loadByte(base + 6))
 		o.PropertyBase = Address(m.loadWord(base + 7))
 	} else {
+		o.NumAttrs = 48
 		base := m.objectTableAddress() + (63 * 2) + Address((i-1)*14)
 		copy(o.Attributes[:6], m.memory[base:])
 		o.Parent = m.loadWord(base + 6)
@@ -137,18 +256,24 @@ func (m *Machine) loadObject(i Word) *object {
 	return o
 }
 
+// ObjectAttrs returns the numbers of the attributes set on object i
+// (1-based), in increasing order.
+func (m *Machine) ObjectAttrs(i Word) []uint8 {
+	return m.loadObject(i).Attrs()
+}
+
 // storeObject updates the record for object i (1-based) in the object table.
 func (m *Machine) storeObject(i Word, o *object) {
 	if m.Version() <= 3 {
 		base := m.objectTableAddress() + (31 * 2) + Address((i-1)*9)
-		copy(m.memory[base:], o.Attributes[:4])
+		m.storeBytes(base, o.Attributes[:4])
 		m.storeByte(base+4, byte(o.Parent))
 		m.storeByte(base+5, byte(o.Sibling))
 		m.storeByte(base+6, byte(o.Child))
 		m.storeWord(base+7, Word(o.PropertyBase))
 	} else {
 		base := m.objectTableAddress() + (63 * 2) + Address((i-1)*14)
-		copy(m.memory[base:], o.Attributes[:6])
+		m.storeBytes(base, o.Attributes[:6])
 		m.storeWord(base+6, o.Parent)
 		m.storeWord(base+8, o.Sibling)
 		m.storeWord(base+10, o.Child)
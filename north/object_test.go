@@ -0,0 +1,284 @@
+package north
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestObjectAttrs(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Attrs   []int
+	}{
+		{3, nil},
+		{3, []int{0}},
+		{3, []int{2, 7, 19}},
+		{3, []int{0, 8, 15, 16, 31}},
+		{5, []int{2, 7, 19}},
+		{5, []int{0, 31, 32, 40, 47}},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		b.Instr("quit")
+		b.Object("thing", "", tt.Attrs)
+		m := buildMachine(t, b, new(bufferUI))
+
+		var want []uint8
+		for _, a := range tt.Attrs {
+			want = append(want, uint8(a))
+		}
+		if attrs := m.ObjectAttrs(1); !reflect.DeepEqual(attrs, want) {
+			t.Errorf("v%d: m.ObjectAttrs(1) != %v (got %v)", tt.Version, want, attrs)
+		}
+	}
+}
+
+func TestAttrRange(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Attr    uint16
+		OK      bool
+	}{
+		{3, 31, true},
+		{3, 32, false},
+		{4, 32, true},
+		{5, 47, true},
+		{3, 48, false},
+		{5, 48, false},
+		{5, 0x100, false},
+	}
+	for _, tt := range tests {
+		for _, op := range []string{"set_attr", "clear_attr", "test_attr"} {
+			b := zasm.New(tt.Version)
+			b.Routine("main", 0)
+			if op == "test_attr" {
+				b.Instr(op, zasm.Obj("thing"), zasm.Large(tt.Attr), zasm.Branch("next"))
+			} else {
+				b.Instr(op, zasm.Obj("thing"), zasm.Large(tt.Attr))
+			}
+			b.Label("next")
+			b.Instr("quit")
+			b.Object("thing", "", nil)
+			m := buildMachine(t, b, new(bufferUI))
+			err := m.Step()
+			if tt.OK && err != nil {
+				t.Errorf("v%d %s %d: %v", tt.Version, op, tt.Attr, err)
+			} else if !tt.OK && err == nil {
+				t.Errorf("v%d %s %d: no error", tt.Version, op, tt.Attr)
+			}
+			if tt.OK && op == "set_attr" {
+				if attrs := m.ObjectAttrs(1); !reflect.DeepEqual(attrs, []uint8{uint8(tt.Attr)}) {
+					t.Errorf("v%d %s %d: attributes = %v", tt.Version, op, tt.Attr, attrs)
+				}
+			}
+		}
+	}
+
+	o := &object{NumAttrs: 32}
+	if err := o.SetAttr(32, true); err == nil {
+		t.Error("o.SetAttr(32, true) on a 32-attribute object did not fail")
+	}
+	if o.Attributes != [6]byte{} {
+		t.Errorf("o.SetAttr(32, true) changed attributes to %v", o.Attributes)
+	}
+	if o.Attr(40) {
+		t.Error("o.Attr(40) on a 32-attribute object is true")
+	}
+}
+
+func TestPropertyWords(t *testing.T) {
+	for _, version := range []byte{3, 5} {
+		b := zasm.New(version)
+		b.Routine("main", 0)
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Const(5), zasm.Store(zasm.Global(0)))
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Const(6), zasm.Store(zasm.Global(1)))
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Const(7), zasm.Store(zasm.Global(2)))
+		b.Instr("put_prop", zasm.Obj("thing"), zasm.Const(5), zasm.Large(0xabcd))
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Const(5), zasm.Store(zasm.Global(3)))
+		b.Instr("quit")
+		b.PropertyDefault(7, 0xbeef)
+		b.Object("thing", "", nil, zasm.WordProp(5, 0x1234), zasm.Prop(6, 0x42))
+		m := buildMachine(t, b, new(bufferUI))
+		for i := 0; i < 5; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("v%d: step %d: %v", version, i, err)
+			}
+		}
+
+		globals := []struct {
+			Name string
+			Want Word
+		}{
+			{"word property", 0x1234},
+			{"byte property", 0x0042},
+			{"default property", 0xbeef},
+			{"word property after put_prop", 0xabcd},
+		}
+		for i, g := range globals {
+			if w := m.Variable(uint8(0x10 + i)); w != g.Want {
+				t.Errorf("v%d: %s != %v (got %v)", version, g.Name, g.Want, w)
+			}
+		}
+		a, _ := m.loadObject(1).PropertyAddress(m, 5)
+		if p := m.memory[a : a+2]; !bytes.Equal(p, []byte{0xab, 0xcd}) {
+			t.Errorf("v%d: property bytes after put_prop != [ab cd] (got % x)", version, p)
+		}
+	}
+}
+
+func TestPropertyList(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Props   []zasm.Property
+	}{
+		{3, []zasm.Property{zasm.WordProp(20, 0x1234), zasm.Prop(12, 1, 2, 3), zasm.Prop(5, 0x42)}},
+		{5, []zasm.Property{zasm.WordProp(40, 0xabcd), zasm.Prop(33, 9, 8, 7, 6, 5), zasm.Prop(2, 0x42)}},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		b.Instr("get_next_prop", zasm.Obj("thing"), zasm.Const(0), zasm.Store(zasm.Global(0)))
+		for g := uint8(0); g < 3; g++ {
+			b.Instr("get_next_prop", zasm.Obj("thing"), zasm.Global(g), zasm.Store(zasm.Global(g+1)))
+		}
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Global(0), zasm.Store(zasm.Global(4)))
+		b.Instr("get_prop", zasm.Obj("thing"), zasm.Global(2), zasm.Store(zasm.Global(5)))
+		b.Instr("quit")
+		b.Object("thing", "", nil, tt.Props...)
+		m := buildMachine(t, b, new(bufferUI))
+		for i := 0; i < 6; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("v%d: step %d: %v", tt.Version, i, err)
+			}
+		}
+
+		list, err := m.PropertyList(1)
+		if err != nil {
+			t.Errorf("v%d: PropertyList: %v", tt.Version, err)
+			continue
+		}
+		if len(list) != len(tt.Props) {
+			t.Errorf("v%d: len(list) != %d (got %d)", tt.Version, len(tt.Props), len(list))
+			continue
+		}
+		for i, p := range list {
+			if w := m.Variable(0x10 + uint8(i)); Word(p.Number) != w {
+				t.Errorf("v%d: list[%d].Number != get_next_prop %v (got %d)", tt.Version, i, w, p.Number)
+			}
+			if !bytes.Equal(p.Data, tt.Props[i].Data) {
+				t.Errorf("v%d: list[%d].Data != % x (got % x)", tt.Version, i, tt.Props[i].Data, p.Data)
+			}
+		}
+		if w := m.Variable(0x13); w != 0 {
+			t.Errorf("v%d: last get_next_prop != 0 (got %v)", tt.Version, w)
+		}
+		if w := m.Variable(0x14); w != Word(list[0].Data[0])<<8|Word(list[0].Data[1]) {
+			t.Errorf("v%d: get_prop %d = %v; list has % x", tt.Version, list[0].Number, w, list[0].Data)
+		}
+		if w := m.Variable(0x15); w != Word(list[2].Data[0]) {
+			t.Errorf("v%d: get_prop %d = %v; list has % x", tt.Version, list[2].Number, w, list[2].Data)
+		}
+	}
+}
+
+func TestGetNextProp(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Props   []zasm.Property
+		Want    []Word // get_next_prop 0, then each property in turn
+	}{
+		{3, nil, []Word{0}},
+		{3, []zasm.Property{zasm.Prop(7, 1)}, []Word{7, 0}},
+		{3, []zasm.Property{zasm.Prop(31, 1, 2, 3, 4, 5, 6, 7, 8), zasm.Prop(1, 9)}, []Word{31, 1, 0}},
+		{5, nil, []Word{0}},
+		{5, []zasm.Property{zasm.Prop(63, 1)}, []Word{63, 0}},
+		{5, []zasm.Property{zasm.Prop(50, make([]byte, 64)...), zasm.Prop(9, 1, 2, 3), zasm.WordProp(3, 0xffff)}, []Word{50, 9, 3, 0}},
+	}
+	for _, tt := range tests {
+		b := zasm.New(tt.Version)
+		b.Routine("main", 0)
+		b.Instr("get_next_prop", zasm.Obj("thing"), zasm.Const(0), zasm.Store(zasm.SP))
+		for _, p := range tt.Props {
+			b.Instr("get_next_prop", zasm.Obj("thing"), zasm.Const(uint16(p.Num)), zasm.Store(zasm.SP))
+		}
+		b.Instr("quit")
+		b.Object("thing", "", nil, tt.Props...)
+		m := buildMachine(t, b, new(bufferUI))
+		for i := range tt.Want {
+			if err := m.Step(); err != nil {
+				t.Fatalf("v%d %d props: step %d: %v", tt.Version, len(tt.Props), i, err)
+			}
+		}
+		if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, tt.Want) {
+			t.Errorf("v%d %d props: get_next_prop results = %v; want %v", tt.Version, len(tt.Props), s, tt.Want)
+		}
+	}
+}
+
+func TestGetNextPropMissing(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("get_next_prop", zasm.Obj("thing"), zasm.Const(4), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	b.Object("thing", "", nil, zasm.Prop(5, 1), zasm.Prop(3, 1))
+	m := buildMachine(t, b, new(bufferUI))
+	if err := m.Step(); err == nil {
+		t.Error("get_next_prop of a missing property succeeded")
+	}
+}
+
+func TestObjectTree(t *testing.T) {
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	b.Object("room", "", nil)      // 1
+	b.Object("table", "room", nil) // 2
+	b.Object("box", "room", nil)   // 3
+	b.Object("lamp", "table", nil) // 4
+	b.Object("coin", "box", nil)   // 5
+	b.Object("gem", "box", nil)    // 6
+	b.Object("dust", "coin", nil)  // 7
+	b.Object("void", "", nil)      // 8
+	m := buildMachine(t, b, new(bufferUI))
+
+	children := []struct {
+		Obj  Word
+		Want []Word
+	}{
+		{1, []Word{2, 3}},
+		{3, []Word{5, 6}},
+		{5, []Word{7}},
+		{8, nil},
+		{0, nil},
+		{9, nil},
+	}
+	for _, tt := range children {
+		if got := m.Children(tt.Obj); !reflect.DeepEqual(got, tt.Want) {
+			t.Errorf("Children(%d) = %v; want %v", tt.Obj, got, tt.Want)
+		}
+	}
+	if got, want := m.Descendants(1), []Word{2, 4, 3, 5, 7, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Descendants(1) = %v; want %v", got, want)
+	}
+	if got := m.Descendants(8); got != nil {
+		t.Errorf("Descendants(8) = %v; want []", got)
+	}
+
+	// Make the gem contain the room and the dust's sibling the box.
+	gem := m.loadObject(6)
+	gem.Child = 1
+	m.storeObject(6, gem)
+	dust := m.loadObject(7)
+	dust.Sibling = 3
+	m.storeObject(7, dust)
+	if got, want := m.Children(5), []Word{7, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after cycle, Children(5) = %v; want %v", got, want)
+	}
+	if got, want := m.Descendants(1), []Word{2, 4, 3, 5, 7, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after cycle, Descendants(1) = %v; want %v", got, want)
+	}
+}
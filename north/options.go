@@ -0,0 +1,193 @@
+package north
+
+// An Option configures a Machine when it's created or loaded.  Most settings
+// are fixed once the story is loaded; Strict, MaxStackDepth, DecodeCache, and
+// EventLogSize can be changed between steps with the Machine's SetStrict,
+// SetMaxStackDepth, SetStringCache, and SetEventLogSize methods.  The UI, the
+// OnQuit and OnRestart hooks, and the random source are not settings, and may
+// be changed between steps.
+type Option func(*config)
+
+// config is the settings chosen by Options.  The zero value is the default
+// for every setting.
+type config struct {
+	strict           bool
+	undoLevels       int // 0 for DefaultUndoLevels, less for none
+	undoKeepOldest   bool
+	instructionLimit int64
+	seed             int64
+	seeded           bool
+	decodeCache      int
+	maxStackDepth    int // 0 for DefaultMaxStackDepth, less for no limit
+	eventLogSize     int // 0 for DefaultEventLogSize, less for none
+	maxStringLength  int // 0 for DefaultMaxStringLength, less for no limit
+	linear           bool
+}
+
+// A Config is the effective configuration of a Machine, as returned by
+// Machine.Config.
+type Config struct {
+	Strict bool
+
+	// UndoLevels is the number of states save_undo keeps.  Zero means undo
+	// is off.
+	UndoLevels int
+
+	// UndoDropOldest is true if save_undo drops the oldest state when
+	// UndoLevels are already saved, instead of storing 0.  It's true by
+	// default.
+	UndoDropOldest bool
+
+	// InstructionLimit is the number of instructions the story may execute
+	// after it's loaded.  Zero means no limit.
+	InstructionLimit int64
+
+	// Seed is the random number generator's seed if Seeded is true.
+	// Otherwise the generator is seeded from the clock.
+	Seed   int64
+	Seeded bool
+
+	// DecodeCache is the number of decoded strings that are cached.
+	DecodeCache int
+
+	// MaxStackDepth is the most frames the call stack may hold.  Zero means
+	// no limit.
+	MaxStackDepth int
+
+	// EventLogSize is the number of events in the machine's event log.
+	EventLogSize int
+
+	// MaxStringLength is the most Z-characters decoded in one string.  Zero
+	// means no limit.
+	MaxStringLength int
+
+	// Linear is true in linear mode, for screen readers.
+	Linear bool
+}
+
+// Config returns the machine's effective configuration, with defaults filled
+// in.
+func (m *Machine) Config() Config {
+	return Config{
+		Strict:           m.cfg.strict,
+		UndoLevels:       clampNone(m.undoLevels()),
+		UndoDropOldest:   !m.cfg.undoKeepOldest,
+		InstructionLimit: m.cfg.instructionLimit,
+		Seed:             m.cfg.seed,
+		Seeded:           m.cfg.seeded,
+		DecodeCache:      m.cfg.decodeCache,
+		MaxStackDepth:    clampNone(m.maxStackDepth()),
+		EventLogSize:     clampNone(m.eventLogSize()),
+		MaxStringLength:  clampNone(m.maxStringLength()),
+		Linear:           m.cfg.linear,
+	}
+}
+
+// clampNone returns n, or 0 if n is negative.
+func clampNone(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// applyOptions sets the configuration from opts, starting from the defaults.
+func (m *Machine) applyOptions(opts []Option) {
+	m.cfg = config{}
+	for _, opt := range opts {
+		opt(&m.cfg)
+	}
+}
+
+// Strict sets whether the machine stops on problems in the story that it could
+// otherwise work around.  When not strict, the default, the machine warns the
+// UI instead, if it is a Warner.
+func Strict(strict bool) Option {
+	return func(c *config) {
+		c.strict = strict
+	}
+}
+
+// UndoLevels sets how many states save_undo keeps.  The default is
+// DefaultUndoLevels.  Zero or less turns undo off, and the story is told it
+// isn't available.
+func UndoLevels(n int) Option {
+	return func(c *config) {
+		c.undoLevels = noneIfZero(n)
+	}
+}
+
+// UndoDropOldest sets what save_undo does once it has saved UndoLevels
+// states.  By default, drop is true: it drops the oldest state to make room,
+// so the story can always undo its latest turn.  With drop false, it saves
+// nothing more and stores 0, as the standard says it does when it can't save.
+// Stories that never restore_undo, or that undo only right after saving, then
+// find undo failing for good after UndoLevels turns.
+func UndoDropOldest(drop bool) Option {
+	return func(c *config) {
+		c.undoKeepOldest = !drop
+	}
+}
+
+// InstructionLimit stops the story after it executes n instructions since it
+// was loaded: Step returns a LimitReached TerminationError instead of
+// executing more.  Zero, the default, means no limit.
+func InstructionLimit(n int64) Option {
+	return func(c *config) {
+		c.instructionLimit = n
+	}
+}
+
+// Seed makes the random number generator start from seed n, instead of the
+// current time, whenever it's seeded: on load, on restart, and when the
+// story asks for random mode with random 0.  Runs with the same seed and the
+// same input produce the same output.
+func Seed(n int64) Option {
+	return func(c *config) {
+		c.seed, c.seeded = n, true
+	}
+}
+
+// DecodeCache sets the number of decoded strings the machine keeps for reuse.
+// Only strings in static or high memory are cached, since they can't change.
+// The default, 0, turns the cache off.
+func DecodeCache(entries int) Option {
+	return func(c *config) {
+		c.decodeCache = entries
+	}
+}
+
+// MaxStackDepth limits the number of frames on the call stack.  The default is
+// DefaultMaxStackDepth.  A limit of zero or less means no limit.
+func MaxStackDepth(n int) Option {
+	return func(c *config) {
+		c.maxStackDepth = noneIfZero(n)
+	}
+}
+
+// EventLogSize sets how many events the machine remembers.  The default is
+// DefaultEventLogSize.  Zero or less turns the log off.
+func EventLogSize(n int) Option {
+	return func(c *config) {
+		c.eventLogSize = noneIfZero(n)
+	}
+}
+
+// MaxStringLength limits the number of Z-characters decoded in one string or
+// abbreviation, so that printing from a bad address stops instead of decoding
+// all of memory.  The default is DefaultMaxStringLength.  A limit of zero or
+// less means no limit.
+func MaxStringLength(n int) Option {
+	return func(c *config) {
+		c.maxStringLength = noneIfZero(n)
+	}
+}
+
+// noneIfZero maps a setting of zero or less to -1, which config uses for
+// none, since its zero values are the defaults.
+func noneIfZero(n int) int {
+	if n <= 0 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,363 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestStrictOption(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("call_vs", zasm.Const(1), zasm.Store(zasm.SP)) // below static memory
+		b.Instr("quit")
+		m := buildMachine(t, b, new(bufferUI), Strict(strict))
+		if err := m.Step(); (err != nil) != strict {
+			t.Errorf("Strict(%t): Step() = %v", strict, err)
+		}
+	}
+}
+
+// undoStory returns a story that sets g0 to 1, saves undo state, sets g0 to
+// 5, and restores, printing g0 and save_undo's result each time save_undo
+// returns.
+func undoStory() *zasm.Builder {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("store", zasm.Const(0x10), zasm.Const(1))
+	b.Instr("save_undo", zasm.Store(zasm.Global(1)))
+	b.Instr("print_num", zasm.Global(0))
+	b.Instr("print_char", zasm.Const(' '))
+	b.Instr("print_num", zasm.Global(1))
+	b.Instr("new_line")
+	b.Instr("je", zasm.Global(1), zasm.Const(1), zasm.IfFalse("done"))
+	b.Instr("store", zasm.Const(0x10), zasm.Const(5))
+	b.Instr("restore_undo", zasm.Store(zasm.SP))
+	b.Instr("print_num", zasm.SP)
+	b.Instr("new_line")
+	b.Label("done")
+	b.Instr("quit")
+	return b
+}
+
+func TestUndoLevels(t *testing.T) {
+	tests := []struct {
+		Opts   []Option
+		Output string
+		Flag   bool
+	}{
+		{nil, "1 1\n1 2\n", true},
+		{[]Option{UndoLevels(1)}, "1 1\n1 2\n", true},
+		{[]Option{UndoLevels(0)}, "1 -1\n", false},
+	}
+	for _, tt := range tests {
+		img, err := undoStory().Build()
+		if err != nil {
+			t.Fatal("build story:", err)
+		}
+		// The story wants undo.
+		img[flags2Game] |= 1 << 4
+		ui := new(bufferUI)
+		m, err := NewMachine(bytes.NewReader(img), ui, tt.Opts...)
+		if err != nil {
+			t.Fatal("load story:", err)
+		}
+		if flag := m.loadByte(flags2Game)&(1<<4) != 0; flag != tt.Flag {
+			t.Errorf("%d options: undo flag = %t; want %t", len(tt.Opts), flag, tt.Flag)
+		}
+		if err := m.Run(); !errors.Is(err, ErrQuit) {
+			t.Errorf("%d options: Run() = %v; want %v", len(tt.Opts), err, ErrQuit)
+		}
+		if s := ui.String(); s != tt.Output {
+			t.Errorf("%d options: output = %q; want %q", len(tt.Opts), s, tt.Output)
+		}
+	}
+}
+
+func TestUndoDropsOldest(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("save_undo", zasm.Store(zasm.SP))
+	b.Instr("save_undo", zasm.Store(zasm.SP))
+	b.Instr("restore_undo", zasm.Store(zasm.SP))
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI), UndoLevels(1), UndoDropOldest(true))
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	// The first save is gone, so the restore returned to just after the
+	// second one.
+	if s := m.Frames()[0].Stack; len(s) != 2 || s[0] != 1 || s[1] != 2 {
+		t.Errorf("stack = %v; want [1 2]", s)
+	}
+	if len(m.undo) != 0 {
+		t.Errorf("%d undo states left; want 0", len(m.undo))
+	}
+	// The restore returned to restore_undo, which now has nothing to restore
+	// and stores 0.
+	if err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Frames()[0].Stack; len(s) != 3 || s[2] != 0 {
+		t.Errorf("stack = %v; want [1 2 0]", s)
+	}
+}
+
+func TestUndoDepth(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	for i := 0; i < 4; i++ {
+		b.Instr("save_undo", zasm.Store(zasm.SP))
+	}
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI), UndoLevels(2), UndoDropOldest(false))
+	if m.UndoAvailable() || m.UndoDepth() != 0 {
+		t.Errorf("before save_undo: UndoAvailable() = %t, UndoDepth() = %d; want false, 0", m.UndoAvailable(), m.UndoDepth())
+	}
+	for i := 1; i <= 4; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("save_undo %d: %v", i, err)
+		}
+		want := i
+		if want > 2 {
+			want = 2
+		}
+		if !m.UndoAvailable() || m.UndoDepth() != want {
+			t.Errorf("after save_undo %d: UndoAvailable() = %t, UndoDepth() = %d; want true, %d", i, m.UndoAvailable(), m.UndoDepth(), want)
+		}
+	}
+	// Once the stack is full, save_undo stores 0 if it keeps the oldest
+	// state.
+	if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, []Word{1, 1, 0, 0}) {
+		t.Errorf("stack = %v; want [1 1 0 0]", s)
+	}
+
+	// By default, it drops the oldest state to make room.
+	m = buildMachine(t, b, new(bufferUI), UndoLevels(2))
+	for i := 1; i <= 4; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("save_undo %d dropping oldest: %v", i, err)
+		}
+	}
+	if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, []Word{1, 1, 1, 1}) || m.UndoDepth() != 2 {
+		t.Errorf("dropping oldest: stack = %v, UndoDepth() = %d; want [1 1 1 1], 2", s, m.UndoDepth())
+	}
+
+	// With undo off, save_undo stores -1.
+	m = buildMachine(t, b, new(bufferUI), UndoLevels(0))
+	if err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Frames()[0].Stack; len(s) != 1 || s[0] != 0xffff || m.UndoAvailable() {
+		t.Errorf("undo off: stack = %v, UndoAvailable() = %t; want [0xffff], false", s, m.UndoAvailable())
+	}
+}
+
+func TestInstructionLimit(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Label("top")
+	b.Instr("inc", zasm.Const(0x10))
+	b.Instr("jump", zasm.Label("top"))
+	m := buildMachine(t, b, new(bufferUI), InstructionLimit(10))
+	err := m.Run()
+	var term *TerminationError
+	if !errors.As(err, &term) || term.Reason != LimitReached {
+		t.Fatalf("Run() = %v; want %v TerminationError", err, LimitReached)
+	}
+	if g := m.loadWord(m.globalAddress(0)); g != 5 {
+		t.Errorf("g0 = %d; want 5", g)
+	}
+	if n := m.Steps(); n != 10 {
+		t.Errorf("Steps() = %d; want 10", n)
+	}
+}
+
+func TestSeedOption(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 5; j++ {
+			b.Instr("random", zasm.Large(1000), zasm.Store(zasm.SP))
+			b.Instr("print_num", zasm.SP)
+			b.Instr("print_char", zasm.Const(' '))
+		}
+		b.Instr("random", zasm.Const(0), zasm.Store(zasm.SP))
+		b.Instr("new_line")
+	}
+	b.Instr("quit")
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		ui := new(bufferUI)
+		m := buildMachine(t, b, ui, Seed(42))
+		if err := m.Run(); !errors.Is(err, ErrQuit) {
+			t.Fatalf("Run() = %v; want %v", err, ErrQuit)
+		}
+		outputs = append(outputs, ui.String())
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("runs with the same seed printed %q and %q", outputs[0], outputs[1])
+	}
+	lines := bytes.Split([]byte(outputs[0]), []byte("\n"))
+	if len(lines) < 2 || !bytes.Equal(lines[0], lines[1]) {
+		t.Errorf("random 0 didn't restart the sequence: %q", outputs[0])
+	}
+}
+
+func TestDecodeCacheOption(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	if m := buildMachine(t, b, new(bufferUI)); m.strings != nil {
+		t.Error("cache is on by default")
+	}
+	m := buildMachine(t, b, new(bufferUI), DecodeCache(8))
+	if m.strings == nil || m.strings.size != 8 {
+		t.Error("DecodeCache(8) didn't make an 8-entry cache")
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI))
+	want := Config{
+		UndoLevels:      DefaultUndoLevels,
+		UndoDropOldest:  true,
+		MaxStackDepth:   DefaultMaxStackDepth,
+		EventLogSize:    DefaultEventLogSize,
+		MaxStringLength: DefaultMaxStringLength,
+	}
+	if c := m.Config(); c != want {
+		t.Errorf("Config() = %+v; want %+v", c, want)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI),
+		Strict(true),
+		UndoLevels(0),
+		UndoDropOldest(false),
+		InstructionLimit(100),
+		Seed(7),
+		DecodeCache(32),
+		MaxStackDepth(-5),
+		EventLogSize(16),
+		MaxStringLength(0),
+	)
+	want := Config{
+		Strict:           true,
+		InstructionLimit: 100,
+		Seed:             7,
+		Seeded:           true,
+		DecodeCache:      32,
+		EventLogSize:     16,
+	}
+	if c := m.Config(); c != want {
+		t.Errorf("Config() = %+v; want %+v", c, want)
+	}
+	if n := len(m.events.events); n != 16 {
+		t.Errorf("event log holds %d events before the first step; want 16", n)
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	m, err := NewMachine(bytes.NewReader(img), new(bufferUI), Strict(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Loading without options keeps the configuration, as on restart.
+	if err := m.Load(bytes.NewReader(img)); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Config().Strict {
+		t.Error("Load without options lost Strict")
+	}
+	// Options replace it.
+	if err := m.Load(bytes.NewReader(img), DecodeCache(4)); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.Config(); c.Strict || c.DecodeCache != 4 {
+		t.Errorf("after Load with DecodeCache(4), Config() = %+v", c)
+	}
+}
+
+func TestSettersAfterLoad(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI), DecodeCache(8))
+	m.SetStrict(true)
+	m.SetMaxStackDepth(3)
+	m.SetStringCache(0)
+	m.SetEventLogSize(0)
+	c := m.Config()
+	if !c.Strict || c.MaxStackDepth != 3 || c.DecodeCache != 0 || c.EventLogSize != 0 {
+		t.Errorf("after the setters, Config() = %+v; want Strict, MaxStackDepth 3, and no cache or event log", c)
+	}
+	if m.strings != nil {
+		t.Error("SetStringCache(0) kept the string cache")
+	}
+	if len(m.events.events) != 0 {
+		t.Errorf("SetEventLogSize(0) left room for %d events; want 0", len(m.events.events))
+	}
+
+	// Loading the story again goes back to the options.
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	if err := m.Load(bytes.NewReader(img), DecodeCache(8)); err != nil {
+		t.Fatal("Load:", err)
+	}
+	if c := m.Config(); c.Strict || c.DecodeCache != 8 || c.MaxStackDepth != DefaultMaxStackDepth {
+		t.Errorf("after Load, Config() = %+v; want the options' settings", c)
+	}
+}
+
+func TestSetRandSource(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("random", zasm.Large(1000), zasm.Store(zasm.SP))
+	b.Instr("random", zasm.Const(6), zasm.Store(zasm.SP))
+	b.Instr("random", zasm.Const(0), zasm.Store(zasm.SP))
+	b.Instr("random", zasm.Large(30000), zasm.Store(zasm.SP))
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI))
+	m.SetRandSource(rand.NewSource(99))
+	for i := 0; i < 4; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	// The same source gives the same numbers, even across random 0.
+	r := rand.New(rand.NewSource(99))
+	want := []Word{
+		Word(r.Uint32()%1000 + 1),
+		Word(r.Uint32()%6 + 1),
+		0,
+		Word(r.Uint32()%30000 + 1),
+	}
+	if s := m.Frames()[0].Stack; !reflect.DeepEqual(s, want) {
+		t.Errorf("random results = %v; want %v", s, want)
+	}
+}
@@ -0,0 +1,148 @@
+package north
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrPatchRelease is returned by ApplyPatch for a patch made for a different
+// release of the story.
+var ErrPatchRelease = errors.New("Patch is for a different release")
+
+// A Patch is a list of byte changes to one release of a story file, like the
+// bug fixes that are passed around for old Infocom games.
+//
+// As text, a patch has a release line, an optional serial line, and a line
+// for each changed byte giving its hex address, old value, and new value:
+//
+//	# Fix the stuck lantern.
+//	release 88
+//	serial 840726
+//	4f2a: 00 01
+type Patch struct {
+	Release Word
+	// Serial is the six-character serial number of the release.  If it's
+	// empty, any serial number matches.
+	Serial  string
+	Changes []PatchByte
+}
+
+// A PatchByte is a change to the byte at Address from Old to New.
+type PatchByte struct {
+	Address  Address
+	Old, New byte
+}
+
+// ParsePatch reads a patch in the text format.  Blank lines and lines
+// starting with '#' are ignored.
+func ParsePatch(r io.Reader) (Patch, error) {
+	var p Patch
+	hasRelease := false
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		switch {
+		case f[0] == "release" && len(f) == 2:
+			r, err := strconv.ParseUint(f[1], 10, 16)
+			if err != nil {
+				return Patch{}, fmt.Errorf("patch line %d: bad release %q", n, f[1])
+			}
+			p.Release, hasRelease = Word(r), true
+		case f[0] == "serial" && len(f) == 2:
+			if len(f[1]) != 6 {
+				return Patch{}, fmt.Errorf("patch line %d: serial %q isn't six characters", n, f[1])
+			}
+			p.Serial = f[1]
+		case len(f) == 3 && strings.HasSuffix(f[0], ":"):
+			a, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSuffix(f[0], ":"), "0x"), 16, 32)
+			if err != nil {
+				return Patch{}, fmt.Errorf("patch line %d: bad address %q", n, f[0])
+			}
+			ob, err1 := strconv.ParseUint(f[1], 16, 8)
+			nb, err2 := strconv.ParseUint(f[2], 16, 8)
+			if err1 != nil || err2 != nil {
+				return Patch{}, fmt.Errorf("patch line %d: bad bytes %q", n, line)
+			}
+			p.Changes = append(p.Changes, PatchByte{Address(a), byte(ob), byte(nb)})
+		default:
+			return Patch{}, fmt.Errorf("patch line %d: can't parse %q", n, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return Patch{}, err
+	}
+	if !hasRelease {
+		return Patch{}, errors.New("patch has no release line")
+	}
+	return p, nil
+}
+
+// String returns the patch in the text format read by ParsePatch.
+func (p Patch) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "release %d\n", p.Release)
+	if p.Serial != "" {
+		fmt.Fprintf(&sb, "serial %s\n", p.Serial)
+	}
+	for _, c := range p.Changes {
+		fmt.Fprintf(&sb, "%04x: %02x %02x\n", uint32(c.Address), c.Old, c.New)
+	}
+	return sb.String()
+}
+
+// GeneratePatch returns the patch that turns the story file original into
+// modified, guarded by original's release and serial number.  Bytes past the
+// end of the shorter file are ignored, since a patch can't change a story's
+// length.
+func GeneratePatch(original, modified []byte) Patch {
+	var p Patch
+	if len(original) >= 0x18 {
+		p.Release = Word(original[0x2])<<8 | Word(original[0x3])
+		p.Serial = string(original[0x12:0x18])
+	}
+	n := len(original)
+	if len(modified) < n {
+		n = len(modified)
+	}
+	for i := 0; i < n; i++ {
+		if original[i] != modified[i] {
+			p.Changes = append(p.Changes, PatchByte{Address(i), original[i], modified[i]})
+		}
+	}
+	return p
+}
+
+// ApplyPatch changes m's memory as p says.  It checks the release, the serial
+// number, and every old byte before writing anything, so a patch that doesn't
+// match leaves m as it was.
+//
+// Patches are the only way to write to static and high memory: they're meant
+// to be applied after a story is loaded and before its first instruction.
+// The story's checksum is left alone, so verify reports the file as loaded.
+func ApplyPatch(m *Machine, p Patch) error {
+	if m.release() != p.Release || (p.Serial != "" && m.serial() != p.Serial) {
+		return fmt.Errorf("%w: story is release %d serial %s, patch is for release %d serial %s", ErrPatchRelease, m.release(), m.serial(), p.Release, p.Serial)
+	}
+	for _, c := range p.Changes {
+		if int(c.Address) >= m.storyLength {
+			return fmt.Errorf("Patch address %v is past the end of the story", c.Address)
+		}
+		if b := m.memory[c.Address]; b != c.Old {
+			return fmt.Errorf("Patch expects %#02x at %v; found %#02x", c.Old, c.Address, b)
+		}
+	}
+	for _, c := range p.Changes {
+		m.storeByte(c.Address, c.New)
+	}
+	// Decoded strings may have been patched.
+	m.resetStringCache()
+	return nil
+}
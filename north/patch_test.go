@@ -0,0 +1,119 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// patchStory returns a small story with release 88, serial 840726.
+func patchStory(t *testing.T) []byte {
+	b := zasm.New(3)
+	b.Release, b.Serial = 88, "840726"
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("Broken"))
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	return img
+}
+
+func TestParsePatch(t *testing.T) {
+	const text = "# A fix.\nrelease 88\nserial 840726\n\n0x4f2a: 00 01\n4f2b: ff 7e\n"
+	p, err := ParsePatch(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Patch{
+		Release: 88,
+		Serial:  "840726",
+		Changes: []PatchByte{{0x4f2a, 0x00, 0x01}, {0x4f2b, 0xff, 0x7e}},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("ParsePatch(%q) = %+v; want %+v", text, p, want)
+	}
+
+	for _, bad := range []string{
+		"4f2a: 00 01\n",
+		"release 88\n4f2a: 00\n",
+		"release 88\n4f2a: 00 100\n",
+		"release 88\nserial 84\n",
+		"release x\n",
+	} {
+		if _, err := ParsePatch(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParsePatch(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestApplyPatchGuards(t *testing.T) {
+	img := patchStory(t)
+	a := Address(len(img) - 1)
+	tests := []struct {
+		Name  string
+		Patch Patch
+		Err   error
+	}{
+		{"release", Patch{Release: 87, Changes: []PatchByte{{a, img[a], 0}}}, ErrPatchRelease},
+		{"serial", Patch{Release: 88, Serial: "840725", Changes: []PatchByte{{a, img[a], 0}}}, ErrPatchRelease},
+		{"old byte", Patch{Release: 88, Changes: []PatchByte{{a - 1, img[a-1], 0}, {a, img[a] + 1, 0}}}, nil},
+		{"past end", Patch{Release: 88, Changes: []PatchByte{{Address(len(img)), 0, 1}}}, nil},
+	}
+	for _, tt := range tests {
+		m, err := NewMachineFromBytes(img, new(bufferUI))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := append([]byte(nil), m.memory...)
+		err = ApplyPatch(m, tt.Patch)
+		if err == nil {
+			t.Errorf("%s: ApplyPatch succeeded", tt.Name)
+			continue
+		}
+		if tt.Err != nil && !errors.Is(err, tt.Err) {
+			t.Errorf("%s: ApplyPatch = %v; want %v", tt.Name, err, tt.Err)
+		}
+		if !bytes.Equal(m.memory, before) {
+			t.Errorf("%s: memory changed by failed patch", tt.Name)
+		}
+	}
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	img := patchStory(t)
+	fixed := append([]byte(nil), img...)
+	// Patch the message in high memory and a byte of dynamic memory.
+	m, err := NewMachineFromBytes(img, new(bufferUI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := m.PC()
+	fixed[pc+1] ^= 0x04
+	fixed[0x40] ^= 0xff
+
+	p := GeneratePatch(img, fixed)
+	if len(p.Changes) != 2 || p.Release != 88 || p.Serial != "840726" {
+		t.Fatalf("GeneratePatch = %+v", p)
+	}
+	p, err = ParsePatch(strings.NewReader(p.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyPatch(m, p); err != nil {
+		t.Fatal("ApplyPatch:", err)
+	}
+	for _, a := range []Address{pc + 1, 0x40} {
+		if m.memory[a] != fixed[a] {
+			t.Errorf("memory[%v] = %#02x after patch; want %#02x", a, m.memory[a], fixed[a])
+		}
+	}
+	if !m.verify() {
+		t.Error("verify fails after patch; want checksum of the file as loaded")
+	}
+}
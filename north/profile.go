@@ -0,0 +1,64 @@
+package north
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A stackProfile counts the call stacks seen by sampling.
+type stackProfile struct {
+	every  int
+	counts map[string]int64
+}
+
+// sample counts the call stack, weighted by the number of instructions since
+// the last sample.
+func (p *stackProfile) sample(stack []stackFrame) {
+	var sb strings.Builder
+	for i, f := range stack {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		if f.Routine == 0 {
+			// The main frame of versions 1-5 isn't a routine.
+			sb.WriteString("main")
+		} else {
+			sb.WriteString(f.Routine.String())
+		}
+	}
+	p.counts[sb.String()] += int64(p.every)
+}
+
+// EnableStackProfile samples the call stack every sampleEvery instructions,
+// for WriteFoldedStacks, and discards any earlier samples.  A sampleEvery of
+// zero or less turns the profile off.
+func (m *Machine) EnableStackProfile(sampleEvery int) {
+	if sampleEvery <= 0 {
+		m.profile = nil
+		return
+	}
+	m.profile = &stackProfile{every: sampleEvery, counts: make(map[string]int64)}
+}
+
+// WriteFoldedStacks writes the stack profile in the folded format read by
+// flamegraph.pl and speedscope.  Each line is a call stack, from the main
+// routine to the innermost routine with addresses separated by semicolons,
+// followed by the number of instructions executed in it.
+func (m *Machine) WriteFoldedStacks(w io.Writer) error {
+	if m.profile == nil {
+		return nil
+	}
+	stacks := make([]string, 0, len(m.profile.counts))
+	for s := range m.profile.counts {
+		stacks = append(stacks, s)
+	}
+	sort.Strings(stacks)
+	for _, s := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", s, m.profile.counts[s]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package north
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestStackProfile(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("call_vn", zasm.Routine("outer"))
+	b.Instr("quit")
+	b.Routine("outer", 0)
+	b.Instr("call_vn", zasm.Routine("inner"))
+	b.Instr("rtrue")
+	b.Routine("inner", 0)
+	b.Instr("add", zasm.Const(1), zasm.Const(2), zasm.Store(zasm.Var(0)))
+	b.Instr("add", zasm.Const(3), zasm.Const(4), zasm.Store(zasm.Var(0)))
+	b.Instr("rtrue")
+	m := buildMachine(t, b, new(bufferUI))
+	m.EnableStackProfile(1)
+
+	var outer, inner Address
+	for i := 0; i < 20; i++ {
+		if err := m.Step(); err != nil {
+			break
+		}
+		switch f := m.Frames(); len(f) {
+		case 2:
+			outer = f[1].Routine
+		case 3:
+			inner = f[2].Routine
+		}
+	}
+	var buf bytes.Buffer
+	if err := m.WriteFoldedStacks(&buf); err != nil {
+		t.Fatal("WriteFoldedStacks:", err)
+	}
+	want := fmt.Sprintf("main 2\nmain;%v 2\nmain;%v;%v 3\n", outer, outer, inner)
+	if s := buf.String(); s != want {
+		t.Errorf("folded stacks:\n%s\nwant:\n%s", s, want)
+	}
+
+	m.EnableStackProfile(0)
+	buf.Reset()
+	if err := m.WriteFoldedStacks(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("WriteFoldedStacks with profile off = %q, %v; want \"\", <nil>", buf.String(), err)
+	}
+}
@@ -0,0 +1,155 @@
+package north
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A PropertyTable is an object's property block in editable form.  The
+// Z-machine never resizes properties, but tools that patch stories do.
+type PropertyTable struct {
+	// Name is the encoded short name, a whole number of words.
+	Name []byte
+
+	// Properties are kept in descending order by number, as stored.
+	Properties []PropertyEntry
+}
+
+// ParsePropertyTable reads the property block at a in a story of the given
+// version.
+func ParsePropertyTable(mem []byte, a Address, version byte) (*PropertyTable, error) {
+	pt, _, err := parsePropertyTable(mem, a, version)
+	return pt, err
+}
+
+// parsePropertyTable is ParsePropertyTable that also returns the address
+// just past the table's terminating zero.
+func parsePropertyTable(mem []byte, a Address, version byte) (*PropertyTable, Address, error) {
+	if int(a) >= len(mem) {
+		return nil, 0, fmt.Errorf("Property table at %v is outside memory", a)
+	}
+	pt := new(PropertyTable)
+	end := a + 1 + Address(mem[a])*2
+	if int(end) > len(mem) {
+		return nil, 0, fmt.Errorf("Property table at %v: name runs past end of memory", a)
+	}
+	pt.Name = append([]byte(nil), mem[a+1:end]...)
+	for a = end; ; {
+		if int(a) >= len(mem) {
+			return nil, 0, fmt.Errorf("Property table runs past end of memory")
+		}
+		var n uint8
+		var size int
+		switch {
+		case mem[a] == 0:
+			return pt, a + 1, nil
+		case version <= 3:
+			n, size = mem[a]&0x1f, int(mem[a]>>5)+1
+			a++
+		case mem[a]&0x80 == 0:
+			n, size = mem[a]&0x3f, int(mem[a]>>6&1)+1
+			a++
+		default:
+			if int(a)+1 >= len(mem) {
+				return nil, 0, fmt.Errorf("Property table runs past end of memory")
+			}
+			n, size = mem[a]&0x3f, int(mem[a+1]&0x3f)
+			if size == 0 {
+				// Standard 12.4.2.1.1: 0 should be interpreted as 64
+				size = 64
+			}
+			a += 2
+		}
+		if int(a)+size > len(mem) {
+			return nil, 0, fmt.Errorf("Property %d at %v runs past end of memory", n, a)
+		}
+		pt.Properties = append(pt.Properties, PropertyEntry{n, append([]byte(nil), mem[a:a+Address(size)]...)})
+		a += Address(size)
+	}
+}
+
+// Get returns the data of property n, or nil if the table doesn't have it.
+func (pt *PropertyTable) Get(n uint8) []byte {
+	for _, p := range pt.Properties {
+		if p.Number == n {
+			return p.Data
+		}
+	}
+	return nil
+}
+
+// Set adds property n or replaces its data.
+func (pt *PropertyTable) Set(n uint8, data []byte) {
+	for i := range pt.Properties {
+		if pt.Properties[i].Number == n {
+			pt.Properties[i].Data = data
+			return
+		}
+	}
+	pt.Properties = append(pt.Properties, PropertyEntry{n, data})
+	sort.Slice(pt.Properties, func(i, j int) bool { return pt.Properties[i].Number > pt.Properties[j].Number })
+}
+
+// Remove deletes property n.  It reports whether the table had it.
+func (pt *PropertyTable) Remove(n uint8) bool {
+	for i := range pt.Properties {
+		if pt.Properties[i].Number == n {
+			pt.Properties = append(pt.Properties[:i], pt.Properties[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Encode returns the property block for a story of the given version.
+func (pt *PropertyTable) Encode(version byte) ([]byte, error) {
+	if len(pt.Name)%2 != 0 || len(pt.Name) > 255*2 {
+		return nil, fmt.Errorf("Property table name is %d bytes", len(pt.Name))
+	}
+	b := append([]byte{byte(len(pt.Name) / 2)}, pt.Name...)
+	for i, p := range pt.Properties {
+		if i > 0 && pt.Properties[i-1].Number <= p.Number {
+			return nil, fmt.Errorf("Property %d out of order", p.Number)
+		}
+		size := len(p.Data)
+		switch {
+		case p.Number == 0:
+			return nil, fmt.Errorf("Property 0 is not allowed")
+		case version <= 3:
+			if p.Number > 31 || size < 1 || size > 8 {
+				return nil, fmt.Errorf("Property %d with %d bytes is out of range for version %d", p.Number, size, version)
+			}
+			b = append(b, byte(size-1)<<5|p.Number)
+		case p.Number > 63 || size < 1 || size > 64:
+			return nil, fmt.Errorf("Property %d with %d bytes is out of range for version %d", p.Number, size, version)
+		case size <= 2:
+			b = append(b, byte(size-1)<<6|p.Number)
+		default:
+			b = append(b, 0x80|p.Number, 0x80|byte(size&0x3f))
+		}
+		b = append(b, p.Data...)
+	}
+	return append(b, 0), nil
+}
+
+// PropertyTable returns the property block of object i (1-based).
+func (m *Machine) PropertyTable(i Word) (*PropertyTable, error) {
+	return ParsePropertyTable(m.memory, m.loadObject(i).PropertyBase, m.Version())
+}
+
+// RelocateProperties writes pt to dynamic memory at a and points object i
+// (1-based) at it.  The caller must make sure the space is free.
+func (m *Machine) RelocateProperties(i Word, a Address, pt *PropertyTable) error {
+	b, err := pt.Encode(m.Version())
+	if err != nil {
+		return err
+	}
+	if a < headerSize || a+Address(len(b)) > m.staticMemoryBase() {
+		return fmt.Errorf("Property table at %v with %d bytes is not in dynamic memory", a, len(b))
+	}
+	m.storeBytes(a, b)
+	o := m.loadObject(i)
+	o.PropertyBase = a
+	m.storeObject(i, o)
+	return nil
+}
@@ -0,0 +1,121 @@
+package north
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func propTableStory(version byte) *zasm.Builder {
+	big := make([]byte, 64)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	props := []zasm.Property{zasm.WordProp(20, 0x1234), zasm.Prop(12, 1, 2, 3), zasm.Prop(5, 0x42)}
+	if version >= 4 {
+		props = append(props, zasm.Prop(40, big...), zasm.Prop(33, 9, 8, 7))
+	} else {
+		props = append(props, zasm.Prop(30, big[:8]...))
+	}
+	b := zasm.New(version)
+	b.Routine("main", 0)
+	b.Instr("get_prop", zasm.Obj("lamp"), zasm.Const(7), zasm.Store(zasm.Global(0)))
+	b.Instr("get_prop", zasm.Obj("lamp"), zasm.Const(20), zasm.Store(zasm.Global(1)))
+	b.Instr("quit")
+	b.Object("lamp", "", nil, props...)
+	b.Data("free", make([]byte, 128))
+	return b
+}
+
+func TestPropertyTableRoundTrip(t *testing.T) {
+	for _, version := range []byte{3, 5} {
+		m := buildMachine(t, propTableStory(version), new(bufferUI))
+		base := m.loadObject(1).PropertyBase
+		pt, err := m.PropertyTable(1)
+		if err != nil {
+			t.Errorf("v%d: PropertyTable: %v", version, err)
+			continue
+		}
+		list, _ := m.PropertyList(1)
+		if len(pt.Properties) != len(list) {
+			t.Errorf("v%d: len(pt.Properties) != %d (got %d)", version, len(list), len(pt.Properties))
+		}
+		for i := range list {
+			if i < len(pt.Properties) && (pt.Properties[i].Number != list[i].Number || !bytes.Equal(pt.Properties[i].Data, list[i].Data)) {
+				t.Errorf("v%d: property %d = %v; want %v", version, i, pt.Properties[i], list[i])
+			}
+		}
+		b, err := pt.Encode(version)
+		if err != nil {
+			t.Errorf("v%d: Encode: %v", version, err)
+		} else if orig := m.memory[base : base+Address(len(b))]; !bytes.Equal(b, orig) {
+			t.Errorf("v%d: Encode = % x; want % x", version, b, orig)
+		}
+	}
+}
+
+func TestRelocateProperties(t *testing.T) {
+	for _, version := range []byte{3, 5} {
+		b := propTableStory(version)
+		img, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewMachine(bytes.NewReader(img), new(bufferUI))
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := m.loadObject(1).FetchName(m)
+		pt, err := m.PropertyTable(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt.Set(7, []byte{0xbe, 0xef})
+		pt.Set(20, []byte{0x56, 0x78})
+		if !pt.Remove(12) || pt.Remove(12) {
+			t.Errorf("v%d: Remove(12) twice didn't report true then false", version)
+		}
+		free, _ := b.DataAddress("free")
+		if err := m.RelocateProperties(1, Address(free), pt); err != nil {
+			t.Fatalf("v%d: RelocateProperties: %v", version, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("v%d: step %d: %v", version, i, err)
+			}
+		}
+		if w := m.Variable(0x10); w != 0xbeef {
+			t.Errorf("v%d: get_prop 7 != 0xbeef (got %v)", version, w)
+		}
+		if w := m.Variable(0x11); w != 0x5678 {
+			t.Errorf("v%d: get_prop 20 != 0x5678 (got %v)", version, w)
+		}
+		if p, _ := m.loadObject(1).Property(m, 12); p != nil {
+			t.Errorf("v%d: removed property 12 = % x", version, p)
+		}
+		if s, _ := m.loadObject(1).FetchName(m); s != name {
+			t.Errorf("v%d: name after relocating = %q; want %q", version, s, name)
+		}
+	}
+}
+
+func TestPropertyTableEncodeErrors(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Props   []PropertyEntry
+	}{
+		{3, []PropertyEntry{{32, []byte{1}}}},
+		{3, []PropertyEntry{{1, make([]byte, 9)}}},
+		{5, []PropertyEntry{{1, make([]byte, 65)}}},
+		{5, []PropertyEntry{{64, []byte{1}}}},
+		{5, []PropertyEntry{{1, nil}}},
+		{5, []PropertyEntry{{1, []byte{1}}, {2, []byte{1}}}},
+	}
+	for _, tt := range tests {
+		pt := &PropertyTable{Properties: tt.Props}
+		if _, err := pt.Encode(tt.Version); err == nil {
+			t.Errorf("v%d: Encode(%v) succeeded", tt.Version, tt.Props)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package north
+
+import "errors"
+
+// A Snapshot is a copy of a machine's state held in memory: the same state
+// that SaveState encodes, without the encoding.  Taking one between
+// instructions, such as while StepUntilInput waits for input, is safe, and
+// a snapshot never changes once it's taken.  Input submitted with
+// SubmitInput but not yet read is not part of the state.
+type Snapshot struct {
+	ifid  string
+	state savedState
+}
+
+// Snapshot returns a copy of the machine's current state.
+func (m *Machine) Snapshot() *Snapshot {
+	return &Snapshot{
+		ifid: m.IFID(),
+		state: savedState{
+			Memory:  m.dynamicDiff(),
+			Stack:   copyStack(m.stack),
+			Streams: m.streams,
+			RTables: append([]rtable(nil), m.rtables...),
+		},
+	}
+}
+
+// Size returns about how many bytes the snapshot holds, so that callers
+// keeping many of them can bound the memory they use.
+func (s *Snapshot) Size() int {
+	n := len(s.state.Memory) + len(s.state.RTables)*4
+	for _, f := range s.state.Stack {
+		n += 16 + 2*(len(f.Locals)+len(f.Stack))
+	}
+	return n
+}
+
+// RestoreSnapshot returns the machine to the state in s, which must have
+// been taken from a machine running the same story.  Like RestoreState, it
+// keeps the transcript and fixed-pitch bits of Flags 2.  s can be restored
+// any number of times.
+func (m *Machine) RestoreSnapshot(s *Snapshot) error {
+	if s.ifid != m.IFID() {
+		return errors.New("Snapshot is of a different story")
+	}
+	if err := m.checkState(&s.state); err != nil {
+		return err
+	}
+	if err := m.applyDynamicDiff(s.state.Memory); err != nil {
+		return err
+	}
+	m.stack = copyStack(s.state.Stack)
+	m.rtables = append(m.rtables[:0], s.state.RTables...)
+	m.streams = s.state.Streams
+	m.flags2Changed()
+	return nil
+}
+
+// copyStack returns a copy of stack that shares no memory with it.
+func copyStack(stack []stackFrame) []stackFrame {
+	c := make([]stackFrame, len(stack))
+	for i, f := range stack {
+		f.Locals = append([]Word(nil), f.Locals...)
+		f.Stack = append([]Word(nil), f.Stack...)
+		c[i] = f
+	}
+	return c
+}
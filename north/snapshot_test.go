@@ -0,0 +1,91 @@
+package north
+
+import (
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// countingStory adds one to g0 and reads a line, forever.  It keeps a value
+// on the routine's stack so that snapshots have a stack to copy.
+func countingStory() *zasm.Builder {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("push", zasm.Const(7))
+	b.Label("loop")
+	b.Instr("inc", zasm.Const(0x10))
+	b.Instr("aread", zasm.Addr("text"), zasm.Addr("parse"), zasm.Store(zasm.Global(1)))
+	b.Instr("jump", zasm.Label("loop"))
+	b.Data("text", append([]byte{20}, make([]byte, 21)...))
+	b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+	return b
+}
+
+// turn submits a line and runs m to its next prompt.
+func turn(t *testing.T, m *Machine, line string) {
+	t.Helper()
+	m.SubmitInput(line)
+	if _, err := m.StepUntilInput(); err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	b := countingStory()
+	m := buildMachine(t, b, new(scriptUI))
+	if _, err := m.StepUntilInput(); err != nil {
+		t.Fatal("StepUntilInput:", err)
+	}
+	snap := m.Snapshot()
+	if snap.Size() <= 0 {
+		t.Errorf("Size() = %d; want > 0", snap.Size())
+	}
+	turn(t, m, "one")
+	turn(t, m, "two")
+	if g := m.Variable(0x10); g != 3 {
+		t.Fatalf("g0 after two turns = %d; want 3", g)
+	}
+
+	// A snapshot can be restored more than once, and the turns after it
+	// don't change it.
+	for i := 0; i < 2; i++ {
+		if err := m.RestoreSnapshot(snap); err != nil {
+			t.Fatal("RestoreSnapshot:", err)
+		}
+		if g := m.Variable(0x10); g != 1 {
+			t.Errorf("restore %d: g0 = %d; want 1", i, g)
+		}
+		if d := m.StackDepth(); d != 1 {
+			t.Errorf("restore %d: stack depth = %d; want 1", i, d)
+		}
+		turn(t, m, "again")
+		if g := m.Variable(0x10); g != 2 {
+			t.Errorf("restore %d: g0 after a turn = %d; want 2", i, g)
+		}
+		m.stack[0].Stack[0] = 99
+	}
+
+	// Another machine running the same story can take the snapshot.
+	m2 := buildMachine(t, b, new(scriptUI))
+	if err := m2.RestoreSnapshot(snap); err != nil {
+		t.Fatal("RestoreSnapshot on a second machine:", err)
+	}
+	if g := m2.Variable(0x10); g != 1 {
+		t.Errorf("second machine: g0 = %d; want 1", g)
+	}
+	if s := m2.stack[0].Stack; len(s) != 1 || s[0] != 7 {
+		t.Errorf("second machine: stack = %v; want [7]", s)
+	}
+}
+
+func TestSnapshotOtherStory(t *testing.T) {
+	m := buildMachine(t, countingStory(), new(scriptUI))
+	other := zasm.New(5)
+	other.Release = 2
+	other.Routine("main", 0)
+	other.Instr("quit")
+	m2 := buildMachine(t, other, new(scriptUI))
+	if err := m2.RestoreSnapshot(m.Snapshot()); err == nil {
+		t.Error("RestoreSnapshot from another story succeeded")
+	}
+}
@@ -0,0 +1,80 @@
+package north
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stack limits
+const (
+	// DefaultMaxStackDepth is the number of frames a machine allows unless
+	// the MaxStackDepth option says otherwise.
+	DefaultMaxStackDepth = 1024
+
+	// MaxEvalStack is the number of words a routine may have on its
+	// evaluation stack.
+	MaxEvalStack = 1024
+)
+
+// A StackOverflowError is returned by Step when a story calls too many
+// routines without returning, or pushes too many words in one routine.  It
+// lists the innermost routines to help find the runaway code.
+type StackOverflowError struct {
+	// Eval is true if the evaluation stack overflowed, rather than the call
+	// stack.
+	Eval bool
+
+	// Limit is the limit that was reached.
+	Limit int
+
+	// Routines are the addresses of the innermost routines on the call
+	// stack, innermost first.  At most 10 are listed.
+	Routines []Address
+}
+
+func (e *StackOverflowError) Error() string {
+	var sb strings.Builder
+	if e.Eval {
+		fmt.Fprintf(&sb, "Evaluation stack overflow (%d words)", e.Limit)
+	} else {
+		fmt.Fprintf(&sb, "Call stack overflow (%d frames)", e.Limit)
+	}
+	sb.WriteString("; innermost routines:")
+	for _, a := range e.Routines {
+		fmt.Fprintf(&sb, " %v", a)
+	}
+	return sb.String()
+}
+
+// stackOverflow returns a StackOverflowError for the current stack.
+func (m *Machine) stackOverflow(eval bool) error {
+	e := &StackOverflowError{Eval: eval, Limit: m.maxStackDepth()}
+	if eval {
+		e.Limit = MaxEvalStack
+	}
+	for i := len(m.stack) - 1; i >= 0 && len(e.Routines) < 10; i-- {
+		e.Routines = append(e.Routines, m.stack[i].Routine)
+	}
+	return e
+}
+
+// SetMaxStackDepth changes the MaxStackDepth setting between steps.  A limit
+// of zero or less means no limit.  Frames already on the stack are kept even
+// if there are more of them than the new limit.
+func (m *Machine) SetMaxStackDepth(n int) {
+	MaxStackDepth(n)(&m.cfg)
+}
+
+// maxStackDepth returns the frame limit, or -1 for no limit.
+func (m *Machine) maxStackDepth() int {
+	if m.cfg.maxStackDepth == 0 {
+		return DefaultMaxStackDepth
+	}
+	return m.cfg.maxStackDepth
+}
+
+// MaxStackDepthSeen returns the most frames that have been on the call stack
+// since the story was loaded.
+func (m *Machine) MaxStackDepthSeen() int {
+	return m.maxDepthSeen
+}
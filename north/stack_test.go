@@ -0,0 +1,84 @@
+package north
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestCallStackOverflow(t *testing.T) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Op(zasm.VAR, 0x19, zasm.Routine("rec")) // call_vn
+	zb.Op(zasm.OP0, 0x0a)                      // quit
+	zb.Routine("rec", 0)
+	zb.Op(zasm.VAR, 0x19, zasm.Routine("rec")) // call_vn
+	zb.Op(zasm.OP0, 0x00)                      // rtrue
+	m := buildMachine(t, zb, new(bufferUI), MaxStackDepth(20))
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = m.Step()
+	}
+	var serr *StackOverflowError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Step() = %v; want StackOverflowError", err)
+	}
+	if serr.Eval || serr.Limit != 20 {
+		t.Errorf("Eval, Limit = %t, %d; want false, 20", serr.Eval, serr.Limit)
+	}
+	if len(serr.Routines) != 10 {
+		t.Fatalf("len(Routines) = %d; want 10", len(serr.Routines))
+	}
+	rec := m.Frames()[19].Routine
+	for i, a := range serr.Routines {
+		if a != rec {
+			t.Errorf("Routines[%d] = %v; want %v", i, a, rec)
+		}
+	}
+	if n := m.MaxStackDepthSeen(); n != 20 {
+		t.Errorf("MaxStackDepthSeen() = %d; want 20", n)
+	}
+	if n := m.StackDepth(); n != 20 {
+		t.Errorf("StackDepth() = %d; want 20", n)
+	}
+}
+
+func TestEvalStackOverflow(t *testing.T) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Label("top")
+	zb.Op(zasm.VAR, 0x08, zasm.Const(1))     // push
+	zb.Op(zasm.OP1, 0x0c, zasm.Label("top")) // jump
+	m := buildMachine(t, zb, new(bufferUI))
+
+	var err error
+	for i := 0; i < 3*MaxEvalStack && err == nil; i++ {
+		err = m.Step()
+	}
+	var serr *StackOverflowError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Step() = %v; want StackOverflowError", err)
+	}
+	if !serr.Eval || serr.Limit != MaxEvalStack {
+		t.Errorf("Eval, Limit = %t, %d; want true, %d", serr.Eval, serr.Limit, MaxEvalStack)
+	}
+}
+
+func TestUnlimitedStackDepth(t *testing.T) {
+	zb := zasm.New(5)
+	zb.Routine("main", 0)
+	zb.Op(zasm.VAR, 0x19, zasm.Routine("rec")) // call_vn
+	zb.Routine("rec", 0)
+	zb.Op(zasm.VAR, 0x19, zasm.Routine("rec")) // call_vn
+	m := buildMachine(t, zb, new(bufferUI), MaxStackDepth(0))
+	for i := 0; i < DefaultMaxStackDepth+10; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("Step #%d: %v", i, err)
+		}
+	}
+	if n := m.MaxStackDepthSeen(); n != DefaultMaxStackDepth+11 {
+		t.Errorf("MaxStackDepthSeen() = %d; want %d", n, DefaultMaxStackDepth+11)
+	}
+}
@@ -0,0 +1,92 @@
+package north
+
+import (
+	"fmt"
+)
+
+// A StopReason says why a story stopped running without a fault.
+type StopReason int
+
+// Stop reasons
+const (
+	// Quit means the story executed quit.
+	Quit StopReason = 1 + iota
+	// Restart means the story executed restart.
+	Restart
+	// EndOfMain means the story returned from its main routine.
+	EndOfMain
+	// InputClosed means the story asked for input after the UI ran out.
+	// The read can be retried by stepping again.
+	InputClosed
+	// Canceled means the run's context was done.
+	Canceled
+	// LimitReached means the story executed as many instructions as the
+	// InstructionLimit option allows.
+	LimitReached
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case Quit:
+		return "quit"
+	case Restart:
+		return "restart"
+	case EndOfMain:
+		return "end of main"
+	case InputClosed:
+		return "input closed"
+	case Canceled:
+		return "canceled"
+	case LimitReached:
+		return "instruction limit reached"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// A TerminationError is returned by Step and Run when the story stops for a
+// reason other than a fault.  Faults, like bad instructions or UI failures,
+// are returned as other errors.
+//
+// errors.Is matches a TerminationError against ErrQuit, ErrRestart,
+// ErrReturnFromMain, or ErrInputClosed, according to its reason, and against
+// the context's error if it was canceled.
+type TerminationError struct {
+	Reason StopReason
+
+	// Err is the context's error when Reason is Canceled.
+	Err error
+}
+
+func (e *TerminationError) Error() string {
+	if s := e.sentinel(); s != nil {
+		return s.Error()
+	}
+	if e.Err != nil {
+		return "Z-machine stopped: " + e.Err.Error()
+	}
+	return "Z-machine stopped: " + e.Reason.String()
+}
+
+// Is reports whether target is the sentinel error for e's reason.
+func (e *TerminationError) Is(target error) bool {
+	return target != nil && target == e.sentinel()
+}
+
+// Unwrap returns the context's error for a canceled run.
+func (e *TerminationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TerminationError) sentinel() error {
+	switch e.Reason {
+	case Quit:
+		return ErrQuit
+	case Restart:
+		return ErrRestart
+	case EndOfMain:
+		return ErrReturnFromMain
+	case InputClosed:
+		return ErrInputClosed
+	}
+	return nil
+}
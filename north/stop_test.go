@@ -0,0 +1,92 @@
+package north
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestTerminationErrorIs(t *testing.T) {
+	sentinels := []error{ErrQuit, ErrRestart, ErrReturnFromMain, ErrInputClosed, ErrNoFrame, context.Canceled}
+	tests := []struct {
+		Err   error
+		Match error
+	}{
+		{&TerminationError{Reason: Quit}, ErrQuit},
+		{&TerminationError{Reason: Restart}, ErrRestart},
+		{&TerminationError{Reason: EndOfMain}, ErrReturnFromMain},
+		{&TerminationError{Reason: InputClosed}, ErrInputClosed},
+		{&TerminationError{Reason: Canceled, Err: context.Canceled}, context.Canceled},
+	}
+	for _, tt := range tests {
+		for _, s := range sentinels {
+			if is := errors.Is(tt.Err, s); is != (s == tt.Match) {
+				t.Errorf("errors.Is(%v, %v) = %t", tt.Err, s, is)
+			}
+		}
+	}
+}
+
+func TestStepTermination(t *testing.T) {
+	tests := []struct {
+		Instr  string
+		Reason StopReason
+	}{
+		{"quit", Quit},
+		{"restart", Restart},
+		{"rtrue", EndOfMain},
+	}
+	for _, tt := range tests {
+		b := zasm.New(3)
+		b.Routine("main", 0)
+		b.Instr(tt.Instr)
+		m := buildMachine(t, b, new(bufferUI))
+		err := m.Step()
+		var term *TerminationError
+		if !errors.As(err, &term) {
+			t.Errorf("%s: Step() = %v; want TerminationError", tt.Instr, err)
+		} else if term.Reason != tt.Reason {
+			t.Errorf("%s: Reason != %v (got %v)", tt.Instr, tt.Reason, term.Reason)
+		}
+	}
+
+	// Faults aren't terminations.
+	b := zasm.New(3)
+	b.Routine("main", 0)
+	b.Instr("pop")
+	m := buildMachine(t, b, new(bufferUI))
+	var term *TerminationError
+	if err := m.Step(); err == nil || errors.As(err, &term) {
+		t.Errorf("pop: Step() = %v; want fault", err)
+	}
+}
+
+func TestTerminationCallbacks(t *testing.T) {
+	tests := []struct {
+		Instr   string
+		Quit    int
+		Restart int
+		Err     error
+	}{
+		{"quit", 1, 0, ErrQuit},
+		{"restart", 0, 1, ErrRestart},
+		{"rtrue", 0, 0, ErrReturnFromMain},
+	}
+	for _, tt := range tests {
+		b := zasm.New(3)
+		b.Routine("main", 0)
+		b.Instr(tt.Instr)
+		m := buildMachine(t, b, new(bufferUI))
+		var quits, restarts int
+		m.OnQuit(func() { quits++ })
+		m.OnRestart(func() { restarts++ })
+		if err := m.Step(); !errors.Is(err, tt.Err) {
+			t.Errorf("%s: Step() = %v; want %v", tt.Instr, err, tt.Err)
+		}
+		if quits != tt.Quit || restarts != tt.Restart {
+			t.Errorf("%s: called OnQuit %d times and OnRestart %d times; want %d and %d", tt.Instr, quits, restarts, tt.Quit, tt.Restart)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package north
+
+import (
+	"container/list"
+)
+
+// stringCacheKey identifies a decoded string in a stringCache.  Abbreviation
+// strings are decoded without abbreviation expansion, so they are kept apart
+// from regular strings at the same address.
+type stringCacheKey struct {
+	Addr   Address
+	Abbrev bool
+}
+
+type stringCacheEntry struct {
+	key stringCacheKey
+	s   string
+}
+
+// A stringCache is a least-recently-used cache of decoded strings.
+type stringCache struct {
+	size    int
+	order   *list.List
+	entries map[stringCacheKey]*list.Element
+}
+
+func newStringCache(size int) *stringCache {
+	return &stringCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[stringCacheKey]*list.Element, size),
+	}
+}
+
+// Len returns the number of strings in the cache.
+func (c *stringCache) Len() int {
+	return c.order.Len()
+}
+
+// Get returns the string stored for k, marking it as recently used.
+func (c *stringCache) Get(k stringCacheKey) (string, bool) {
+	e, ok := c.entries[k]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*stringCacheEntry).s, true
+}
+
+// Put stores s for k, evicting the least recently used string if the cache is
+// full.
+func (c *stringCache) Put(k stringCacheKey, s string) {
+	if e, ok := c.entries[k]; ok {
+		e.Value.(*stringCacheEntry).s = s
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*stringCacheEntry).key)
+	}
+	c.entries[k] = c.order.PushFront(&stringCacheEntry{key: k, s: s})
+}
+
+// SetStringCache changes the DecodeCache setting between steps and discards
+// the strings cached so far.  An entries value of 0 turns the cache off.
+func (m *Machine) SetStringCache(entries int) {
+	DecodeCache(entries)(&m.cfg)
+	m.resetStringCache()
+}
+
+// resetStringCache discards all cached strings.
+func (m *Machine) resetStringCache() {
+	if m.cfg.decodeCache > 0 {
+		m.strings = newStringCache(m.cfg.decodeCache)
+	} else {
+		m.strings = nil
+	}
+}
+
+// cachedString returns the string for k from the cache, calling decode to fill
+// the cache on a miss.  Strings in dynamic memory bypass the cache.
+func (m *Machine) cachedString(k stringCacheKey, decode func() (string, error)) (string, error) {
+	if m.strings == nil || k.Addr < m.staticMemoryBase() {
+		return decode()
+	}
+	if s, ok := m.strings.Get(k); ok {
+		return s, nil
+	}
+	s, err := decode()
+	if err != nil {
+		return "", err
+	}
+	m.strings.Put(k, s)
+	return s, nil
+}
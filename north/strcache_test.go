@@ -0,0 +1,125 @@
+package north
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// bufferUI is a UI that records window 0 output and has no input.
+type bufferUI struct {
+	bytes.Buffer
+}
+
+func (ui *bufferUI) ReadRune() (rune, int, error) {
+	return 0, 0, io.EOF
+}
+
+func (ui *bufferUI) Input(n int) ([]rune, error) {
+	return nil, io.EOF
+}
+
+func (ui *bufferUI) Output(window int, text string) error {
+	if window == 0 {
+		ui.WriteString(text)
+	}
+	return nil
+}
+
+func (ui *bufferUI) Save(m *Machine) error {
+	return nil
+}
+
+func (ui *bufferUI) Restore(m *Machine) error {
+	return nil
+}
+
+// Addresses in newStringTestMachine's memory.
+const (
+	stringTestDynamic Address = 0x80
+	stringTestStatic  Address = 0x120
+)
+
+// newStringTestMachine returns a version 3 machine with "Hi" stored at
+// stringTestDynamic and stringTestStatic.
+func newStringTestMachine(opts ...Option) (*Machine, *bufferUI) {
+	m, ui := newTestMachine(3, 0x200, opts...)
+	m.storeWord(stringTestDynamic, 0x91ae)
+	m.storeWord(stringTestStatic, 0x91ae)
+	return m, ui
+}
+
+func TestStringCacheStatic(t *testing.T) {
+	m, ui := newStringTestMachine(DecodeCache(4))
+	for i := 0; i < 2; i++ {
+		in := &shortInstruction{version: 3, opcode: 0x8d, operand: Word(stringTestStatic / 2)}
+		if err := m.step1OPInstruction(decoded(in)); err != nil {
+			t.Fatalf("print_paddr: %v", err)
+		}
+	}
+	if s := ui.String(); s != "HiHi" {
+		t.Errorf("output = %q; want %q", s, "HiHi")
+	}
+	if n := m.strings.Len(); n != 1 {
+		t.Errorf("m.strings.Len() = %d; want 1", n)
+	}
+}
+
+func TestStringCacheDynamic(t *testing.T) {
+	m, ui := newStringTestMachine(DecodeCache(4))
+	in := &shortInstruction{version: 3, opcode: 0x87, operand: Word(stringTestDynamic)}
+	if err := m.step1OPInstruction(decoded(in)); err != nil {
+		t.Fatalf("print_addr: %v", err)
+	}
+	// Change "Hi" to "Ho".
+	m.storeWord(stringTestDynamic, 0x91b4)
+	if err := m.step1OPInstruction(decoded(in)); err != nil {
+		t.Fatalf("print_addr: %v", err)
+	}
+	if s := ui.String(); s != "HiHo" {
+		t.Errorf("output = %q; want %q", s, "HiHo")
+	}
+	if n := m.strings.Len(); n != 0 {
+		t.Errorf("m.strings.Len() = %d; want 0", n)
+	}
+}
+
+func TestStringCacheEviction(t *testing.T) {
+	c := newStringCache(2)
+	c.Put(stringCacheKey{Addr: 1}, "a")
+	c.Put(stringCacheKey{Addr: 2}, "b")
+	c.Get(stringCacheKey{Addr: 1})
+	c.Put(stringCacheKey{Addr: 3}, "c")
+	if _, ok := c.Get(stringCacheKey{Addr: 2}); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	for _, k := range []stringCacheKey{{Addr: 1}, {Addr: 3}} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("entry %v evicted", k.Addr)
+		}
+	}
+}
+
+func TestStringCacheDisabled(t *testing.T) {
+	m, _ := newStringTestMachine(DecodeCache(0))
+	if m.strings != nil {
+		t.Error("DecodeCache(0) did not disable cache")
+	}
+	if s, err := m.loadString(stringTestStatic, true); err != nil || s != "Hi" {
+		t.Errorf("m.loadString(%v, true) = %q, %v; want \"Hi\", <nil>", stringTestStatic, s, err)
+	}
+}
+
+func BenchmarkPrintPaddr(b *testing.B) {
+	m, ui := newStringTestMachine(DecodeCache(64))
+	in := decoded(&shortInstruction{version: 3, opcode: 0x8d, operand: Word(stringTestStatic / 2)})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10000; j++ {
+			if err := m.step1OPInstruction(in); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ui.Reset()
+	}
+}
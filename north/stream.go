@@ -0,0 +1,90 @@
+package north
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SelectOutputStream selects output stream n, or deselects stream -n, as the
+// output_stream opcode does.  table is the address of the table for stream 3.
+func (m *Machine) SelectOutputStream(n int, table Address) error {
+	switch n {
+	case 0:
+		// do nothing
+	case screenOutput:
+		m.streams |= 1 << screenOutput
+	case -screenOutput:
+		m.streams &^= 1 << screenOutput
+	case transcriptOutput:
+		m.setTranscript(true)
+	case -transcriptOutput:
+		m.setTranscript(false)
+	case redirectOutput:
+		if len(m.rtables) == cap(m.rtables) {
+			return errors.New("Too many output redirection levels")
+		}
+		if table < headerSize || table+2 > m.staticMemoryBase() {
+			return fmt.Errorf("Output redirection table at %v is not in dynamic memory", table)
+		}
+		m.streams |= 1 << redirectOutput
+		m.rtables = append(m.rtables, rtable{table, table + 2})
+		m.storeWord(table, 0)
+	case -redirectOutput:
+		if len(m.rtables) > 1 {
+			m.rtables = m.rtables[:len(m.rtables)-1]
+		} else {
+			m.rtables = m.rtables[:0]
+			m.streams &^= 1 << redirectOutput
+		}
+	default:
+		return fmt.Errorf("Invalid output stream: %d", n)
+	}
+	return nil
+}
+
+// OutputStreamSelected reports whether output stream n is selected.
+func (m *Machine) OutputStreamSelected(n int) bool {
+	return n > 0 && n < numOutputStreams && m.streams&(1<<uint(n)) != 0
+}
+
+// writeTranscript sends s to the UI's transcript.  A TranscriptPrompter is
+// asked for a file the first time.  If it can't provide one, or writing to it
+// fails, the machine warns and clears the transcript bit rather than stopping
+// the story.
+func (m *Machine) writeTranscript(s string) error {
+	if t, ok := m.ui.(Transcriber); ok {
+		return t.Transcript(s)
+	}
+	p, ok := m.ui.(TranscriptPrompter)
+	if !ok {
+		return nil
+	}
+	if m.transcript == nil {
+		w, err := p.AskTranscriptFile()
+		if err != nil {
+			m.warn("Opening transcript: %v", err)
+		}
+		if w == nil {
+			m.setTranscript(false)
+			return nil
+		}
+		m.transcript = w
+	}
+	if _, err := io.WriteString(m.transcript, s); err != nil {
+		m.warn("Writing transcript: %v", err)
+		m.setTranscript(false)
+	}
+	return nil
+}
+
+// closeTranscript closes the file from the TranscriptPrompter, if one is
+// open.
+func (m *Machine) closeTranscript() error {
+	if m.transcript == nil {
+		return nil
+	}
+	err := m.transcript.Close()
+	m.transcript = nil
+	return err
+}
@@ -0,0 +1,786 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+// transcriptUI is a bufferUI that records the transcript stream and the
+// fixed-pitch setting.
+type transcriptUI struct {
+	bufferUI
+	transcript bytes.Buffer
+	fixed      bool
+}
+
+func (ui *transcriptUI) Transcript(text string) error {
+	ui.transcript.WriteString(text)
+	return nil
+}
+
+func (ui *transcriptUI) SetFixedPitch(fixed bool) {
+	ui.fixed = fixed
+}
+
+func TestFlags2Transcript(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("loadw", zasm.Const(0), zasm.Const(8), zasm.Store(zasm.SP))
+	b.Instr("or", zasm.SP, zasm.Const(0x03), zasm.Store(zasm.SP))
+	b.Instr("storew", zasm.Const(0), zasm.Const(8), zasm.SP)
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("output_stream", zasm.Large(0xfffe)) // -2
+	b.Instr("print", zasm.Text("c"))
+	b.Instr("output_stream", zasm.Const(2))
+	b.Instr("print", zasm.Text("d"))
+	b.Instr("quit")
+	ui := new(transcriptUI)
+	m := buildMachine(t, b, ui)
+
+	steps := []struct {
+		Transcript string
+		Fixed      bool
+		Flags2     byte
+	}{
+		{"", false, 0x00},  // print "a"
+		{"", false, 0x00},  // loadw
+		{"", false, 0x00},  // or
+		{"", true, 0x03},   // storew
+		{"b", true, 0x03},  // print "b"
+		{"b", true, 0x02},  // output_stream -2
+		{"b", true, 0x02},  // print "c"
+		{"b", true, 0x03},  // output_stream 2
+		{"bd", true, 0x03}, // print "d"
+	}
+	for i, st := range steps {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if s := ui.transcript.String(); s != st.Transcript {
+			t.Errorf("step %d: transcript != %q (got %q)", i, st.Transcript, s)
+		}
+		if ui.fixed != st.Fixed {
+			t.Errorf("step %d: fixed pitch != %t (got %t)", i, st.Fixed, ui.fixed)
+		}
+		if f := m.loadByte(flags2Game); f&0x03 != st.Flags2 {
+			t.Errorf("step %d: flags2 low bits != %#02x (got %#02x)", i, st.Flags2, f&0x03)
+		}
+	}
+	if s := ui.String(); s != "abcd" {
+		t.Errorf("screen != \"abcd\" (got %q)", s)
+	}
+}
+
+// echoTranscriptUI is a transcriptUI that reads one line of input.
+type echoTranscriptUI struct {
+	transcriptUI
+	line   string
+	echoes bool
+}
+
+func (ui *echoTranscriptUI) Input(n int) ([]rune, error) {
+	return []rune(ui.line), nil
+}
+
+func (ui *echoTranscriptUI) EchoesInput() bool {
+	return ui.echoes
+}
+
+func TestReadEcho(t *testing.T) {
+	tests := []struct {
+		Echoes bool
+		Screen string
+	}{
+		{false, ">look\n"},
+		{true, ">"},
+	}
+	for _, tt := range tests {
+		b := zasm.New(3)
+		b.Routine("main", 0)
+		b.Instr("output_stream", zasm.Const(2))
+		b.Instr("print", zasm.Text(">"))
+		b.Instr("sread", zasm.Addr("text"), zasm.Addr("parse"))
+		b.Instr("quit")
+		b.Data("text", append([]byte{32}, make([]byte, 32)...))
+		b.Data("parse", append([]byte{4}, make([]byte, 17)...))
+		ui := &echoTranscriptUI{line: "look", echoes: tt.Echoes}
+		m := buildMachine(t, b, ui)
+		for i := 0; i < 3; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("echoes=%t: step %d: %v", tt.Echoes, i, err)
+			}
+		}
+		if s := ui.transcript.String(); s != ">look\n" {
+			t.Errorf("echoes=%t: transcript != \">look\\n\" (got %q)", tt.Echoes, s)
+		}
+		if s := ui.String(); s != tt.Screen {
+			t.Errorf("echoes=%t: screen != %q (got %q)", tt.Echoes, tt.Screen, s)
+		}
+	}
+}
+
+// pitchRunsUI is a bufferUI that records each output with the fixed-pitch
+// setting it was printed in.
+type pitchRunsUI struct {
+	bufferUI
+	fixed bool
+	runs  []string
+}
+
+func (ui *pitchRunsUI) SetFixedPitch(fixed bool) {
+	ui.fixed = fixed
+}
+
+func (ui *pitchRunsUI) Output(window int, text string) error {
+	if len(ui.runs) > 10 {
+		return errors.New("too much output")
+	}
+	if ui.fixed {
+		text = "[" + text + "]"
+	}
+	ui.runs = append(ui.runs, text)
+	return nil
+}
+
+func TestFlags2FixedPitchRuns(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("storeb", zasm.Const(0), zasm.Const(0x11), zasm.Const(0x02))
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("print", zasm.Text("c"))
+	b.Instr("storeb", zasm.Const(0), zasm.Const(0x11), zasm.Const(0x00))
+	b.Instr("print", zasm.Text("d"))
+	b.Instr("quit")
+	ui := new(pitchRunsUI)
+	m := buildMachine(t, b, ui)
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []string{"a", "[b]", "[c]", "d"}; !reflect.DeepEqual(ui.runs, want) {
+		t.Errorf("output runs = %q; want %q", ui.runs, want)
+	}
+}
+
+func TestFlags2FixedPitchRestart(t *testing.T) {
+	// The story sets the fixed-pitch bit and restarts.  It quits once it
+	// sees the bit already set at the start.
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("loadb", zasm.Const(0), zasm.Const(0x11), zasm.Store(zasm.SP))
+	b.Instr("test", zasm.SP, zasm.Const(0x02), zasm.IfTrue("done"))
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("storeb", zasm.Const(0), zasm.Const(0x11), zasm.Const(0x02))
+	b.Instr("restart")
+	b.Label("done")
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("quit")
+	ui := new(pitchRunsUI)
+	i := newInterpreter(t, b, ui)
+	if err := i.Run(); err != nil {
+		t.Fatal("Run:", err)
+	}
+	if want := []string{"a", "[b]"}; !reflect.DeepEqual(ui.runs, want) {
+		t.Errorf("output runs = %q; want %q", ui.runs, want)
+	}
+}
+
+func TestFlags2KeptOnRestore(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	ui := new(pitchRunsUI)
+	m.SetUI(ui)
+	snap := m.DynamicMemorySnapshot()
+	m.storeByte(flags2Game, 0x02)
+	if err := m.ApplyDynamicMemory(snap); err != nil {
+		t.Fatal(err)
+	}
+	if f := m.loadByte(flags2Game); f&0x03 != 0x02 || !ui.fixed {
+		t.Errorf("after restore, flags2 = %#02x, fixed = %t; want 0x02, true", f, ui.fixed)
+	}
+}
+
+// mouseTranscriptUI is a transcriptUI with a mouse.
+type mouseTranscriptUI struct {
+	transcriptUI
+}
+
+func (ui *mouseTranscriptUI) MouseState() (x, y int, buttons Word) {
+	return 0, 0, 0
+}
+
+func TestFlags2SetUIMidGame(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("output_stream", zasm.Const(2))
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	// The story wants the mouse.
+	img[flags2Game] |= 0x20
+	m, err := NewMachine(bytes.NewReader(img), new(bufferUI))
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+	if f := m.loadByte(flags2Game); f&0x20 != 0 {
+		t.Errorf("flags2 = %#02x with no mouse; want bit 5 clear", f)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	ui := new(mouseTranscriptUI)
+	m.SetUI(ui)
+	if f := m.loadByte(flags2Game); f != 0x21 {
+		t.Errorf("flags2 after SetUI = %#02x; want 0x21", f)
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("print after SetUI:", err)
+	}
+	if s := ui.transcript.String(); s != "b" {
+		t.Errorf("transcript after SetUI = %q; want \"b\"", s)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("output_stream", zasm.Const(2))
+	b.Instr("output_stream", zasm.Const(3), zasm.Addr("table"))
+	b.Instr("quit")
+	b.Data("table", make([]byte, 16))
+	ui := new(transcriptUI)
+	m := buildMachine(t, b, ui)
+	if err := m.Step(); err != nil {
+		t.Fatal("output_stream 2:", err)
+	}
+	if err := m.Print("[hint]"); err != nil {
+		t.Fatal("Print:", err)
+	}
+	if s := ui.transcript.String(); s != "[hint]" {
+		t.Errorf("transcript = %q; want \"[hint]\"", s)
+	}
+	if s := ui.String(); s != "[hint]" {
+		t.Errorf("screen = %q; want \"[hint]\"", s)
+	}
+
+	// Redirected text goes only to the table.
+	if err := m.Step(); err != nil {
+		t.Fatal("output_stream 3:", err)
+	}
+	if err := m.Print("ok"); err != nil {
+		t.Fatal("Print:", err)
+	}
+	a, _ := b.DataAddress("table")
+	if n, text := m.loadWord(Address(a)), string(m.memory[a+2:a+4]); n != 2 || text != "ok" {
+		t.Errorf("table = %d %q; want 2 \"ok\"", n, text)
+	}
+	if s := ui.transcript.String(); s != "[hint]" {
+		t.Errorf("transcript after redirect = %q; want \"[hint]\"", s)
+	}
+}
+
+func TestRedirectOverflow(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	table := m.staticMemoryBase() - 4
+	if err := m.SelectOutputStream(redirectOutput, table); err != nil {
+		t.Fatal("SelectOutputStream:", err)
+	}
+	if err := m.Print("ok"); err != nil {
+		t.Fatal("Print:", err)
+	}
+	if err := m.Print("!"); err == nil {
+		t.Error("Print past dynamic memory succeeded")
+	}
+	if n := m.loadWord(table); n != 2 {
+		t.Errorf("table length = %d; want 2", n)
+	}
+	if c := m.memory[m.staticMemoryBase()]; c != 0 {
+		t.Errorf("static memory = %d; want 0", c)
+	}
+}
+
+func TestRedirectNewLine(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("output_stream", zasm.Const(3), zasm.Addr("table"))
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("new_line")
+	b.Instr("call_1n", zasm.Routine("sub"))
+	b.Instr("output_stream", zasm.Large(0xfffd)) // -3
+	b.Instr("quit")
+	b.Routine("sub", 0)
+	b.Instr("print_ret", zasm.Text("b"))
+	b.Data("table", make([]byte, 16))
+	ui := new(bufferUI)
+	m := buildMachine(t, b, ui)
+	for i := 0; i < 6; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	a, _ := b.DataAddress("table")
+	want := []byte{0, 4, 'a', 13, 'b', 13}
+	if got := m.memory[a : a+6]; !bytes.Equal(got, want) {
+		t.Errorf("table = %v; want %v", got, want)
+	}
+	if s := ui.String(); s != "" {
+		t.Errorf("screen = %q; want \"\"", s)
+	}
+}
+
+func TestSelectOutputStream(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("a"))
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("print", zasm.Text("c"))
+	b.Instr("quit")
+	b.Data("table", make([]byte, 16))
+	ui := new(transcriptUI)
+	m := buildMachine(t, b, ui)
+	a, _ := b.DataAddress("table")
+	table := Address(a)
+
+	if err := m.SelectOutputStream(2, 0); err != nil {
+		t.Fatal("SelectOutputStream(2):", err)
+	}
+	if err := m.SelectOutputStream(3, table); err != nil {
+		t.Fatal("SelectOutputStream(3):", err)
+	}
+	if !m.OutputStreamSelected(2) || !m.OutputStreamSelected(3) {
+		t.Error("streams 2 and 3 not selected")
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("print a:", err)
+	}
+	var saved bytes.Buffer
+	if err := m.SaveState(&saved); err != nil {
+		t.Fatal("SaveState:", err)
+	}
+
+	if err := m.SelectOutputStream(-3, 0); err != nil {
+		t.Fatal("SelectOutputStream(-3):", err)
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("print b:", err)
+	}
+	if m.OutputStreamSelected(3) || ui.transcript.String() != "b" {
+		t.Errorf("after deselecting stream 3: selected = %t, transcript = %q; want false, \"b\"", m.OutputStreamSelected(3), ui.transcript.String())
+	}
+
+	if err := m.RestoreState(&saved); err != nil {
+		t.Fatal("RestoreState:", err)
+	}
+	if !m.OutputStreamSelected(3) || !m.OutputStreamSelected(2) {
+		t.Error("streams 2 and 3 not selected after restore")
+	}
+	if err := m.Step(); err != nil {
+		t.Fatal("print b after restore:", err)
+	}
+	want := []byte{0, 2, 'a', 'b'}
+	if got := m.memory[table : table+4]; !bytes.Equal(got, want) {
+		t.Errorf("table after restore = %v; want %v", got, want)
+	}
+	if s := ui.transcript.String(); s != "b" {
+		t.Errorf("transcript = %q; want \"b\"", s)
+	}
+
+	if err := m.SelectOutputStream(5, 0); err == nil {
+		t.Error("SelectOutputStream(5) succeeded")
+	}
+	if err := m.SelectOutputStream(3, m.staticMemoryBase()); err == nil {
+		t.Error("SelectOutputStream(3) with a table in static memory succeeded")
+	}
+}
+
+// transcriptFile is a transcript file that can be made to fail.
+type transcriptFile struct {
+	buf    bytes.Buffer
+	fail   bool
+	closed bool
+}
+
+func (f *transcriptFile) Write(p []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("disk full")
+	}
+	return f.buf.Write(p)
+}
+
+func (f *transcriptFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+// promptUI is a warnUI that offers file when asked for a transcript file.
+type promptUI struct {
+	warnUI
+	file  *transcriptFile
+	asked int
+}
+
+func (ui *promptUI) AskTranscriptFile() (io.WriteCloser, error) {
+	ui.asked++
+	if ui.file == nil {
+		return nil, nil
+	}
+	return ui.file, nil
+}
+
+func TestTranscriptPrompter(t *testing.T) {
+	tests := []struct {
+		Name       string
+		File       *transcriptFile
+		Transcript string
+		Flags2     byte // after printing "b"
+		Warnings   int
+	}{
+		{"accept", &transcriptFile{}, "bc", 0x01, 0},
+		{"decline", nil, "", 0x00, 0},
+		{"write error", &transcriptFile{fail: true}, "", 0x00, 1},
+	}
+	for _, tt := range tests {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("loadw", zasm.Const(0), zasm.Const(8), zasm.Store(zasm.SP))
+		b.Instr("or", zasm.SP, zasm.Const(0x01), zasm.Store(zasm.SP))
+		b.Instr("storew", zasm.Const(0), zasm.Const(8), zasm.SP)
+		b.Instr("print", zasm.Text("b"))
+		b.Instr("print", zasm.Text("c"))
+		b.Instr("output_stream", zasm.Large(0xfffe)) // -2
+		b.Instr("print", zasm.Text("d"))
+		b.Instr("quit")
+		ui := &promptUI{file: tt.File}
+		m := buildMachine(t, b, ui)
+		for i := 0; i < 4; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("%s: step %d: %v", tt.Name, i, err)
+			}
+		}
+		if ui.asked != 1 {
+			t.Errorf("%s: asked %d times; want 1", tt.Name, ui.asked)
+		}
+		if f := m.loadByte(flags2Game) & 0x01; f != tt.Flags2 {
+			t.Errorf("%s: transcript bit = %d; want %d", tt.Name, f, tt.Flags2)
+		}
+		for i := 0; i < 3; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("%s: step %d: %v", tt.Name, i+4, err)
+			}
+		}
+		if ui.asked != 1 {
+			t.Errorf("%s: asked %d times by the end; want 1", tt.Name, ui.asked)
+		}
+		if m.loadByte(flags2Game)&0x01 != 0 {
+			t.Errorf("%s: transcript bit set after output_stream -2", tt.Name)
+		}
+		if len(ui.warnings) != tt.Warnings {
+			t.Errorf("%s: warnings = %q; want %d", tt.Name, ui.warnings, tt.Warnings)
+		}
+		if tt.File == nil {
+			continue
+		}
+		if s := tt.File.buf.String(); s != tt.Transcript {
+			t.Errorf("%s: transcript = %q; want %q", tt.Name, s, tt.Transcript)
+		}
+		if !tt.File.closed {
+			t.Errorf("%s: transcript file not closed", tt.Name)
+		}
+	}
+}
+
+// TestTranscriptKeptOnRestore checks that the ways of restoring memory keep
+// the transcript file open instead of asking for a new one.
+func TestTranscriptKeptOnRestore(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("output_stream", zasm.Const(2))
+	b.Instr("print", zasm.Text("b"))
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	restores := []struct {
+		Name    string
+		Restore func(m *Machine, mem []byte) error
+	}{
+		{"restore_undo", func(m *Machine, mem []byte) error {
+			if !m.restoreUndo() {
+				return errors.New("no undo state")
+			}
+			return nil
+		}},
+		{"ApplyDynamicMemory", (*Machine).ApplyDynamicMemory},
+		{"restart", func(m *Machine, mem []byte) error {
+			return m.restart(img)
+		}},
+	}
+	for _, r := range restores {
+		f := new(transcriptFile)
+		ui := &promptUI{file: f}
+		m := buildMachine(t, b, ui)
+		mem := m.DynamicMemorySnapshot()
+		m.saveUndo(0x10)
+		for i := 0; i < 2; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("%s: step %d: %v", r.Name, i, err)
+			}
+		}
+		if err := r.Restore(m, mem); err != nil {
+			t.Errorf("%s: %v", r.Name, err)
+			continue
+		}
+		if m.loadByte(flags2Game)&0x01 == 0 {
+			t.Errorf("%s: transcript bit cleared", r.Name)
+		}
+		if err := m.Print("c"); err != nil {
+			t.Errorf("%s: Print: %v", r.Name, err)
+		}
+		if ui.asked != 1 || f.closed {
+			t.Errorf("%s: asked for a transcript file %d times, closed = %t; want 1 time, open", r.Name, ui.asked, f.closed)
+		}
+		if s := f.buf.String(); s != "bc" {
+			t.Errorf("%s: transcript = %q; want \"bc\"", r.Name, s)
+		}
+	}
+}
+
+// screenUI is a transcriptUI that records screen output in every window.
+type screenUI struct {
+	transcriptUI
+	screen bytes.Buffer
+}
+
+func (ui *screenUI) Output(window int, text string) error {
+	ui.screen.WriteString(text)
+	return nil
+}
+
+func TestOutputRouting(t *testing.T) {
+	tests := []struct {
+		window  int
+		streams []int
+		want    route
+	}{
+		{0, nil, 0},
+		{0, []int{1}, routeScreen},
+		{0, []int{2}, routeTranscript},
+		{0, []int{1, 2}, routeScreen | routeTranscript},
+		{0, []int{3}, routeTable},
+		{0, []int{1, 3}, routeTable},
+		{0, []int{2, 3}, routeTable},
+		{0, []int{1, 2, 3}, routeTable},
+		{1, nil, 0},
+		{1, []int{1}, routeScreen},
+		{1, []int{2}, 0},
+		{1, []int{1, 2}, routeScreen},
+		{1, []int{3}, routeTable},
+		{1, []int{1, 3}, routeTable},
+		{1, []int{2, 3}, routeTable},
+		{1, []int{1, 2, 3}, routeTable},
+	}
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("quit")
+	b.Data("table", make([]byte, 16))
+	for _, test := range tests {
+		ui := new(screenUI)
+		m := buildMachine(t, b, ui)
+		a, _ := b.DataAddress("table")
+		table := Address(a)
+		if err := m.SelectOutputStream(-1, 0); err != nil {
+			t.Fatal("SelectOutputStream(-1):", err)
+		}
+		for _, n := range test.streams {
+			if err := m.SelectOutputStream(n, table); err != nil {
+				t.Fatalf("SelectOutputStream(%d): %v", n, err)
+			}
+		}
+		m.window = test.window
+		if got := routeOutput(m.streams, m.window); got != test.want {
+			t.Errorf("window %d, streams %v: route = %03b; want %03b", test.window, test.streams, got, test.want)
+		}
+
+		if err := m.out("x"); err != nil {
+			t.Errorf("window %d, streams %v: out: %v", test.window, test.streams, err)
+			continue
+		}
+		var got route
+		if ui.screen.String() == "x" {
+			got |= routeScreen
+		}
+		if ui.transcript.String() == "x" {
+			got |= routeTranscript
+		}
+		if m.loadWord(table) == 1 && m.memory[table+2] == 'x' {
+			got |= routeTable
+		}
+		if got != test.want {
+			t.Errorf("window %d, streams %v: \"x\" went to %03b; want %03b", test.window, test.streams, got, test.want)
+		}
+	}
+}
+
+// unicodeUI is a bufferUI that can print Latin-1 but read only ASCII.
+type unicodeUI struct {
+	bufferUI
+}
+
+func (ui *unicodeUI) CanOutput(r rune) bool { return r < 0x100 }
+func (ui *unicodeUI) CanInput(r rune) bool  { return r < 0x80 }
+
+func TestPrintUnicode(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("output_stream", zasm.Const(3), zasm.Addr("table"))
+	b.Instr("print_unicode", zasm.Large(0x263a))
+	b.Instr("print_unicode", zasm.Const(0xe9))
+	b.Instr("output_stream", zasm.Large(0xfffd)) // -3
+	b.Instr("print_unicode", zasm.Large(0x263a))
+	b.Instr("check_unicode", zasm.Large(0x263a), zasm.Store(zasm.Global(0)))
+	b.Instr("check_unicode", zasm.Const(0xe9), zasm.Store(zasm.Global(1)))
+	b.Instr("check_unicode", zasm.Const('a'), zasm.Store(zasm.Global(2)))
+	b.Instr("quit")
+	b.Data("table", make([]byte, 8))
+	ui := new(unicodeUI)
+	m := buildMachine(t, b, ui)
+	for i := 0; i < 8; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	a, _ := b.DataAddress("table")
+	if got, want := m.memory[a:a+4], []byte{0, 2, '?', 170}; !bytes.Equal(got, want) {
+		t.Errorf("table = %v; want %v", got, want)
+	}
+	if s := ui.String(); s != "?" {
+		t.Errorf("screen = %q; want \"?\"", s)
+	}
+	for i, want := range []Word{0, 1, 3} {
+		if g := m.Variable(0x10 + uint8(i)); g != want {
+			t.Errorf("check_unicode result %d = %d; want %d", i, g, want)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print", zasm.Text("ab"))
+	b.Instr("new_line")
+	b.Instr("print", zasm.Text("hello"))
+	b.Instr("print", zasm.Text("x\nyz"))
+	b.Instr("set_window", zasm.Const(1))
+	b.Instr("set_cursor", zasm.Const(1), zasm.Const(5))
+	b.Instr("print", zasm.Text("ab"))
+	b.Instr("erase_window", zasm.Const(1))
+	b.Instr("quit")
+	m := buildMachine(t, b, new(bufferUI))
+	steps := []struct {
+		Lower, Upper int
+	}{
+		{2, 0},
+		{0, 0},
+		{5, 0},
+		{2, 0},
+		{2, 0},
+		{2, 4},
+		{2, 6},
+		{2, 0},
+	}
+	for i, want := range steps {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if lower, upper := m.Column(0), m.Column(1); lower != want.Lower || upper != want.Upper {
+			t.Errorf("after step %d: columns = %d, %d; want %d, %d", i, lower, upper, want.Lower, want.Upper)
+		}
+	}
+}
+
+func TestOutputSanitized(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print_unicode", zasm.Const(0x1b))
+	b.Instr("print_unicode", zasm.Const(0))
+	b.Instr("print_unicode", zasm.Const(0x9b))
+	b.Instr("check_unicode", zasm.Const(0x1b), zasm.Store(zasm.Global(0)))
+	b.Instr("quit")
+	ui := new(screenUI)
+	m := buildMachine(t, b, ui)
+	if err := m.SelectOutputStream(2, 0); err != nil {
+		t.Fatal("SelectOutputStream(2):", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if err := m.Print("\x1b[2J\x00\r\tok\u009b\n"); err != nil {
+		t.Fatal("Print:", err)
+	}
+	const want = "????[2J?? ok?\n"
+	if s := ui.screen.String(); s != want {
+		t.Errorf("screen = %q; want %q", s, want)
+	}
+	if s := ui.transcript.String(); s != want {
+		t.Errorf("transcript = %q; want %q", s, want)
+	}
+	if g := m.Variable(0x10); g != 0 {
+		t.Errorf("check_unicode ESC = %d; want 0", g)
+	}
+}
+
+func TestCheckUnicode(t *testing.T) {
+	tests := []struct {
+		R            rune
+		UI           UI
+		Table        bool // story has '中' in its Unicode translation table
+		Print, Input bool
+	}{
+		{'a', new(bufferUI), false, true, true},
+		{'é', new(bufferUI), false, true, false},
+		{'中', new(bufferUI), false, true, false},
+		{0x1b, new(bufferUI), false, false, false},
+		{0x7f, new(bufferUI), false, false, false},
+		{0x9b, new(bufferUI), false, false, false},
+		{0xd800, new(bufferUI), false, false, false},
+		{0x10ffff + 1, new(bufferUI), false, false, false},
+
+		{'a', new(unicodeUI), false, true, true},
+		{'é', new(unicodeUI), false, true, false},
+		{'中', new(unicodeUI), false, false, false},
+		{'中', new(unicodeUI), true, true, false},
+		{0x1b, new(unicodeUI), false, false, false},
+		{0x1b, new(unicodeUI), true, false, false},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x400)
+		m.ui = tt.UI
+		if tt.Table {
+			// Header extension at 0x100, with the Unicode table at 0x200.
+			m.storeWord(0x36, 0x100)
+			m.storeWord(0x100, 3)
+			m.storeWord(0x106, 0x200)
+			m.storeByte(0x200, 2)
+			m.storeWord(0x201, 'ж')
+			m.storeWord(0x203, '中')
+		}
+		if print, input := m.checkUnicode(tt.R); print != tt.Print || input != tt.Input {
+			t.Errorf("%T, table %t: checkUnicode(%U) = %t, %t; want %t, %t", tt.UI, tt.Table, tt.R, print, input, tt.Print, tt.Input)
+		}
+	}
+}
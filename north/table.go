@@ -0,0 +1,190 @@
+package north
+
+import (
+	"fmt"
+)
+
+// A Table is a view of an array of bytes or words in a machine's memory.
+// Words are big-endian and need not be aligned.  Writes are only allowed to
+// dynamic memory.
+type Table struct {
+	m     *Machine
+	addr  Address
+	n     int
+	words bool
+}
+
+// WordTable returns a view of the n words starting at a.
+func (m *Machine) WordTable(a Address, n int) Table {
+	return Table{m: m, addr: a, n: n, words: true}
+}
+
+// ByteTable returns a view of the n bytes starting at a.
+func (m *Machine) ByteTable(a Address, n int) Table {
+	return Table{m: m, addr: a, n: n}
+}
+
+// Address returns the address of the table's first element.
+func (t Table) Address() Address {
+	return t.addr
+}
+
+// Len returns the number of elements in the table.
+func (t Table) Len() int {
+	return t.n
+}
+
+func (t Table) elemSize() Address {
+	if t.words {
+		return 2
+	}
+	return 1
+}
+
+// elem returns the address of element i, checking that it is in the table and
+// in memory.
+func (t Table) elem(i int) (Address, error) {
+	if i < 0 || i >= t.n {
+		return 0, fmt.Errorf("Table index %d out of range (table has %d)", i, t.n)
+	}
+	a := t.addr + Address(i)*t.elemSize()
+	if a < 0 || int(a+t.elemSize()) > len(t.m.memory) {
+		return 0, fmt.Errorf("Table element %d at %v is outside memory", i, a)
+	}
+	return a, nil
+}
+
+// Get returns element i.
+func (t Table) Get(i int) (Word, error) {
+	a, err := t.elem(i)
+	if err != nil {
+		return 0, err
+	}
+	if t.words {
+		return t.m.loadWord(a), nil
+	}
+	return Word(t.m.loadByte(a)), nil
+}
+
+// Set changes element i to v.  Byte tables store the low byte of v.
+func (t Table) Set(i int, v Word) error {
+	a, err := t.elem(i)
+	if err != nil {
+		return err
+	}
+	if end := a + t.elemSize(); end > t.m.staticMemoryBase() {
+		return fmt.Errorf("Table element %d at %v is not in dynamic memory", i, a)
+	}
+	if t.words {
+		t.m.storeWord(a, v)
+	} else {
+		t.m.storeByte(a, byte(v))
+	}
+	return nil
+}
+
+// Slice returns a copy of the table's elements.
+func (t Table) Slice() ([]Word, error) {
+	s := make([]Word, t.n)
+	for i := range s {
+		var err error
+		if s[i], err = t.Get(i); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// check returns an error if the whole table isn't in memory, or, if write is
+// true, in dynamic memory.
+func (t Table) check(write bool) error {
+	if t.n == 0 {
+		return nil
+	}
+	end := t.addr + Address(t.n)*t.elemSize()
+	if t.addr < 0 || int(end) > len(t.m.memory) {
+		return fmt.Errorf("Table at %v with %d elements is outside memory", t.addr, t.n)
+	}
+	if write && end > t.m.staticMemoryBase() {
+		return fmt.Errorf("Table at %v with %d elements is not in dynamic memory", t.addr, t.n)
+	}
+	return nil
+}
+
+// CopyTo copies t's elements into dst, which must have the same element size
+// and at least as many elements.  Overlapping tables are copied as if through
+// a temporary buffer, like copy_table with a positive size.
+func (t Table) CopyTo(dst Table) error {
+	if t.words != dst.words {
+		return fmt.Errorf("Can't copy between byte and word tables")
+	}
+	if dst.n < t.n {
+		return fmt.Errorf("Destination table has %d elements (need %d)", dst.n, t.n)
+	}
+	if err := t.check(false); err != nil {
+		return err
+	}
+	if err := dst.check(true); err != nil {
+		return err
+	}
+	size := Address(t.n) * t.elemSize()
+	dst.m.storeBytes(dst.addr, t.m.memory[t.addr:t.addr+size])
+	return nil
+}
+
+// CopyFrom copies src's elements into t.  It is the same as src.CopyTo(t).
+func (t Table) CopyFrom(src Table) error {
+	return src.CopyTo(t)
+}
+
+// copyForward copies t's bytes into dst one at a time from the start, even if
+// that overwrites bytes that haven't been copied yet.  This is copy_table
+// with a negative size.
+func (t Table) copyForward(dst Table) error {
+	if err := t.check(false); err != nil {
+		return err
+	}
+	if err := dst.check(true); err != nil {
+		return err
+	}
+	size := Address(t.n) * t.elemSize()
+	for i := Address(0); i < size; i++ {
+		dst.m.storeByte(dst.addr+i, t.m.loadByte(t.addr+i))
+	}
+	return nil
+}
+
+// zero sets all of t's elements to zero.
+func (t Table) zero() error {
+	if err := t.check(true); err != nil {
+		return err
+	}
+	size := Address(t.n) * t.elemSize()
+	for i := Address(0); i < size; i++ {
+		t.m.storeByte(t.addr+i, 0)
+	}
+	return nil
+}
+
+// scanTable searches n fields starting at table for x, as scan_table does.
+// The low 7 bits of form are the length of a field in bytes, and if the top
+// bit is set, the first word of each field is compared instead of the first
+// byte.  It returns the address of the first field that matches.
+func (m *Machine) scanTable(x Word, table Address, n int, form Word) (a Address, found bool, err error) {
+	size := Address(form & 0x7f)
+	for i := 0; i < n; i++ {
+		a := table + Address(i)*size
+		t := m.ByteTable(a, 1)
+		if form&0x80 != 0 {
+			t = m.WordTable(a, 1)
+		}
+		v, err := t.Get(0)
+		if err != nil {
+			return 0, false, err
+		}
+		if v == x {
+			return a, true, nil
+		}
+	}
+	return 0, false, nil
+}
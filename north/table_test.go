@@ -0,0 +1,188 @@
+package north
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestTableGetSet(t *testing.T) {
+	m, _ := newTestMachine(5, 0x400)
+	w := m.WordTable(0x101, 3)
+	for i, v := range []Word{0x1234, 0xabcd, 0x0001} {
+		if err := w.Set(i, v); err != nil {
+			t.Fatalf("Set(%d): %v", i, err)
+		}
+	}
+	if want := []byte{0x12, 0x34, 0xab, 0xcd, 0x00, 0x01}; !bytes.Equal(m.memory[0x101:0x107], want) {
+		t.Errorf("memory = % x; want % x", m.memory[0x101:0x107], want)
+	}
+	if s, err := w.Slice(); err != nil || !reflect.DeepEqual(s, []Word{0x1234, 0xabcd, 0x0001}) {
+		t.Errorf("Slice() = %v, %v", s, err)
+	}
+	b := m.ByteTable(0x101, 6)
+	if v, err := b.Get(2); err != nil || v != 0xab {
+		t.Errorf("ByteTable Get(2) = %v, %v; want 0x00ab", v, err)
+	}
+
+	if _, err := w.Get(3); err == nil {
+		t.Error("Get past end succeeded")
+	}
+	if err := w.Set(-1, 0); err == nil {
+		t.Error("Set(-1) succeeded")
+	}
+	if err := m.WordTable(0x1ff, 2).Set(0, 1); err == nil {
+		t.Error("Set across static memory succeeded")
+	}
+	if _, err := m.WordTable(0x3ff, 1).Get(0); err == nil {
+		t.Error("Get past end of memory succeeded")
+	}
+}
+
+func TestTableCopy(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Src, Dst Address
+		Size     int
+		Want     string
+	}{
+		{"disjoint", 0x100, 0x110, 4, "abcdefgh"},
+		{"overlap right", 0x100, 0x102, 4, "ababcdgh"},
+		{"overlap left", 0x102, 0x100, 4, "cdefefgh"},
+		{"forward overlap right", 0x100, 0x102, -4, "abababgh"},
+		{"forward overlap left", 0x102, 0x100, -4, "cdefefgh"},
+		{"zero", 0x102, 0, 3, "ab\x00\x00\x00fgh"},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x400)
+		copy(m.memory[0x100:], "abcdefgh")
+		copy(m.memory[0x110:], "abcdefgh")
+		inv := &variableInstruction{version: 5, opcode: 0xfd, types: 0x03ff, operands: [8]Word{Word(tt.Src), Word(tt.Dst), Word(int16(tt.Size))}}
+		if err := m.stepVariableInstruction(decoded(inv)); err != nil {
+			t.Errorf("%s: copy_table: %v", tt.Name, err)
+			continue
+		}
+		got := string(m.memory[0x100:0x108])
+		if tt.Dst == 0x110 {
+			got = string(m.memory[0x110:0x114]) + string(m.memory[0x104:0x108])
+		}
+		if got != tt.Want {
+			t.Errorf("%s: copy_table memory = %q; want %q", tt.Name, got, tt.Want)
+		}
+
+		// The Table API gives the same result for positive sizes.
+		if tt.Size < 0 || tt.Dst == 0 {
+			continue
+		}
+		m2, _ := newTestMachine(5, 0x400)
+		copy(m2.memory[0x100:], "abcdefgh")
+		copy(m2.memory[0x110:], "abcdefgh")
+		if err := m2.ByteTable(tt.Src, tt.Size).CopyTo(m2.ByteTable(tt.Dst, tt.Size)); err != nil {
+			t.Errorf("%s: CopyTo: %v", tt.Name, err)
+		} else if !bytes.Equal(m2.memory, m.memory) {
+			t.Errorf("%s: CopyTo differs from copy_table", tt.Name)
+		}
+	}
+}
+
+func TestTableCopyChecks(t *testing.T) {
+	m, _ := newTestMachine(5, 0x400)
+	if err := m.ByteTable(0x100, 4).CopyTo(m.ByteTable(0x200, 4)); err == nil {
+		t.Error("copy into static memory succeeded")
+	}
+	if err := m.ByteTable(0x100, 4).CopyTo(m.ByteTable(0x110, 2)); err == nil {
+		t.Error("copy into short table succeeded")
+	}
+	if err := m.WordTable(0x100, 2).CopyTo(m.ByteTable(0x110, 4)); err == nil {
+		t.Error("copy from word table to byte table succeeded")
+	}
+	if err := m.WordTable(0x100, 2).CopyFrom(m.WordTable(0x200, 2)); err != nil {
+		t.Errorf("copy from static memory: %v", err)
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	m, ui := newTestMachine(5, 0x400)
+	copy(m.memory[0x100:], "abcXdefX")
+	inv := &variableInstruction{version: 5, opcode: 0xfe, types: 0x00ff, operands: [8]Word{0x100, 3, 2, 1}}
+	if err := m.stepVariableInstruction(decoded(inv)); err != nil {
+		t.Fatal("print_table:", err)
+	}
+	if s := ui.String(); s != "abc\ndef" {
+		t.Errorf("print_table printed %q; want \"abc\\ndef\"", s)
+	}
+}
+
+func TestPrintTableColumn(t *testing.T) {
+	m, ui := newTestMachine(5, 0x400)
+	copy(m.memory[0x100:], "abcXdefX")
+	if err := m.Print("> "); err != nil {
+		t.Fatal(err)
+	}
+	inv := &variableInstruction{version: 5, opcode: 0xfe, types: 0x00ff, operands: [8]Word{0x100, 3, 2, 1}}
+	if err := m.stepVariableInstruction(decoded(inv)); err != nil {
+		t.Fatal("print_table:", err)
+	}
+	if s := ui.String(); s != "> abc\n  def" {
+		t.Errorf("print_table printed %q; want \"> abc\\n  def\"", s)
+	}
+}
+
+func TestScanTable(t *testing.T) {
+	tests := []struct {
+		X        uint16
+		Form     uint16 // 0 for the default
+		NotFound bool   // branch on ?~ instead of ?
+		Branch   bool
+		Offset   int // of the match from the table, or -1
+	}{
+		{20, 0, false, true, 2},
+		{25, 0, false, false, -1},
+		{20, 0, true, false, 2},
+		{25, 0, true, true, -1},
+		{30, 0x84, false, true, 4},
+		{30, 0x02, false, false, -1},
+		{10, 0x01, false, true, 1},
+	}
+	for _, tt := range tests {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		args := []zasm.Arg{zasm.Large(tt.X), zasm.Addr("tab"), zasm.Const(3)}
+		if tt.Form != 0 {
+			args = append(args, zasm.Large(tt.Form))
+		}
+		args = append(args, zasm.Store(zasm.SP))
+		if tt.NotFound {
+			args = append(args, zasm.IfFalse("yes"))
+		} else {
+			args = append(args, zasm.IfTrue("yes"))
+		}
+		b.Instr("scan_table", args...)
+		b.Instr("print", zasm.Text("no"))
+		b.Instr("quit")
+		b.Label("yes")
+		b.Instr("print", zasm.Text("yes"))
+		b.Instr("quit")
+		b.Data("tab", []byte{0, 10, 0, 20, 0, 30, 0, 40, 0, 30})
+		ui := new(bufferUI)
+		m := buildMachine(t, b, ui)
+		for i := 0; i < 2; i++ {
+			if err := m.Step(); err != nil {
+				t.Fatalf("scan_table %d form %#x: step %d: %v", tt.X, tt.Form, i, err)
+			}
+		}
+		want := Word(0)
+		if tt.Offset >= 0 {
+			a, _ := b.DataAddress("tab")
+			want = Word(a + tt.Offset)
+		}
+		if s := m.Frames()[0].Stack; len(s) != 1 || s[0] != want {
+			t.Errorf("scan_table %d form %#x stored %v; want %v", tt.X, tt.Form, s, want)
+		}
+		if branched := ui.String() == "yes"; branched != tt.Branch {
+			t.Errorf("scan_table %d form %#x with not-found %t: branched = %t; want %t", tt.X, tt.Form, tt.NotFound, branched, tt.Branch)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package north
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// TextUI is a UI for a plain text stream, like a terminal without cursor
+// control.  Lower window text is word-wrapped at the screen width while buffer
+// mode is on, breaking only at spaces.  Upper window text is written as-is
+// while the window is split, and dropped otherwise.
+type TextUI struct {
+	// TerminalEcho is true if the input stream is shown as it's typed, as on
+	// a terminal.  Otherwise the machine echoes input lines through Output.
+	TerminalEcho bool
+
+	r          *bufio.Reader
+	w          io.Writer
+	width      int
+	unbuffered bool
+	upper      int
+
+	// left and right are the lower window margins set by SetMargins.
+	left, right int
+
+	// col is the number of runes written since the last newline.  line is
+	// lower window text that hasn't been written yet.
+	col  int
+	line []rune
+}
+
+// NewTextUI returns a TextUI that reads from r and writes lines of at most
+// width runes to w.  If width is zero or less, text isn't wrapped.
+func NewTextUI(r io.Reader, w io.Writer, width int) *TextUI {
+	return &TextUI{r: bufio.NewReader(r), w: w, width: width}
+}
+
+// Output writes text to a window.
+func (t *TextUI) Output(window int, text string) error {
+	if window != 0 {
+		if t.upper == 0 {
+			return nil
+		}
+		if err := t.Flush(); err != nil {
+			return err
+		}
+		return t.write(text)
+	}
+	if t.unbuffered || t.width <= 0 {
+		return t.write(text)
+	}
+	for _, r := range text {
+		if r == '\n' {
+			t.line = append(t.line, '\n')
+			if err := t.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		t.line = append(t.line, r)
+		if err := t.wrap(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap writes out full lines while the pending text doesn't fit on the
+// current line.  Spaces at a break are dropped, and words longer than a line
+// are broken at the edge.
+func (t *TextUI) wrap() error {
+	width := t.lineWidth()
+	for t.col+len(t.line) > width {
+		// A space just past the edge can be dropped in favor of the newline.
+		fit := width - t.col
+		sp := -1
+		for i := 0; i <= fit && i < len(t.line); i++ {
+			if t.line[i] == ' ' {
+				sp = i
+			}
+		}
+		var rest []rune
+		switch {
+		case sp >= 0:
+			rest = t.line[sp+1:]
+			end := sp
+			for end > 0 && t.line[end-1] == ' ' {
+				end--
+			}
+			t.line = append(t.line[:end:end], '\n')
+		case t.col > 0:
+			// The word started on an earlier line; move it down whole.
+			rest = t.line
+			t.line = []rune{'\n'}
+		default:
+			// The word is longer than a line.
+			rest = t.line[fit:]
+			t.line = append(t.line[:fit:fit], '\n')
+		}
+		if err := t.Flush(); err != nil {
+			return err
+		}
+		for len(rest) > 0 && rest[0] == ' ' {
+			rest = rest[1:]
+		}
+		t.line = rest
+	}
+	return nil
+}
+
+// lineWidth returns the number of columns that lower window text is wrapped
+// at: the screen width less the margins, but never less than one.
+func (t *TextUI) lineWidth() int {
+	if w := t.width - t.left - t.right; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// SetMargins narrows the lower window by left and right columns, so that text
+// is wrapped that much sooner.  Negative margins count as zero.
+func (t *TextUI) SetMargins(left, right int) {
+	t.left, t.right = clampNone(left), clampNone(right)
+	if !t.unbuffered && t.width > 0 {
+		t.wrap()
+	}
+}
+
+// write writes s to w, keeping track of the column.
+func (t *TextUI) write(s string) error {
+	for _, r := range s {
+		if r == '\n' {
+			t.col = 0
+		} else {
+			t.col++
+		}
+	}
+	_, err := io.WriteString(t.w, s)
+	return err
+}
+
+// Flush writes any lower window text that is waiting for a line break.
+func (t *TextUI) Flush() error {
+	if len(t.line) == 0 {
+		return nil
+	}
+	s := string(t.line)
+	t.line = t.line[:0]
+	return t.write(s)
+}
+
+// SplitWindow records the height of the upper window.  Upper window text is
+// only shown while the height isn't zero.
+func (t *TextUI) SplitWindow(lines int) error {
+	t.upper = lines
+	return nil
+}
+
+// SetBufferMode turns word wrapping in the lower window on or off.
+func (t *TextUI) SetBufferMode(buffered bool) {
+	if !buffered {
+		t.Flush()
+	}
+	t.unbuffered = !buffered
+}
+
+// ScreenSize returns the width given to NewTextUI.  The height is zero, since
+// text scrolls without limit.
+func (t *TextUI) ScreenSize() (width, height int) {
+	return t.width, 0
+}
+
+// ResizeScreen changes the width that lower window text is wrapped at.  Text
+// waiting for a line break is wrapped again at the new width.
+func (t *TextUI) ResizeScreen(width, height int) {
+	t.width = width
+	if !t.unbuffered && t.width > 0 {
+		t.wrap()
+	}
+}
+
+// EchoesInput returns t.TerminalEcho.
+func (t *TextUI) EchoesInput() bool {
+	return t.TerminalEcho
+}
+
+// Input reads a line, keeping at most n runes of it.
+func (t *TextUI) Input(n int) ([]rune, error) {
+	if err := t.Flush(); err != nil {
+		return nil, err
+	}
+	r := make([]rune, 0, n)
+	for {
+		rr, _, err := t.r.ReadRune()
+		if err != nil {
+			return r, err
+		} else if rr == '\n' {
+			break
+		}
+		if len(r) < n {
+			r = append(r, rr)
+		}
+	}
+	if t.TerminalEcho {
+		t.col = 0
+	}
+	return r, nil
+}
+
+// InputWithPrefill shows the prefilled text and reads the rest of the line
+// after it.  The prefilled text can't be edited: TextUI reads whole lines from
+// a plain stream, and a terminal in line mode only lets the player erase what
+// they typed themselves.  Whatever is read is appended to it.
+func (t *TextUI) InputWithPrefill(n int, prefill []rune) ([]rune, error) {
+	if err := t.Flush(); err != nil {
+		return nil, err
+	}
+	if err := t.write(string(prefill)); err != nil {
+		return nil, err
+	}
+	r, err := t.Input(n - len(prefill))
+	return append(prefill, r...), err
+}
+
+// ReadRune reads a single character.  A terminal in line mode shows the
+// character as it's typed; UIs on a terminal should turn echo off around the
+// read.
+func (t *TextUI) ReadRune() (rune, int, error) {
+	if err := t.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return t.r.ReadRune()
+}
+
+// Save fails, since TextUI has nowhere to keep a saved game.
+func (t *TextUI) Save(m *Machine) error {
+	return errors.New("Saving is not supported")
+}
+
+// Restore does nothing, since there is never a saved game to restore.
+func (t *TextUI) Restore(m *Machine) error {
+	return nil
+}
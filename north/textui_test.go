@@ -0,0 +1,220 @@
+package north
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
+)
+
+func TestTextUIWrap(t *testing.T) {
+	tests := []struct {
+		Output []string
+		Want   string
+	}{
+		{
+			[]string{"The quick brown fox jumps over the lazy dog and keeps on running.\n"},
+			"The quick brown fox jumps over the lazy\ndog and keeps on running.\n",
+		},
+		{
+			// Output split mid-word.
+			[]string{"The quick brown fox jumps over the la", "zy dog and keeps on running.\n"},
+			"The quick brown fox jumps over the lazy\ndog and keeps on running.\n",
+		},
+		{
+			// A space at the edge is dropped.
+			[]string{strings.Repeat("x", 40) + " next\n"},
+			strings.Repeat("x", 40) + "\nnext\n",
+		},
+		{
+			// Words longer than a line are broken.
+			[]string{"a " + strings.Repeat("y", 45) + "\n"},
+			"a\n" + strings.Repeat("y", 40) + "\nyyyyy\n",
+		},
+		{
+			[]string{"Short.\n", "Also short.\n"},
+			"Short.\nAlso short.\n",
+		},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		ui := NewTextUI(strings.NewReader(""), &out, 40)
+		for _, s := range tt.Output {
+			if err := ui.Output(0, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := out.String(); got != tt.Want {
+			t.Errorf("Output(%q) wrote %q; want %q", tt.Output, got, tt.Want)
+		}
+	}
+}
+
+func TestTextUIWrapRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	noSpace := strings.NewReplacer(" ", "", "\n", "")
+	for i := 0; i < 500; i++ {
+		width := 1 + r.Intn(50)
+		left, right := r.Intn(4), r.Intn(4)
+		var text strings.Builder
+		for n := r.Intn(30); n > 0; n-- {
+			text.WriteString(strings.Repeat(string(rune('a'+r.Intn(26))), 1+r.Intn(60)))
+			if r.Intn(8) == 0 {
+				text.WriteByte('\n')
+			} else {
+				text.WriteString(strings.Repeat(" ", 1+r.Intn(3)))
+			}
+		}
+		in := text.String()
+
+		var out bytes.Buffer
+		ui := NewTextUI(strings.NewReader(""), &out, width)
+		ui.SetMargins(left, right)
+		for rest := in; rest != ""; {
+			// Output comes in pieces that can end mid-word.
+			n := 1 + r.Intn(len(rest))
+			if err := ui.Output(0, rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := ui.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		limit := ui.lineWidth()
+		for _, line := range strings.Split(out.String(), "\n") {
+			if len(line) > limit {
+				t.Errorf("width %d, margins %d,%d: line %q is longer than %d", width, left, right, line, limit)
+			}
+		}
+		if got, want := noSpace.Replace(out.String()), noSpace.Replace(in); got != want {
+			t.Errorf("width %d, margins %d,%d: Output(%q) wrote %q; lost characters", width, left, right, in, out.String())
+		}
+	}
+}
+
+func TestTextUIMargins(t *testing.T) {
+	var out bytes.Buffer
+	ui := NewTextUI(strings.NewReader(""), &out, 20)
+	ui.SetMargins(2, 8)
+	if err := ui.Output(0, "Marginal   notes run long.\n"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Marginal\nnotes run\nlong.\n"; out.String() != want {
+		t.Errorf("wrote %q; want %q", out.String(), want)
+	}
+}
+
+func TestTextUIPrompt(t *testing.T) {
+	var out bytes.Buffer
+	ui := NewTextUI(strings.NewReader("look\n"), &out, 40)
+	ui.Output(0, strings.Repeat("z", 30)+" >")
+	if out.Len() != 0 {
+		t.Errorf("wrote %q before input", out.String())
+	}
+	if r, err := ui.Input(10); err != nil || string(r) != "look" {
+		t.Errorf("Input(10) = %q, %v; want \"look\", <nil>", string(r), err)
+	}
+	// The prompt is still on the line, so the last word doesn't fit.
+	ui.Output(0, " rest of it\n")
+	if want := strings.Repeat("z", 30) + " > rest of\nit\n"; out.String() != want {
+		t.Errorf("wrote %q; want %q", out.String(), want)
+	}
+}
+
+func TestTextUIWindows(t *testing.T) {
+	long := strings.Repeat("word ", 10) + "\n"
+	var out bytes.Buffer
+	ui := NewTextUI(strings.NewReader(""), &out, 40)
+	ui.Output(1, "dropped")
+	ui.SplitWindow(1)
+	ui.Output(1, long)
+	if out.String() != long {
+		t.Errorf("upper window wrote %q; want %q", out.String(), long)
+	}
+
+	out.Reset()
+	ui.SetBufferMode(false)
+	ui.Output(0, long)
+	if out.String() != long {
+		t.Errorf("unbuffered lower window wrote %q; want %q", out.String(), long)
+	}
+}
+
+func TestBufferMode(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("buffer_mode", zasm.Const(0))
+	b.Instr("print", zasm.Text(strings.Repeat("word ", 10)))
+	b.Instr("buffer_mode", zasm.Const(1))
+	b.Instr("print", zasm.Text(strings.Repeat("word ", 10)))
+	b.Instr("quit")
+	var out bytes.Buffer
+	ui := NewTextUI(strings.NewReader(""), &out, 40)
+	m := buildMachine(t, b, ui)
+	if err := m.Run(); !errors.Is(err, ErrQuit) {
+		t.Fatalf("Run: %v", err)
+	}
+	// The unbuffered line runs past the edge, so buffered text starts on a
+	// new line.
+	want := strings.Repeat("word ", 10) + "\n" + strings.Repeat("word ", 7) + "word\nword word "
+	if out.String() != want {
+		t.Errorf("output = %q; want %q", out.String(), want)
+	}
+}
+
+func TestUpdateScreenSize(t *testing.T) {
+	tests := []struct {
+		Version byte
+		Header  []byte // 0x20 through 0x25
+		Redraw  bool
+	}{
+		{3, []byte{0, 0, 0, 0, 0, 0}, false},
+		{4, []byte{10, 20, 0, 0, 0, 0}, false},
+		{5, []byte{10, 20, 0, 20, 0, 10}, false},
+		{6, []byte{10, 20, 0, 20, 0, 10}, true},
+	}
+	for _, tt := range tests {
+		zb := zasm.New(tt.Version)
+		zb.Routine("main", 0)
+		zb.Op(zasm.OP0, 0x0a) // quit
+		var out bytes.Buffer
+		ui := NewTextUI(strings.NewReader(""), &out, 40)
+		m := buildMachine(t, zb, ui)
+		if tt.Version >= 4 && m.memory[0x21] != 40 {
+			t.Errorf("v%d: width before resize = %d; want 40", tt.Version, m.memory[0x21])
+		}
+
+		m.UpdateScreenSize(20, 10)
+		if h := m.memory[0x20:0x26]; !bytes.Equal(h, tt.Header) {
+			t.Errorf("v%d: header = % x; want % x", tt.Version, h, tt.Header)
+		}
+		if redraw := m.memory[flags2Game]&0x04 != 0; redraw != tt.Redraw {
+			t.Errorf("v%d: redraw = %t; want %t", tt.Version, redraw, tt.Redraw)
+		}
+		if c := m.Capabilities(); c.ScreenWidth != 20 || c.ScreenHeight != 10 {
+			t.Errorf("v%d: screen = %dx%d; want 20x10", tt.Version, c.ScreenWidth, c.ScreenHeight)
+		}
+		if err := m.Print("The quick brown fox jumps over the lazy dog.\n"); err != nil {
+			t.Fatal(err)
+		}
+		if want := "The quick brown fox\njumps over the lazy\ndog.\n"; out.String() != want {
+			t.Errorf("v%d: wrote %q; want %q", tt.Version, out.String(), want)
+		}
+	}
+}
+
+func TestTextUIResizePending(t *testing.T) {
+	var out bytes.Buffer
+	ui := NewTextUI(strings.NewReader(""), &out, 40)
+	ui.Output(0, "The quick brown fox jumps")
+	ui.ResizeScreen(10, 0)
+	ui.Output(0, "\n")
+	if want := "The quick\nbrown fox\njumps\n"; out.String() != want {
+		t.Errorf("wrote %q; want %q", out.String(), want)
+	}
+}
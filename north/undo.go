@@ -0,0 +1,118 @@
+package north
+
+// DefaultUndoLevels is the number of states save_undo keeps unless the
+// UndoLevels option says otherwise.  Once that many are saved, save_undo
+// drops the oldest to make room, unless UndoDropOldest(false) is given.
+const DefaultUndoLevels = 10
+
+// undoLevels returns the number of states save_undo keeps, or -1 if undo is
+// off.
+func (m *Machine) undoLevels() int {
+	if m.cfg.undoLevels == 0 {
+		return DefaultUndoLevels
+	}
+	return m.cfg.undoLevels
+}
+
+// UndoDepth returns the number of states saved by save_undo that
+// restore_undo can return to.  It is never more than Config().UndoLevels.
+func (m *Machine) UndoDepth() int {
+	return len(m.undo)
+}
+
+// UndoAvailable reports whether restore_undo has a state to return to.
+func (m *Machine) UndoAvailable() bool {
+	return len(m.undo) > 0
+}
+
+// An undoState is the machine's state when save_undo executed.  Only the
+// oldest state copies all of dynamic memory, into Machine.undoBase; each later
+// one holds the pages written since the state before it.
+type undoState struct {
+	pages pageDelta
+	gen   uint32
+	stack []stackFrame
+
+	// store is save_undo's store variable, which restore_undo sets to 2.
+	store uint8
+}
+
+// saveUndo saves the machine's state for restore_undo and returns the value
+// save_undo stores: 1 if the state was saved, 0 if the undo stack is full, or
+// -1 if undo is off.  Unless the UndoDropOldest option is false, a full stack
+// drops its oldest state instead.
+func (m *Machine) saveUndo(store uint8) Word {
+	max := m.undoLevels()
+	if max <= 0 {
+		return 0xffff
+	}
+	if len(m.undo) >= max && m.cfg.undoKeepOldest {
+		return 0
+	}
+	for len(m.undo) >= max {
+		m.dropOldestUndo()
+	}
+	st := undoState{
+		stack: copyStack(m.stack),
+		store: store,
+	}
+	if len(m.undo) == 0 {
+		m.undoBase = m.DynamicMemorySnapshot()
+	} else {
+		st.pages = m.deltaSince(m.undo[len(m.undo)-1].gen)
+	}
+	st.gen = m.markGeneration()
+	m.undo = append(m.undo, st)
+	return 1
+}
+
+// dropOldestUndo removes the oldest saved state, folding the pages of the
+// state after it into undoBase.
+func (m *Machine) dropOldestUndo() {
+	if len(m.undo) > 1 {
+		m.undo[1].pages.apply(m.undoBase)
+		m.undo[1].pages = nil
+	}
+	n := copy(m.undo, m.undo[1:])
+	m.undo = m.undo[:n]
+}
+
+// restoreUndo returns the machine to the most recent state saved by
+// saveUndo, as if save_undo had just stored 2.  It returns false if there is
+// no saved state.  Like restore, it keeps the transcript and fixed-pitch bits
+// of Flags 2.
+func (m *Machine) restoreUndo() bool {
+	if len(m.undo) == 0 {
+		return false
+	}
+	st := m.undo[len(m.undo)-1]
+	// Only the pages written since the state was saved can differ from it.
+	for _, p := range m.dirtyPagesSince(st.gen) {
+		if b := m.undoPage(p); b != nil {
+			m.restoreMemory(Address(p<<dirtyPageShift), b)
+		}
+	}
+	m.undo = m.undo[:len(m.undo)-1]
+	m.stack = st.stack
+	m.setVariable(st.store, 2)
+	return true
+}
+
+// undoPage returns page p of dynamic memory as it was in the most recent
+// saved state, or nil if p is past dynamic memory.
+func (m *Machine) undoPage(p int) []byte {
+	for i := len(m.undo) - 1; i >= 0; i-- {
+		if b, ok := m.undo[i].pages[p]; ok {
+			return b
+		}
+	}
+	start := p << dirtyPageShift
+	if start >= len(m.undoBase) {
+		return nil
+	}
+	end := start + dirtyPageSize
+	if end > len(m.undoBase) {
+		end = len(m.undoBase)
+	}
+	return m.undoBase[start:end]
+}
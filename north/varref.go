@@ -0,0 +1,50 @@
+package north
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A VariableRef names a variable as it is encoded in instructions: 0 is the
+// stack, 0x01-0x0f are the current routine's locals, and 0x10-0xff are the
+// globals.
+type VariableRef uint8
+
+// String returns "sp", "localN" (1-15), or "gN" (0-239).
+func (v VariableRef) String() string {
+	switch {
+	case v == 0:
+		return "sp"
+	case v < 0x10:
+		return "local" + strconv.Itoa(int(v))
+	}
+	return "g" + strconv.Itoa(int(v-0x10))
+}
+
+// ParseVariableRef parses the notation returned by VariableRef.String.  It
+// also accepts the encoded number in hex with a "$" prefix, like "$1a".
+func ParseVariableRef(s string) (VariableRef, error) {
+	var n uint64
+	var err error
+	switch {
+	case s == "sp":
+		return 0, nil
+	case strings.HasPrefix(s, "$"):
+		n, err = strconv.ParseUint(s[1:], 16, 8)
+		if err == nil {
+			return VariableRef(n), nil
+		}
+	case strings.HasPrefix(s, "local"):
+		n, err = strconv.ParseUint(s[len("local"):], 10, 8)
+		if err == nil && n >= 1 && n <= 15 {
+			return VariableRef(n), nil
+		}
+	case strings.HasPrefix(s, "g"):
+		n, err = strconv.ParseUint(s[1:], 10, 8)
+		if err == nil && n <= 239 {
+			return VariableRef(n + 0x10), nil
+		}
+	}
+	return 0, fmt.Errorf("Bad variable %q (want sp, local1-local15, or g0-g239)", s)
+}
@@ -0,0 +1,58 @@
+package north
+
+import (
+	"testing"
+)
+
+func TestVariableRef(t *testing.T) {
+	tests := []struct {
+		Ref  VariableRef
+		Name string
+	}{
+		{0x00, "sp"},
+		{0x01, "local1"},
+		{0x0f, "local15"},
+		{0x10, "g0"},
+		{0x1a, "g10"},
+		{0xff, "g239"},
+	}
+	for _, tt := range tests {
+		if s := tt.Ref.String(); s != tt.Name {
+			t.Errorf("VariableRef(%#02x).String() = %q; want %q", uint8(tt.Ref), s, tt.Name)
+		}
+		if v, err := ParseVariableRef(tt.Name); err != nil || v != tt.Ref {
+			t.Errorf("ParseVariableRef(%q) = %#02x, %v; want %#02x", tt.Name, uint8(v), err, uint8(tt.Ref))
+		}
+	}
+
+	if v, err := ParseVariableRef("$1a"); err != nil || v != 0x1a {
+		t.Errorf("ParseVariableRef(\"$1a\") = %#02x, %v; want 0x1a", uint8(v), err)
+	}
+	for _, s := range []string{"", "local0", "local16", "g240", "g-1", "gx", "$100", "x"} {
+		if v, err := ParseVariableRef(s); err == nil {
+			t.Errorf("ParseVariableRef(%q) = %v; want error", s, v)
+		}
+	}
+}
+
+func TestSetVariable(t *testing.T) {
+	m, _ := newTestMachine(5, 0x200)
+	m.stack[0].Locals = make([]Word, 2)
+	tests := []struct {
+		Ref VariableRef
+		OK  bool
+	}{
+		{0x02, true},
+		{0x03, false},
+		{0x10, true},
+	}
+	for _, tt := range tests {
+		err := m.SetVariable(uint8(tt.Ref), 42)
+		if (err == nil) != tt.OK {
+			t.Errorf("SetVariable(%v, 42) = %v; want ok = %t", tt.Ref, err, tt.OK)
+		}
+		if tt.OK && m.Variable(uint8(tt.Ref)) != 42 {
+			t.Errorf("after SetVariable(%v, 42), Variable = %v", tt.Ref, m.Variable(uint8(tt.Ref)))
+		}
+	}
+}
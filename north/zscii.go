@@ -22,6 +22,41 @@ func (e ZSCIIDecodeError) Error() string {
 	return fmt.Sprintf("invalid ZSCII code point %#03x", e.Code)
 }
 
+// DefaultMaxStringLength is the number of Z-characters a machine decodes in one
+// string unless the MaxStringLength option says otherwise.  The longest strings
+// in real stories are a few thousand Z-characters.
+const DefaultMaxStringLength = 32 * 1024
+
+// A StringTooLongError is returned when a string runs past the length limit
+// without an end bit, usually because the story printed something that isn't
+// a string.
+type StringTooLongError struct {
+	Address Address
+	Limit   int
+
+	// Abbrev is true if the string is an abbreviation.
+	Abbrev bool
+}
+
+func (e *StringTooLongError) Error() string {
+	what := "String"
+	if e.Abbrev {
+		what = "Abbreviation"
+	}
+	return fmt.Sprintf("%s at %v is longer than %d Z-characters", what, e.Address, e.Limit)
+}
+
+// maxStringLength returns the string length limit, or -1 for no limit.
+func (m *Machine) maxStringLength() int {
+	if m.cfg.maxStringLength == 0 {
+		return DefaultMaxStringLength
+	}
+	return m.cfg.maxStringLength
+}
+
+// errStringTooLong is returned by a zcharReader that has reached its limit.
+var errStringTooLong = errors.New("string too long")
+
 type AlphabetSet [3][26]rune
 
 var (
@@ -133,14 +168,99 @@ func zsciiLookup(code uint16, output bool) (r rune, err error) {
 		return '\n', nil
 	case code >= 32 && code <= 126:
 		return rune(code), nil
+	case code >= 155 && int(code) < 155+len(defaultUnicodeTable):
+		return defaultUnicodeTable[code-155], nil
 	}
 	return 0, ZSCIIDecodeError{code}
 }
 
+// defaultUnicodeTable maps the extra characters starting at ZSCII 155 for
+// stories without a Unicode translation table (Standard 3.8.5.3).
+var defaultUnicodeTable = [...]rune{
+	'ä', 'ö', 'ü', 'Ä', 'Ö', 'Ü', 'ß', '»', '«', 'ë', 'ï', 'ÿ', 'Ë', 'Ï',
+	'á', 'é', 'í', 'ó', 'ú', 'ý', 'Á', 'É', 'Í', 'Ó', 'Ú', 'Ý',
+	'à', 'è', 'ì', 'ò', 'ù', 'À', 'È', 'Ì', 'Ò', 'Ù',
+	'â', 'ê', 'î', 'ô', 'û', 'Â', 'Ê', 'Î', 'Ô', 'Û',
+	'å', 'Å', 'ø', 'Ø', 'ã', 'ñ', 'õ', 'Ã', 'Ñ', 'Õ',
+	'æ', 'Æ', 'ç', 'Ç', 'þ', 'ð', 'Þ', 'Ð', '£', 'œ', 'Œ', '¡', '¿',
+}
+
+// unicodeTable returns the address of the story's Unicode translation table,
+// or 0 if it uses the default table.
+func (m *Machine) unicodeTable() Address {
+	if m.Version() < 5 {
+		return 0
+	}
+	ext := Address(m.loadWord(0x36))
+	if ext == 0 || m.loadWord(ext) < 3 {
+		return 0
+	}
+	return Address(m.loadWord(ext + 6))
+}
+
+// inUnicodeTable reports whether r is in the story's own Unicode translation
+// table.
+func (m *Machine) inUnicodeTable(r rune) bool {
+	t := m.unicodeTable()
+	if t == 0 {
+		return false
+	}
+	for i, n := Address(0), Address(m.loadByte(t)); i < n; i++ {
+		if rune(m.loadWord(t+1+i*2)) == r {
+			return true
+		}
+	}
+	return false
+}
+
+// zsciiRune is like zsciiLookup, but uses the story's Unicode translation
+// table for the extra characters if it has one.
+func (m *Machine) zsciiRune(code uint16, output bool) (rune, error) {
+	if code >= 155 && code <= 251 {
+		if t := m.unicodeTable(); t != 0 {
+			if i := Address(code - 155); i < Address(m.loadByte(t)) {
+				return rune(m.loadWord(t + 1 + i*2)), nil
+			}
+			return 0, ZSCIIDecodeError{code}
+		}
+	}
+	return zsciiLookup(code, output)
+}
+
+// zsciiCode returns the ZSCII code for r, using the story's Unicode
+// translation table for the extra characters if it has one.  It returns
+// false if r has no code.
+func (m *Machine) zsciiCode(r rune) (byte, bool) {
+	switch {
+	case r == '\n':
+		return 13, true
+	case r >= 32 && r <= 126:
+		return byte(r), true
+	}
+	if t := m.unicodeTable(); t != 0 {
+		for i, n := Address(0), Address(m.loadByte(t)); i < n && i <= 251-155; i++ {
+			if rune(m.loadWord(t+1+i*2)) == r {
+				return byte(155 + i), true
+			}
+		}
+		return 0, false
+	}
+	for i, u := range defaultUnicodeTable {
+		if u == r {
+			return byte(155 + i), true
+		}
+	}
+	return 0, false
+}
+
+// A zcharReader unpacks the Z-characters of a string.  If max is positive,
+// it returns errStringTooLong instead of reading more than max.
 type zcharReader struct {
 	r    io.Reader
 	pair [2]byte
 	i    int
+	n    int
+	max  int
 	err  error
 }
 
@@ -148,6 +268,13 @@ func (z *zcharReader) ReadByte() (byte, error) {
 	if z.err != nil {
 		return 0, z.err
 	}
+	if z.max > 0 && z.i != -1 {
+		if z.n >= z.max {
+			z.err = errStringTooLong
+			return 0, z.err
+		}
+		z.n++
+	}
 
 	switch z.i {
 	case 0:
@@ -182,7 +309,14 @@ func (z *zcharReader) ReadByte() (byte, error) {
 // decodeString decodes a Z-char-encoded ZSCII string from r. alphaset, output,
 // and u are the same as in NewZSCIIDecoder.
 func decodeString(r io.Reader, alphaset AlphabetSet, output bool, u Unabbreviater) (s string, err error) {
-	d := NewZSCIIDecoder(&zcharReader{r: r}, alphaset, output, u)
+	return decodeStringMax(r, alphaset, output, u, 0)
+}
+
+// decodeStringMax is like decodeString, but stops with errStringTooLong after
+// max Z-characters if max is positive.  s holds the text decoded before the
+// error.
+func decodeStringMax(r io.Reader, alphaset AlphabetSet, output bool, u Unabbreviater, max int) (s string, err error) {
+	d := NewZSCIIDecoder(&zcharReader{r: r, max: max}, alphaset, output, u)
 	ru := make([]rune, 0)
 	for {
 		var rr rune
@@ -199,3 +333,96 @@ func decodeString(r io.Reader, alphaset AlphabetSet, output bool, u Unabbreviate
 	}
 	return
 }
+
+// zsciiCode returns the ZSCII code point for a rune in the standard printable
+// range, the inverse of zsciiLookup for those runes.
+func zsciiCode(r rune) (byte, bool) {
+	switch {
+	case r == '\n':
+		return 13, true
+	case r >= 32 && r <= 126:
+		return byte(r), true
+	}
+	return 0, false
+}
+
+// encodeString encodes s as exactly n Z-characters, truncating or padding as
+// necessary, and packs them into n/3 words with the end bit set on the last
+// word.  n must be a positive multiple of 3.  Abbreviations are never used.
+// It uses the shift characters of version 3 and later, and only encodes
+// ASCII; encodeWord encodes for a particular story.
+func encodeString(s string, alphaset AlphabetSet, n int) ([]byte, error) {
+	return encodeZChars(s, alphaset, n, 3, zsciiCode)
+}
+
+// encodeWord encodes s as exactly n Z-characters the way the story's
+// dictionary entries are encoded: with the shift characters of the story's
+// version, and with its Unicode translation table for characters outside
+// ASCII.
+func (m *Machine) encodeWord(s string, n int) ([]byte, error) {
+	return encodeZChars(s, StandardAlphabetSet, n, m.Version(), m.zsciiCode)
+}
+
+// encodeZChars is encodeString for a story of the given version, using code
+// to find the ZSCII codes of characters outside the alphabets.  Versions 1
+// and 2 shift to the second and third alphabets with Z-characters 2 and 3;
+// later versions use 4 and 5.
+func encodeZChars(s string, alphaset AlphabetSet, n int, version byte, code func(rune) (byte, bool)) ([]byte, error) {
+	shiftBase := byte(3)
+	if version <= 2 {
+		shiftBase = 1
+	}
+	zchars := make([]byte, 0, n)
+	for _, r := range s {
+		if len(zchars) >= n {
+			break
+		}
+		if r == ' ' {
+			zchars = append(zchars, 0)
+			continue
+		}
+		if z, alphabet, ok := alphaset.find(r); ok {
+			if alphabet != 0 {
+				zchars = append(zchars, shiftBase+alphabet)
+			}
+			zchars = append(zchars, z)
+			continue
+		}
+		c, ok := code(r)
+		if !ok {
+			return nil, fmt.Errorf("cannot encode %q as ZSCII", r)
+		}
+		zchars = append(zchars, shiftBase+2, 6, c>>5&0x1f, c&0x1f)
+	}
+	if len(zchars) > n {
+		zchars = zchars[:n]
+	}
+	for len(zchars) < n {
+		zchars = append(zchars, 5)
+	}
+
+	b := make([]byte, n/3*2)
+	for i := 0; i < n; i += 3 {
+		w := uint16(zchars[i])<<10 | uint16(zchars[i+1])<<5 | uint16(zchars[i+2])
+		if i+3 == n {
+			w |= 0x8000
+		}
+		b[i/3*2], b[i/3*2+1] = byte(w>>8), byte(w)
+	}
+	return b, nil
+}
+
+// find returns the Z-character for r and the alphabet it's in, counting
+// from 0.  The escape and newline positions of the third alphabet are never
+// matched.
+func (a *AlphabetSet) find(r rune) (z byte, alphabet byte, ok bool) {
+	for i := range a {
+		for j, ar := range a[i] {
+			if ar != r || (i == 2 && j < 2) {
+				continue
+			}
+			return byte(j + 6), byte(i), true
+		}
+	}
+	return 0, 0, false
+}
@@ -2,9 +2,13 @@ package north
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
+
+	"github.com/zombiezen/gonorth/zasm"
 )
 
 func TestZCharReader(t *testing.T) {
@@ -96,3 +100,167 @@ func TestZSCIIDecoder(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeString(t *testing.T) {
+	tests := []struct {
+		Input  string
+		N      int
+		Output []byte
+	}{
+		{"", 6, []byte{0x14, 0xa5, 0x94, 0xa5}},
+		{"hi", 6, []byte{0x35, 0xc5, 0x94, 0xa5}},
+		{"Hi", 3, []byte{0x91, 0xae}},
+		{"a b", 3, []byte{0x98, 0x07}},
+		{"northeast", 6, []byte{0x4e, 0x97, 0xe5, 0xaa}},
+		{"@", 6, []byte{0x14, 0xc2, 0x80, 0xa5}},
+	}
+	for _, tt := range tests {
+		b, err := encodeString(tt.Input, StandardAlphabetSet, tt.N)
+		if err != nil {
+			t.Errorf("encodeString(%q, %d) error: %v", tt.Input, tt.N, err)
+		} else if !bytes.Equal(b, tt.Output) {
+			t.Errorf("encodeString(%q, %d) = % x; want % x", tt.Input, tt.N, b, tt.Output)
+		}
+		s, err := decodeString(bytes.NewReader(b), StandardAlphabetSet, true, nil)
+		if err != nil {
+			t.Errorf("decode %q error: %v", tt.Input, err)
+		} else if len(tt.Input) <= tt.N && s != tt.Input {
+			t.Errorf("decode(encodeString(%q, %d)) = %q", tt.Input, tt.N, s)
+		}
+	}
+	if _, err := encodeString("café", StandardAlphabetSet, 6); err == nil {
+		t.Error("encodeString of non-ZSCII rune did not fail")
+	}
+}
+
+// unterminatedString returns n words of "aaa" without an end bit, followed
+// by a word that ends the string.
+func unterminatedString(n int) []byte {
+	b := make([]byte, 0, n*2+2)
+	for i := 0; i < n; i++ {
+		b = append(b, 0x18, 0xc6)
+	}
+	return append(b, 0x94, 0xa5)
+}
+
+func TestStringTooLong(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		b := zasm.New(5)
+		b.Routine("main", 0)
+		b.Instr("print_addr", zasm.Addr("long"))
+		b.Instr("quit")
+		b.Data("long", unterminatedString(20))
+		ui := new(warnUI)
+		m := buildMachine(t, b, ui, Strict(strict), MaxStringLength(30))
+		a, _ := b.DataAddress("long")
+		err := m.Step()
+
+		var serr *StringTooLongError
+		if strict {
+			if !errors.As(err, &serr) {
+				t.Fatalf("strict: Step() = %v; want StringTooLongError", err)
+			}
+			want := StringTooLongError{Address: Address(a), Limit: 30}
+			if *serr != want {
+				t.Errorf("strict: error = %+v; want %+v", *serr, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal("Step:", err)
+		}
+		if s, want := ui.String(), strings.Repeat("a", 30); s != want {
+			t.Errorf("output = %q; want %q", s, want)
+		}
+		if len(ui.warnings) != 1 {
+			t.Errorf("warnings = %q; want 1 warning", ui.warnings)
+		}
+	}
+}
+
+func TestStringLengthLimit(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print_addr", zasm.Addr("long"))
+	b.Instr("quit")
+	b.Data("long", unterminatedString(9))
+	ui := new(warnUI)
+	m := buildMachine(t, b, ui, Strict(true), MaxStringLength(30))
+	if err := m.Step(); err != nil {
+		t.Fatal("Step:", err)
+	}
+	if s, want := ui.String(), strings.Repeat("a", 27); s != want {
+		t.Errorf("output = %q; want %q", s, want)
+	}
+}
+
+func TestAbbreviationTooLong(t *testing.T) {
+	b := zasm.New(5)
+	b.Routine("main", 0)
+	b.Instr("print_addr", zasm.Addr("short"))
+	b.Instr("quit")
+	b.Data("long", unterminatedString(20))
+	b.Data("abbrevs", make([]byte, 2))
+	// Abbreviation 0, then padding.
+	b.Data("short", []byte{0x84, 0x05})
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal("build story:", err)
+	}
+	long, _ := b.DataAddress("long")
+	abbrevs, _ := b.DataAddress("abbrevs")
+	if long%2 != 0 {
+		t.Fatalf("abbreviation at odd address %#x", long)
+	}
+	img[abbrevs], img[abbrevs+1] = byte(long/2>>8), byte(long/2)
+	img[0x18], img[0x19] = byte(abbrevs>>8), byte(abbrevs)
+	m, err := NewMachineFromBytes(img, new(bufferUI), Strict(true), MaxStringLength(30))
+	if err != nil {
+		t.Fatal("load story:", err)
+	}
+
+	err = m.Step()
+	var serr *StringTooLongError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Step() = %v; want StringTooLongError", err)
+	}
+	want := StringTooLongError{Address: Address(long), Limit: 30, Abbrev: true}
+	if *serr != want {
+		t.Errorf("error = %+v; want %+v", *serr, want)
+	}
+}
+
+func TestZSCIICode(t *testing.T) {
+	tests := []struct {
+		R     rune
+		Table bool // story's Unicode translation table is 'ж', '中'
+		Code  byte
+		OK    bool
+	}{
+		{'a', false, 'a', true},
+		{'\n', false, 13, true},
+		{'ä', false, 155, true},
+		{'é', false, 170, true},
+		{'¿', false, 223, true},
+		{'中', false, 0, false},
+		{0x1b, false, 0, false},
+		{'ж', true, 155, true},
+		{'中', true, 156, true},
+		{'é', true, 0, false},
+		{'a', true, 'a', true},
+	}
+	for _, tt := range tests {
+		m, _ := newTestMachine(5, 0x400)
+		if tt.Table {
+			m.storeWord(0x36, 0x100)
+			m.storeWord(0x100, 3)
+			m.storeWord(0x106, 0x200)
+			m.storeByte(0x200, 2)
+			m.storeWord(0x201, 'ж')
+			m.storeWord(0x203, '中')
+		}
+		if code, ok := m.zsciiCode(tt.R); code != tt.Code || ok != tt.OK {
+			t.Errorf("table %t: zsciiCode(%U) = %d, %t; want %d, %t", tt.Table, tt.R, code, ok, tt.Code, tt.OK)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package zasm
+
+import "sort"
+
+// An opcode is an entry in the mnemonic table.  It applies to stories with
+// versions between minVersion and maxVersion, inclusive.
+type opcode struct {
+	count      OpCount
+	number     uint8
+	minVersion byte
+	maxVersion byte
+}
+
+// opcodes maps mnemonics to the opcodes they name.  Mnemonics that changed
+// meaning between versions have several entries.
+var opcodes = map[string][]opcode{
+	"je":            {{OP2, 0x01, 1, 8}},
+	"jl":            {{OP2, 0x02, 1, 8}},
+	"jg":            {{OP2, 0x03, 1, 8}},
+	"dec_chk":       {{OP2, 0x04, 1, 8}},
+	"inc_chk":       {{OP2, 0x05, 1, 8}},
+	"jin":           {{OP2, 0x06, 1, 8}},
+	"test":          {{OP2, 0x07, 1, 8}},
+	"or":            {{OP2, 0x08, 1, 8}},
+	"and":           {{OP2, 0x09, 1, 8}},
+	"test_attr":     {{OP2, 0x0a, 1, 8}},
+	"set_attr":      {{OP2, 0x0b, 1, 8}},
+	"clear_attr":    {{OP2, 0x0c, 1, 8}},
+	"store":         {{OP2, 0x0d, 1, 8}},
+	"insert_obj":    {{OP2, 0x0e, 1, 8}},
+	"loadw":         {{OP2, 0x0f, 1, 8}},
+	"loadb":         {{OP2, 0x10, 1, 8}},
+	"get_prop":      {{OP2, 0x11, 1, 8}},
+	"get_prop_addr": {{OP2, 0x12, 1, 8}},
+	"get_next_prop": {{OP2, 0x13, 1, 8}},
+	"add":           {{OP2, 0x14, 1, 8}},
+	"sub":           {{OP2, 0x15, 1, 8}},
+	"mul":           {{OP2, 0x16, 1, 8}},
+	"div":           {{OP2, 0x17, 1, 8}},
+	"mod":           {{OP2, 0x18, 1, 8}},
+	"call_2s":       {{OP2, 0x19, 4, 8}},
+	"call_2n":       {{OP2, 0x1a, 5, 8}},
+	"set_colour":    {{OP2, 0x1b, 5, 8}},
+	"throw":         {{OP2, 0x1c, 5, 8}},
+
+	"jz":           {{OP1, 0x00, 1, 8}},
+	"get_sibling":  {{OP1, 0x01, 1, 8}},
+	"get_child":    {{OP1, 0x02, 1, 8}},
+	"get_parent":   {{OP1, 0x03, 1, 8}},
+	"get_prop_len": {{OP1, 0x04, 1, 8}},
+	"inc":          {{OP1, 0x05, 1, 8}},
+	"dec":          {{OP1, 0x06, 1, 8}},
+	"print_addr":   {{OP1, 0x07, 1, 8}},
+	"call_1s":      {{OP1, 0x08, 4, 8}},
+	"remove_obj":   {{OP1, 0x09, 1, 8}},
+	"print_obj":    {{OP1, 0x0a, 1, 8}},
+	"ret":          {{OP1, 0x0b, 1, 8}},
+	"jump":         {{OP1, 0x0c, 1, 8}},
+	"print_paddr":  {{OP1, 0x0d, 1, 8}},
+	"load":         {{OP1, 0x0e, 1, 8}},
+	"not":          {{OP1, 0x0f, 1, 4}, {VAR, 0x18, 5, 8}},
+	"call_1n":      {{OP1, 0x0f, 5, 8}},
+
+	"rtrue":       {{OP0, 0x00, 1, 8}},
+	"rfalse":      {{OP0, 0x01, 1, 8}},
+	"print":       {{OP0, 0x02, 1, 8}},
+	"print_ret":   {{OP0, 0x03, 1, 8}},
+	"nop":         {{OP0, 0x04, 1, 8}},
+	"save":        {{OP0, 0x05, 1, 4}, {EXT, 0x00, 5, 8}},
+	"restore":     {{OP0, 0x06, 1, 4}, {EXT, 0x01, 5, 8}},
+	"restart":     {{OP0, 0x07, 1, 8}},
+	"ret_popped":  {{OP0, 0x08, 1, 8}},
+	"pop":         {{OP0, 0x09, 1, 4}},
+	"catch":       {{OP0, 0x09, 5, 8}},
+	"quit":        {{OP0, 0x0a, 1, 8}},
+	"new_line":    {{OP0, 0x0b, 1, 8}},
+	"show_status": {{OP0, 0x0c, 3, 3}},
+	"verify":      {{OP0, 0x0d, 3, 8}},
+	"piracy":      {{OP0, 0x0f, 5, 8}},
+
+	"call_vs":         {{VAR, 0x00, 1, 8}},
+	"storew":          {{VAR, 0x01, 1, 8}},
+	"storeb":          {{VAR, 0x02, 1, 8}},
+	"put_prop":        {{VAR, 0x03, 1, 8}},
+	"sread":           {{VAR, 0x04, 1, 4}},
+	"aread":           {{VAR, 0x04, 5, 8}},
+	"print_char":      {{VAR, 0x05, 1, 8}},
+	"print_num":       {{VAR, 0x06, 1, 8}},
+	"random":          {{VAR, 0x07, 1, 8}},
+	"push":            {{VAR, 0x08, 1, 8}},
+	"pull":            {{VAR, 0x09, 1, 8}},
+	"split_window":    {{VAR, 0x0a, 3, 8}},
+	"set_window":      {{VAR, 0x0b, 3, 8}},
+	"call_vs2":        {{VAR, 0x0c, 4, 8}},
+	"erase_window":    {{VAR, 0x0d, 4, 8}},
+	"erase_line":      {{VAR, 0x0e, 4, 8}},
+	"set_cursor":      {{VAR, 0x0f, 4, 8}},
+	"get_cursor":      {{VAR, 0x10, 4, 8}},
+	"set_text_style":  {{VAR, 0x11, 4, 8}},
+	"buffer_mode":     {{VAR, 0x12, 4, 8}},
+	"output_stream":   {{VAR, 0x13, 3, 8}},
+	"input_stream":    {{VAR, 0x14, 3, 8}},
+	"sound_effect":    {{VAR, 0x15, 3, 8}},
+	"read_char":       {{VAR, 0x16, 4, 8}},
+	"scan_table":      {{VAR, 0x17, 4, 8}},
+	"call_vn":         {{VAR, 0x19, 5, 8}},
+	"call_vn2":        {{VAR, 0x1a, 5, 8}},
+	"tokenise":        {{VAR, 0x1b, 5, 8}},
+	"encode_text":     {{VAR, 0x1c, 5, 8}},
+	"copy_table":      {{VAR, 0x1d, 5, 8}},
+	"print_table":     {{VAR, 0x1e, 5, 8}},
+	"check_arg_count": {{VAR, 0x1f, 5, 8}},
+
+	"log_shift":     {{EXT, 0x02, 5, 8}},
+	"art_shift":     {{EXT, 0x03, 5, 8}},
+	"set_font":      {{EXT, 0x04, 5, 8}},
+	"save_undo":     {{EXT, 0x09, 5, 8}},
+	"restore_undo":  {{EXT, 0x0a, 5, 8}},
+	"print_unicode": {{EXT, 0x0b, 5, 8}},
+	"check_unicode": {{EXT, 0x0c, 5, 8}},
+	"draw_picture":  {{EXT, 0x05, 6, 6}},
+	"picture_data":  {{EXT, 0x06, 6, 6}},
+	"erase_picture": {{EXT, 0x07, 6, 6}},
+	"read_mouse":    {{EXT, 0x16, 6, 6}},
+	"mouse_window":  {{EXT, 0x17, 6, 6}},
+	"make_menu":     {{EXT, 0x1b, 6, 6}},
+	"picture_table": {{EXT, 0x1c, 6, 6}},
+}
+
+// lookupOpcode finds the opcode for mnemonic in the given version.
+func lookupOpcode(mnemonic string, version byte) (opcode, bool) {
+	for _, op := range opcodes[mnemonic] {
+		if version >= op.minVersion && version <= op.maxVersion {
+			return op, true
+		}
+	}
+	return opcode{}, false
+}
+
+// Lookup returns the operand count class and opcode number of the instruction
+// named by mnemonic in the given version.
+func Lookup(mnemonic string, version byte) (c OpCount, n uint8, ok bool) {
+	op, ok := lookupOpcode(mnemonic, version)
+	return op.count, op.number, ok
+}
+
+// Mnemonics returns the sorted names of the instructions available in the
+// given version.
+func Mnemonics(version byte) []string {
+	var names []string
+	for name := range opcodes {
+		if _, ok := lookupOpcode(name, version); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Instr emits the instruction named by mnemonic, using the standard names
+// from the Z-Machine Standards Document.  The arguments are as for Op.
+func (b *Builder) Instr(mnemonic string, args ...Arg) {
+	op, ok := lookupOpcode(mnemonic, b.version)
+	if !ok {
+		b.fail(errorf("unknown instruction %q in version %d", mnemonic, b.version))
+		return
+	}
+	b.Op(op.count, op.number, args...)
+}
@@ -0,0 +1,81 @@
+package zasm
+
+var alphabets = [3]string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	" \n0123456789.,!?_#'\"/\\-:()",
+}
+
+// zchars converts s to Z-characters using the standard alphabets.  Runes that
+// aren't in an alphabet are written as ZSCII escapes.
+func zchars(s string) ([]byte, error) {
+	var z []byte
+	for _, r := range s {
+		if r == ' ' {
+			z = append(z, 0)
+			continue
+		}
+		if a, i := findAlphabet(r); i >= 0 {
+			if a != 0 {
+				z = append(z, byte(a+3))
+			}
+			z = append(z, byte(i+6))
+			continue
+		}
+		var code int
+		switch {
+		case r == '\n':
+			code = 13
+		case r >= 32 && r <= 126:
+			code = int(r)
+		default:
+			return nil, errorf("cannot encode %q in ZSCII", r)
+		}
+		z = append(z, 5, 6, byte(code>>5&0x1f), byte(code&0x1f))
+	}
+	return z, nil
+}
+
+// findAlphabet returns the alphabet and index of r, or -1 for the index if r
+// isn't in any alphabet.  The escape and newline entries of the third
+// alphabet never match.
+func findAlphabet(r rune) (alphabet, index int) {
+	for a, s := range alphabets {
+		for i, ar := range s {
+			if ar == r && (a != 2 || i >= 2) {
+				return a, i
+			}
+		}
+	}
+	return 0, -1
+}
+
+// encodeText encodes s as a Z-machine string.  If n is positive, the string is
+// truncated or padded to exactly n Z-characters, as for dictionary words.
+func encodeText(s string, n int) ([]byte, error) {
+	z, err := zchars(s)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		if len(z) > n {
+			z = z[:n]
+		}
+		for len(z) < n {
+			z = append(z, 5)
+		}
+	}
+	for len(z) == 0 || len(z)%3 != 0 {
+		z = append(z, 5)
+	}
+
+	b := make([]byte, 0, len(z)/3*2)
+	for i := 0; i < len(z); i += 3 {
+		w := uint16(z[i])<<10 | uint16(z[i+1])<<5 | uint16(z[i+2])
+		if i+3 == len(z) {
+			w |= 0x8000
+		}
+		b = append(b, byte(w>>8), byte(w))
+	}
+	return b, nil
+}
@@ -0,0 +1,833 @@
+// Package zasm assembles small Z-machine story files.  It is meant for
+// building test stories programmatically: routines, strings, a dictionary, an
+// object tree, and the header are laid out and cross-linked by Build.
+// Versions 1-8 are supported, but only a few of version 6's own opcodes are.
+package zasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+func errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("zasm: "+format, args...)
+}
+
+// Special branch targets
+const (
+	ReturnFalse = "rfalse"
+	ReturnTrue  = "rtrue"
+)
+
+// An OpCount is the operand count class of an opcode.
+type OpCount int
+
+// Operand count classes
+const (
+	OP0 OpCount = iota
+	OP1
+	OP2
+	VAR
+	EXT
+)
+
+type fixupKind int
+
+const (
+	fixNone fixupKind = iota
+	fixPacked
+	fixRelative
+	fixBranch
+	fixData
+	fixDict
+	fixObject
+)
+
+// A fixup is a word in high memory that must be patched once the story is laid
+// out.
+type fixup struct {
+	Pos   int
+	Kind  fixupKind
+	Label string
+	Cond  bool
+}
+
+type operandKind int
+
+const (
+	constOperand operandKind = iota
+	largeOperand
+	varOperand
+)
+
+// An Arg is an argument to an instruction: an operand, a store variable, a
+// branch target, or inline text.
+type Arg interface {
+	arg()
+}
+
+// An Operand is an instruction operand.
+type Operand struct {
+	kind  operandKind
+	value uint16
+	fix   fixupKind
+	label string
+}
+
+func (Operand) arg() {}
+
+// Const returns a constant operand.  It is encoded as a small constant if it
+// fits in a byte.
+func Const(v uint16) Operand {
+	return Operand{kind: constOperand, value: v}
+}
+
+// Large returns a constant operand that is always encoded as a large constant.
+func Large(v uint16) Operand {
+	return Operand{kind: largeOperand, value: v}
+}
+
+// Var returns a variable operand.
+func Var(v uint8) Operand {
+	return Operand{kind: varOperand, value: uint16(v)}
+}
+
+// SP is the stack pointer variable.
+var SP = Var(0)
+
+// Local returns the variable operand for local variable n (1-based).
+func Local(n uint8) Operand {
+	return Var(n)
+}
+
+// Global returns the variable operand for global variable n (0-based).
+func Global(n uint8) Operand {
+	return Var(0x10 + n)
+}
+
+// Routine returns the packed address of a routine as an operand.
+func Routine(name string) Operand {
+	return Operand{kind: largeOperand, fix: fixPacked, label: name}
+}
+
+// Packed returns the packed address of a string as an operand.
+func Packed(label string) Operand {
+	return Operand{kind: largeOperand, fix: fixPacked, label: label}
+}
+
+// Label returns the offset to a label for use as the jump operand.
+func Label(name string) Operand {
+	return Operand{kind: largeOperand, fix: fixRelative, label: name}
+}
+
+// Addr returns the byte address of a data block as an operand.
+func Addr(label string) Operand {
+	return Operand{kind: largeOperand, fix: fixData, label: label}
+}
+
+// DictAddr returns the address of a dictionary entry as an operand.
+func DictAddr(word string) Operand {
+	return Operand{kind: largeOperand, fix: fixDict, label: word}
+}
+
+// Obj returns the number of an object as an operand.
+func Obj(name string) Operand {
+	return Operand{kind: largeOperand, fix: fixObject, label: name}
+}
+
+type storeArg uint8
+
+func (storeArg) arg() {}
+
+// Store stores the instruction's result in variable v.
+func Store(v Operand) Arg {
+	return storeArg(v.value)
+}
+
+type branchArg struct {
+	label string
+	cond  bool
+}
+
+func (branchArg) arg() {}
+
+// IfTrue branches to label when the instruction's condition is true.  label
+// may be ReturnTrue or ReturnFalse.
+func IfTrue(label string) Arg {
+	return branchArg{label, true}
+}
+
+// Branch is the same as IfTrue.
+func Branch(label string) Arg {
+	return IfTrue(label)
+}
+
+// IfFalse branches to label when the instruction's condition is false.
+func IfFalse(label string) Arg {
+	return branchArg{label, false}
+}
+
+type textArg string
+
+func (textArg) arg() {}
+
+// Text is the inline string for print and print_ret.
+func Text(s string) Arg {
+	return textArg(s)
+}
+
+// A Property is an object property.
+type Property struct {
+	Num  uint8
+	Data []byte
+}
+
+// Prop returns a property with the given number and data.
+func Prop(num uint8, data ...byte) Property {
+	return Property{num, data}
+}
+
+// WordProp returns a property holding a single word.
+func WordProp(num uint8, w uint16) Property {
+	return Property{num, []byte{byte(w >> 8), byte(w)}}
+}
+
+type object struct {
+	Name   string
+	Parent string
+	Attrs  []int
+	Props  []Property
+}
+
+type dataBlock struct {
+	Label string
+	Data  []byte
+}
+
+// A Builder assembles a story file.  Methods record the first error that
+// occurs, which is returned by Build.
+type Builder struct {
+	version byte
+
+	// Release and Serial are copied into the header.
+	Release uint16
+	Serial  string
+
+	// Separators are the word separators of the dictionary.
+	Separators []rune
+
+	high   []byte
+	labels map[string]int
+	fixups []fixup
+
+	globals    [240]uint16
+	defaults   [63]uint16
+	data       []dataBlock
+	dataAddrs  map[string]int
+	objects    []object
+	objectNums map[string]int
+	words      []string
+	err        error
+}
+
+// New returns a builder for a story of the given version.
+func New(version byte) *Builder {
+	b := &Builder{
+		version:    version,
+		Serial:     "000000",
+		Separators: []rune{'.', ',', '"'},
+		labels:     make(map[string]int),
+		objectNums: make(map[string]int),
+	}
+	switch version {
+	case 1, 2, 3, 4, 5, 6, 7, 8:
+	default:
+		b.err = errorf("unsupported version %d", version)
+	}
+	return b
+}
+
+// Version returns the story version being built.
+func (b *Builder) Version() byte {
+	return b.version
+}
+
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// packing returns the packed address multiplier.  In versions 6 and 7, the
+// routine and string offsets are left at zero.
+func (b *Builder) packing() int {
+	switch {
+	case b.version <= 3:
+		return 2
+	case b.version <= 7:
+		return 4
+	}
+	return 8
+}
+
+// lengthUnit returns the multiplier for the header's file length.
+func (b *Builder) lengthUnit() int {
+	switch {
+	case b.version <= 3:
+		return 2
+	case b.version <= 5:
+		return 4
+	}
+	return 8
+}
+
+func (b *Builder) align(n int) {
+	for len(b.high)%n != 0 {
+		b.high = append(b.high, 0)
+	}
+}
+
+func (b *Builder) defineLabel(name string) {
+	if _, exists := b.labels[name]; exists {
+		b.fail(errorf("label %q defined twice", name))
+		return
+	}
+	b.labels[name] = len(b.high)
+}
+
+// Routine starts a routine with nlocals local variables.  In versions 1-4,
+// defaults gives the initial values of the locals.  The routine named "main"
+// is where execution starts; in versions other than 6 and 7, it must not have
+// locals.
+func (b *Builder) Routine(name string, nlocals int, defaults ...uint16) {
+	if nlocals > 15 || len(defaults) > nlocals {
+		b.fail(errorf("routine %s: bad locals", name))
+		return
+	}
+	b.align(b.packing())
+	b.defineLabel(name)
+	b.high = append(b.high, byte(nlocals))
+	if b.version <= 4 {
+		for i := 0; i < nlocals; i++ {
+			var d uint16
+			if i < len(defaults) {
+				d = defaults[i]
+			}
+			b.high = append(b.high, byte(d>>8), byte(d))
+		}
+	}
+}
+
+// Label defines a label at the next instruction.
+func (b *Builder) Label(name string) {
+	b.defineLabel(name)
+}
+
+// String adds a string to high memory.  Its packed address is given by
+// Packed(label).
+func (b *Builder) String(label, text string) {
+	enc, err := encodeText(text, 0)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	b.align(b.packing())
+	b.defineLabel(label)
+	b.high = append(b.high, enc...)
+}
+
+// Data adds a block of dynamic memory.  Its address is given by Addr(label).
+func (b *Builder) Data(label string, data []byte) {
+	b.data = append(b.data, dataBlock{label, append([]byte(nil), data...)})
+}
+
+// DataAddress returns the address of the data block added with label.  It is
+// only valid after a successful Build.
+func (b *Builder) DataAddress(label string) (int, bool) {
+	a, ok := b.dataAddrs[label]
+	return a, ok
+}
+
+// SetGlobal sets the initial value of global variable n (0-based).
+func (b *Builder) SetGlobal(n int, v uint16) {
+	b.globals[n] = v
+}
+
+// PropertyDefault sets the default value of property num.
+func (b *Builder) PropertyDefault(num uint8, v uint16) {
+	b.defaults[num-1] = v
+}
+
+// DictWord adds a word to the dictionary.
+func (b *Builder) DictWord(words ...string) {
+	b.words = append(b.words, words...)
+}
+
+// Object adds an object to the object tree and returns its number.  Objects
+// are numbered from 1 in the order they are added, and children are ordered
+// the same way.  parent may be empty for a root object.
+func (b *Builder) Object(name, parent string, attrs []int, props ...Property) int {
+	if _, exists := b.objectNums[name]; exists {
+		b.fail(errorf("object %q defined twice", name))
+		return 0
+	}
+	b.objects = append(b.objects, object{name, parent, attrs, props})
+	b.objectNums[name] = len(b.objects)
+	return len(b.objects)
+}
+
+// Op emits an instruction with operand count class c and opcode number n.
+// The arguments are the operands followed by the store variable, branch, and
+// text, as the opcode requires.
+func (b *Builder) Op(c OpCount, n uint8, args ...Arg) {
+	var ops []Operand
+	var store *storeArg
+	var branch *branchArg
+	var text *textArg
+	for _, a := range args {
+		switch a := a.(type) {
+		case Operand:
+			ops = append(ops, a)
+		case storeArg:
+			store = &a
+		case branchArg:
+			branch = &a
+		case textArg:
+			text = &a
+		}
+	}
+
+	switch c {
+	case OP0:
+		if len(ops) != 0 {
+			b.fail(errorf("0OP:%02x given %d operands", n, len(ops)))
+			return
+		}
+		b.high = append(b.high, 0xb0|n&0x0f)
+	case OP1:
+		if len(ops) != 1 {
+			b.fail(errorf("1OP:%02x given %d operands", n, len(ops)))
+			return
+		}
+		b.high = append(b.high, 0x80|ops[0].typeBits()<<4|n&0x0f)
+	case OP2:
+		if len(ops) == 2 && ops[0].small() && ops[1].small() {
+			op := n & 0x1f
+			if ops[0].kind == varOperand {
+				op |= 0x40
+			}
+			if ops[1].kind == varOperand {
+				op |= 0x20
+			}
+			b.high = append(b.high, op)
+		} else {
+			if len(ops) > 4 {
+				b.fail(errorf("2OP:%02x given %d operands", n, len(ops)))
+				return
+			}
+			b.high = append(b.high, 0xc0|n&0x1f)
+			b.high = append(b.high, typeBytes(ops, 1)...)
+		}
+	case VAR:
+		b.high = append(b.high, 0xe0|n&0x1f)
+		switch {
+		case len(ops) <= 4:
+			b.high = append(b.high, typeBytes(ops, 1)...)
+		case len(ops) <= 8 && (n == 0x0c || n == 0x1a):
+			b.high = append(b.high, typeBytes(ops, 2)...)
+		default:
+			b.fail(errorf("VAR:%02x given %d operands", n, len(ops)))
+			return
+		}
+	case EXT:
+		if len(ops) > 4 {
+			b.fail(errorf("EXT:%02x given %d operands", n, len(ops)))
+			return
+		}
+		b.high = append(b.high, 0xbe, n)
+		b.high = append(b.high, typeBytes(ops, 1)...)
+	}
+
+	for _, o := range ops {
+		b.operand(o)
+	}
+	if store != nil {
+		b.high = append(b.high, byte(*store))
+	}
+	if branch != nil {
+		b.branch(*branch)
+	}
+	if text != nil {
+		enc, err := encodeText(string(*text), 0)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		b.high = append(b.high, enc...)
+	}
+}
+
+func (o Operand) small() bool {
+	return o.kind == varOperand || o.kind == constOperand && o.value < 0x100
+}
+
+// typeBits returns the 2-bit operand type.
+func (o Operand) typeBits() byte {
+	switch {
+	case o.kind == varOperand:
+		return 2
+	case o.small():
+		return 1
+	}
+	return 0
+}
+
+// typeBytes returns n bytes of operand types for ops.
+func typeBytes(ops []Operand, n int) []byte {
+	t := make([]byte, n)
+	for i := range t {
+		t[i] = 0xff
+	}
+	for i, o := range ops {
+		shift := uint(6 - i%4*2)
+		t[i/4] = t[i/4]&^(3<<shift) | o.typeBits()<<shift
+	}
+	return t
+}
+
+func (b *Builder) operand(o Operand) {
+	if o.fix != fixNone {
+		b.fixups = append(b.fixups, fixup{Pos: len(b.high), Kind: o.fix, Label: o.label})
+	}
+	if o.small() {
+		b.high = append(b.high, byte(o.value))
+	} else {
+		b.high = append(b.high, byte(o.value>>8), byte(o.value))
+	}
+}
+
+func (b *Builder) branch(br branchArg) {
+	var cond byte
+	if br.cond {
+		cond = 0x80
+	}
+	switch br.label {
+	case ReturnFalse:
+		b.high = append(b.high, cond|0x40)
+	case ReturnTrue:
+		b.high = append(b.high, cond|0x41)
+	default:
+		b.fixups = append(b.fixups, fixup{Pos: len(b.high), Kind: fixBranch, Label: br.label, Cond: br.cond})
+		b.high = append(b.high, 0, 0)
+	}
+}
+
+// Header field addresses
+const (
+	hdrVersion        = 0x00
+	hdrRelease        = 0x02
+	hdrHighMemory     = 0x04
+	hdrInitialPC      = 0x06
+	hdrDictionary     = 0x08
+	hdrObjects        = 0x0a
+	hdrGlobals        = 0x0c
+	hdrStaticMemory   = 0x0e
+	hdrSerial         = 0x12
+	hdrAbbreviations  = 0x18
+	hdrFileLength     = 0x1a
+	hdrChecksum       = 0x1c
+	headerSize        = 0x40
+	numGlobals        = 240
+	dictEntryDataSize = 3
+)
+
+// Build lays out the story and returns its image.
+func (b *Builder) Build() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	mainAddr, ok := b.labels["main"]
+	if !ok {
+		return nil, errors.New("zasm: no main routine")
+	}
+
+	img := make([]byte, headerSize)
+	putWord := func(a int, w uint16) {
+		img[a], img[a+1] = byte(w>>8), byte(w)
+	}
+
+	// Object table
+	objectsAddr := len(img)
+	img, err := b.appendObjects(img)
+	if err != nil {
+		return nil, err
+	}
+
+	// Globals
+	globalsAddr := len(img)
+	for _, g := range b.globals {
+		img = append(img, byte(g>>8), byte(g))
+	}
+
+	// Dynamic data
+	dataAddrs := make(map[string]int, len(b.data))
+	b.dataAddrs = dataAddrs
+	for _, d := range b.data {
+		if _, exists := dataAddrs[d.Label]; exists {
+			return nil, errorf("data %q defined twice", d.Label)
+		}
+		dataAddrs[d.Label] = len(img)
+		img = append(img, d.Data...)
+	}
+
+	// Dictionary
+	if len(img)%2 != 0 {
+		img = append(img, 0)
+	}
+	staticAddr := len(img)
+	dictAddr := len(img)
+	img, dictAddrs, err := b.appendDictionary(img)
+	if err != nil {
+		return nil, err
+	}
+
+	// High memory
+	for len(img)%b.lengthUnit() != 0 {
+		img = append(img, 0)
+	}
+	highAddr := len(img)
+	img = append(img, b.high...)
+	for len(img)%b.lengthUnit() != 0 {
+		img = append(img, 0)
+	}
+
+	for _, f := range b.fixups {
+		pos := highAddr + f.Pos
+		switch f.Kind {
+		case fixPacked:
+			off, ok := b.labels[f.Label]
+			if !ok {
+				return nil, errorf("undefined label %q", f.Label)
+			}
+			putWord(pos, uint16((highAddr+off)/b.packing()))
+		case fixRelative, fixBranch:
+			off, ok := b.labels[f.Label]
+			if !ok {
+				return nil, errorf("undefined label %q", f.Label)
+			}
+			// Offsets are relative to the end of the two-byte field, plus two.
+			rel := off - f.Pos
+			if f.Kind == fixRelative {
+				putWord(pos, uint16(int16(rel)))
+				continue
+			}
+			// Long branches have a 14-bit offset with the condition in the top bit.
+			if rel < -0x2000 || rel >= 0x2000 {
+				return nil, errorf("branch to %q out of range", f.Label)
+			}
+			w := uint16(rel) & 0x3fff
+			if f.Cond {
+				w |= 0x8000
+			}
+			putWord(pos, w)
+		case fixData:
+			a, ok := dataAddrs[f.Label]
+			if !ok {
+				return nil, errorf("undefined data %q", f.Label)
+			}
+			putWord(pos, uint16(a))
+		case fixDict:
+			a, ok := dictAddrs[f.Label]
+			if !ok {
+				return nil, errorf("word %q not in dictionary", f.Label)
+			}
+			putWord(pos, uint16(a))
+		case fixObject:
+			n, ok := b.objectNums[f.Label]
+			if !ok {
+				return nil, errorf("undefined object %q", f.Label)
+			}
+			putWord(pos, uint16(n))
+		}
+	}
+
+	// Header
+	img[hdrVersion] = b.version
+	putWord(hdrRelease, b.Release)
+	putWord(hdrHighMemory, uint16(highAddr))
+	pc := highAddr + mainAddr + 1
+	switch {
+	case b.version <= 4:
+		pc += 2 * int(b.high[mainAddr])
+	case b.version == 6 || b.version == 7:
+		// The header holds the packed address of the main routine.
+		pc = (highAddr + mainAddr) / b.packing()
+	}
+	putWord(hdrInitialPC, uint16(pc))
+	putWord(hdrDictionary, uint16(dictAddr))
+	putWord(hdrObjects, uint16(objectsAddr))
+	putWord(hdrGlobals, uint16(globalsAddr))
+	putWord(hdrStaticMemory, uint16(staticAddr))
+	copy(img[hdrSerial:hdrSerial+6], b.Serial)
+	putWord(hdrAbbreviations, 0)
+	putWord(hdrFileLength, uint16(len(img)/b.lengthUnit()))
+	var sum uint16
+	for _, c := range img[headerSize:] {
+		sum += uint16(c)
+	}
+	putWord(hdrChecksum, sum)
+	return img, nil
+}
+
+func (b *Builder) appendObjects(img []byte) ([]byte, error) {
+	ndefaults, entrySize, nattrs := 31, 9, 32
+	if b.version >= 4 {
+		ndefaults, entrySize, nattrs = 63, 14, 48
+	}
+	for _, d := range b.defaults[:ndefaults] {
+		img = append(img, byte(d>>8), byte(d))
+	}
+
+	// Compute tree links in declaration order.
+	type links struct{ parent, sibling, child int }
+	tree := make([]links, len(b.objects)+1)
+	lastChild := make([]int, len(b.objects)+1)
+	for i, o := range b.objects {
+		n := i + 1
+		if o.Parent == "" {
+			continue
+		}
+		p, ok := b.objectNums[o.Parent]
+		if !ok {
+			return nil, errorf("object %q: undefined parent %q", o.Name, o.Parent)
+		}
+		tree[n].parent = p
+		if lastChild[p] == 0 {
+			tree[p].child = n
+		} else {
+			tree[lastChild[p]].sibling = n
+		}
+		lastChild[p] = n
+	}
+
+	entries := len(img)
+	img = append(img, make([]byte, len(b.objects)*entrySize)...)
+	for i, o := range b.objects {
+		e := img[entries+i*entrySize : entries+(i+1)*entrySize]
+		for _, a := range o.Attrs {
+			if a < 0 || a >= nattrs {
+				return nil, errorf("object %q: bad attribute %d", o.Name, a)
+			}
+			e[a/8] |= 1 << uint(7-a%8)
+		}
+		l := tree[i+1]
+		if b.version <= 3 {
+			e[4], e[5], e[6] = byte(l.parent), byte(l.sibling), byte(l.child)
+		} else {
+			e[6], e[7] = byte(l.parent>>8), byte(l.parent)
+			e[8], e[9] = byte(l.sibling>>8), byte(l.sibling)
+			e[10], e[11] = byte(l.child>>8), byte(l.child)
+		}
+		propAddr := len(img)
+		e[entrySize-2], e[entrySize-1] = byte(propAddr>>8), byte(propAddr)
+
+		var err error
+		img, err = b.appendProperties(img, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func (b *Builder) appendProperties(img []byte, o object) ([]byte, error) {
+	name, err := encodeText(o.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+	img = append(img, byte(len(name)/2))
+	img = append(img, name...)
+
+	props := append([]Property(nil), o.Props...)
+	sort.Slice(props, func(i, j int) bool { return props[i].Num > props[j].Num })
+	for i, p := range props {
+		if i > 0 && props[i-1].Num == p.Num {
+			return nil, errorf("object %q: property %d defined twice", o.Name, p.Num)
+		}
+		size := len(p.Data)
+		switch {
+		case p.Num == 0:
+			return nil, errorf("object %q: property 0", o.Name)
+		case b.version <= 3:
+			if p.Num > 31 || size < 1 || size > 8 {
+				return nil, errorf("object %q: bad property %d", o.Name, p.Num)
+			}
+			img = append(img, byte(size-1)<<5|p.Num)
+		case p.Num > 63 || size < 1 || size > 64:
+			return nil, errorf("object %q: bad property %d", o.Name, p.Num)
+		case size <= 2:
+			img = append(img, byte(size-1)<<6|p.Num)
+		default:
+			img = append(img, 0x80|p.Num, 0x80|byte(size&0x3f))
+		}
+		img = append(img, p.Data...)
+	}
+	return append(img, 0), nil
+}
+
+func (b *Builder) appendDictionary(img []byte) ([]byte, map[string]int, error) {
+	img = append(img, byte(len(b.Separators)))
+	for _, r := range b.Separators {
+		if r < 32 || r > 126 {
+			return nil, nil, errorf("bad separator %q", r)
+		}
+		img = append(img, byte(r))
+	}
+
+	n := 6
+	if b.version >= 4 {
+		n = 9
+	}
+	type entry struct {
+		word string
+		enc  []byte
+	}
+	entries := make([]entry, 0, len(b.words))
+	seen := make(map[string]bool, len(b.words))
+	for _, w := range b.words {
+		enc, err := encodeText(w, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !seen[string(enc)] {
+			seen[string(enc)] = true
+			entries = append(entries, entry{w, enc})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].enc, entries[j].enc) < 0
+	})
+
+	entrySize := n/3*2 + dictEntryDataSize
+	img = append(img, byte(entrySize), byte(len(entries)>>8), byte(len(entries)))
+	addrs := make(map[string]int, len(b.words))
+	for _, e := range entries {
+		addrs[e.word] = len(img)
+		img = append(img, e.enc...)
+		img = append(img, make([]byte, dictEntryDataSize)...)
+	}
+	// Words that were truncated to the same entry share its address.
+	for _, w := range b.words {
+		if _, ok := addrs[w]; !ok {
+			enc, _ := encodeText(w, n)
+			for _, e := range entries {
+				if bytes.Equal(e.enc, enc) {
+					addrs[w] = addrs[e.word]
+				}
+			}
+		}
+	}
+	return img, addrs, nil
+}
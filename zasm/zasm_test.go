@@ -0,0 +1,223 @@
+package zasm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func word(img []byte, a int) int {
+	return int(img[a])<<8 | int(img[a+1])
+}
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		Input  string
+		N      int
+		Output []byte
+	}{
+		{"Hi", 0, []byte{0x91, 0xae}},
+		{"", 0, []byte{0x94, 0xa5}},
+		{"hi", 6, []byte{0x35, 0xc5, 0x94, 0xa5}},
+		{"northeast", 6, []byte{0x4e, 0x97, 0xe5, 0xaa}},
+		{"@", 0, []byte{0x14, 0xc2, 0x80, 0xa5}},
+	}
+	for _, tt := range tests {
+		b, err := encodeText(tt.Input, tt.N)
+		if err != nil {
+			t.Errorf("encodeText(%q, %d) error: %v", tt.Input, tt.N, err)
+		} else if !bytes.Equal(b, tt.Output) {
+			t.Errorf("encodeText(%q, %d) = % x; want % x", tt.Input, tt.N, b, tt.Output)
+		}
+	}
+}
+
+func TestBuildHeader(t *testing.T) {
+	for _, version := range []byte{3, 5, 8} {
+		b := New(version)
+		b.Release = 7
+		b.Serial = "260101"
+		b.Routine("main", 0)
+		b.Op(OP0, 0x0a) // quit
+		img, err := b.Build()
+		if err != nil {
+			t.Errorf("v%d: Build error: %v", version, err)
+			continue
+		}
+		if img[0] != version {
+			t.Errorf("v%d: version byte = %d", version, img[0])
+		}
+		if r := word(img, hdrRelease); r != 7 {
+			t.Errorf("v%d: release = %d; want 7", version, r)
+		}
+		if s := string(img[hdrSerial : hdrSerial+6]); s != "260101" {
+			t.Errorf("v%d: serial = %q; want \"260101\"", version, s)
+		}
+		pc := word(img, hdrInitialPC)
+		if img[pc] != 0xba {
+			t.Errorf("v%d: byte at initial PC = %#02x; want 0xba", version, img[pc])
+		}
+		high := word(img, hdrHighMemory)
+		if high%b.packing() != 0 || pc != high+1 {
+			t.Errorf("v%d: high memory = %#x, initial PC = %#x", version, high, pc)
+		}
+		if static := word(img, hdrStaticMemory); static > high || static < word(img, hdrGlobals)+numGlobals*2 {
+			t.Errorf("v%d: static memory at %#x", version, static)
+		}
+		if n := word(img, hdrFileLength) * b.packing(); n != len(img) {
+			t.Errorf("v%d: file length = %d; want %d", version, n, len(img))
+		}
+		var sum uint16
+		for _, c := range img[headerSize:] {
+			sum += uint16(c)
+		}
+		if c := word(img, hdrChecksum); c != int(sum) {
+			t.Errorf("v%d: checksum = %#04x; want %#04x", version, c, sum)
+		}
+	}
+}
+
+func TestBuildNoMain(t *testing.T) {
+	b := New(3)
+	b.Routine("foo", 0)
+	if _, err := b.Build(); err == nil {
+		t.Error("Build without main did not fail")
+	}
+}
+
+func TestBuildUndefinedLabel(t *testing.T) {
+	b := New(3)
+	b.Routine("main", 0)
+	b.Op(OP1, 0x0c, Label("nowhere")) // jump
+	if _, err := b.Build(); err == nil {
+		t.Error("Build with undefined label did not fail")
+	}
+}
+
+func TestBuildObjects(t *testing.T) {
+	b := New(3)
+	b.Routine("main", 0)
+	b.Op(OP0, 0x0a)
+	b.Object("room", "", []int{0, 31}, WordProp(5, 0x1234))
+	b.Object("lamp", "room", nil, Prop(3, 1))
+	b.Object("box", "room", []int{9})
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := word(img, hdrObjects) + 31*2
+	tests := []struct {
+		Attrs                  [4]byte
+		Parent, Sibling, Child byte
+	}{
+		{[4]byte{0x80, 0, 0, 0x01}, 0, 0, 2},
+		{[4]byte{}, 1, 3, 0},
+		{[4]byte{0, 0x40, 0, 0}, 1, 0, 0},
+	}
+	for i, tt := range tests {
+		e := img[objs+i*9:]
+		if !bytes.Equal(e[:4], tt.Attrs[:]) {
+			t.Errorf("object %d attributes = % x; want % x", i+1, e[:4], tt.Attrs)
+		}
+		if e[4] != tt.Parent || e[5] != tt.Sibling || e[6] != tt.Child {
+			t.Errorf("object %d links = %d, %d, %d; want %d, %d, %d", i+1, e[4], e[5], e[6], tt.Parent, tt.Sibling, tt.Child)
+		}
+	}
+
+	props := word(img, objs+7)
+	name, _ := encodeText("room", 0)
+	if n := int(img[props]); n != len(name)/2 || !bytes.Equal(img[props+1:props+1+len(name)], name) {
+		t.Errorf("room name = % x; want % x", img[props+1:props+1+2*n], name)
+	}
+	p := props + 1 + len(name)
+	if want := []byte{0x25, 0x12, 0x34, 0}; !bytes.Equal(img[p:p+4], want) {
+		t.Errorf("room properties = % x; want % x", img[p:p+4], want)
+	}
+}
+
+func TestBuildDictionary(t *testing.T) {
+	b := New(3)
+	b.Routine("main", 0)
+	b.Op(OP2, 0x0d, Const(0x10), DictAddr("zebra")) // store
+	b.Op(OP0, 0x0a)
+	b.DictWord("zebra", "apple", "mango", "northeast", "northeastern")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := word(img, hdrDictionary)
+	if n := int(img[d]); n != 3 || string(img[d+1:d+4]) != ".,\"" {
+		t.Errorf("separators = %q", img[d+1:d+1+n])
+	}
+	d += 4
+	if img[d] != 7 {
+		t.Errorf("entry size = %d; want 7", img[d])
+	}
+	if n := word(img, d+1); n != 4 {
+		t.Errorf("entry count = %d; want 4", n)
+	}
+	entries := d + 3
+	for i := 1; i < 4; i++ {
+		if bytes.Compare(img[entries+(i-1)*7:entries+(i-1)*7+4], img[entries+i*7:entries+i*7+4]) >= 0 {
+			t.Errorf("entries %d and %d out of order", i-1, i)
+		}
+	}
+	zebra, _ := encodeText("zebra", 6)
+	if last := img[entries+3*7:]; !bytes.Equal(last[:4], zebra) {
+		t.Errorf("last entry = % x; want zebra (% x)", last[:4], zebra)
+	}
+
+	// store 0x10 -> large constant operand after a VAR-form opcode
+	pc := word(img, hdrInitialPC)
+	if a := word(img, pc+3); a != entries+3*7 {
+		t.Errorf("DictAddr(zebra) = %#x; want %#x", a, entries+3*7)
+	}
+}
+
+func TestBuildBranches(t *testing.T) {
+	b := New(5)
+	b.Routine("main", 0)
+	b.Label("top")
+	b.Op(OP1, 0x00, SP, IfTrue("done")) // jz
+	b.Op(OP1, 0x00, SP, IfFalse(ReturnTrue))
+	b.Op(OP1, 0x0c, Label("top")) // jump
+	b.Label("done")
+	b.Op(OP0, 0x0a)
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := word(img, hdrInitialPC)
+	want := []byte{
+		0xa0, 0x00, 0x80, 0x08, // jz sp ?done
+		0xa0, 0x00, 0x41, // jz sp ?~rtrue
+		0x8c, 0xff, 0xf8, // jump top
+		0xba, // quit
+	}
+	if got := img[pc : pc+len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("code = % x; want % x", got, want)
+	}
+}
+
+func TestInstr(t *testing.T) {
+	b := New(3)
+	b.Routine("main", 0)
+	b.Instr("add", Local(1), Const(2), Store(SP))
+	b.Instr("quit")
+	img, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := word(img, hdrInitialPC)
+	if want := []byte{0x54, 0x01, 0x02, 0x00, 0xba}; !bytes.Equal(img[pc:pc+5], want) {
+		t.Errorf("code = % x; want % x", img[pc:pc+5], want)
+	}
+
+	for _, name := range []string{"frobnicate", "aread", "call_vn"} {
+		b := New(3)
+		b.Routine("main", 0)
+		b.Instr(name)
+		if _, err := b.Build(); err == nil {
+			t.Errorf("Instr(%q) in version 3 did not fail", name)
+		}
+	}
+}